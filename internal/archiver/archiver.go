@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/feature"
@@ -51,6 +52,8 @@ type ChangeStats struct {
 type Summary struct {
 	Files, Dirs    ChangeStats
 	ProcessedBytes uint64
+	// ChangedDuringBackup counts the files whose size changed while reading them
+	ChangedDuringBackup uint
 	ItemStats
 }
 
@@ -88,6 +91,9 @@ type Archiver struct {
 	mu        sync.Mutex
 	summary   *Summary
 
+	// err is returned by Snapshot if New was called with invalid options
+	err error
+
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
@@ -139,6 +145,37 @@ type Options struct {
 	// SaveTreeConcurrency sets how many trees are marshalled and saved to the
 	// repo concurrently.
 	SaveTreeConcurrency uint
+
+	// ChunkerPolynomial is the polynomial the caller expects to be used for
+	// splitting files into blobs. Files are always split using the polynomial
+	// stored in the repository config, as deduplication silently stops working
+	// otherwise. If set, ChunkerPolynomial must match that polynomial, or
+	// Snapshot fails.
+	ChunkerPolynomial *chunker.Pol
+
+	// StoreBirthTime records the birth time of each item in the generic
+	// attributes of its node, if the file system provides it. The restorer
+	// applies it again on platforms which allow changing the birth time and
+	// silently ignores it otherwise.
+	StoreBirthTime bool
+
+	// StoreFileFlags records the immutable, append-only and, on macOS and
+	// FreeBSD, hidden flags of files and directories in the generic
+	// attributes of their nodes. On Linux, this requires opening each item
+	// once more.
+	StoreFileFlags bool
+
+	// StoreSparseHoles records the holes of sparse files in the generic
+	// attributes of their nodes. This allows the restorer to recreate the
+	// exact layout of the file, including allocated ranges which only
+	// contain zeros. Currently, holes are only detected on Linux and Windows.
+	StoreSparseHoles bool
+
+	// FailOnChangedFiles reports files whose size changed between scanning
+	// and reading them as an error, which is passed to Archiver.Error.
+	// Otherwise, the node records the size of the content which was read and
+	// the file is counted in Summary.ChangedDuringBackup.
+	FailOnChangedFiles bool
 }
 
 // ApplyDefaults returns a copy of o with the default options set for all unset
@@ -183,9 +220,20 @@ func New(repo archiverRepo, fs fs.FS, opts Options) *Archiver {
 		CompleteBlob: func(uint64) {},
 	}
 
+	if opts.ChunkerPolynomial != nil && *opts.ChunkerPolynomial != repo.Config().ChunkerPolynomial {
+		arch.err = errors.Errorf("chunker polynomial %v does not match the polynomial %v of the repository",
+			*opts.ChunkerPolynomial, repo.Config().ChunkerPolynomial)
+	}
+
 	return arch
 }
 
+// ChunkerPolynomial returns the polynomial used to split files into blobs,
+// which is the one stored in the repository config.
+func (arch *Archiver) ChunkerPolynomial() chunker.Pol {
+	return arch.Repo.Config().ChunkerPolynomial
+}
+
 // error calls arch.Error if it is set and the error is different from context.Canceled.
 func (arch *Archiver) error(item string, err error) error {
 	if arch.Error == nil || err == nil {
@@ -252,6 +300,27 @@ func (arch *Archiver) nodeFromFileInfo(snPath, filename string, fi os.FileInfo,
 	if !arch.WithAtime {
 		node.AccessTime = node.ModTime
 	}
+	if arch.Options.StoreBirthTime {
+		if btime, ok := fs.BirthTime(filename, fi); ok {
+			err = errors.CombineErrors(err, node.SetBirthTime(btime))
+		}
+	}
+	if arch.Options.StoreFileFlags && (node.Type == "file" || node.Type == "dir") {
+		// the flags are optional metadata, thus failures do not fail the backup
+		if flags, ferr := fs.GetFileFlags(filename); ferr == nil {
+			err = errors.CombineErrors(err, node.SetFileFlags(flags))
+		} else {
+			debug.Log("unable to get file flags of %v: %v", filename, ferr)
+		}
+	}
+	if arch.Options.StoreSparseHoles && node.Type == "file" {
+		// a file without holes is restored without holes anyway
+		if holes, herr := fs.SparseHoles(filename); herr == nil && len(holes) > 0 {
+			err = errors.CombineErrors(err, node.SetSparseHoles(holes))
+		} else if herr != nil {
+			debug.Log("unable to get holes of %v: %v", filename, herr)
+		}
+	}
 	if feature.Flag.Enabled(feature.DeviceIDForHardlinks) {
 		if node.Links == 1 || node.Type == "dir" {
 			// the DeviceID is only necessary for hardlinked files
@@ -798,10 +867,18 @@ func (arch *Archiver) runWorkers(ctx context.Context, wg *errgroup.Group) {
 
 	arch.fileSaver = NewFileSaver(ctx, wg,
 		arch.blobSaver.Save,
-		arch.Repo.Config().ChunkerPolynomial,
+		arch.ChunkerPolynomial(),
 		arch.Options.ReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
+	arch.fileSaver.ChangedDuringBackup = func(string) {
+		arch.mu.Lock()
+		defer arch.mu.Unlock()
+		if arch.summary != nil {
+			arch.summary.ChangedDuringBackup++
+		}
+	}
+	arch.fileSaver.FailOnChangedFiles = arch.Options.FailOnChangedFiles
 
 	arch.treeSaver = NewTreeSaver(ctx, wg, arch.Options.SaveTreeConcurrency, arch.blobSaver.Save, arch.Error)
 }
@@ -817,6 +894,9 @@ func (arch *Archiver) stopWorkers() {
 
 // Snapshot saves several targets and returns a snapshot.
 func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, *Summary, error) {
+	if arch.err != nil {
+		return nil, restic.ID{}, nil, arch.err
+	}
 	arch.summary = &Summary{}
 
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
@@ -904,18 +984,19 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		BackupStart: opts.BackupStart,
 		BackupEnd:   time.Now(),
 
-		FilesNew:            arch.summary.Files.New,
-		FilesChanged:        arch.summary.Files.Changed,
-		FilesUnmodified:     arch.summary.Files.Unchanged,
-		DirsNew:             arch.summary.Dirs.New,
-		DirsChanged:         arch.summary.Dirs.Changed,
-		DirsUnmodified:      arch.summary.Dirs.Unchanged,
-		DataBlobs:           arch.summary.ItemStats.DataBlobs,
-		TreeBlobs:           arch.summary.ItemStats.TreeBlobs,
-		DataAdded:           arch.summary.ItemStats.DataSize + arch.summary.ItemStats.TreeSize,
-		DataAddedPacked:     arch.summary.ItemStats.DataSizeInRepo + arch.summary.ItemStats.TreeSizeInRepo,
-		TotalFilesProcessed: arch.summary.Files.New + arch.summary.Files.Changed + arch.summary.Files.Unchanged,
-		TotalBytesProcessed: arch.summary.ProcessedBytes,
+		FilesNew:                 arch.summary.Files.New,
+		FilesChanged:             arch.summary.Files.Changed,
+		FilesUnmodified:          arch.summary.Files.Unchanged,
+		DirsNew:                  arch.summary.Dirs.New,
+		DirsChanged:              arch.summary.Dirs.Changed,
+		DirsUnmodified:           arch.summary.Dirs.Unchanged,
+		DataBlobs:                arch.summary.ItemStats.DataBlobs,
+		TreeBlobs:                arch.summary.ItemStats.TreeBlobs,
+		DataAdded:                arch.summary.ItemStats.DataSize + arch.summary.ItemStats.TreeSize,
+		DataAddedPacked:          arch.summary.ItemStats.DataSizeInRepo + arch.summary.ItemStats.TreeSizeInRepo,
+		TotalFilesProcessed:      arch.summary.Files.New + arch.summary.Files.Changed + arch.summary.Files.Unchanged,
+		TotalBytesProcessed:      arch.summary.ProcessedBytes,
+		FilesChangedDuringBackup: arch.summary.ChangedDuringBackup,
 	}
 
 	id, err := restic.SaveSnapshot(ctx, arch.Repo, sn)
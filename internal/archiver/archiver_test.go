@@ -1653,6 +1653,78 @@ func checkSnapshotStats(t *testing.T, sn *restic.Snapshot, stat Summary) {
 	bothZeroOrNeither(t, uint64(stat.DataSizeInRepo+stat.TreeSizeInRepo), uint64(sn.Summary.DataAddedPacked))
 }
 
+func TestArchiverChunkerPolynomial(t *testing.T) {
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{"foo": TestFile{Content: "foo"}})
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	pol := repo.Config().ChunkerPolynomial
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{ChunkerPolynomial: &pol})
+	rtest.Equals(t, pol, arch.ChunkerPolynomial())
+	_, _, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	other := pol + 2
+	arch = New(repo, fs.Track{FS: fs.Local{}}, Options{ChunkerPolynomial: &other})
+	_, _, _, err = arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "does not match"), "expected polynomial mismatch, got %v", err)
+}
+
+func TestArchiverStoreBirthTime(t *testing.T) {
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{"foo": TestFile{Content: "foo"}})
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	fi, err := os.Lstat("foo")
+	rtest.OK(t, err)
+	expected, supported := fs.BirthTime("foo", fi)
+
+	for _, store := range []bool{false, true} {
+		arch := New(repo, fs.Track{FS: fs.Local{}}, Options{StoreBirthTime: store})
+		sn, _, _, err := arch.Snapshot(context.TODO(), []string{"foo"}, SnapshotOptions{Time: time.Now()})
+		rtest.OK(t, err)
+
+		tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+		rtest.OK(t, err)
+		node := tree.Find("foo")
+		rtest.Assert(t, node != nil, "missing node foo")
+
+		btime, ok, err := node.BirthTime()
+		rtest.OK(t, err)
+		// the file system of the test may not record the birth time
+		rtest.Equals(t, store && supported, ok)
+		if ok {
+			rtest.Assert(t, btime.Equal(expected), "unexpected birth time %v, expected %v", btime, expected)
+		}
+	}
+}
+
+func TestArchiverStoreSparseHoles(t *testing.T) {
+	tempdir, repo := prepareTempdirRepoSrc(t, TestDir{"foo": TestFile{Content: "foo"}})
+	back := rtest.Chdir(t, tempdir)
+	defer back()
+
+	rtest.OK(t, os.Truncate("foo", 1<<20))
+	expected, err := fs.SparseHoles("foo")
+	if err != nil || len(expected) == 0 {
+		t.Skipf("the file system does not report holes: %v", err)
+	}
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{StoreSparseHoles: true})
+	sn, _, _, err := arch.Snapshot(context.TODO(), []string{"foo"}, SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	node := tree.Find("foo")
+	rtest.Assert(t, node != nil, "missing node foo")
+
+	holes, ok, err := node.SparseHoles()
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "holes were not stored")
+	rtest.Equals(t, expected, holes)
+}
+
 func TestArchiverParent(t *testing.T) {
 	var tests = []struct {
 		src         TestDir
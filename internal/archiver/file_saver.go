@@ -30,8 +30,18 @@ type FileSaver struct {
 	CompleteBlob func(bytes uint64)
 
 	NodeFromFileInfo func(snPath, filename string, fi os.FileInfo, ignoreXattrListError bool) (*restic.Node, error)
+
+	// ChangedDuringBackup is called for each file whose size changed while
+	// reading it, unless FailOnChangedFiles is set.
+	ChangedDuringBackup func(snPath string)
+	// FailOnChangedFiles reports these files as an error instead.
+	FailOnChangedFiles bool
 }
 
+// ErrChangedDuringBackup is returned for a file whose size changed between
+// scanning and reading it, if FileSaver.FailOnChangedFiles is set.
+var ErrChangedDuringBackup = errors.New("file changed during backup")
+
 // NewFileSaver returns a new file saver. A worker pool with fileWorkers is
 // started, it is stopped when ctx is cancelled.
 func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, pol chunker.Pol, fileWorkers, blobWorkers uint) *FileSaver {
@@ -47,7 +57,8 @@ func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, pol
 		pol:          pol,
 		ch:           ch,
 
-		CompleteBlob: func(uint64) {},
+		CompleteBlob:        func(uint64) {},
+		ChangedDuringBackup: func(string) {},
 	}
 
 	for i := uint(0); i < fileWorkers; i++ {
@@ -236,6 +247,16 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return
 	}
 
+	if uint64(fi.Size()) != node.Size {
+		// the node always records the size of the stored content
+		debug.Log("%v: size changed from %d to %d bytes while reading", target, fi.Size(), node.Size)
+		if s.FailOnChangedFiles {
+			completeError(fmt.Errorf("%w: size changed from %d to %d bytes", ErrChangedDuringBackup, fi.Size(), node.Size))
+			return
+		}
+		s.ChangedDuringBackup(snPath)
+	}
+
 	fnr.node = node
 	lock.Lock()
 	// require one additional completeFuture() call to ensure that the future only completes
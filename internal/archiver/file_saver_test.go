@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/test"
@@ -100,3 +101,40 @@ func TestFileSaver(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFileSaverChangedDuringBackup(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		filename := createTestFiles(t, 1)[0]
+		testFs := fs.Local{}
+		s, ctx, wg := startFileSaver(ctx, t)
+		s.FailOnChangedFiles = fail
+		var changed []string
+		s.ChangedDuringBackup = func(snPath string) {
+			changed = append(changed, snPath)
+		}
+
+		f, err := testFs.Open(filename)
+		test.OK(t, err)
+		fi, err := f.Stat()
+		test.OK(t, err)
+		// the file grows after it was scanned
+		test.OK(t, os.WriteFile(filename, []byte("testfile-0 and more data"), 0600))
+
+		fn := s.Save(ctx, "/testfile", filename, f, fi, func() {}, func() {}, func(*restic.Node, ItemStats) {})
+		fnr := fn.take(ctx)
+		s.TriggerShutdown()
+		test.OK(t, wg.Wait())
+
+		if fail {
+			test.Assert(t, errors.Is(fnr.err, ErrChangedDuringBackup), "unexpected error %v", fnr.err)
+			test.Equals(t, 0, len(changed))
+		} else {
+			test.OK(t, fnr.err)
+			test.Equals(t, uint64(len("testfile-0 and more data")), fnr.node.Size)
+			test.Equals(t, []string{"/testfile"}, changed)
+		}
+	}
+}
@@ -38,6 +38,14 @@ func (d *Dumper) DumpTree(ctx context.Context, tree *restic.Tree, rootPath strin
 	ch := make(chan *restic.Node, 10)
 	go sendTrees(ctx, d.repo, tree, rootPath, ch)
 
+	return d.DumpNodes(ctx, ch)
+}
+
+// DumpNodes writes the nodes received from ch to d's Writer in d's archive
+// format until ch is closed. The Path of each node is its name within the
+// archive, relative to the root "/". Only files, directories and symlinks are
+// supported.
+func (d *Dumper) DumpNodes(ctx context.Context, ch <-chan *restic.Node) error {
 	switch d.format {
 	case "tar":
 		return d.dumpTar(ctx, ch)
@@ -22,14 +22,19 @@ func (d *Dumper) dumpTar(ctx context.Context, ch <-chan *restic.Node) (err error
 		}
 	}()
 
+	links := make(map[hardlinkKey]string)
 	for node := range ch {
-		if err := d.dumpNodeTar(ctx, node, w); err != nil {
+		if err := d.dumpNodeTar(ctx, node, w, links); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+type hardlinkKey struct {
+	inode, deviceID uint64
+}
+
 // copied from archive/tar.FileInfoHeader
 const (
 	// Mode constants from the USTAR spec:
@@ -48,7 +53,10 @@ func tarIdentifier(id uint32) int {
 	return int(id)
 }
 
-func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writer) error {
+// dumpNodeTar writes node to w. Files with several hardlinks are only written
+// once, further occurrences are written as hardlinks to the first one, which
+// is recorded in links.
+func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writer, links map[hardlinkKey]string) error {
 	relPath, err := filepath.Rel("/", node.Path)
 	if err != nil {
 		return err
@@ -83,6 +91,20 @@ func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writ
 		header.Typeflag = tar.TypeReg
 	}
 
+	if IsFile(node) && node.Links > 1 {
+		key := hardlinkKey{node.Inode, node.DeviceID}
+		if first, ok := links[key]; ok {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = first
+			header.Size = 0
+			if err := w.WriteHeader(header); err != nil {
+				return fmt.Errorf("writing header for %q: %w", node.Path, err)
+			}
+			return nil
+		}
+		links[key] = header.Name
+	}
+
 	if IsLink(node) {
 		header.Typeflag = tar.TypeSymlink
 		header.Linkname = node.LinkTarget
@@ -135,7 +135,7 @@ func TestFieldTooLong(t *testing.T) {
 	}
 
 	d := Dumper{format: "tar"}
-	err := d.dumpNodeTar(context.Background(), &node, tar.NewWriter(io.Discard))
+	err := d.dumpNodeTar(context.Background(), &node, tar.NewWriter(io.Discard), make(map[hardlinkKey]string))
 
 	// We want a tar.ErrFieldTooLong that has the filename.
 	rtest.Assert(t, errors.Is(err, tar.ErrFieldTooLong), "wrong type %T", err)
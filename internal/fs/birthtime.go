@@ -0,0 +1,7 @@
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// ErrBirthTimeUnsupported is returned by SetBirthTime on platforms which do
+// not allow changing the birth time of a file.
+var ErrBirthTimeUnsupported = errors.New("setting the birth time is not supported")
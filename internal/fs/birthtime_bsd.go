@@ -0,0 +1,21 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// BirthTime returns the birth time of the item described by fi. It reports
+// false if the file system does not record it.
+func BirthTime(_ string, fi os.FileInfo) (time.Time, bool) {
+	s, ok := fi.Sys().(*syscall.Stat_t)
+	// file systems without support report either zero or -1
+	if !ok || s.Birthtimespec.Sec <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(s.Birthtimespec.Unix()), true
+}
@@ -0,0 +1,20 @@
+package fs
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// BirthTime returns the birth time of the item at path, without following
+// symlinks. It reports false if the file system does not record it.
+func BirthTime(path string, _ os.FileInfo) (time.Time, bool) {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, fixpath(path), unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx)
+	// some file systems report a zero birth time instead of clearing the mask
+	if err != nil || stx.Mask&unix.STATX_BTIME == 0 || stx.Btime.Sec <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), true
+}
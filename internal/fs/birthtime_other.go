@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package fs
+
+import (
+	"os"
+	"time"
+)
+
+// BirthTime is not supported on this platform and always reports false.
+// On Windows, the creation time is stored as part of the node instead.
+func BirthTime(_ string, _ os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
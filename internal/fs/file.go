@@ -6,6 +6,15 @@ import (
 	"time"
 )
 
+// FixPath returns name in a form that can be passed directly to OS-level
+// calls which bypass the wrappers in this package. On Windows, the path is
+// converted to an absolute extended-length path (prefixed with `\\?\`) so
+// that paths longer than MAX_PATH work. On other platforms, name is returned
+// unchanged.
+func FixPath(name string) string {
+	return fixpath(name)
+}
+
 // Mkdir creates a new directory with the specified name and permission bits.
 // If there is an error, it will be of type *PathError.
 func Mkdir(name string, perm os.FileMode) error {
@@ -133,7 +142,7 @@ func IsAccessDenied(err error) bool {
 // ResetPermissions resets the permissions of the file at the specified path
 func ResetPermissions(path string) error {
 	// Set the default file permissions
-	if err := os.Chmod(path, 0600); err != nil {
+	if err := os.Chmod(fixpath(path), 0600); err != nil {
 		return err
 	}
 	return nil
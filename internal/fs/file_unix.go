@@ -6,6 +6,8 @@ package fs
 import (
 	"os"
 	"syscall"
+
+	"github.com/restic/restic/internal/errors"
 )
 
 // fixpath returns an absolute path on windows, so restic can open long file
@@ -48,3 +50,9 @@ func Chmod(name string, mode os.FileMode) error {
 
 	return err
 }
+
+// IsLocked checks if the error is due to the file being in use by another
+// process, that is an executable which is currently running.
+func IsLocked(err error) bool {
+	return errors.Is(err, syscall.ETXTBSY)
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/restic/restic/internal/errors"
 	"golang.org/x/sys/windows"
 )
 
@@ -85,7 +86,7 @@ func ClearSystem(path string) error {
 
 // ClearAttribute removes the specified attribute from the file.
 func ClearAttribute(path string, attribute uint32) error {
-	ptr, err := windows.UTF16PtrFromString(path)
+	ptr, err := windows.UTF16PtrFromString(fixpath(path))
 	if err != nil {
 		return err
 	}
@@ -124,3 +125,9 @@ func OpenHandleForEA(nodeType, path string, writeAccess bool) (handle windows.Ha
 	}
 	return handle, err
 }
+
+// IsLocked checks if the error is due to the file being opened or locked by
+// another process.
+func IsLocked(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+}
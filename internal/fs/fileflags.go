@@ -0,0 +1,23 @@
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// FileFlags are the flags of a file or directory which restrict how it can
+// be modified, independent of the operating system.
+type FileFlags uint32
+
+const (
+	// FileFlagImmutable prevents any modification, including renaming and
+	// deleting the item (chattr +i, chflags uchg/schg).
+	FileFlagImmutable FileFlags = 1 << iota
+	// FileFlagAppendOnly only allows appending to a file (chattr +a,
+	// chflags uappnd/sappnd).
+	FileFlagAppendOnly
+	// FileFlagHidden hides an item in the GUI (chflags hidden). It only
+	// exists on macOS and FreeBSD and is ignored on Linux.
+	FileFlagHidden
+)
+
+// ErrFileFlagsUnsupported is returned by GetFileFlags and SetFileFlags if the
+// platform or the file system does not support file flags.
+var ErrFileFlagsUnsupported = errors.New("file flags are not supported")
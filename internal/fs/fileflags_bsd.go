@@ -0,0 +1,82 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// flags from sys/stat.h, which are identical on darwin and freebsd
+const (
+	ufImmutable = 0x2
+	ufAppend    = 0x4
+	ufHidden    = 0x8000
+	sfImmutable = 0x20000
+	sfAppend    = 0x40000
+)
+
+func getNativeFlags(path string) (uint32, error) {
+	fi, err := os.Lstat(fixpath(path))
+	if err != nil {
+		return 0, err
+	}
+	s, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, ErrFileFlagsUnsupported
+	}
+	return s.Flags, nil
+}
+
+// GetFileFlags returns the flags of the file or directory at path.
+func GetFileFlags(path string) (FileFlags, error) {
+	native, err := getNativeFlags(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var flags FileFlags
+	if native&(ufImmutable|sfImmutable) != 0 {
+		flags |= FileFlagImmutable
+	}
+	if native&(ufAppend|sfAppend) != 0 {
+		flags |= FileFlagAppendOnly
+	}
+	if native&ufHidden != 0 {
+		flags |= FileFlagHidden
+	}
+	return flags, nil
+}
+
+// SetFileFlags sets the flags of the file or directory at path. The user
+// variants of the flags are set, which, unlike the system variants, can be
+// cleared again without rebooting into single user mode. Other flags are
+// left unchanged.
+func SetFileFlags(path string, flags FileFlags) error {
+	native, err := getNativeFlags(path)
+	if err != nil {
+		return err
+	}
+	native &^= ufImmutable | sfImmutable | ufAppend | sfAppend | ufHidden
+	if flags&FileFlagImmutable != 0 {
+		native |= ufImmutable
+	}
+	if flags&FileFlagAppendOnly != 0 {
+		native |= ufAppend
+	}
+	if flags&FileFlagHidden != 0 {
+		native |= ufHidden
+	}
+	if err := unix.Chflags(fixpath(path), int(native)); err != nil {
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return ErrFileFlagsUnsupported
+		}
+		return &os.PathError{Op: "chflags", Path: path, Err: err}
+	}
+	return nil
+}
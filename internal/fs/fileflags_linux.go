@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// flags from linux/fs.h, as used by chattr
+const (
+	fsImmutableFl = 0x10
+	fsAppendFl    = 0x20
+)
+
+func openForFlags(path string) (int, error) {
+	fd, err := unix.Open(fixpath(path), unix.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return fd, nil
+}
+
+func flagsError(op, path string, err error) error {
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) {
+		return ErrFileFlagsUnsupported
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// GetFileFlags returns the flags of the file or directory at path.
+func GetFileFlags(path string) (FileFlags, error) {
+	fd, err := openForFlags(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	native, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return 0, flagsError("getflags", path, err)
+	}
+
+	var flags FileFlags
+	if native&fsImmutableFl != 0 {
+		flags |= FileFlagImmutable
+	}
+	if native&fsAppendFl != 0 {
+		flags |= FileFlagAppendOnly
+	}
+	return flags, nil
+}
+
+// SetFileFlags sets the flags of the file or directory at path. Other flags
+// which are not represented by FileFlags are left unchanged. FileFlagHidden
+// does not exist on Linux and is ignored.
+func SetFileFlags(path string, flags FileFlags) error {
+	fd, err := openForFlags(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	native, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return flagsError("getflags", path, err)
+	}
+	native &^= fsImmutableFl | fsAppendFl
+	if flags&FileFlagImmutable != 0 {
+		native |= fsImmutableFl
+	}
+	if flags&FileFlagAppendOnly != 0 {
+		native |= fsAppendFl
+	}
+	if err := unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, int(native)); err != nil {
+		return flagsError("setflags", path, err)
+	}
+	return nil
+}
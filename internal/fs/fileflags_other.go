@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package fs
+
+// GetFileFlags returns ErrFileFlagsUnsupported on this platform.
+func GetFileFlags(_ string) (FileFlags, error) {
+	return 0, ErrFileFlagsUnsupported
+}
+
+// SetFileFlags returns ErrFileFlagsUnsupported on this platform.
+func SetFileFlags(_ string, _ FileFlags) error {
+	return ErrFileFlagsUnsupported
+}
@@ -0,0 +1,8 @@
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// ErrReflinkUnsupported is returned by Reflink if the platform or the file
+// system does not support sharing data between files, or if both files are
+// located on different file systems.
+var ErrReflinkUnsupported = errors.New("reflinks are not supported")
@@ -0,0 +1,42 @@
+package fs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Reflink replaces the content of dst, which is created if necessary, with
+// the content of src, such that both files share their data blocks until
+// either of them is modified (FICLONE). This is supported by Btrfs and XFS,
+// among others. The metadata of src is not copied.
+func Reflink(src, dst string) error {
+	in, err := os.Open(fixpath(src))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(fixpath(dst), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	if cerr := out.Close(); err == nil && cerr != nil {
+		return errors.WithStack(cerr)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.EOPNOTSUPP), errors.Is(err, unix.ENOTTY),
+		errors.Is(err, unix.EXDEV), errors.Is(err, unix.EINVAL):
+		return ErrReflinkUnsupported
+	default:
+		return &os.PathError{Op: "reflink", Path: dst, Err: err}
+	}
+}
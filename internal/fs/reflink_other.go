@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+// Reflink returns ErrReflinkUnsupported on this platform.
+func Reflink(_, _ string) error {
+	return ErrReflinkUnsupported
+}
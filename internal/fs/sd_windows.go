@@ -106,6 +106,7 @@ func SetSecurityDescriptor(filePath string, securityDescriptor *[]byte) error {
 		sacl = nil
 	}
 
+	filePath = fixpath(filePath)
 	if lowerPrivileges.Load() {
 		err = setNamedSecurityInfoLow(filePath, dacl)
 	} else {
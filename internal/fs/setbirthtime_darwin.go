@@ -0,0 +1,20 @@
+package fs
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetBirthTime sets the birth time of the item at path, without following
+// symlinks.
+func SetBirthTime(path string, t time.Time) error {
+	attrs := unix.Attrlist{
+		Bitmapcount: unix.ATTR_BIT_MAP_COUNT,
+		Commonattr:  unix.ATTR_CMN_CRTIME,
+	}
+	ts := unix.NsecToTimespec(t.UnixNano())
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&ts)), unsafe.Sizeof(ts))
+	return unix.Setattrlist(fixpath(path), &attrs, buf, unix.FSOPT_NOFOLLOW)
+}
@@ -0,0 +1,12 @@
+//go:build !darwin
+// +build !darwin
+
+package fs
+
+import "time"
+
+// SetBirthTime returns ErrBirthTimeUnsupported, as the birth time can only
+// be changed on macOS.
+func SetBirthTime(_ string, _ time.Time) error {
+	return ErrBirthTimeUnsupported
+}
@@ -0,0 +1,13 @@
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// SparseHole is a range of a file which is not allocated on disk.
+type SparseHole struct {
+	Offset int64
+	Length int64
+}
+
+// ErrSparseUnsupported is returned by SparseHoles and SetSparseHoles if the
+// platform or the file system cannot report or create holes.
+var ErrSparseUnsupported = errors.New("sparse file layout is not supported")
@@ -0,0 +1,98 @@
+package fs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+func sparseError(op, path string, err error) error {
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		return ErrSparseUnsupported
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// SparseHoles returns the holes of the regular file at path, sorted by
+// offset. It uses SEEK_DATA and SEEK_HOLE, thus the result depends on the
+// block size of the file system.
+func SparseHoles(path string) ([]SparseHole, error) {
+	fd, err := unix.Open(fixpath(path), unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return nil, &os.PathError{Op: "fstat", Path: path, Err: err}
+	}
+
+	var holes []SparseHole
+	for offset := int64(0); offset < stat.Size; {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no more data until the end of the file
+			data = stat.Size
+		} else if err != nil {
+			return nil, sparseError("seek", path, err)
+		}
+		if data > offset {
+			holes = append(holes, SparseHole{Offset: offset, Length: data - offset})
+		}
+		if data >= stat.Size {
+			break
+		}
+
+		offset, err = unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, sparseError("seek", path, err)
+		}
+	}
+	return holes, nil
+}
+
+// SetSparseHoles changes the layout of the regular file at path such that
+// exactly the given holes are not allocated. All other ranges are allocated,
+// even if they only contain zeros. The content of the file outside of the
+// holes is not modified.
+func SetSparseHoles(path string, holes []SparseHole) error {
+	fd, err := unix.Open(fixpath(path), unix.O_WRONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return &os.PathError{Op: "fstat", Path: path, Err: err}
+	}
+
+	allocate := func(offset, end int64) error {
+		if end <= offset {
+			return nil
+		}
+		return unix.Fallocate(fd, unix.FALLOC_FL_KEEP_SIZE, offset, end-offset)
+	}
+
+	offset := int64(0)
+	for _, hole := range holes {
+		if err := allocate(offset, hole.Offset); err != nil {
+			return sparseError("fallocate", path, err)
+		}
+		if err := unix.Fallocate(fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, hole.Offset, hole.Length); err != nil {
+			return sparseError("fallocate", path, err)
+		}
+		offset = hole.Offset + hole.Length
+	}
+	if err := allocate(offset, stat.Size); err != nil {
+		return sparseError("fallocate", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package fs
+
+// SparseHoles returns ErrSparseUnsupported on this platform.
+func SparseHoles(_ string) ([]SparseHole, error) {
+	return nil, ErrSparseUnsupported
+}
+
+// SetSparseHoles returns ErrSparseUnsupported on this platform.
+func SetSparseHoles(_ string, _ []SparseHole) error {
+	return ErrSparseUnsupported
+}
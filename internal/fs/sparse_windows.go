@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// fileAllocatedRangeBuffer is FILE_ALLOCATED_RANGE_BUFFER, which is used by
+// FSCTL_QUERY_ALLOCATED_RANGES.
+type fileAllocatedRangeBuffer struct {
+	FileOffset int64
+	Length     int64
+}
+
+// fileZeroDataInformation is FILE_ZERO_DATA_INFORMATION, which is used by
+// FSCTL_SET_ZERO_DATA.
+type fileZeroDataInformation struct {
+	FileOffset      int64
+	BeyondFinalZero int64
+}
+
+func sparseError(op, path string, err error) error {
+	if errors.Is(err, windows.ERROR_INVALID_FUNCTION) || errors.Is(err, windows.ERROR_NOT_SUPPORTED) {
+		return ErrSparseUnsupported
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+// SetSparse marks f as a sparse file, such that ranges which are never
+// written or which are zeroed by SetSparseHoles are not allocated on disk.
+func SetSparse(f *os.File) error {
+	var t uint32
+	err := windows.DeviceIoControl(windows.Handle(f.Fd()), windows.FSCTL_SET_SPARSE, nil, 0, nil, 0, &t, nil)
+	if err != nil {
+		return sparseError("DeviceIoControl", f.Name(), err)
+	}
+	return nil
+}
+
+// SparseHoles returns the holes of the regular file at path, sorted by
+// offset. It uses FSCTL_QUERY_ALLOCATED_RANGES, thus only files which are
+// marked as sparse can have holes.
+func SparseHoles(path string) ([]SparseHole, error) {
+	f, err := os.OpenFile(fixpath(path), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	var holes []SparseHole
+	ranges := make([]fileAllocatedRangeBuffer, 64)
+	offset := int64(0)
+	for offset < size {
+		query := fileAllocatedRangeBuffer{FileOffset: offset, Length: size - offset}
+		var n uint32
+		err := windows.DeviceIoControl(windows.Handle(f.Fd()), windows.FSCTL_QUERY_ALLOCATED_RANGES,
+			(*byte)(unsafe.Pointer(&query)), uint32(unsafe.Sizeof(query)),
+			(*byte)(unsafe.Pointer(&ranges[0])), uint32(len(ranges))*uint32(unsafe.Sizeof(ranges[0])), &n, nil)
+		more := errors.Is(err, windows.ERROR_MORE_DATA)
+		if err != nil && !more {
+			return nil, sparseError("DeviceIoControl", path, err)
+		}
+
+		count := int(n / uint32(unsafe.Sizeof(ranges[0])))
+		for _, r := range ranges[:count] {
+			if r.FileOffset > offset {
+				holes = append(holes, SparseHole{Offset: offset, Length: r.FileOffset - offset})
+			}
+			offset = r.FileOffset + r.Length
+		}
+		if !more || count == 0 {
+			break
+		}
+	}
+	if offset < size {
+		holes = append(holes, SparseHole{Offset: offset, Length: size - offset})
+	}
+	return holes, nil
+}
+
+// SetSparseHoles marks the regular file at path as sparse and deallocates
+// the given holes using FSCTL_SET_ZERO_DATA, which also sets their content to
+// zeros. Unlike on Linux, other ranges are not allocated explicitly, they
+// keep their current allocation.
+func SetSparseHoles(path string, holes []SparseHole) error {
+	f, err := os.OpenFile(fixpath(path), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := SetSparse(f); err != nil {
+		return err
+	}
+	for _, hole := range holes {
+		zero := fileZeroDataInformation{FileOffset: hole.Offset, BeyondFinalZero: hole.Offset + hole.Length}
+		var t uint32
+		err := windows.DeviceIoControl(windows.Handle(f.Fd()), windows.FSCTL_SET_ZERO_DATA,
+			(*byte)(unsafe.Pointer(&zero)), uint32(unsafe.Sizeof(zero)), nil, 0, &t, nil)
+		if err != nil {
+			return sparseError("DeviceIoControl", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// ErrXattrUnsupported is returned by SetXattr if the platform or the file
+// system does not support extended attributes.
+var ErrXattrUnsupported = errors.New("extended attributes are not supported")
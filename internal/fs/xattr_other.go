@@ -0,0 +1,9 @@
+//go:build !darwin && !freebsd && !linux && !solaris
+// +build !darwin,!freebsd,!linux,!solaris
+
+package fs
+
+// SetXattr returns ErrXattrUnsupported on this platform.
+func SetXattr(_, _ string, _ []byte) error {
+	return ErrXattrUnsupported
+}
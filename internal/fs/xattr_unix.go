@@ -0,0 +1,25 @@
+//go:build darwin || freebsd || linux || solaris
+// +build darwin freebsd linux solaris
+
+package fs
+
+import (
+	"syscall"
+
+	"github.com/pkg/xattr"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// SetXattr sets the extended attribute name of path, following symlinks. If
+// the file system does not support extended attributes, ErrXattrUnsupported
+// is returned.
+func SetXattr(path, name string, data []byte) error {
+	err := xattr.Set(path, name, data)
+	var xerr *xattr.Error
+	if errors.As(err, &xerr) && (xerr.Err == syscall.ENOTSUP || xerr.Err == xattr.ENOATTR) {
+		// SMB/CIFS mounts on Linux can report ENOATTR instead of ENOTSUP
+		return ErrXattrUnsupported
+	}
+	return errors.WithStack(err)
+}
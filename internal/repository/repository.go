@@ -1078,6 +1078,21 @@ type packBlobIterator struct {
 	decode []byte
 }
 
+// DamagedBlobError is reported for a blob whose data was loaded but cannot be
+// decrypted or decompressed, or does not match the blob ID. Loading the blob
+// again from the same pack is pointless.
+type DamagedBlobError struct {
+	Err error
+}
+
+func (e *DamagedBlobError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DamagedBlobError) Unwrap() error {
+	return e.Err
+}
+
 type packBlobValue struct {
 	Handle    restic.BlobHandle
 	Plaintext []byte
@@ -1159,6 +1174,9 @@ func (b *packBlobIterator) Next() (packBlobValue, error) {
 		}
 	}
 
+	if err != nil {
+		err = &DamagedBlobError{Err: err}
+	}
 	return packBlobValue{entry.BlobHandle, plaintext, err}, nil
 }
 
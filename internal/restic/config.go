@@ -74,8 +74,8 @@ func LoadConfig(ctx context.Context, r LoaderUnpacked) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
-		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
+	if err := cfg.CheckVersion(); err != nil {
+		return Config{}, err
 	}
 
 	if checkPolynomial {
@@ -87,6 +87,18 @@ func LoadConfig(ctx context.Context, r LoaderUnpacked) (Config, error) {
 	return cfg, nil
 }
 
+// CheckVersion returns an error if the repository version is not supported
+// by this version of restic. The error includes the supported versions.
+func (cfg Config) CheckVersion() error {
+	if cfg.Version > MaxRepoVersion {
+		return errors.Errorf("repository version %v is newer than the highest version %v supported by this version of restic, please upgrade restic", cfg.Version, MaxRepoVersion)
+	}
+	if cfg.Version < MinRepoVersion {
+		return errors.Errorf("unsupported repository version %v, the lowest supported version is %v", cfg.Version, MinRepoVersion)
+	}
+	return nil
+}
+
 func SaveConfig(ctx context.Context, r SaverUnpacked, cfg Config) error {
 	_, err := SaveJSONUnpacked(ctx, r, ConfigFile, cfg)
 	return err
@@ -2,6 +2,8 @@ package restic_test
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/restic"
@@ -63,3 +65,14 @@ func TestConfig(t *testing.T) {
 	rtest.Assert(t, cfg1 == cfg2,
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestConfigCheckVersion(t *testing.T) {
+	for version := restic.MinRepoVersion; version <= restic.MaxRepoVersion; version++ {
+		rtest.OK(t, restic.Config{Version: uint(version)}.CheckVersion())
+	}
+
+	err := restic.Config{Version: restic.MaxRepoVersion + 1}.CheckVersion()
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), fmt.Sprintf("version %v is newer than the highest version %v", restic.MaxRepoVersion+1, restic.MaxRepoVersion)),
+		"unexpected error %v", err)
+	rtest.Assert(t, restic.Config{Version: 0}.CheckVersion() != nil, "missing error for version 0")
+}
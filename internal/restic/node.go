@@ -50,18 +50,40 @@ const (
 	TypeFileAttributes GenericAttributeType = "windows.file_attributes"
 	// TypeSecurityDescriptor is the GenericAttributeType used for storing security descriptors including owner, group, discretionary access control list (DACL), system access control list (SACL)) for windows files within the generic attributes map.
 	TypeSecurityDescriptor GenericAttributeType = "windows.security_descriptor"
+	// TypeAlternateDataStreams is the GenericAttributeType used for storing the alternate data streams of windows files and folders, indexed by the name of the stream.
+	TypeAlternateDataStreams GenericAttributeType = "windows.alternate_data_streams"
+
+	// Below are attributes for unix-like operating systems.
+
+	// TypeBirthTime is the GenericAttributeType used for storing the birth time of files, if supported by the file system.
+	TypeBirthTime GenericAttributeType = "unix.birth_time"
+	// TypeFileFlags is the GenericAttributeType used for storing the immutable, append-only and hidden flags of files and directories.
+	TypeFileFlags GenericAttributeType = "unix.file_flags"
+	// TypeStub is the GenericAttributeType used for marking a restored file as a stub without content. It stores the size of the original file.
+	TypeStub GenericAttributeType = "unix.stub"
+	// TypeSparseHoles is the GenericAttributeType used for storing the holes of sparse files.
+	TypeSparseHoles GenericAttributeType = "unix.sparse_holes"
+	// TypeCapability is the GenericAttributeType used for storing the file capabilities of executables on Linux.
+	TypeCapability GenericAttributeType = "linux.capability"
 
 	// Generic Attributes for other OS types should be defined here.
 )
 
 // init is called when the package is initialized. Any new GenericAttributeTypes being created must be added here as well.
 func init() {
-	storeGenericAttributeType(TypeCreationTime, TypeFileAttributes, TypeSecurityDescriptor)
+	storeGenericAttributeType(TypeCreationTime, TypeFileAttributes, TypeSecurityDescriptor, TypeAlternateDataStreams, TypeBirthTime, TypeFileFlags, TypeStub, TypeSparseHoles, TypeCapability)
 }
 
 // genericAttributesForOS maintains a map of known genericAttributesForOS to the OSType
 var genericAttributesForOS = map[GenericAttributeType]OSType{}
 
+// IsKnownGenericAttributeType returns whether attributeType is known to this
+// version of restic, independent of the OS it applies to.
+func IsKnownGenericAttributeType(attributeType GenericAttributeType) bool {
+	_, ok := genericAttributesForOS[attributeType]
+	return ok
+}
+
 // storeGenericAttributeType adds and entry in genericAttributesForOS map
 func storeGenericAttributeType(attributeTypes ...GenericAttributeType) {
 	for _, attributeType := range attributeTypes {
@@ -225,9 +247,21 @@ func (node *Node) CreateAt(ctx context.Context, path string, repo BlobLoader) er
 	return nil
 }
 
+// RestoreMetadataOptions selects which parts of the node metadata are
+// restored by RestoreMetadataWithOptions.
+type RestoreMetadataOptions struct {
+	// SkipTimestamps leaves the access and modification time of the path untouched.
+	SkipTimestamps bool
+}
+
 // RestoreMetadata restores node metadata
 func (node Node) RestoreMetadata(path string, warn func(msg string)) error {
-	err := node.restoreMetadata(path, warn)
+	return node.RestoreMetadataWithOptions(path, warn, RestoreMetadataOptions{})
+}
+
+// RestoreMetadataWithOptions restores the node metadata selected by opts.
+func (node Node) RestoreMetadataWithOptions(path string, warn func(msg string), opts RestoreMetadataOptions) error {
+	err := node.restoreMetadata(path, warn, opts)
 	if err != nil {
 		debug.Log("restoreMetadata(%s) error %v", path, err)
 	}
@@ -235,7 +269,7 @@ func (node Node) RestoreMetadata(path string, warn func(msg string)) error {
 	return err
 }
 
-func (node Node) restoreMetadata(path string, warn func(msg string)) error {
+func (node Node) restoreMetadata(path string, warn func(msg string), opts RestoreMetadataOptions) error {
 	var firsterr error
 
 	if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
@@ -249,10 +283,12 @@ func (node Node) restoreMetadata(path string, warn func(msg string)) error {
 		}
 	}
 
-	if err := node.RestoreTimestamps(path); err != nil {
-		debug.Log("error restoring timestamps for dir %v: %v", path, err)
-		if firsterr != nil {
-			firsterr = err
+	if !opts.SkipTimestamps {
+		if err := node.RestoreTimestamps(path); err != nil {
+			debug.Log("error restoring timestamps for dir %v: %v", path, err)
+			if firsterr != nil {
+				firsterr = err
+			}
 		}
 	}
 
@@ -273,6 +309,8 @@ func (node Node) restoreMetadata(path string, warn func(msg string)) error {
 	// Moving RestoreTimestamps and restoreExtendedAttributes calls above as for readonly files in windows
 	// calling Chmod below will no longer allow any modifications to be made on the file and the
 	// calls above would fail.
+	// Chmod must also run after lchown, as changing the owner clears the setuid and
+	// setgid bits. node.Mode includes these bits as well as the sticky bit.
 	if node.Type != "symlink" {
 		if err := fs.Chmod(path, node.Mode); err != nil {
 			if firsterr != nil {
@@ -281,6 +319,12 @@ func (node Node) restoreMetadata(path string, warn func(msg string)) error {
 		}
 	}
 
+	// file capabilities are cleared when the content or the owner changes,
+	// thus they are applied last
+	if err := node.restoreCapability(path, warn); err != nil && firsterr == nil {
+		firsterr = err
+	}
+
 	return firsterr
 }
 
@@ -294,7 +338,7 @@ func (node Node) RestoreTimestamps(path string) error {
 		return node.restoreSymlinkTimestamps(path, utimes)
 	}
 
-	if err := syscall.UtimesNano(path, utimes[:]); err != nil {
+	if err := syscall.UtimesNano(fs.FixPath(path), utimes[:]); err != nil {
 		return errors.Wrap(err, "UtimesNano")
 	}
 
@@ -502,6 +546,12 @@ func (node Node) Equals(other Node) bool {
 	return true
 }
 
+// SameAttributes returns whether node and other have the same extended and
+// generic attributes.
+func (node Node) SameAttributes(other Node) bool {
+	return node.sameExtendedAttributes(other) && node.sameGenericAttributes(other)
+}
+
 func (node Node) sameContent(other Node) bool {
 	if node.Content == nil {
 		return other.Content == nil
@@ -722,6 +772,239 @@ func (node *Node) fillTimes(stat *statT) {
 	node.AccessTime = time.Unix(atim.Unix())
 }
 
+// SetBirthTime stores t as the birth time of the node in its generic attributes.
+func (node *Node) SetBirthTime(t time.Time) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[TypeBirthTime] = data
+	return nil
+}
+
+// BirthTime returns the birth time stored in the generic attributes of the
+// node. It reports false if no birth time was stored.
+func (node Node) BirthTime() (time.Time, bool, error) {
+	data, ok := node.GenericAttributes[TypeBirthTime]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	var t time.Time
+	if err := json.Unmarshal(data, &t); err != nil {
+		return time.Time{}, false, errors.Wrap(err, "Unmarshal")
+	}
+	return t, true, nil
+}
+
+// fileFlagNames are the names of the file flags as stored in the generic attributes.
+var fileFlagNames = []struct {
+	flag fs.FileFlags
+	name string
+}{
+	{fs.FileFlagImmutable, "immutable"},
+	{fs.FileFlagAppendOnly, "append-only"},
+	{fs.FileFlagHidden, "hidden"},
+}
+
+// SetFileFlags stores flags in the generic attributes of the node. Nothing
+// is stored if no flag is set.
+func (node *Node) SetFileFlags(flags fs.FileFlags) error {
+	if flags == 0 {
+		delete(node.GenericAttributes, TypeFileFlags)
+		return nil
+	}
+	var names []string
+	for _, f := range fileFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	data, err := json.Marshal(names)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[TypeFileFlags] = data
+	return nil
+}
+
+// FileFlags returns the flags stored in the generic attributes of the node.
+// Unknown flags are ignored.
+func (node Node) FileFlags() (fs.FileFlags, error) {
+	data, ok := node.GenericAttributes[TypeFileFlags]
+	if !ok {
+		return 0, nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return 0, errors.Wrap(err, "Unmarshal")
+	}
+	var flags fs.FileFlags
+	for _, name := range names {
+		for _, f := range fileFlagNames {
+			if f.name == name {
+				flags |= f.flag
+			}
+		}
+	}
+	return flags, nil
+}
+
+// SetSparseHoles stores the holes of a sparse file in the generic attributes
+// of the node. Each hole is stored as a pair of offset and length.
+func (node *Node) SetSparseHoles(holes []fs.SparseHole) error {
+	pairs := make([][2]int64, 0, len(holes))
+	for _, hole := range holes {
+		pairs = append(pairs, [2]int64{hole.Offset, hole.Length})
+	}
+	data, err := json.Marshal(pairs)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[TypeSparseHoles] = data
+	return nil
+}
+
+// SparseHoles returns the holes stored in the generic attributes of the
+// node. It reports false if no holes were stored.
+func (node Node) SparseHoles() ([]fs.SparseHole, bool, error) {
+	data, ok := node.GenericAttributes[TypeSparseHoles]
+	if !ok {
+		return nil, false, nil
+	}
+	var pairs [][2]int64
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return nil, false, errors.Wrap(err, "Unmarshal")
+	}
+	holes := make([]fs.SparseHole, 0, len(pairs))
+	for _, p := range pairs {
+		holes = append(holes, fs.SparseHole{Offset: p[0], Length: p[1]})
+	}
+	return holes, true, nil
+}
+
+// SetAlternateDataStreams stores the content of the alternate data streams
+// of a file in the generic attributes of the node, indexed by stream name.
+func (node *Node) SetAlternateDataStreams(streams map[string][]byte) error {
+	data, err := json.Marshal(streams)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[TypeAlternateDataStreams] = data
+	return nil
+}
+
+// AlternateDataStreams returns the alternate data streams stored in the
+// generic attributes of the node. It reports false if no streams were stored
+// and fails for stream names which cannot be used as part of a path.
+func (node Node) AlternateDataStreams() (map[string][]byte, bool, error) {
+	data, ok := node.GenericAttributes[TypeAlternateDataStreams]
+	if !ok {
+		return nil, false, nil
+	}
+	var streams map[string][]byte
+	if err := json.Unmarshal(data, &streams); err != nil {
+		return nil, false, errors.Wrap(err, "Unmarshal")
+	}
+	for name := range streams {
+		if err := validateStreamName(name); err != nil {
+			return nil, false, err
+		}
+	}
+	return streams, true, nil
+}
+
+// validateStreamName returns an error if name is not a valid name for an
+// alternate data stream, which is appended to the path of a file.
+func validateStreamName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\:\x00") {
+		return errors.Errorf("invalid alternate data stream name %q", name)
+	}
+	return nil
+}
+
+// capabilityExtendedAttribute is the extended attribute which holds the file
+// capabilities on Linux.
+const capabilityExtendedAttribute = "security.capability"
+
+// SetCapability stores the file capabilities in their binary format, as found
+// in the security.capability extended attribute, in the generic attributes of
+// the node.
+func (node *Node) SetCapability(data []byte) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[TypeCapability] = buf
+	return nil
+}
+
+// Capability returns the file capabilities stored in the generic attributes
+// of the node. For snapshots created by older versions, which only stored the
+// extended attribute, its value is returned. It reports false if the node
+// has no capabilities.
+func (node Node) Capability() ([]byte, bool, error) {
+	if buf, ok := node.GenericAttributes[TypeCapability]; ok {
+		var data []byte
+		if err := json.Unmarshal(buf, &data); err != nil {
+			return nil, false, errors.Wrap(err, "Unmarshal")
+		}
+		return data, true, nil
+	}
+	for _, attr := range node.ExtendedAttributes {
+		if attr.Name == capabilityExtendedAttribute {
+			return attr.Value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// StubExtendedAttribute is the name of the extended attribute which marks a
+// file restored as a stub. Its value is the size of the original file in
+// decimal.
+const StubExtendedAttribute = "user.restic.stub"
+
+// SetStub marks the node as a stub of a file with the given size.
+func (node *Node) SetStub(size uint64) error {
+	data, err := json.Marshal(size)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[TypeStub] = data
+	return nil
+}
+
+// StubSize returns the size of the original file if the node is marked as a
+// stub. It reports false otherwise.
+func (node Node) StubSize() (uint64, bool, error) {
+	data, ok := node.GenericAttributes[TypeStub]
+	if !ok {
+		return 0, false, nil
+	}
+	var size uint64
+	if err := json.Unmarshal(data, &size); err != nil {
+		return 0, false, errors.Wrap(err, "Unmarshal")
+	}
+	return size, true, nil
+}
+
 // HandleUnknownGenericAttributesFound is used for handling and distinguing between scenarios related to future versions and cross-OS repositories
 func HandleUnknownGenericAttributesFound(unknownAttribs []GenericAttributeType, warn func(msg string)) {
 	for _, unknownAttrib := range unknownAttribs {
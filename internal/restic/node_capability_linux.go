@@ -0,0 +1,39 @@
+package restic
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// capabilityWarning ensures that missing permissions to restore file
+// capabilities are only reported once.
+var capabilityWarning sync.Once
+
+// restoreCapability applies the file capabilities of the node to path. This
+// requires the CAP_SETFCAP capability, usually only available to root. If it
+// is missing, a single warning is reported and the capabilities are skipped.
+func (node Node) restoreCapability(path string, warn func(msg string)) error {
+	if node.Type != "file" {
+		return nil
+	}
+	data, ok, err := node.Capability()
+	if err != nil || !ok {
+		return err
+	}
+
+	err = setxattr(path, capabilityExtendedAttribute, data)
+	if errors.Is(err, os.ErrPermission) {
+		debug.Log("not permitted to restore capabilities of %v: %v", path, err)
+		capabilityWarning.Do(func() {
+			if warn != nil {
+				warn(fmt.Sprintf("%v: not permitted to restore file capabilities, this requires running as root", path))
+			}
+		})
+		return nil
+	}
+	return err
+}
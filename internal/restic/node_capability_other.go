@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package restic
+
+// restoreCapability is a no-op, file capabilities only exist on Linux.
+func (node Node) restoreCapability(_ string, _ func(msg string)) error {
+	return nil
+}
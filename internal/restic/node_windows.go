@@ -26,6 +26,9 @@ type WindowsAttributes struct {
 	// SecurityDescriptor is used for storing security descriptors which includes
 	// owner, group, discretionary access control list (DACL), system access control list (SACL)
 	SecurityDescriptor *[]byte `generic:"security_descriptor"`
+	// AlternateDataStreams is used for storing the content of the alternate
+	// data streams, indexed by the name of the stream.
+	AlternateDataStreams *map[string][]byte `generic:"alternate_data_streams"`
 }
 
 var (
@@ -47,7 +50,7 @@ func lchown(_ string, _ int, _ int) (err error) {
 // restoreSymlinkTimestamps restores timestamps for symlinks
 func (node Node) restoreSymlinkTimestamps(path string, utimes [2]syscall.Timespec) error {
 	// tweaked version of UtimesNano from go/src/syscall/syscall_windows.go
-	pathp, e := syscall.UTF16PtrFromString(path)
+	pathp, e := syscall.UTF16PtrFromString(fs.FixPath(path))
 	if e != nil {
 		return e
 	}
@@ -213,6 +216,12 @@ func (node Node) restoreGenericAttributes(path string, warn func(msg string)) (e
 			errs = append(errs, fmt.Errorf("error restoring creation time for: %s : %v", path, err))
 		}
 	}
+	if windowsAttributes.AlternateDataStreams != nil {
+		// must be written before the file attributes, which may mark the file read-only
+		if err := restoreAlternateDataStreams(path, *windowsAttributes.AlternateDataStreams); err != nil {
+			errs = append(errs, fmt.Errorf("error restoring alternate data streams for: %s : %v", path, err))
+		}
+	}
 	if windowsAttributes.FileAttributes != nil {
 		if err := restoreFileAttributes(path, windowsAttributes.FileAttributes); err != nil {
 			errs = append(errs, fmt.Errorf("error restoring file attributes for: %s : %v", path, err))
@@ -235,10 +244,23 @@ func genericAttributesToWindowsAttrs(attrs map[GenericAttributeType]json.RawMess
 	return windowsAttributes, unknownAttribs, err
 }
 
+// restoreAlternateDataStreams writes each stream to path:name.
+func restoreAlternateDataStreams(path string, streams map[string][]byte) error {
+	for name, data := range streams {
+		if err := validateStreamName(name); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fs.FixPath(path)+":"+name, data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // restoreCreationTime gets the creation time from the data and sets it to the file/folder at
 // the specified path.
 func restoreCreationTime(path string, creationTime *syscall.Filetime) (err error) {
-	pathPointer, err := syscall.UTF16PtrFromString(path)
+	pathPointer, err := syscall.UTF16PtrFromString(fs.FixPath(path))
 	if err != nil {
 		return err
 	}
@@ -259,7 +281,7 @@ func restoreCreationTime(path string, creationTime *syscall.Filetime) (err error
 // restoreFileAttributes gets the File Attributes from the data and sets them to the file/folder
 // at the specified path.
 func restoreFileAttributes(path string, fileAttributes *uint32) (err error) {
-	pathPointer, err := syscall.UTF16PtrFromString(path)
+	pathPointer, err := syscall.UTF16PtrFromString(fs.FixPath(path))
 	if err != nil {
 		return err
 	}
@@ -157,6 +157,34 @@ func TestRestoreFileAttributes(t *testing.T) {
 	}
 }
 
+func TestRestoreAlternateDataStreams(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	streams := map[string][]byte{
+		"Zone.Identifier": []byte("[ZoneTransfer]\r\nZoneId=3\r\n"),
+		"empty":           {},
+	}
+	testNode := Node{
+		Name:    "testfile",
+		Type:    "file",
+		Mode:    0644,
+		ModTime: parseTime("2005-05-14 21:07:03.111"),
+	}
+	test.OK(t, testNode.SetAlternateDataStreams(streams))
+
+	testPath, _ := restoreAndGetNode(t, tempDir, testNode, false)
+	for name, expected := range streams {
+		data, err := os.ReadFile(fs.FixPath(testPath) + ":" + name)
+		test.OK(t, errors.Wrapf(err, "Could not read stream %s of %s", name, testPath))
+		test.Equals(t, expected, data)
+	}
+
+	// stream names must not escape the file
+	test.OK(t, testNode.SetAlternateDataStreams(map[string][]byte{`..\escape`: nil}))
+	err := testNode.RestoreMetadata(testPath, func(string) {})
+	test.Assert(t, err != nil, "expected error for invalid stream name")
+}
+
 func runGenericAttributesTest(t *testing.T, tempDir string, genericAttributeName GenericAttributeType, genericAttributeExpected WindowsAttributes, warningExpected bool) {
 	genericAttributes, err := WindowsAttrsToGenericAttributes(genericAttributeExpected)
 	test.OK(t, err)
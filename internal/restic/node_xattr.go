@@ -6,10 +6,13 @@ package restic
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
 
 	"github.com/pkg/xattr"
 )
@@ -64,9 +67,42 @@ func handleXattrErr(err error) error {
 	}
 }
 
-// restoreGenericAttributes is no-op.
-func (node *Node) restoreGenericAttributes(_ string, warn func(msg string)) error {
-	return node.handleAllUnknownGenericAttributesFound(warn)
+// restoreGenericAttributes restores the birth time if the platform supports
+// changing it. Otherwise, it is silently ignored. A stub marker is stored as
+// the extended attribute StubExtendedAttribute.
+func (node *Node) restoreGenericAttributes(path string, warn func(msg string)) error {
+	var firsterr error
+	for name := range node.GenericAttributes {
+		var err error
+		switch name {
+		case TypeBirthTime:
+			var btime time.Time
+			btime, _, err = node.BirthTime()
+			if err == nil {
+				err = fs.SetBirthTime(path, btime)
+				if errors.Is(err, fs.ErrBirthTimeUnsupported) {
+					debug.Log("not restoring birth time of %v: %v", path, err)
+					err = nil
+				}
+			}
+		case TypeStub:
+			var size uint64
+			size, _, err = node.StubSize()
+			if err == nil {
+				err = setxattr(path, StubExtendedAttribute, []byte(strconv.FormatUint(size, 10)))
+			}
+		case TypeSparseHoles:
+			// applied by the restorer once the content is written
+		case TypeCapability:
+			// applied by restoreCapability after the mode
+		default:
+			handleUnknownGenericAttributeFound(name, warn)
+		}
+		if err != nil && firsterr == nil {
+			firsterr = errors.WithStack(err)
+		}
+	}
+	return firsterr
 }
 
 // fillGenericAttributes is a no-op.
@@ -77,6 +113,11 @@ func (node *Node) fillGenericAttributes(_ string, _ os.FileInfo, _ *statT) (allo
 func (node Node) restoreExtendedAttributes(path string) error {
 	expectedAttrs := map[string]struct{}{}
 	for _, attr := range node.ExtendedAttributes {
+		if attr.Name == capabilityExtendedAttribute {
+			// applied by restoreCapability after the mode
+			expectedAttrs[attr.Name] = struct{}{}
+			continue
+		}
 		err := setxattr(path, attr.Name, attr.Value)
 		if err != nil {
 			return err
@@ -118,6 +159,12 @@ func (node *Node) fillExtendedAttributes(path string, ignoreListError bool) erro
 			fmt.Fprintf(os.Stderr, "can not obtain extended attribute %v for %v:\n", attr, path)
 			continue
 		}
+		if attr == capabilityExtendedAttribute {
+			// kept as extended attribute as well for older versions
+			if err := node.SetCapability(attrVal); err != nil {
+				return err
+			}
+		}
 		attr := ExtendedAttribute{
 			Name:  attr,
 			Value: attrVal,
@@ -48,6 +48,8 @@ type SnapshotSummary struct {
 	DataAddedPacked     uint64 `json:"data_added_packed"`
 	TotalFilesProcessed uint   `json:"total_files_processed"`
 	TotalBytesProcessed uint64 `json:"total_bytes_processed"`
+	// files whose size changed while they were read
+	FilesChangedDuringBackup uint `json:"files_changed_during_backup,omitempty"`
 }
 
 // NewSnapshot returns an initialized snapshot struct for the current user and
@@ -3,6 +3,7 @@ package restic
 import (
 	"context"
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,21 +21,78 @@ type SnapshotFilter struct {
 	Hosts []string
 	Tags  TagLists
 	Paths []string
+	// HostPatterns contains glob patterns as supported by path.Match. If set,
+	// the hostname of a snapshot must match at least one of them.
+	HostPatterns []string
+	// PathPrefixes are directories of which at least one must contain one of
+	// the paths of a snapshot, or be equal to it.
+	PathPrefixes []string
 	// Match snapshots from before this timestamp. Zero for no limit.
 	TimestampLimit time.Time
 }
 
 func (f *SnapshotFilter) Empty() bool {
-	return len(f.Hosts)+len(f.Tags)+len(f.Paths) == 0
+	return len(f.Hosts)+len(f.Tags)+len(f.Paths)+len(f.HostPatterns)+len(f.PathPrefixes) == 0
+}
+
+func (f *SnapshotFilter) validate() error {
+	for _, pattern := range f.HostPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid host pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether sn matches all criteria of the filter. It fails
+// if the filter contains an invalid host pattern.
+func (f *SnapshotFilter) Matches(sn *Snapshot) (bool, error) {
+	if err := f.validate(); err != nil {
+		return false, err
+	}
+	return f.matches(sn), nil
 }
 
 func (f *SnapshotFilter) matches(sn *Snapshot) bool {
-	return sn.HasHostname(f.Hosts) && sn.HasTagList(f.Tags) && sn.HasPaths(f.Paths)
+	return sn.HasHostname(f.Hosts) && sn.HasTagList(f.Tags) && sn.HasPaths(f.Paths) &&
+		f.matchesHostPatterns(sn.Hostname) && f.matchesPathPrefixes(sn.Paths)
+}
+
+func (f *SnapshotFilter) matchesHostPatterns(hostname string) bool {
+	if len(f.HostPatterns) == 0 {
+		return true
+	}
+	for _, pattern := range f.HostPatterns {
+		// the patterns were validated before
+		if ok, _ := path.Match(pattern, hostname); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *SnapshotFilter) matchesPathPrefixes(paths []string) bool {
+	if len(f.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.PathPrefixes {
+		prefix = filepath.Clean(prefix)
+		for _, p := range paths {
+			rel, err := filepath.Rel(prefix, filepath.Clean(p))
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // findLatest finds the latest snapshot with optional target/directory,
 // tags, hostname, and timestamp filters.
 func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader LoaderUnpacked) (*Snapshot, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
 
 	var err error
 	absTargets := make([]string, 0, len(f.Paths))
@@ -126,7 +184,11 @@ type SnapshotFindCb func(string, *Snapshot, error) error
 var ErrInvalidSnapshotSyntax = errors.New("<snapshot>:<subfolder> syntax not allowed")
 
 // FindAll yields Snapshots, either given explicitly by `snapshotIDs` or filtered from the list of all snapshots.
+// Snapshots are loaded concurrently and passed to fn one at a time, thus they are never all kept in memory at once.
 func (f *SnapshotFilter) FindAll(ctx context.Context, be Lister, loader LoaderUnpacked, snapshotIDs []string, fn SnapshotFindCb) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
 	if len(snapshotIDs) != 0 {
 		var err error
 		usedFilter := false
@@ -2,7 +2,10 @@ package restic_test
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -89,3 +92,56 @@ func TestFindAllSubpathError(t *testing.T) {
 		}))
 	test.Assert(t, count == 2, "unexpected number of subfolder errors: %v, wanted %v", count, 2)
 }
+
+func TestFindAllFilter(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	for _, sn := range []struct {
+		host  string
+		tags  []string
+		paths []string
+	}{
+		{"web-1", []string{"daily"}, []string{"/srv/www", "/etc"}},
+		{"web-2", []string{"daily", "full"}, []string{"/srv/www/site"}},
+		{"db-1", []string{"full"}, []string{"/var/lib/db"}},
+		{"db-1", nil, []string{"/srv/wwwdata"}},
+	} {
+		snapshot, err := restic.NewSnapshot(sn.paths, sn.tags, sn.host, time.Now())
+		test.OK(t, err)
+		_, err = restic.SaveSnapshot(context.TODO(), repo, snapshot)
+		test.OK(t, err)
+	}
+
+	for _, exp := range []struct {
+		filter   restic.SnapshotFilter
+		expected []string
+	}{
+		{restic.SnapshotFilter{}, []string{"db-1", "db-1", "web-1", "web-2"}},
+		{restic.SnapshotFilter{HostPatterns: []string{"web-*"}}, []string{"web-1", "web-2"}},
+		{restic.SnapshotFilter{HostPatterns: []string{"db-?", "web-2"}}, []string{"db-1", "db-1", "web-2"}},
+		{restic.SnapshotFilter{Tags: restic.TagLists{{"daily", "full"}}}, []string{"web-2"}},
+		{restic.SnapshotFilter{Tags: restic.TagLists{{"full"}}, HostPatterns: []string{"db-*"}}, []string{"db-1"}},
+		{restic.SnapshotFilter{PathPrefixes: []string{"/srv/www"}}, []string{"web-1", "web-2"}},
+		{restic.SnapshotFilter{PathPrefixes: []string{"/etc/", "/var"}}, []string{"db-1", "web-1"}},
+		{restic.SnapshotFilter{PathPrefixes: []string{"/"}}, []string{"db-1", "db-1", "web-1", "web-2"}},
+		{restic.SnapshotFilter{HostPatterns: []string{"mail"}}, nil},
+	} {
+		var hosts []string
+		err := exp.filter.FindAll(context.TODO(), repo, repo, nil, func(_ string, sn *restic.Snapshot, err error) error {
+			if err != nil {
+				return err
+			}
+			hosts = append(hosts, sn.Hostname)
+			return nil
+		})
+		test.OK(t, err)
+		sort.Strings(hosts)
+		test.Equals(t, exp.expected, hosts, fmt.Sprintf("filter %+v", exp.filter))
+	}
+
+	f := restic.SnapshotFilter{HostPatterns: []string{"["}}
+	err := f.FindAll(context.TODO(), repo, repo, nil, func(string, *restic.Snapshot, error) error {
+		return nil
+	})
+	test.Assert(t, err != nil, "expected error for invalid host pattern")
+}
@@ -0,0 +1,77 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/dump"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ArchiveFormat is the format of the archive written by RestoreToArchive.
+type ArchiveFormat string
+
+const (
+	// ArchiveTar is a tar archive in the PAX format.
+	ArchiveTar ArchiveFormat = "tar"
+	// ArchiveZip is a zip archive. It cannot represent hardlinks, thus
+	// hardlinked files are stored several times.
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+// RestoreToArchive writes all files, directories and symlinks selected by
+// SelectFilter to w as a tar or zip archive instead of restoring them to the
+// file system. Other node types cannot be represented and are skipped. Items
+// are stored with their mode and modification time, and paths are relative
+// to the root of the snapshot. In tar archives, further occurrences of a
+// hardlinked file are stored as hardlinks to the first one.
+func (res *Restorer) RestoreToArchive(ctx context.Context, w io.Writer, format ArchiveFormat) error {
+	if format != ArchiveTar && format != ArchiveZip {
+		return errors.Errorf("unknown archive format %q", format)
+	}
+	if err := res.CheckCompatibility(); err != nil {
+		return err
+	}
+
+	d := dump.New(string(format), res.repo, w)
+	nodes := make(chan *restic.Node, 10)
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		defer close(nodes)
+
+		send := func(node *restic.Node, _, location string) error {
+			switch node.Type {
+			case "file", "dir", "symlink":
+			default:
+				res.warn(fmt.Sprintf("skipping %v %v, it cannot be stored in an archive", node.Type, location))
+				return nil
+			}
+			// the archive uses the path of the node as name
+			n := *node
+			n.Path = filepath.ToSlash(location)
+			select {
+			case nodes <- &n:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		root := string(filepath.Separator)
+		_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+			enterDir:  send,
+			visitNode: send,
+		})
+		return err
+	})
+	g.Go(func() error {
+		// an error cancels ctx, which stops the traversal
+		return d.DumpNodes(ctx, nodes)
+	})
+	return g.Wait()
+}
@@ -0,0 +1,89 @@
+package restorer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestoreToArchive(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Mode: 0750 | os.ModeDir, ModTime: modTime, Nodes: map[string]Node{
+				"file":     File{Data: "content: file\n", Mode: 0640, ModTime: modTime, Inode: 42, Links: 2},
+				"hardlink": File{Data: "content: file\n", Mode: 0640, ModTime: modTime, Inode: 42, Links: 2},
+			}},
+			"excluded": File{Data: "content: excluded\n"},
+			"link":     Symlink{Target: "dir/file", ModTime: modTime},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/excluded", true
+	}
+
+	var buf bytes.Buffer
+	rtest.OK(t, res.RestoreToArchive(context.TODO(), &buf, ArchiveTar))
+
+	type entry struct {
+		typeflag byte
+		mode     int64
+		linkname string
+		content  string
+	}
+	entries := make(map[string]entry)
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		content, err := io.ReadAll(tr)
+		rtest.OK(t, err)
+		rtest.Assert(t, hdr.ModTime.Equal(modTime), "wrong mtime %v for %v", hdr.ModTime, hdr.Name)
+		names = append(names, hdr.Name)
+		entries[hdr.Name] = entry{hdr.Typeflag, hdr.Mode & 0777, hdr.Linkname, string(content)}
+	}
+	rtest.Equals(t, []string{"dir/", "dir/file", "dir/hardlink", "link"}, names)
+	rtest.Equals(t, entry{tar.TypeDir, 0750, "", ""}, entries["dir/"])
+	rtest.Equals(t, entry{tar.TypeReg, 0640, "", "content: file\n"}, entries["dir/file"])
+	rtest.Equals(t, entry{tar.TypeLink, 0640, "dir/file", ""}, entries["dir/hardlink"])
+	rtest.Equals(t, byte(tar.TypeSymlink), entries["link"].typeflag)
+	rtest.Equals(t, "dir/file", entries["link"].linkname)
+
+	buf.Reset()
+	rtest.OK(t, res.RestoreToArchive(context.TODO(), &buf, ArchiveZip))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	rtest.OK(t, err)
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rd, err := f.Open()
+		rtest.OK(t, err)
+		data, err := io.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.OK(t, rd.Close())
+		contents[f.Name] = string(data)
+	}
+	rtest.Equals(t, map[string]string{
+		"dir/":         "",
+		"dir/file":     "content: file\n",
+		"dir/hardlink": "content: file\n",
+		"link":         "dir/file",
+	}, contents)
+
+	rtest.Assert(t, res.RestoreToArchive(context.TODO(), &buf, "rar") != nil, "unknown format accepted")
+}
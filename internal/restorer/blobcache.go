@@ -0,0 +1,133 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// BlobCache stores the data blobs loaded by the restorer, such that they can
+// be shared between restores, see Options.BlobCache. The methods may be
+// called concurrently. Failures to store or load blobs must not be reported,
+// the blob is then loaded from the repository instead.
+type BlobCache interface {
+	// Get returns the content of blob blobID from pack packID, if cached.
+	Get(packID restic.ID, blobID restic.ID) ([]byte, bool)
+	// Put stores the content of blob blobID from pack packID. buf must not
+	// be retained after Put returns.
+	Put(packID restic.ID, blobID restic.ID, buf []byte)
+}
+
+// wrapBlobCache returns a blobsLoaderFn which serves blobs from cache and
+// passes requests for missing blobs on to loader. Blobs loaded by loader are
+// added to the cache.
+func wrapBlobCache(cache BlobCache, loader blobsLoaderFn) blobsLoaderFn {
+	if cache == nil {
+		return loader
+	}
+	return func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		var missing []restic.Blob
+		for _, blob := range blobs {
+			buf, ok := cache.Get(packID, blob.ID)
+			if !ok {
+				missing = append(missing, blob)
+				continue
+			}
+			if err := handleBlobFn(blob.BlobHandle, buf, nil); err != nil {
+				return err
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+
+		return loader(ctx, packID, missing, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if err == nil {
+				cache.Put(packID, blob.ID, buf)
+			}
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+}
+
+// DiskBlobCache is a BlobCache which stores each blob as a file in a
+// directory, which can be shared by several processes. Blobs are stored
+// unencrypted and verified against their ID when they are loaded. The size
+// of the directory is not limited.
+type DiskBlobCache struct {
+	dir string
+}
+
+// NewDiskBlobCache returns a DiskBlobCache which stores the blobs in dir,
+// which is created if it does not exist yet.
+func NewDiskBlobCache(dir string) (*DiskBlobCache, error) {
+	if err := fs.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &DiskBlobCache{dir: dir}, nil
+}
+
+// filename returns the path of the file for blob id. As the content of a
+// data blob determines its ID, the pack it was loaded from does not matter.
+func (c *DiskBlobCache) filename(id restic.ID) string {
+	name := id.String()
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Get implements BlobCache. Files whose content does not match the blob ID
+// are removed.
+func (c *DiskBlobCache) Get(_ restic.ID, blobID restic.ID) ([]byte, bool) {
+	filename := c.filename(blobID)
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			debug.Log("reading cached blob %v failed: %v", blobID.Str(), err)
+		}
+		return nil, false
+	}
+	if restic.Hash(buf) != blobID {
+		debug.Log("cached blob %v is damaged, removing it", blobID.Str())
+		_ = fs.Remove(filename)
+		return nil, false
+	}
+	return buf, true
+}
+
+// Put implements BlobCache. The blob is written to a temporary file first,
+// such that other processes never read an incomplete blob.
+func (c *DiskBlobCache) Put(_ restic.ID, blobID restic.ID, buf []byte) {
+	filename := c.filename(blobID)
+	if _, err := fs.Lstat(filename); err == nil {
+		return
+	}
+	if err := c.put(filename, buf); err != nil {
+		debug.Log("caching blob %v failed: %v", blobID.Str(), err)
+	}
+}
+
+func (c *DiskBlobCache) put(filename string, buf []byte) error {
+	dir := filepath.Dir(filename)
+	if err := fs.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = fs.Remove(f.Name())
+	}
+	return err
+}
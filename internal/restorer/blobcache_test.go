@@ -0,0 +1,51 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerDiskBlobCache(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"bar": File{Data: "content: bar\n"},
+		},
+	}, noopGetGenericAttributes)
+	fooID := restic.Hash([]byte("content: foo\n"))
+	barID := restic.Hash([]byte("content: bar\n"))
+
+	cacheDir := filepath.Join(rtest.TempDir(t), "cache")
+	restore := func() map[restic.ID]int {
+		// each restore uses a separate cache instance, like another process
+		cache, err := NewDiskBlobCache(cacheDir)
+		rtest.OK(t, err)
+		countingRepo := &blobCountingRepo{Repository: repo, loaded: make(map[restic.ID]int)}
+		res := NewRestorer(countingRepo, sn, Options{BlobCache: cache})
+		tempdir := rtest.TempDir(t)
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+		_, err = res.VerifyFiles(context.TODO(), tempdir)
+		rtest.OK(t, err)
+		return countingRepo.loaded
+	}
+
+	rtest.Equals(t, map[restic.ID]int{fooID: 1, barID: 1}, restore())
+	// the blobs are served from the cache
+	rtest.Equals(t, map[restic.ID]int{}, restore())
+
+	// damaged blobs are loaded from the repository again
+	cache, err := NewDiskBlobCache(cacheDir)
+	rtest.OK(t, err)
+	rtest.OK(t, os.WriteFile(cache.filename(fooID), []byte("damaged"), 0600))
+	rtest.Equals(t, map[restic.ID]int{fooID: 1}, restore())
+	buf, ok := cache.Get(restic.ID{}, fooID)
+	rtest.Assert(t, ok, "blob was not cached again")
+	rtest.Equals(t, "content: foo\n", string(buf))
+}
@@ -0,0 +1,119 @@
+package restorer
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+type checkpointEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Content restic.ID `json:"content"`
+}
+
+// verifyCheckpoint records the files verified by VerifyFiles, one JSON object
+// per line. All methods are no-ops for a nil checkpoint.
+type verifyCheckpoint struct {
+	verified map[string]checkpointEntry
+
+	m  sync.Mutex
+	f  *os.File
+	wr *bufio.Writer
+}
+
+// openVerifyCheckpoint loads the entries from the checkpoint file at path and
+// opens it for appending. A missing file is created.
+func openVerifyCheckpoint(path string) (*verifyCheckpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	c := &verifyCheckpoint{verified: make(map[string]checkpointEntry)}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open checkpoint")
+	}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e checkpointEntry
+		// the last line may be incomplete if the previous run was killed
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		c.verified[e.Path] = e
+	}
+	if err := sc.Err(); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "read checkpoint")
+	}
+
+	c.f = f
+	c.wr = bufio.NewWriter(f)
+	return c, nil
+}
+
+// isVerified returns whether the file at path was verified in a previous run
+// and neither the file nor the node have changed since.
+func (c *verifyCheckpoint) isVerified(path string, node *restic.Node) bool {
+	if c == nil {
+		return false
+	}
+	e, ok := c.verified[path]
+	if !ok {
+		return false
+	}
+	fi, err := fs.Lstat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	return fi.Size() == e.Size && fi.ModTime().Equal(e.ModTime) &&
+		uint64(e.Size) == node.Size && e.Content.Equal(ContentHash(node.Content))
+}
+
+// add records that the file at path matches node.
+func (c *verifyCheckpoint) add(path string, node *restic.Node) error {
+	if c == nil {
+		return nil
+	}
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return errors.Wrap(err, "Lstat")
+	}
+	buf, err := json.Marshal(checkpointEntry{
+		Path:    path,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Content: ContentHash(node.Content),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	_, err = c.wr.Write(append(buf, '\n'))
+	return errors.Wrap(err, "write checkpoint")
+}
+
+// close flushes all recorded entries to the checkpoint file.
+func (c *verifyCheckpoint) close() error {
+	if c == nil {
+		return nil
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	err := c.wr.Flush()
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Wrap(err, "write checkpoint")
+}
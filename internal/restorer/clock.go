@@ -0,0 +1,15 @@
+package restorer
+
+import "time"
+
+// Clock provides the current time to the restorer.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock, it returns the system time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,117 @@
+package restorer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// caseCollisions detects items whose names only differ in case, which would
+// overwrite each other on a case-insensitive file system. The decisions made
+// in the first restore pass are recorded such that later passes map all
+// items in the same way.
+type caseCollisions struct {
+	dst      string
+	resolver func(location, existing string) string
+
+	probed      bool
+	insensitive bool
+
+	seen    map[string]string // lower case location -> location
+	renamed map[string]string // location -> new location, empty if skipped
+}
+
+func newCaseCollisions(dst string, resolver func(location, existing string) string) *caseCollisions {
+	return &caseCollisions{
+		dst:      dst,
+		resolver: resolver,
+		seen:     make(map[string]string),
+		renamed:  make(map[string]string),
+	}
+}
+
+// caseInsensitive returns whether dst is located on a case-insensitive file
+// system. The file system is only probed once.
+func (c *caseCollisions) caseInsensitive() bool {
+	if c.probed {
+		return c.insensitive
+	}
+	c.probed = true
+
+	f, err := os.CreateTemp(c.dst, ".restic-case-probe-")
+	if err != nil {
+		debug.Log("unable to probe case sensitivity of %v: %v", c.dst, err)
+		return false
+	}
+	name := f.Name()
+	_ = f.Close()
+	defer func() {
+		_ = fs.Remove(name)
+	}()
+
+	_, err = fs.Lstat(filepath.Join(c.dst, strings.ToUpper(filepath.Base(name))))
+	c.insensitive = err == nil
+	debug.Log("file system at %v is case-insensitive: %v", c.dst, c.insensitive)
+	return c.insensitive
+}
+
+// check must be called once for each item in the first pass. It returns the
+// target and location to use for the item. If ok is false, the item must be
+// skipped, err then describes the collision, unless it was already reported
+// for a parent directory.
+func (c *caseCollisions) check(target, location string) (newTarget, newLocation string, ok bool, err error) {
+	target, location, ok = c.resolve(target, location)
+	if !ok {
+		return target, location, false, nil
+	}
+
+	key := strings.ToLower(location)
+	existing, found := c.seen[key]
+	if !found || existing == location || !c.caseInsensitive() {
+		c.seen[key] = location
+		return target, location, true, nil
+	}
+
+	if c.resolver != nil {
+		if name := c.resolver(location, existing); name != "" {
+			renamed := filepath.Join(filepath.Dir(location), name)
+			if _, found := c.seen[strings.ToLower(renamed)]; !found {
+				debug.Log("restoring %v as %v", location, renamed)
+				c.renamed[location] = renamed
+				c.seen[strings.ToLower(renamed)] = renamed
+				return filepath.Join(c.dst, renamed), renamed, true, nil
+			}
+		}
+	}
+
+	c.renamed[location] = ""
+	return target, location, false, errors.Errorf("%v collides with %v on a case-insensitive file system", location, existing)
+}
+
+// resolve applies the decisions of the first pass for target and location.
+// If ok is false, the item was skipped.
+func (c *caseCollisions) resolve(target, location string) (newTarget, newLocation string, ok bool) {
+	if c == nil || len(c.renamed) == 0 {
+		return target, location, true
+	}
+
+	sep := string(filepath.Separator)
+	loc := sep
+	for _, name := range strings.Split(strings.TrimPrefix(location, sep), sep) {
+		loc = filepath.Join(loc, name)
+		if renamed, found := c.renamed[loc]; found {
+			if renamed == "" {
+				return target, location, false
+			}
+			loc = renamed
+		}
+	}
+	if loc == location {
+		return target, location, true
+	}
+	return filepath.Join(c.dst, loc), loc, true
+}
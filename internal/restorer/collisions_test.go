@@ -0,0 +1,132 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestCaseCollisions(t *testing.T) {
+	dst := filepath.FromSlash("/target")
+	loc := filepath.FromSlash
+
+	for _, test := range []struct {
+		resolver func(location, existing string) string
+		expected map[string]string // location -> new location, empty if skipped
+	}{
+		{
+			expected: map[string]string{
+				"/dir/file":       "/dir/file",
+				"/dir/File":       "",
+				"/dir/File/child": "",
+				"/Dir":            "",
+				"/Dir/file":       "",
+				"/other":          "/other",
+			},
+		},
+		{
+			resolver: func(location, _ string) string {
+				return filepath.Base(location) + ".1"
+			},
+			expected: map[string]string{
+				"/dir/file":       "/dir/file",
+				"/dir/File":       "/dir/File.1",
+				"/dir/File/child": "/dir/File.1/child",
+				"/Dir":            "/Dir.1",
+				"/Dir/file":       "/Dir.1/file",
+				"/other":          "/other",
+			},
+		},
+	} {
+		c := newCaseCollisions(dst, test.resolver)
+		// pretend to restore to a case-insensitive file system
+		c.probed = true
+		c.insensitive = true
+
+		// first pass
+		for _, location := range []string{"/dir", "/dir/file", "/dir/File", "/dir/File/child", "/Dir", "/Dir/file", "/other"} {
+			expected, ok := test.expected[location]
+			target, newLocation, ok2, err := c.check(filepath.Join(dst, loc(location)), loc(location))
+			if location == "/dir" {
+				rtest.Assert(t, ok2 && err == nil, "unexpected collision for %v: %v", location, err)
+				continue
+			}
+			rtest.Assert(t, ok, "missing expectation for %v", location)
+			if expected == "" {
+				rtest.Assert(t, !ok2, "expected %v to be skipped", location)
+				// only report the collision for the item itself, not its children
+				rtest.Equals(t, !strings.HasSuffix(location, "/child") && location != "/Dir/file", err != nil)
+				continue
+			}
+			rtest.OK(t, err)
+			rtest.Assert(t, ok2, "expected %v to be restored", location)
+			rtest.Equals(t, loc(expected), newLocation)
+			rtest.Equals(t, filepath.Join(dst, loc(expected)), target)
+		}
+
+		// later passes must map items in the same way
+		for location, expected := range test.expected {
+			_, newLocation, ok := c.resolve(filepath.Join(dst, loc(location)), loc(location))
+			rtest.Equals(t, expected != "", ok)
+			if ok {
+				rtest.Equals(t, loc(expected), newLocation)
+			}
+		}
+	}
+}
+
+func isCaseInsensitive(t *testing.T, dir string) bool {
+	rtest.OK(t, os.WriteFile(filepath.Join(dir, "probe"), nil, 0600))
+	_, err := os.Stat(filepath.Join(dir, "PROBE"))
+	rtest.OK(t, os.Remove(filepath.Join(dir, "probe")))
+	return err == nil
+}
+
+func TestRestorerCaseCollisions(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	if !isCaseInsensitive(t, tempdir) {
+		t.Skip("requires a case-insensitive file system")
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"File": File{Data: "content: File\n"},
+			"file": File{Data: "content: file\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := NewRestorer(repo, sn, Options{})
+	var collisions []string
+	res.Error = func(location string, _ error) error {
+		collisions = append(collisions, location)
+		return nil
+	}
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.Equals(t, []string{string(filepath.Separator) + "file"}, collisions)
+
+	data, err := os.ReadFile(filepath.Join(tempdir, "File"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: File\n", string(data))
+
+	res = NewRestorer(repo, sn, Options{
+		ConflictResolver: func(location, _ string) string {
+			return filepath.Base(location) + ".1"
+		},
+	})
+	tempdir = rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	for name, content := range map[string]string{"File": "content: File\n", "file.1": "content: file\n"} {
+		data, err := os.ReadFile(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+}
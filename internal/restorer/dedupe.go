@@ -0,0 +1,122 @@
+package restorer
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// dedupeKey identifies the files which are hardlinked with each other by
+// Options.DedupeByContent. As hardlinks share their metadata, it must match
+// in addition to the content, and the files must be on the same device.
+type dedupeKey struct {
+	content  restic.ID
+	metadata restic.ID
+	device   uint64
+}
+
+// dedupePlan tracks which files of a restore are hardlinked to a file with
+// the same content. All methods are no-ops for a nil dedupePlan.
+type dedupePlan struct {
+	skip func(location string, node *restic.Node) bool
+	// the first restored file for each key, by location
+	sources map[dedupeKey]string
+	// files which are hardlinked instead of restored, to the location of the
+	// first file
+	links map[string]string
+	// devices of the parent directories of restored files
+	devices map[string]uint64
+}
+
+func (res *Restorer) newDedupePlan() *dedupePlan {
+	if !res.opts.DedupeByContent || res.opts.ContentTransform != nil || res.opts.TextConvert != nil {
+		// transformed files do not necessarily have the same content
+		return nil
+	}
+	return &dedupePlan{
+		skip:    res.opts.DedupeSkip,
+		sources: make(map[dedupeKey]string),
+		links:   make(map[string]string),
+		devices: make(map[string]uint64),
+	}
+}
+
+// key returns the key of node restored to target. ok is false if the file
+// cannot be deduplicated.
+func (p *dedupePlan) key(node *restic.Node, target, location string) (key dedupeKey, ok bool) {
+	if p == nil || len(node.Content) == 0 || node.Links > 1 {
+		// hardlinks from the snapshot are restored as such
+		return dedupeKey{}, false
+	}
+	if p.skip != nil && p.skip(location, node) {
+		return dedupeKey{}, false
+	}
+
+	metadata, err := json.Marshal(struct {
+		Mode               uint32
+		ModTime            time.Time
+		UID, GID           uint32
+		User, Group        string
+		ExtendedAttributes []restic.ExtendedAttribute
+		GenericAttributes  map[restic.GenericAttributeType]json.RawMessage
+	}{uint32(node.Mode), node.ModTime, node.UID, node.GID, node.User, node.Group, node.ExtendedAttributes, node.GenericAttributes})
+	if err != nil {
+		return dedupeKey{}, false
+	}
+
+	dir := filepath.Dir(target)
+	device, found := p.devices[dir]
+	if !found {
+		fi, err := fs.Lstat(dir)
+		if err != nil {
+			return dedupeKey{}, false
+		}
+		// device IDs are not available on Windows, where hardlinks fail
+		// across volumes instead
+		device, _ = fs.DeviceID(fi)
+		p.devices[dir] = device
+	}
+	return dedupeKey{content: contentKey(node.Content), metadata: restic.Hash(metadata), device: device}, true
+}
+
+// plan returns whether the file at location can be hardlinked to a file with
+// the same content which is restored earlier.
+func (p *dedupePlan) plan(node *restic.Node, target, location string) bool {
+	key, ok := p.key(node, target, location)
+	if !ok {
+		return false
+	}
+	first, ok := p.sources[key]
+	if !ok {
+		return false
+	}
+	debug.Log("linking %v to %v with the same content", location, first)
+	p.links[location] = first
+	return true
+}
+
+// addSource records the file at location, which is restored or already has
+// the content of node, as the target of hardlinks for further files.
+func (p *dedupePlan) addSource(node *restic.Node, target, location string) {
+	key, ok := p.key(node, target, location)
+	if !ok {
+		return
+	}
+	if _, ok := p.sources[key]; !ok {
+		p.sources[key] = location
+	}
+}
+
+// source returns the location of the file to which the file at location is
+// hardlinked, if any.
+func (p *dedupePlan) source(location string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	first, ok := p.links[location]
+	return first, ok
+}
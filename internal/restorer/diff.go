@@ -0,0 +1,213 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DiffKind describes how an item differs between two trees.
+type DiffKind int
+
+const (
+	// DiffAdded is an item which only exists in the new tree.
+	DiffAdded DiffKind = iota
+	// DiffRemoved is an item which only exists in the old tree.
+	DiffRemoved
+	// DiffModified is an item whose content changed, for example the data of
+	// a file or the target of a symlink. Its metadata may have changed, too.
+	DiffModified
+	// DiffMetadata is an item whose content is unchanged but whose metadata,
+	// like the mode, ownership or timestamps, changed.
+	DiffMetadata
+	// DiffTypeChanged is an item which was replaced by one of another type.
+	// The children of a replaced directory are reported as removed, those of
+	// a new directory as added.
+	DiffTypeChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	case DiffMetadata:
+		return "metadata"
+	case DiffTypeChanged:
+		return "type-changed"
+	default:
+		return "unknown"
+	}
+}
+
+// DiffEntry is a single difference reported by DiffTrees.
+type DiffEntry struct {
+	Path string // location of the item within the trees
+	Kind DiffKind
+	Old  *restic.Node // nil for DiffAdded
+	New  *restic.Node // nil for DiffRemoved
+}
+
+// DiffTrees compares the trees treeA and treeB and calls fn for each item
+// which differs. Both trees are walked in lockstep, one directory level at a
+// time, thus the complete difference is never kept in memory. Entries are
+// reported depth-first, sorted by name within each directory, and a
+// directory is reported before its children. If a directory is added or
+// removed, all of its children are reported as well. Subtrees with identical IDs are skipped without loading them. The
+// walk stops at the first error returned by fn.
+func DiffTrees(ctx context.Context, repo restic.BlobLoader, treeA, treeB restic.ID, fn func(change DiffEntry) error) error {
+	return diffTrees(ctx, repo, string(filepath.Separator), treeA, treeB, fn)
+}
+
+func diffTrees(ctx context.Context, repo restic.BlobLoader, location string, treeA, treeB restic.ID, fn func(change DiffEntry) error) error {
+	if treeA.Equal(treeB) {
+		return nil
+	}
+	debug.Log("diff %v: %v %v", location, treeA.Str(), treeB.Str())
+
+	a, err := restic.LoadTree(ctx, repo, treeA)
+	if err != nil {
+		return errors.Wrapf(err, "load tree %v", treeA.Str())
+	}
+	b, err := restic.LoadTree(ctx, repo, treeB)
+	if err != nil {
+		return errors.Wrapf(err, "load tree %v", treeB.Str())
+	}
+
+	// the nodes of a tree are sorted by name
+	i, j := 0, 0
+	for i < len(a.Nodes) || j < len(b.Nodes) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		switch {
+		case j == len(b.Nodes) || (i < len(a.Nodes) && a.Nodes[i].Name < b.Nodes[j].Name):
+			err = diffAll(ctx, repo, filepath.Join(location, a.Nodes[i].Name), a.Nodes[i], DiffRemoved, fn)
+			i++
+		case i == len(a.Nodes) || b.Nodes[j].Name < a.Nodes[i].Name:
+			err = diffAll(ctx, repo, filepath.Join(location, b.Nodes[j].Name), b.Nodes[j], DiffAdded, fn)
+			j++
+		default:
+			err = diffNodes(ctx, repo, filepath.Join(location, a.Nodes[i].Name), a.Nodes[i], b.Nodes[j], fn)
+			i++
+			j++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffNodes compares two nodes with the same location.
+func diffNodes(ctx context.Context, repo restic.BlobLoader, location string, oldNode, newNode *restic.Node, fn func(change DiffEntry) error) error {
+	if oldNode.Type != newNode.Type {
+		if err := fn(DiffEntry{Path: location, Kind: DiffTypeChanged, Old: oldNode, New: newNode}); err != nil {
+			return err
+		}
+		if err := diffChildren(ctx, repo, location, oldNode, DiffRemoved, fn); err != nil {
+			return err
+		}
+		return diffChildren(ctx, repo, location, newNode, DiffAdded, fn)
+	}
+
+	if oldNode.Type == "dir" {
+		if oldNode.Subtree == nil || newNode.Subtree == nil {
+			return errors.Errorf("dir %v without subtree", location)
+		}
+		if !sameMetadata(oldNode, newNode) {
+			if err := fn(DiffEntry{Path: location, Kind: DiffMetadata, Old: oldNode, New: newNode}); err != nil {
+				return err
+			}
+		}
+		return diffTrees(ctx, repo, location, *oldNode.Subtree, *newNode.Subtree, fn)
+	}
+
+	if !sameContent(oldNode, newNode) {
+		return fn(DiffEntry{Path: location, Kind: DiffModified, Old: oldNode, New: newNode})
+	}
+	if !sameMetadata(oldNode, newNode) {
+		return fn(DiffEntry{Path: location, Kind: DiffMetadata, Old: oldNode, New: newNode})
+	}
+	return nil
+}
+
+// diffAll reports node and, for a directory, all its children as kind, which
+// must be either DiffAdded or DiffRemoved.
+func diffAll(ctx context.Context, repo restic.BlobLoader, location string, node *restic.Node, kind DiffKind, fn func(change DiffEntry) error) error {
+	if err := fn(newDiffEntry(location, node, kind)); err != nil {
+		return err
+	}
+	return diffChildren(ctx, repo, location, node, kind, fn)
+}
+
+// diffChildren reports all children of node as kind if node is a directory.
+func diffChildren(ctx context.Context, repo restic.BlobLoader, location string, node *restic.Node, kind DiffKind, fn func(change DiffEntry) error) error {
+	if node.Type != "dir" {
+		return nil
+	}
+	if node.Subtree == nil {
+		return errors.Errorf("dir %v without subtree", location)
+	}
+
+	// use location as target, as WalkTree starts the locations at the root
+	report := func(node *restic.Node, target, _ string) error {
+		return fn(newDiffEntry(target, node, kind))
+	}
+	return WalkTree(ctx, repo, location, *node.Subtree, TreeVisitor{
+		EnterDir:  report,
+		VisitNode: report,
+	})
+}
+
+func newDiffEntry(location string, node *restic.Node, kind DiffKind) DiffEntry {
+	if kind == DiffAdded {
+		return DiffEntry{Path: location, Kind: kind, New: node}
+	}
+	return DiffEntry{Path: location, Kind: kind, Old: node}
+}
+
+// sameContent returns whether the content of two nodes of the same type
+// matches.
+func sameContent(a, b *restic.Node) bool {
+	if a.Size != b.Size || a.LinkTarget != b.LinkTarget || a.Device != b.Device {
+		return false
+	}
+	if len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !a.Content[i].Equal(b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameMetadata returns whether two nodes with the same content have the same
+// metadata. Only metadata which is restored is compared.
+func sameMetadata(a, b *restic.Node) bool {
+	x, y := restoredMetadata(a), restoredMetadata(b)
+	x.Subtree, y.Subtree = nil, nil
+	return x.Equals(y)
+}
+
+// restoredMetadata returns a copy of node without the metadata which is not
+// restored, that is the access and change time as well as the inode. The
+// access time changes whenever an item is read, for example while creating
+// a snapshot.
+func restoredMetadata(node *restic.Node) restic.Node {
+	n := *node
+	n.AccessTime, n.ChangeTime = time.Time{}, time.Time{}
+	n.Inode, n.DeviceID = 0, 0
+	return n
+}
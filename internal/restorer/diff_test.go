@@ -0,0 +1,77 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestDiffTrees(t *testing.T) {
+	baseTime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	oldSn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"modified":  File{Data: "content: old\n", ModTime: baseTime},
+				"unchanged": File{Data: "content: unchanged\n", ModTime: baseTime},
+				"removed":   File{Data: "content: removed\n", ModTime: baseTime},
+			}},
+			"mode":     File{Data: "content: mode\n", Mode: 0600, ModTime: baseTime},
+			"retyped":  File{Data: "content: retyped\n", ModTime: baseTime},
+			"same":     Dir{ModTime: baseTime, Nodes: map[string]Node{"file": File{Data: "x", ModTime: baseTime}}},
+			"touched":  Dir{ModTime: baseTime},
+			"zremoved": Dir{ModTime: baseTime, Nodes: map[string]Node{"file": File{Data: "x", ModTime: baseTime}}},
+		},
+	}, noopGetGenericAttributes)
+	newSn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"added": Dir{ModTime: baseTime, Nodes: map[string]Node{"file": File{Data: "content: added\n", ModTime: baseTime}}},
+			"dir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"modified":  File{Data: "content: new\n", ModTime: baseTime},
+				"unchanged": File{Data: "content: unchanged\n", ModTime: baseTime},
+			}},
+			"mode":    File{Data: "content: mode\n", Mode: 0644, ModTime: baseTime},
+			"retyped": Dir{ModTime: baseTime, Nodes: map[string]Node{"child": Symlink{Target: "foo", ModTime: baseTime}}},
+			"same":    Dir{ModTime: baseTime, Nodes: map[string]Node{"file": File{Data: "x", ModTime: baseTime}}},
+			"touched": Dir{ModTime: baseTime.Add(time.Hour)},
+		},
+	}, noopGetGenericAttributes)
+
+	type change struct {
+		Path string
+		Kind DiffKind
+	}
+	var changes []change
+	err := DiffTrees(context.TODO(), repo, *oldSn.Tree, *newSn.Tree, func(entry DiffEntry) error {
+		rtest.Assert(t, (entry.Old == nil) == (entry.Kind == DiffAdded), "unexpected old node for %v", entry.Path)
+		rtest.Assert(t, (entry.New == nil) == (entry.Kind == DiffRemoved), "unexpected new node for %v", entry.Path)
+		changes = append(changes, change{entry.Path, entry.Kind})
+		return nil
+	})
+	rtest.OK(t, err)
+
+	loc := filepath.FromSlash
+	rtest.Equals(t, []change{
+		{loc("/added"), DiffAdded},
+		{loc("/added/file"), DiffAdded},
+		{loc("/dir/modified"), DiffModified},
+		{loc("/dir/removed"), DiffRemoved},
+		{loc("/mode"), DiffMetadata},
+		{loc("/retyped"), DiffTypeChanged},
+		{loc("/retyped/child"), DiffAdded},
+		{loc("/touched"), DiffMetadata},
+		{loc("/zremoved"), DiffRemoved},
+		{loc("/zremoved/file"), DiffRemoved},
+	}, changes)
+
+	// identical trees have no differences
+	err = DiffTrees(context.TODO(), repo, *oldSn.Tree, *oldSn.Tree, func(entry DiffEntry) error {
+		t.Errorf("unexpected change %v", entry.Path)
+		return nil
+	})
+	rtest.OK(t, err)
+}
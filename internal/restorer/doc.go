@@ -21,4 +21,13 @@
 // file before any of the preceding file blobs. It is therefore possible to
 // have gaps in the data written to the target files if restore fails or
 // interrupted by the user.
+//
+// Besides files, directories and symlinks, the Restorer also recreates named
+// pipes and device nodes. Creating device nodes usually requires root
+// privileges, failures are reported via Restorer.Error. Sockets are skipped
+// with a warning, as they are only meaningful while a process listens on them.
+//
+// Ownership is restored from the numeric user and group IDs of the snapshot.
+// If Options.UserLookup or Options.GroupLookup resolves the name recorded in
+// a node, the resulting ID takes precedence over the numeric one.
 package restorer
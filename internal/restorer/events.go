@@ -0,0 +1,177 @@
+package restorer
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// restoreEvent is a single entry of the event stream written to
+// Options.EventWriter. Each event is serialized as one line of JSON.
+type restoreEvent struct {
+	Action string    `json:"action"` // "start", "restored", "updated", "skipped", "error" or "summary"
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path,omitempty"`
+	Size   uint64    `json:"size,omitempty"`
+	Error  string    `json:"error,omitempty"`
+
+	// only set for the summary
+	FilesRestored *uint64 `json:"files_restored,omitempty"`
+	FilesUpdated  *uint64 `json:"files_updated,omitempty"`
+	FilesSkipped  *uint64 `json:"files_skipped,omitempty"`
+	DirsRestored  *uint64 `json:"dirs_restored,omitempty"`
+	DirsSkipped   *uint64 `json:"dirs_skipped,omitempty"`
+	Errors        *uint64 `json:"errors,omitempty"`
+	BytesRestored *uint64 `json:"bytes_restored,omitempty"`
+}
+
+// eventWriter writes restore events to an io.Writer. It is safe for
+// concurrent use, each event is written with a single call to Write. If the
+// writer is nil, the events are only counted. All methods are no-ops for a
+// nil eventWriter.
+type eventWriter struct {
+	m     sync.Mutex
+	wr    io.Writer
+	clock Clock
+
+	stats RestoreStats
+}
+
+func newEventWriter(wr io.Writer, clock Clock) *eventWriter {
+	return &eventWriter{wr: wr, clock: clock}
+}
+
+func (e *eventWriter) write(ev restoreEvent) {
+	if e.wr == nil {
+		return
+	}
+	ev.Time = e.clock.Now()
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		debug.Log("unable to marshal event %v: %v", ev, err)
+		return
+	}
+	buf = append(buf, '\n')
+
+	if _, err := e.wr.Write(buf); err != nil {
+		debug.Log("unable to write event: %v", err)
+	}
+}
+
+func (e *eventWriter) start(dst string) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats = RestoreStats{}
+	e.write(restoreEvent{Action: "start", Path: dst})
+}
+
+// restored records that the item at location was restored completely.
+func (e *eventWriter) restored(location string, size uint64) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats.FilesRestored++
+	e.stats.BytesRestored += size
+	e.write(restoreEvent{Action: "restored", Path: location, Size: size})
+}
+
+// updated records that only the metadata of the item at location was restored.
+func (e *eventWriter) updated(location string, size uint64) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats.FilesUpdated++
+	e.write(restoreEvent{Action: "updated", Path: location, Size: size})
+}
+
+func (e *eventWriter) skipped(location string, size uint64) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats.FilesSkipped++
+	e.write(restoreEvent{Action: "skipped", Path: location, Size: size})
+}
+
+// dirRestored records that the directory at location was created or its
+// metadata restored. Unlike restored, it does not count towards the restored
+// files.
+func (e *eventWriter) dirRestored(location string) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats.DirsRestored++
+	e.write(restoreEvent{Action: "restored", Path: location})
+}
+
+// dirSkipped records that the directory at location was left untouched.
+// Unlike skipped, it does not count towards the skipped files.
+func (e *eventWriter) dirSkipped(location string) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats.DirsSkipped++
+	e.write(restoreEvent{Action: "skipped", Path: location})
+}
+
+func (e *eventWriter) error(location string, err error) {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.stats.Errors++
+	e.write(restoreEvent{Action: "error", Path: location, Error: err.Error()})
+}
+
+func (e *eventWriter) summary() {
+	if e == nil {
+		return
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.write(restoreEvent{
+		Action:        "summary",
+		FilesRestored: &e.stats.FilesRestored,
+		FilesUpdated:  &e.stats.FilesUpdated,
+		FilesSkipped:  &e.stats.FilesSkipped,
+		DirsRestored:  &e.stats.DirsRestored,
+		DirsSkipped:   &e.stats.DirsSkipped,
+		Errors:        &e.stats.Errors,
+		BytesRestored: &e.stats.BytesRestored,
+	})
+}
+
+// summaryStats returns the statistics of the current restore.
+func (e *eventWriter) summaryStats() RestoreStats {
+	if e == nil {
+		return RestoreStats{}
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return e.stats
+}
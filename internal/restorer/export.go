@@ -0,0 +1,65 @@
+package restorer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// MetadataEntry is the metadata of a single node written by ExportMetadata.
+type MetadataEntry struct {
+	Path        string      `json:"path"` // location within the snapshot, separated by slashes
+	Type        string      `json:"type"`
+	Size        *uint64     `json:"size,omitempty"` // only set for files
+	Mode        os.FileMode `json:"mode"`
+	Permissions string      `json:"permissions"`
+	UID         uint32      `json:"uid"`
+	GID         uint32      `json:"gid"`
+	User        string      `json:"user,omitempty"`
+	Group       string      `json:"group,omitempty"`
+	ModTime     time.Time   `json:"mtime"`
+	LinkTarget  string      `json:"link_target,omitempty"`
+}
+
+// ExportMetadata writes the metadata of all nodes selected by SelectFilter
+// to w as newline-delimited JSON, one MetadataEntry per line. Nodes are
+// written in traversal order, a directory before its children. Only the
+// trees are loaded, no file content is read and nothing is restored.
+func (res *Restorer) ExportMetadata(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	export := func(node *restic.Node, _, location string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry := MetadataEntry{
+			Path:        filepath.ToSlash(location),
+			Type:        node.Type,
+			Mode:        node.Mode,
+			Permissions: node.Mode.String(),
+			UID:         node.UID,
+			GID:         node.GID,
+			User:        node.User,
+			Group:       node.Group,
+			ModTime:     node.ModTime,
+			LinkTarget:  node.LinkTarget,
+		}
+		if node.Type == "file" {
+			size := node.Size
+			entry.Size = &size
+		}
+		return errors.WithStack(enc.Encode(entry))
+	}
+
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		enterDir:  export,
+		visitNode: export,
+	})
+	return err
+}
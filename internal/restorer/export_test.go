@@ -0,0 +1,62 @@
+package restorer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerExportMetadata(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: timeForTest, Nodes: map[string]Node{
+				"file": File{Data: "content: file\n", Mode: 0640, ModTime: timeForTest},
+				"link": Symlink{Target: "file", ModTime: timeForTest},
+			}},
+			"excluded": File{Data: "content: excluded\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/excluded", true
+	}
+
+	var buf bytes.Buffer
+	rtest.OK(t, res.ExportMetadata(context.TODO(), &buf))
+
+	var entries []MetadataEntry
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry MetadataEntry
+		rtest.OK(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	rtest.OK(t, scanner.Err())
+
+	rtest.Equals(t, 3, len(entries))
+	rtest.Equals(t, "/dir", entries[0].Path)
+	rtest.Equals(t, "dir", entries[0].Type)
+	rtest.Assert(t, entries[0].Size == nil, "unexpected size for directory")
+
+	file := entries[1]
+	rtest.Equals(t, "/dir/file", file.Path)
+	rtest.Equals(t, "file", file.Type)
+	rtest.Equals(t, uint64(len("content: file\n")), *file.Size)
+	rtest.Equals(t, "-rw-r-----", file.Permissions)
+	rtest.Assert(t, file.ModTime.Equal(timeForTest), "unexpected mtime %v", file.ModTime)
+
+	rtest.Equals(t, "/dir/link", entries[2].Path)
+	rtest.Equals(t, "symlink", entries[2].Type)
+	rtest.Equals(t, "file", entries[2].LinkTarget)
+}
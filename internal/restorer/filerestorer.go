@@ -2,13 +2,20 @@ package restorer
 
 import (
 	"context"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/restore"
@@ -27,6 +34,17 @@ type fileInfo struct {
 	location   string      // file on local filesystem relative to restorer basedir
 	blobs      interface{} // blobs of the file
 	state      *fileState
+	pending    int // number of blob writes which have not completed yet, protected by lock
+	priority   int // files are restored in order of ascending priority
+	// existed is set if the file already existed before it was first
+	// written to, protected by lock
+	existed bool
+
+	// ctx expires once the content of the file did not complete within the
+	// per file timeout, it is created when the first pack of the file is
+	// downloaded. Both fields are protected by lock.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type fileBlobInfo struct {
@@ -56,6 +74,57 @@ type fileRestorer struct {
 	dst   string
 	files []*fileInfo
 	Error func(string, error) error
+
+	// zeroFillMissing replaces blobs which cannot be loaded with zeros
+	zeroFillMissing bool
+	damagedLock     sync.Mutex
+	damaged         map[string][]DamagedRange
+
+	// atomicReplace writes files to a temporary file first, see writePath
+	atomicReplace bool
+	// tempDir holds the temporary files instead of the target directories
+	tempDir    string
+	failedLock sync.Mutex
+	failed     map[string]struct{}
+
+	// verifyOnWrite checks the hash of each blob before writing it and
+	// removes partially written files if restoring them failed
+	verifyOnWrite bool
+
+	// cleanupOnCancel removes incompletely written files if the restore is
+	// cancelled
+	cleanupOnCancel bool
+
+	// perFileTimeout abandons files whose content is not complete within
+	// this duration after their first pack started downloading
+	perFileTimeout time.Duration
+	timedOut       map[string]struct{} // protected by failedLock
+
+	metrics *restoreMetrics
+
+	// memory limits the size of the blobs which are loaded concurrently to
+	// memoryLimit bytes
+	memory      *semaphore.Weighted
+	memoryLimit int64
+
+	// emptyFiles counts the files without content, which are created
+	// without loading any blobs
+	emptyFiles int
+
+	// trackCompleted records the files whose content was written completely
+	// in completed, such that they can be finished if the restore stops at
+	// its deadline
+	trackCompleted bool
+	completedLock  sync.Mutex
+	completed      []string
+}
+
+// DamagedRange is a part of a restored file which was filled with zeros as the
+// corresponding blob could not be loaded.
+type DamagedRange struct {
+	Offset int64
+	Length int64
+	Err    error
 }
 
 func newFileRestorer(dst string,
@@ -78,17 +147,186 @@ func newFileRestorer(dst string,
 		workerCount: workerCount,
 		dst:         dst,
 		Error:       restorerAbortOnAllErrors,
+		damaged:     make(map[string][]DamagedRange),
+		failed:      make(map[string]struct{}),
+		timedOut:    make(map[string]struct{}),
+	}
+}
+
+// countFetchedBlobs returns a blobsLoaderFn which reports all blobs and packs
+// loaded by loader to progress. It must wrap the loader which accesses the
+// repository.
+func countFetchedBlobs(loader blobsLoaderFn, progress *restore.Progress) blobsLoaderFn {
+	if progress == nil {
+		return loader
+	}
+	return func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		lengths := make(map[restic.BlobHandle]uint, len(blobs))
+		for _, blob := range blobs {
+			lengths[blob.BlobHandle] = blob.Length
+		}
+		var bytesFetched uint64
+		defer func() {
+			progress.AddFetchedPack(bytesFetched)
+		}()
+		return loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if err == nil {
+				progress.AddFetchedBlob(uint64(len(buf)))
+				bytesFetched += uint64(lengths[blob])
+			}
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+}
+
+// retryLoads returns a blobsLoaderFn which retries loading the blobs which
+// were not passed to handleBlobFn yet if loader fails, at most retries times.
+// Errors for single blobs are retried as well, as the repository falls back
+// to loading blobs one by one if a pack cannot be loaded and only passes the
+// resulting errors to handleBlobFn. They are only passed on to handleBlobFn
+// once all retries are exhausted. The delay before the first retry is backoff
+// and doubles for each further retry. Blobs which are damaged, that is which
+// fail to decrypt or do not match their ID, are not retried. Neither are
+// errors returned by handleBlobFn.
+func retryLoads(loader blobsLoaderFn, retries int, backoff time.Duration) blobsLoaderFn {
+	if retries <= 0 {
+		return loader
+	}
+	return func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		handled := restic.NewBlobSet()
+		for attempt := 0; ; attempt++ {
+			var handlerErr, blobErr error
+			err := loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+				var damaged *repository.DamagedBlobError
+				if err != nil && attempt < retries && !errors.As(err, &damaged) {
+					// retried below
+					blobErr = err
+					return nil
+				}
+				handled.Insert(blob)
+				handlerErr = handleBlobFn(blob, buf, err)
+				return handlerErr
+			})
+			if handlerErr != nil || attempt >= retries || (err == nil && blobErr == nil) {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == nil {
+				err = blobErr
+			}
+
+			var remaining []restic.Blob
+			for _, blob := range blobs {
+				if !handled.Has(blob.BlobHandle) {
+					remaining = append(remaining, blob)
+				}
+			}
+			if len(remaining) == 0 {
+				return nil
+			}
+			blobs = remaining
+
+			delay := backoff << attempt
+			debug.Log("loading pack %v failed, retrying %d blobs in %v: %v", packID.Str(), len(blobs), delay, err)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
 	}
 }
 
-func (r *fileRestorer) addFile(location string, content restic.IDs, size int64, state *fileState) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, state: state})
+func (r *fileRestorer) addFile(location string, content restic.IDs, size int64, state *fileState, priority int) {
+	r.files = append(r.files, &fileInfo{location: location, blobs: content, size: size, state: state, priority: priority})
 }
 
 func (r *fileRestorer) targetPath(location string) string {
 	return filepath.Join(r.dst, location)
 }
 
+// writePath returns the path the content of the file at location is written
+// to. With atomicReplace, this is a temporary file next to the target path.
+func (r *fileRestorer) writePath(location string) string {
+	if r.atomicReplace && r.tempDir != "" {
+		// the name must be unique within the flat temporary directory
+		return filepath.Join(r.tempDir, "."+restic.Hash([]byte(location)).String()+".restic-tmp")
+	}
+	if r.atomicReplace {
+		return tempPath(r.targetPath(location))
+	}
+	return r.targetPath(location)
+}
+
+// tempPath returns the name of the temporary file used to replace path.
+func tempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".restic-tmp")
+}
+
+// checkTempDir returns an error unless tempDir, an absolute path, is an
+// existing directory on the same file system as dst, such that temporary
+// files can be renamed to their targets. dst does not have to exist yet.
+func checkTempDir(tempDir, dst string) error {
+	fi, err := fs.Stat(tempDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("%v is not a directory", tempDir)
+	}
+
+	dir := dst
+	dstInfo, err := fs.Stat(dir)
+	for errors.Is(err, os.ErrNotExist) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+		dstInfo, err = fs.Stat(dir)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if runtime.GOOS == "windows" {
+		// device IDs are not available, a volume is a single file system
+		if !strings.EqualFold(filepath.VolumeName(tempDir), filepath.VolumeName(dir)) {
+			return errors.Errorf("%v is not on the same volume as %v", tempDir, dst)
+		}
+		return nil
+	}
+	tempDevice, err := fs.DeviceID(fi)
+	if err != nil {
+		return err
+	}
+	dstDevice, err := fs.DeviceID(dstInfo)
+	if err != nil {
+		return err
+	}
+	if tempDevice != dstDevice {
+		return errors.Errorf("%v is not on the same file system as %v", tempDir, dst)
+	}
+	return nil
+}
+
+// hasTimedOut returns whether the file at location was abandoned as its
+// content did not complete within the per file timeout.
+func (r *fileRestorer) hasTimedOut(location string) bool {
+	r.failedLock.Lock()
+	defer r.failedLock.Unlock()
+	_, ok := r.timedOut[location]
+	return ok
+}
+
+// hasFailed returns whether an error was reported for the file at location.
+func (r *fileRestorer) hasFailed(location string) bool {
+	r.failedLock.Lock()
+	defer r.failedLock.Unlock()
+	_, ok := r.failed[location]
+	return ok
+}
+
 func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID, packBlob restic.Blob, idx int)) error {
 	if len(blobIDs) == 0 {
 		return nil
@@ -106,7 +344,59 @@ func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID
 }
 
 func (r *fileRestorer) restoreFiles(ctx context.Context) error {
+	// drop no longer necessary file list
+	files := r.files
+	r.files = nil
+
+	var err error
+	for _, bucket := range priorityBuckets(files) {
+		if err = r.restoreBucket(ctx, bucket); err != nil {
+			break
+		}
+	}
+
+	if r.perFileTimeout > 0 {
+		if errTimeout := r.finishTimeouts(files); err == nil {
+			err = errTimeout
+		}
+	}
+	// only files which were not restored completely can still be open
+	r.filesWriter.closeAll()
+	if r.perFileTimeout > 0 {
+		r.removeTimedOutFiles(files)
+	}
+	if r.verifyOnWrite {
+		r.removePartialFiles(files)
+	}
+	if (r.cleanupOnCancel || errors.Is(ctx.Err(), context.DeadlineExceeded)) && ctx.Err() != nil {
+		// files which are present after the deadline must be complete
+		r.removeIncompleteFiles(files)
+	}
+	return err
+}
+
+// priorityBuckets groups files by ascending priority. The files of each
+// bucket keep their order.
+func priorityBuckets(files []*fileInfo) [][]*fileInfo {
+	sorted := make([]*fileInfo, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].priority < sorted[j].priority
+	})
 
+	var buckets [][]*fileInfo
+	for i, file := range sorted {
+		if i == 0 || file.priority != sorted[i-1].priority {
+			buckets = append(buckets, nil)
+		}
+		buckets[len(buckets)-1] = append(buckets[len(buckets)-1], file)
+	}
+	return buckets
+}
+
+// restoreBucket restores the content of files and returns once all their
+// packs have been processed.
+func (r *fileRestorer) restoreBucket(ctx context.Context, files []*fileInfo) error {
 	packs := make(map[restic.ID]*packInfo) // all packs
 	// Process packs in order of first access. While this cannot guarantee
 	// that file chunks are restored sequentially, it offers a good enough
@@ -114,7 +404,7 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 	var packOrder restic.IDs
 
 	// create packInfo from fileInfo
-	for _, file := range r.files {
+	for _, file := range files {
 		fileBlobs := file.blobs.(restic.IDs)
 		if len(fileBlobs) == 0 {
 			err := r.restoreEmptyFileAt(file.location)
@@ -130,6 +420,9 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		}
 		fileOffset := int64(0)
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob, idx int) {
+			if !file.state.HasMatchingBlob(idx) {
+				file.pending++
+			}
 			if largeFile && !file.state.HasMatchingBlob(idx) {
 				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
 				fileOffset += int64(blob.DataLength())
@@ -168,17 +461,20 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 			file.blobs = packsMap
 		}
 	}
-	// drop no longer necessary file list
-	r.files = nil
+	r.progress.AddRequiredPacks(uint64(len(packOrder)))
 
 	wg, ctx := errgroup.WithContext(ctx)
 	downloadCh := make(chan *packInfo)
 
 	worker := func() error {
 		for pack := range downloadCh {
-			if err := r.downloadPack(ctx, pack); err != nil {
+			r.metrics.workerActive(1)
+			err := r.downloadPack(ctx, pack)
+			r.metrics.workerActive(-1)
+			if err != nil {
 				return err
 			}
+			r.progress.AddFinishedPack()
 		}
 		return nil
 	}
@@ -206,8 +502,142 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 	return wg.Wait()
 }
 
+// startFileTimeouts starts the timeout of each file in files which is not
+// running yet. The returned context is cancelled once all files have timed
+// out or were completed, such that a slow pack is abandoned if no file
+// needs it anymore. The returned function must be called afterwards.
+func (r *fileRestorer) startFileTimeouts(ctx context.Context, files map[*fileInfo]struct{}) (context.Context, func()) {
+	if r.perFileTimeout <= 0 {
+		return ctx, func() {}
+	}
+	fileCtxs := make([]context.Context, 0, len(files))
+	for file := range files {
+		file.lock.Lock()
+		if file.ctx == nil {
+			// derived from ctx, such that cancelling the restore also
+			// cancels the file
+			file.ctx, file.cancel = context.WithTimeout(ctx, r.perFileTimeout)
+		}
+		fileCtxs = append(fileCtxs, file.ctx)
+		file.lock.Unlock()
+	}
+
+	packCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		for _, fileCtx := range fileCtxs {
+			select {
+			case <-fileCtx.Done():
+			case <-done:
+				return
+			}
+		}
+		cancel()
+	}()
+	return packCtx, func() {
+		close(done)
+		cancel()
+	}
+}
+
+// checkTimeout returns whether file was abandoned as it timed out. The
+// timeout is reported once via Error.
+func (r *fileRestorer) checkTimeout(file *fileInfo) (bool, error) {
+	file.lock.Lock()
+	expired := file.ctx != nil && file.pending > 0 && errors.Is(file.ctx.Err(), context.DeadlineExceeded)
+	file.lock.Unlock()
+	if !expired {
+		return false, nil
+	}
+
+	r.failedLock.Lock()
+	_, reported := r.timedOut[file.location]
+	r.timedOut[file.location] = struct{}{}
+	r.failedLock.Unlock()
+	if reported {
+		return true, nil
+	}
+	debug.Log("restoring %v timed out", file.location)
+	return true, r.sanitizeError(file, errors.Errorf("restoring the content did not complete within %v", r.perFileTimeout))
+}
+
+// finishTimeouts reports all files which timed out without receiving
+// another blob afterwards and releases the timers of all files.
+func (r *fileRestorer) finishTimeouts(files []*fileInfo) error {
+	var firstErr error
+	for _, file := range files {
+		if _, err := r.checkTimeout(file); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		file.lock.Lock()
+		if file.cancel != nil {
+			file.cancel()
+		}
+		file.lock.Unlock()
+	}
+	return firstErr
+}
+
+// removeTimedOutFiles removes the data already written to files which timed
+// out.
+func (r *fileRestorer) removeTimedOutFiles(files []*fileInfo) {
+	for _, file := range files {
+		if !file.inProgress || !r.hasTimedOut(file.location) {
+			continue
+		}
+		debug.Log("removing timed out file %v", file.location)
+		if err := fs.Remove(r.writePath(file.location)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			debug.Log("unable to remove %v: %v", file.location, err)
+		}
+	}
+}
+
+// addCompleted records that the content of the file at location was written
+// completely, if trackCompleted is set.
+func (r *fileRestorer) addCompleted(location string) {
+	if !r.trackCompleted {
+		return
+	}
+	r.completedLock.Lock()
+	defer r.completedLock.Unlock()
+	r.completed = append(r.completed, location)
+}
+
+// removeIncompleteFiles removes files which were written to but not
+// completed. With atomicReplace, the temporary files are never renamed to
+// their target, thus they are all removed.
+func (r *fileRestorer) removeIncompleteFiles(files []*fileInfo) {
+	for _, file := range files {
+		file.lock.Lock()
+		incomplete := file.inProgress && (file.pending > 0 || r.atomicReplace)
+		file.lock.Unlock()
+		if !incomplete {
+			continue
+		}
+		debug.Log("removing incomplete file %v", file.location)
+		if err := fs.Remove(r.writePath(file.location)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			debug.Log("unable to remove %v: %v", file.location, err)
+		}
+	}
+}
+
+// removePartialFiles removes the files created by the restore for which an
+// error was reported. Files which were not written to or which already
+// existed before are left untouched.
+func (r *fileRestorer) removePartialFiles(files []*fileInfo) {
+	for _, file := range files {
+		if !file.inProgress || file.existed || !r.hasFailed(file.location) {
+			continue
+		}
+		debug.Log("removing partially restored file %v", file.location)
+		if err := fs.Remove(r.writePath(file.location)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			debug.Log("unable to remove %v: %v", file.location, err)
+		}
+	}
+}
+
 func (r *fileRestorer) restoreEmptyFileAt(location string) error {
-	f, err := createFile(r.targetPath(location), 0, false)
+	f, err := createFile(r.writePath(location), 0, false)
 	if err != nil {
 		return err
 	}
@@ -215,7 +645,10 @@ func (r *fileRestorer) restoreEmptyFileAt(location string) error {
 		return err
 	}
 
+	r.emptyFiles++
+	r.addCompleted(location)
 	r.progress.AddProgress(location, 0, 0)
+	r.metrics.written(location, 0, 0)
 	return nil
 }
 
@@ -262,14 +695,74 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 		}
 	}
 
+	if r.memory != nil {
+		size := packMemory(blobs)
+		// a pack larger than the limit must still be restored eventually
+		if size > r.memoryLimit {
+			size = r.memoryLimit
+		}
+		if err := r.memory.Acquire(ctx, size); err != nil {
+			return err
+		}
+		defer r.memory.Release(size)
+	}
+
+	packCtx, done := r.startFileTimeouts(ctx, pack.files)
+	defer done()
+
 	// track already processed blobs for precise error reporting
 	processedBlobs := restic.NewBlobSet()
-	err := r.downloadBlobs(ctx, pack.id, blobs, processedBlobs)
+	err := r.downloadBlobs(packCtx, pack.id, blobs, processedBlobs)
+	if err != nil && ctx.Err() == nil && packCtx.Err() != nil {
+		// no file needs the remaining blobs anymore, the timeouts are
+		// reported by finishTimeouts
+		return nil
+	}
 	return r.reportError(blobs, processedBlobs, err)
 }
 
+// packMemory returns the number of bytes held in memory while loading blobs
+// from a pack. The pack is read from the first to the last blob, including
+// unneeded data between them, and the plaintext of one blob is held in
+// addition to that at a time.
+func packMemory(blobs blobToFileOffsetsMapping) int64 {
+	var start, end, plaintext uint
+	first := true
+	for _, entry := range blobs {
+		blob := entry.blob
+		if first || blob.Offset < start {
+			start = blob.Offset
+		}
+		if first || blob.Offset+blob.Length > end {
+			end = blob.Offset + blob.Length
+		}
+		if blob.DataLength() > plaintext {
+			plaintext = blob.DataLength()
+		}
+		first = false
+	}
+	return int64(end-start) + int64(plaintext)
+}
+
+// zeroFillRemaining zero fills all blobs which were not processed before
+// loading the pack failed.
+func (r *fileRestorer) zeroFillRemaining(blobs blobToFileOffsetsMapping, processedBlobs restic.BlobSet, err error) error {
+	for _, entry := range blobs {
+		if processedBlobs.Has(entry.blob.BlobHandle) {
+			continue
+		}
+		if errFill := r.zeroFillBlob(entry.blob, entry.files, err); errFill != nil {
+			return errFill
+		}
+	}
+	return nil
+}
+
 func (r *fileRestorer) sanitizeError(file *fileInfo, err error) error {
 	if err != nil {
+		r.failedLock.Lock()
+		r.failed[file.location] = struct{}{}
+		r.failedLock.Unlock()
 		err = r.Error(file.location, err)
 	}
 	return err
@@ -306,46 +799,124 @@ func (r *fileRestorer) downloadBlobs(ctx context.Context, packID restic.ID,
 	for _, entry := range blobs {
 		blobList = append(blobList, entry.blob)
 	}
-	return r.blobsLoader(ctx, packID, blobList,
+	var handlerErr error
+	err := r.blobsLoader(ctx, packID, blobList,
 		func(h restic.BlobHandle, blobData []byte, err error) error {
 			processedBlobs.Insert(h)
 			blob := blobs[h.ID]
+			if err == nil && r.verifyOnWrite {
+				if id := restic.Hash(blobData); !id.Equal(h.ID) {
+					err = errors.Errorf("blob %v has wrong hash %v", h.ID.Str(), id.Str())
+				}
+			}
+			if err != nil && r.zeroFillMissing {
+				handlerErr = r.zeroFillBlob(blob.blob, blob.files, err)
+				return handlerErr
+			}
 			if err != nil {
 				for file := range blob.files {
 					if errFile := r.sanitizeError(file, err); errFile != nil {
+						handlerErr = errFile
 						return errFile
 					}
 				}
 				return nil
 			}
-			for file, offsets := range blob.files {
-				for _, offset := range offsets {
-					writeToFile := func() error {
-						// this looks overly complicated and needs explanation
-						// two competing requirements:
-						// - must create the file once and only once
-						// - should allow concurrent writes to the file
-						// so write the first blob while holding file lock
-						// write other blobs after releasing the lock
-						createSize := int64(-1)
+			r.metrics.fetched(len(blobData))
+			references := 0
+			for _, offsets := range blob.files {
+				references += len(offsets)
+			}
+			r.progress.AddBlobReferences(uint64(references), uint64(len(blobData)))
+			handlerErr = r.writeBlob(blob.files, blobData)
+			return handlerErr
+		})
+	if err != nil && handlerErr == nil && r.zeroFillMissing && ctx.Err() == nil {
+		// loading the pack failed, replace all remaining blobs with zeros
+		return r.zeroFillRemaining(blobs, processedBlobs, err)
+	}
+	return err
+}
+
+// writeBlob writes blobData to all files and offsets in files.
+func (r *fileRestorer) writeBlob(files map[*fileInfo][]int64, blobData []byte) error {
+	for file, offsets := range files {
+		if r.verifyOnWrite && r.hasFailed(file.location) {
+			// the file is removed anyways
+			continue
+		}
+		if timedOut, err := r.checkTimeout(file); timedOut {
+			if err != nil {
+				return err
+			}
+			// the file is removed anyways
+			continue
+		}
+		for _, offset := range offsets {
+			writeToFile := func() error {
+				// this looks overly complicated and needs explanation
+				// two competing requirements:
+				// - must create the file once and only once
+				// - should allow concurrent writes to the file
+				// so write the first blob while holding file lock
+				// write other blobs after releasing the lock
+				createSize := int64(-1)
+				file.lock.Lock()
+				if file.inProgress {
+					file.lock.Unlock()
+				} else {
+					defer file.lock.Unlock()
+					file.inProgress = true
+					if _, err := fs.Lstat(r.writePath(file.location)); err == nil {
+						file.existed = true
+					}
+					createSize = file.size
+				}
+				writeErr := r.filesWriter.writeToFile(r.writePath(file.location), blobData, offset, createSize, file.sparse)
+				r.progress.AddProgress(file.location, uint64(len(blobData)), uint64(file.size))
+				if writeErr == nil {
+					r.metrics.written(file.location, uint64(len(blobData)), uint64(file.size))
+					if createSize < 0 {
+						// the lock is already held if the file was created above
 						file.lock.Lock()
-						if file.inProgress {
-							file.lock.Unlock()
-						} else {
-							defer file.lock.Unlock()
-							file.inProgress = true
-							createSize = file.size
-						}
-						writeErr := r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize, file.sparse)
-						r.progress.AddProgress(file.location, uint64(len(blobData)), uint64(file.size))
-						return writeErr
+						defer file.lock.Unlock()
+					}
+					file.pending--
+					if file.pending == 0 && file.cancel != nil {
+						// the file is complete and cannot time out anymore
+						file.cancel()
 					}
-					err := r.sanitizeError(file, writeToFile())
-					if err != nil {
-						return err
+					if file.pending == 0 {
+						r.addCompleted(file.location)
+					}
+					if file.pending == 0 && r.filesWriter.bufferSize > 0 {
+						writeErr = r.filesWriter.closeFile(r.writePath(file.location))
 					}
 				}
+				return writeErr
 			}
-			return nil
-		})
+			err := r.sanitizeError(file, writeToFile())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zeroFillBlob writes zeros instead of the content of a blob which failed to
+// load and records the affected ranges.
+func (r *fileRestorer) zeroFillBlob(blob restic.Blob, files map[*fileInfo][]int64, loadErr error) error {
+	debug.Log("zero filling blob %v: %v", blob.ID.Str(), loadErr)
+	length := int64(blob.DataLength())
+
+	r.damagedLock.Lock()
+	for file, offsets := range files {
+		for _, offset := range offsets {
+			r.damaged[file.location] = append(r.damaged[file.location], DamagedRange{Offset: offset, Length: length, Err: loadErr})
+		}
+	}
+	r.damagedLock.Unlock()
+
+	return r.writeBlob(files, make([]byte, length))
 }
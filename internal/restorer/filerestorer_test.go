@@ -6,11 +6,16 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/sync/semaphore"
 )
 
 type TestBlob struct {
@@ -342,3 +347,491 @@ func TestFatalDownloadError(t *testing.T) {
 	rtest.Assert(t, len(errors) == 1, "unexpected number of restore errors, expected: 1, got: %v", len(errors))
 	rtest.Assert(t, errors[0] == "file2", "expected error for file2, got: %v", errors[0])
 }
+
+func TestZeroFillMissing(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack1"},
+			},
+		},
+		{
+			name: "file2",
+			blobs: []TestBlob{
+				{"data2-1", "pack2"},
+			},
+		},
+	}
+
+	repo := newTestRepo(content)
+
+	loadError := errors.New("load error")
+	damagedBlob := restic.Hash([]byte("data1-2"))
+	brokenPack := repo.blobs[restic.Hash([]byte("data2-1"))][0].PackID
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		if packID.Equal(brokenPack) {
+			return loadError
+		}
+		return loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if blob.ID.Equal(damagedBlob) {
+				return handleBlobFn(blob, nil, loadError)
+			}
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 2, false, nil)
+	r.zeroFillMissing = true
+	r.files = repo.files
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+
+	for location, expected := range map[string]string{
+		"file1": "data1-1\x00\x00\x00\x00\x00\x00\x00",
+		"file2": "\x00\x00\x00\x00\x00\x00\x00",
+	} {
+		data, err := os.ReadFile(r.targetPath(location))
+		rtest.OK(t, err)
+		rtest.Equals(t, expected, string(data))
+	}
+
+	rtest.Equals(t, map[string][]DamagedRange{
+		"file1": {{Offset: 7, Length: 7, Err: loadError}},
+		"file2": {{Offset: 0, Length: 7, Err: loadError}},
+	}, r.damaged)
+}
+
+func TestVerifyOnWrite(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack2"},
+			},
+		},
+		{
+			name: "file2",
+			blobs: []TestBlob{
+				{"data2-1", "pack1"},
+			},
+		},
+	}
+
+	repo := newTestRepo(content)
+
+	corruptBlob := restic.Hash([]byte("data1-2"))
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		return loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if blob.ID.Equal(corruptBlob) {
+				buf = []byte("corrupt")
+			}
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 2, false, nil)
+	r.verifyOnWrite = true
+	r.files = repo.files
+	var errors []string
+	r.Error = func(s string, _ error) error {
+		errors = append(errors, s)
+		return nil
+	}
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	rtest.Equals(t, []string{"file1"}, errors)
+
+	_, err := os.Stat(r.targetPath("file1"))
+	rtest.Assert(t, os.IsNotExist(err), "partial file was not removed: %v", err)
+
+	data, err := os.ReadFile(r.targetPath("file2"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "data2-1", string(data))
+
+	// a file which existed before the restore is not removed
+	rtest.OK(t, os.WriteFile(r.targetPath("file1"), []byte("existing content"), 0600))
+	repo = newTestRepo(content)
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		return loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if blob.ID.Equal(corruptBlob) {
+				buf = []byte("corrupt")
+			}
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+	r = newFileRestorer(tempdir, repo.loader, repo.Lookup, 2, false, nil)
+	r.verifyOnWrite = true
+	r.files = repo.files
+	errors = nil
+	r.Error = func(s string, _ error) error {
+		errors = append(errors, s)
+		return nil
+	}
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	rtest.Equals(t, []string{"file1"}, errors)
+	_, err = os.Stat(r.targetPath("file1"))
+	rtest.OK(t, err)
+}
+
+func TestCleanupOnCancel(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack2"},
+			},
+		},
+		{
+			name: "file2",
+			blobs: []TestBlob{
+				{"data2-1", "pack1"},
+			},
+		},
+	}
+
+	repo := newTestRepo(content)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel the restore once pack1 was restored completely
+	cancelPack := repo.blobs[restic.Hash([]byte("data1-2"))][0].PackID
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		if packID.Equal(cancelPack) {
+			cancel()
+			return ctx.Err()
+		}
+		return loader(ctx, packID, blobs, handleBlobFn)
+	}
+
+	// use a single worker such that the packs are restored in order
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 1, false, nil)
+	r.cleanupOnCancel = true
+	r.files = repo.files
+
+	err := r.restoreFiles(ctx)
+	rtest.Assert(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+
+	_, err = os.Stat(r.targetPath("file1"))
+	rtest.Assert(t, os.IsNotExist(err), "incomplete file was not removed: %v", err)
+
+	data, err := os.ReadFile(r.targetPath("file2"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "data2-1", string(data))
+}
+
+func TestRetryLoads(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack1"},
+			},
+		},
+		{
+			name: "file2",
+			blobs: []TestBlob{
+				{"data2-1", "pack2"},
+			},
+		},
+	}
+
+	repo := newTestRepo(content)
+
+	// pack1 fails once after the first blob, pack2 contains a damaged blob
+	loadError := errors.New("load error")
+	flakyPack := repo.blobs[restic.Hash([]byte("data1-1"))][0].PackID
+	damagedBlob := restic.Hash([]byte("data2-1"))
+	var calls, requested int
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		calls++
+		requested += len(blobs)
+		return loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if blob.ID.Equal(damagedBlob) {
+				return handleBlobFn(blob, nil, &repository.DamagedBlobError{Err: loadError})
+			}
+			if err := handleBlobFn(blob, buf, err); err != nil {
+				return err
+			}
+			if packID.Equal(flakyPack) && calls == 1 {
+				return loadError
+			}
+			return nil
+		})
+	}
+
+	// use a single worker such that the packs are restored in order
+	r := newFileRestorer(tempdir, retryLoads(repo.loader, 3, time.Millisecond), repo.Lookup, 1, false, nil)
+	r.files = repo.files
+	var failed []string
+	r.Error = func(location string, err error) error {
+		failed = append(failed, location)
+		return nil
+	}
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	data, err := os.ReadFile(r.targetPath("file1"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "data1-1data1-2", string(data))
+	rtest.Equals(t, []string{"file2"}, failed)
+	// only the second blob of pack1 is requested again
+	rtest.Equals(t, 3, calls)
+	rtest.Equals(t, 4, requested)
+}
+
+func TestRetryLoadsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	loadError := errors.New("load error")
+	load := retryLoads(func(context.Context, restic.ID, []restic.Blob, func(restic.BlobHandle, []byte, error) error) error {
+		return loadError
+	}, 10, time.Hour)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := load(ctx, restic.NewRandomID(), []restic.Blob{{}}, func(restic.BlobHandle, []byte, error) error {
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+}
+
+func TestFileRestorerWriteBuffer(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack1"},
+				{"data1-3", "pack2"},
+			},
+		},
+		{
+			name: "file2",
+			blobs: []TestBlob{
+				{"data2-1", "pack2"},
+				{"data2-1", "pack2"},
+			},
+		},
+	}
+
+	for _, sparse := range []bool{false, true} {
+		repo := newTestRepo(content)
+		r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 2, sparse, nil)
+		r.filesWriter.bufferSize = 16
+		r.files = repo.files
+		files := r.files
+
+		rtest.OK(t, r.restoreFiles(context.TODO()))
+		for _, file := range files {
+			data, err := os.ReadFile(r.targetPath(file.location))
+			rtest.OK(t, err)
+			rtest.Equals(t, repo.fileContent(file), string(data))
+		}
+		for i := range r.filesWriter.buckets {
+			rtest.Equals(t, 0, len(r.filesWriter.buckets[i].files))
+		}
+	}
+}
+
+func TestFileRestorerMaxMemory(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	var content []TestFile
+	for i := 0; i < 4; i++ {
+		content = append(content, TestFile{
+			name: fmt.Sprintf("file%d", i),
+			blobs: []TestBlob{
+				{fmt.Sprintf("data%d-1", i), fmt.Sprintf("pack%d", i)},
+				{fmt.Sprintf("data%d-2", i), fmt.Sprintf("pack%d", i)},
+			},
+		})
+	}
+
+	repo := newTestRepo(content)
+
+	// each pack holds 14 bytes, thus at most one pack fits into the limit
+	const limit = 20
+	var m sync.Mutex
+	var inflight, maxInflight int
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		size := 0
+		for _, blob := range blobs {
+			size += int(blob.Length)
+		}
+		m.Lock()
+		inflight += size
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		m.Unlock()
+		defer func() {
+			m.Lock()
+			inflight -= size
+			m.Unlock()
+		}()
+
+		// give other workers the chance to start loading
+		time.Sleep(10 * time.Millisecond)
+		return loader(ctx, packID, blobs, handleBlobFn)
+	}
+
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 4, false, nil)
+	r.memory = semaphore.NewWeighted(limit)
+	r.memoryLimit = limit
+	r.files = repo.files
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	verifyRestore(t, r, repo)
+	rtest.Equals(t, 14, maxInflight)
+}
+
+func TestFileRestorerMaxMemoryLargePack(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack1"},
+			},
+		},
+	}
+
+	// a pack larger than the limit is still restored
+	repo := newTestRepo(content)
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 2, false, nil)
+	r.memory = semaphore.NewWeighted(4)
+	r.memoryLimit = 4
+	r.files = repo.files
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	verifyRestore(t, r, repo)
+}
+
+func TestPackMemory(t *testing.T) {
+	rtest.Equals(t, int64(0), packMemory(blobToFileOffsetsMapping{}))
+
+	blobs := make(blobToFileOffsetsMapping)
+	for _, blob := range []restic.Blob{
+		{BlobHandle: restic.BlobHandle{ID: restic.NewRandomID()}, Offset: 200, Length: 60},
+		{BlobHandle: restic.BlobHandle{ID: restic.NewRandomID()}, Offset: 0, Length: 40, UncompressedLength: 100},
+	} {
+		entry := blobs[blob.ID]
+		entry.blob = blob
+		blobs[blob.ID] = entry
+	}
+	// the gap between the blobs is read as well, the plaintext of the
+	// compressed blob is larger than its stored length
+	rtest.Equals(t, int64(260+100), packMemory(blobs))
+}
+
+func TestFileRestorerPerFileTimeout(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{
+			name: "slow",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+				{"data1-2", "pack2"},
+			},
+		},
+		{
+			name: "fast",
+			blobs: []TestBlob{
+				{"data2-1", "pack3"},
+			},
+		},
+	}
+
+	repo := newTestRepo(content)
+
+	// pack2 only returns once the restore gives up on it
+	slowPack := repo.blobs[restic.Hash([]byte("data1-2"))][0].PackID
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		if packID.Equal(slowPack) {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return loader(ctx, packID, blobs, handleBlobFn)
+	}
+
+	// use a single worker such that the slow pack blocks all others
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 1, false, nil)
+	r.perFileTimeout = 50 * time.Millisecond
+	r.files = repo.files
+	var reported []string
+	r.Error = func(location string, err error) error {
+		reported = append(reported, location)
+		rtest.Assert(t, strings.Contains(err.Error(), "did not complete"), "unexpected error %v", err)
+		return nil
+	}
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	rtest.Equals(t, []string{"slow"}, reported)
+	rtest.Assert(t, r.hasTimedOut("slow"), "slow file did not time out")
+
+	_, err := os.Stat(r.targetPath("slow"))
+	rtest.Assert(t, os.IsNotExist(err), "timed out file was not removed: %v", err)
+
+	data, err := os.ReadFile(r.targetPath("fast"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "data2-1", string(data))
+}
+
+func TestFileRestorerPriority(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	content := []TestFile{
+		{name: "file1", blobs: []TestBlob{{"data1-1", "pack1"}, {"shared", "pack2"}}},
+		{name: "manifest", blobs: []TestBlob{{"shared", "pack2"}, {"manifest", "pack3"}}},
+		{name: "file3", blobs: []TestBlob{{"data3-1", "pack4"}}},
+	}
+	repo := newTestRepo(content)
+
+	var lock sync.Mutex
+	var loaded []string
+	loader := repo.loader
+	repo.loader = func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		return loader(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+			lock.Lock()
+			loaded = append(loaded, string(buf))
+			lock.Unlock()
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+
+	r := newFileRestorer(tempdir, repo.loader, repo.Lookup, 2, false, nil)
+	for _, file := range repo.files {
+		if file.location == "manifest" {
+			file.priority = 1
+		}
+	}
+	r.files = repo.files
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+
+	// the shared pack is loaded once per priority
+	rtest.Equals(t, 5, len(loaded))
+	first, last := loaded[:3], loaded[3:]
+	sort.Strings(first)
+	sort.Strings(last)
+	rtest.Equals(t, []string{"data1-1", "data3-1", "shared"}, first)
+	rtest.Equals(t, []string{"manifest", "shared"}, last)
+
+	for _, file := range content {
+		data, err := os.ReadFile(r.targetPath(file.name))
+		rtest.OK(t, err)
+		rtest.Equals(t, repo.filesPathToContent[file.name], string(data))
+	}
+}
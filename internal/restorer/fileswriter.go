@@ -11,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
 )
 
 // writes blobs to target files.
@@ -20,6 +21,11 @@ import (
 // to use multiple os.File to write to the same target file
 type filesWriter struct {
 	buckets []filesWriterBucket
+
+	// bufferSize is the maximum number of bytes which are collected from
+	// adjacent blobs before they are written to a file. If it is positive,
+	// files are kept open until closeFile is called.
+	bufferSize int
 }
 
 type filesWriterBucket struct {
@@ -31,6 +37,11 @@ type partialFile struct {
 	*os.File
 	users  int // Reference count.
 	sparse bool
+
+	// data which was not written yet, see filesWriter.write
+	bufLock   sync.Mutex
+	buf       []byte
+	bufOffset int64
 }
 
 func newFilesWriter(count int) *filesWriter {
@@ -187,7 +198,7 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		bucket.lock.Lock()
 		defer bucket.lock.Unlock()
 
-		if bucket.files[path].users == 1 {
+		if bucket.files[path].users == 1 && w.bufferSize <= 0 {
 			delete(bucket.files, path)
 			return wr.Close()
 		}
@@ -200,7 +211,7 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		return err
 	}
 
-	_, err = wr.WriteAt(blob, offset)
+	err = w.write(wr, blob, offset)
 
 	if err != nil {
 		// ignore subsequent errors
@@ -210,3 +221,84 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 
 	return releaseWriter(wr)
 }
+
+// write writes blob to wr at offset. With a positive bufferSize, adjacent
+// blobs are collected and written at once. In sparse mode, blobs which only
+// contain zeros are never buffered, such that they still result in holes.
+func (w *filesWriter) write(wr *partialFile, blob []byte, offset int64) error {
+	if w.bufferSize <= 0 {
+		_, err := wr.WriteAt(blob, offset)
+		return err
+	}
+
+	wr.bufLock.Lock()
+	defer wr.bufLock.Unlock()
+
+	zeros := wr.sparse && restic.ZeroPrefixLen(blob) == len(blob)
+	if !zeros && len(wr.buf) > 0 && offset == wr.bufOffset+int64(len(wr.buf)) && len(wr.buf)+len(blob) <= w.bufferSize {
+		wr.buf = append(wr.buf, blob...)
+		return nil
+	}
+
+	if err := wr.flush(); err != nil {
+		return err
+	}
+	if zeros || len(blob) >= w.bufferSize {
+		_, err := wr.WriteAt(blob, offset)
+		return err
+	}
+	wr.buf = append(wr.buf[:0], blob...)
+	wr.bufOffset = offset
+	return nil
+}
+
+// flush writes the buffered data. The caller must hold bufLock.
+func (wr *partialFile) flush() error {
+	if len(wr.buf) == 0 {
+		return nil
+	}
+	_, err := wr.WriteAt(wr.buf, wr.bufOffset)
+	wr.buf = wr.buf[:0]
+	return err
+}
+
+// closeFile flushes the buffered data of the file at path and closes it. It
+// must only be called once all writes to the file have returned.
+func (w *filesWriter) closeFile(path string) error {
+	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
+	bucket.lock.Lock()
+	wr, ok := bucket.files[path]
+	delete(bucket.files, path)
+	bucket.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	wr.bufLock.Lock()
+	err := wr.flush()
+	wr.bufLock.Unlock()
+	if errClose := wr.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// closeAll closes all files which are still open, for example as restoring
+// them failed. Errors are only logged, as the files are incomplete anyway.
+func (w *filesWriter) closeAll() {
+	for i := range w.buckets {
+		bucket := &w.buckets[i]
+		bucket.lock.Lock()
+		paths := make([]string, 0, len(bucket.files))
+		for path := range bucket.files {
+			paths = append(paths, path)
+		}
+		bucket.lock.Unlock()
+
+		for _, path := range paths {
+			if err := w.closeFile(path); err != nil {
+				debug.Log("closing %v failed: %v", path, err)
+			}
+		}
+	}
+}
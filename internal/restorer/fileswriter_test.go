@@ -39,6 +39,35 @@ func TestFilesWriterBasic(t *testing.T) {
 	rtest.Equals(t, []byte{2, 2}, buf)
 }
 
+func TestFilesWriterBuffered(t *testing.T) {
+	dir := rtest.TempDir(t)
+	w := newFilesWriter(1)
+	w.bufferSize = 4
+
+	f1 := dir + "/f1"
+
+	rtest.OK(t, w.writeToFile(f1, []byte{1, 1}, 0, 8, true))
+	rtest.OK(t, w.writeToFile(f1, []byte{2, 2}, 2, -1, true))
+	// the file is kept open and the adjacent blobs are buffered
+	rtest.Equals(t, 1, len(w.buckets[0].files))
+	buf, err := os.ReadFile(f1)
+	rtest.OK(t, err)
+	rtest.Equals(t, make([]byte, 8), buf)
+
+	// zeros are never buffered, non-adjacent blobs flush the buffer
+	rtest.OK(t, w.writeToFile(f1, []byte{0, 0}, 4, -1, true))
+	rtest.OK(t, w.writeToFile(f1, []byte{3, 3}, 6, -1, true))
+	buf, err = os.ReadFile(f1)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte{1, 1, 2, 2, 0, 0, 0, 0}, buf)
+
+	rtest.OK(t, w.closeFile(f1))
+	rtest.Equals(t, 0, len(w.buckets[0].files))
+	buf, err = os.ReadFile(f1)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte{1, 1, 2, 2, 0, 0, 3, 3}, buf)
+}
+
 func TestCreateFile(t *testing.T) {
 	basepath := filepath.Join(t.TempDir(), "test")
 
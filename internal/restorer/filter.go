@@ -0,0 +1,92 @@
+package restorer
+
+import (
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// SelectFilter decides whether a node is restored and whether the children of
+// a directory may be restored, see Restorer.SelectFilter.
+type SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+// NewTypeFilter returns a filter which selects nodes of the given types, for
+// example "file" or "symlink". The children of all directories are checked.
+func NewTypeFilter(types ...string) SelectFilter {
+	selected := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		selected[t] = struct{}{}
+	}
+
+	return func(_ string, _ string, node *restic.Node) (bool, bool) {
+		_, ok := selected[node.Type]
+		return ok, node.Type == "dir"
+	}
+}
+
+// NewSizeFilter returns a filter which selects files with a size between minSize
+// and maxSize bytes, both inclusive. If maxSize is zero, the size is not limited.
+// Other node types are not selected, but the children of all directories are
+// checked.
+func NewSizeFilter(minSize, maxSize uint64) SelectFilter {
+	return func(_ string, _ string, node *restic.Node) (bool, bool) {
+		if node.Type != "file" {
+			return false, node.Type == "dir"
+		}
+		return node.Size >= minSize && (maxSize == 0 || node.Size <= maxSize), false
+	}
+}
+
+// NewModTimeFilter returns a filter which selects nodes modified after
+// `after` and before `before`. A zero time disables the corresponding bound.
+// The children of all directories are checked independently of the
+// modification time of the directory.
+func NewModTimeFilter(after, before time.Time) SelectFilter {
+	return func(_ string, _ string, node *restic.Node) (bool, bool) {
+		selected := (after.IsZero() || node.ModTime.After(after)) &&
+			(before.IsZero() || node.ModTime.Before(before))
+		return selected, node.Type == "dir"
+	}
+}
+
+// AndFilter returns a filter which selects a node only if all filters select
+// it. Children of a directory are only checked if all filters allow it.
+func AndFilter(filters ...SelectFilter) SelectFilter {
+	return func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selectedForRestore, childMayBeSelected := true, true
+		for _, filter := range filters {
+			selected, child := filter(item, dstpath, node)
+			selectedForRestore = selectedForRestore && selected
+			childMayBeSelected = childMayBeSelected && child
+		}
+		return selectedForRestore, childMayBeSelected
+	}
+}
+
+// OrFilter returns a filter which selects a node if any of the filters
+// selects it. Children of a directory are checked if any filter allows it.
+func OrFilter(filters ...SelectFilter) SelectFilter {
+	return func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selectedForRestore, childMayBeSelected := false, false
+		for _, filter := range filters {
+			selected, child := filter(item, dstpath, node)
+			selectedForRestore = selectedForRestore || selected
+			childMayBeSelected = childMayBeSelected || child
+		}
+		return selectedForRestore, childMayBeSelected
+	}
+}
+
+// DebugFilter returns a filter which passes each decision of inner to sink,
+// for example to log why an item was not restored. The results of inner are
+// returned unchanged. If inner is nil, all nodes are selected.
+func DebugFilter(inner SelectFilter, sink func(item string, dstpath string, node *restic.Node, selectedForRestore bool, childMayBeSelected bool)) SelectFilter {
+	if inner == nil {
+		inner = func(string, string, *restic.Node) (bool, bool) { return true, true }
+	}
+	return func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selectedForRestore, childMayBeSelected := inner(item, dstpath, node)
+		sink(item, dstpath, node, selectedForRestore, childMayBeSelected)
+		return selectedForRestore, childMayBeSelected
+	}
+}
@@ -0,0 +1,139 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSelectFilters(t *testing.T) {
+	baseTime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"otherfile": File{Data: "x", ModTime: baseTime.Add(time.Hour)},
+				"link":      Symlink{Target: "otherfile", ModTime: baseTime},
+				"subdir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+					"file": File{Data: "content: file\n", ModTime: baseTime.Add(2 * time.Hour)},
+				}},
+			}},
+			"foo": File{Data: "content: foo\n", ModTime: baseTime},
+		},
+	}
+
+	var tests = []struct {
+		Select  SelectFilter
+		Visitor TraverseTreeCheck
+	}{
+		// select only symlinks
+		{
+			Select: NewTypeFilter("symlink"),
+			Visitor: checkVisitOrder([]TreeVisit{
+				{"visitNode", "/dir/link"},
+				{"leaveDir", "/dir"},
+			}),
+		},
+		// select files with at least two bytes
+		{
+			Select: NewSizeFilter(2, 0),
+			Visitor: checkVisitOrder([]TreeVisit{
+				{"visitNode", "/dir/subdir/file"},
+				{"leaveDir", "/dir/subdir"},
+				{"leaveDir", "/dir"},
+				{"visitNode", "/foo"},
+			}),
+		},
+		// select nodes modified after baseTime
+		{
+			Select: NewModTimeFilter(baseTime, time.Time{}),
+			Visitor: checkVisitOrder([]TreeVisit{
+				{"visitNode", "/dir/otherfile"},
+				{"visitNode", "/dir/subdir/file"},
+				{"leaveDir", "/dir/subdir"},
+				{"leaveDir", "/dir"},
+			}),
+		},
+		// select directories and small files
+		{
+			Select: OrFilter(NewTypeFilter("dir"), NewSizeFilter(0, 1)),
+			Visitor: checkVisitOrder([]TreeVisit{
+				{"enterDir", "/dir"},
+				{"visitNode", "/dir/otherfile"},
+				{"enterDir", "/dir/subdir"},
+				{"leaveDir", "/dir/subdir"},
+				{"leaveDir", "/dir"},
+			}),
+		},
+		// select large files modified after baseTime
+		{
+			Select: AndFilter(NewSizeFilter(2, 0), NewModTimeFilter(baseTime, time.Time{})),
+			Visitor: checkVisitOrder([]TreeVisit{
+				{"visitNode", "/dir/subdir/file"},
+				{"leaveDir", "/dir/subdir"},
+				{"leaveDir", "/dir"},
+			}),
+		},
+		// a filter which excludes the children of directories takes precedence
+		{
+			Select: AndFilter(NewTypeFilter("file"), func(_ string, _ string, _ *restic.Node) (bool, bool) {
+				return true, false
+			}),
+			Visitor: checkVisitOrder([]TreeVisit{
+				{"visitNode", "/foo"},
+			}),
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			res := NewRestorer(repo, sn, Options{})
+			res.SelectFilter = test.Select
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			target := filepath.Join(rtest.TempDir(t), "target")
+			_, err := res.traverseTree(ctx, target, string(filepath.Separator), *sn.Tree, test.Visitor(t))
+			rtest.OK(t, err)
+		})
+	}
+}
+
+func TestDebugFilter(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+			"link": Symlink{Target: "dir/file"},
+		},
+	}, noopGetGenericAttributes)
+
+	type decision struct {
+		item               string
+		selected, children bool
+	}
+	var trace []decision
+	filter := DebugFilter(NewTypeFilter("file"), func(item string, _ string, _ *restic.Node, selected bool, children bool) {
+		trace = append(trace, decision{filepath.ToSlash(item), selected, children})
+	})
+
+	nodes, err := ListNodes(context.TODO(), repo, *sn.Tree, ListOptions{Recursive: true, SelectFilter: filter})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(nodes))
+	rtest.Equals(t, "file", nodes[0].Node.Name)
+	rtest.Equals(t, []decision{
+		{"/dir", false, true},
+		{"/dir/file", true, false},
+		{"/link", false, false},
+	}, trace)
+}
@@ -0,0 +1,98 @@
+package restorer
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// fsyncs collects the restored items which must be flushed to stable
+// storage, see Options.Fsync. All methods are no-ops for a nil fsyncs.
+type fsyncs struct {
+	files []string
+	// directories are synced once, however many entries they contain
+	dirs map[string]struct{}
+}
+
+func newFsyncs(enabled bool) *fsyncs {
+	if !enabled {
+		return nil
+	}
+	return &fsyncs{dirs: make(map[string]struct{})}
+}
+
+// addFile records a regular file, whose content and metadata must be synced,
+// along with its parent directory.
+func (s *fsyncs) addFile(target string) {
+	if s == nil {
+		return
+	}
+	s.files = append(s.files, target)
+	s.addEntry(target)
+}
+
+// addDir records a directory along with its parent directory.
+func (s *fsyncs) addDir(target string) {
+	if s == nil {
+		return
+	}
+	s.dirs[target] = struct{}{}
+	s.addEntry(target)
+}
+
+// addEntry records the parent directory of target, which contains the entry
+// for target.
+func (s *fsyncs) addEntry(target string) {
+	if s == nil {
+		return
+	}
+	s.dirs[filepath.Dir(target)] = struct{}{}
+}
+
+// sync flushes all files and afterwards all directories.
+func (s *fsyncs) sync() error {
+	if s == nil {
+		return nil
+	}
+	for _, target := range s.files {
+		if err := syncPath(target); err != nil {
+			return err
+		}
+	}
+	if runtime.GOOS == "windows" {
+		// directories cannot be synced on Windows
+		return nil
+	}
+
+	dirs := make([]string, 0, len(s.dirs))
+	for dir := range s.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := syncPath(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncPath(path string) error {
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if errors.Is(err, os.ErrPermission) {
+		// a restored file may be write-only
+		f, err = fs.OpenFile(path, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Wrapf(err, "fsync %v", path)
+}
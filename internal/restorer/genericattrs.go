@@ -0,0 +1,98 @@
+package restorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// GenericAttributeFilter selects the generic attributes which are restored,
+// see Options.RestoreGenericAttributes.
+type GenericAttributeFilter struct {
+	// Include lists the attribute types which are restored. If it is empty,
+	// all types are included.
+	Include []restic.GenericAttributeType
+	// Exclude lists attribute types which are not restored, even if they are
+	// included.
+	Exclude []restic.GenericAttributeType
+}
+
+func (f *GenericAttributeFilter) selects(attributeType restic.GenericAttributeType) bool {
+	if f == nil {
+		return true
+	}
+	for _, t := range f.Exclude {
+		if t == attributeType {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, t := range f.Include {
+		if t == attributeType {
+			return true
+		}
+	}
+	return false
+}
+
+// filterGenericAttributes returns node without the generic attributes which
+// are not selected by Options.RestoreGenericAttributes or unknown to restic.
+// Unknown types are recorded for warnUnknownAttributes. node is not
+// modified.
+func (res *Restorer) filterGenericAttributes(node *restic.Node) *restic.Node {
+	var drop []restic.GenericAttributeType
+	unknown := false
+	for attributeType := range node.GenericAttributes {
+		if !restic.IsKnownGenericAttributeType(attributeType) {
+			unknown = true
+			drop = append(drop, attributeType)
+		} else if !res.opts.RestoreGenericAttributes.selects(attributeType) {
+			drop = append(drop, attributeType)
+		}
+	}
+	if unknown {
+		res.metadataLock.Lock()
+		if res.unknownAttributes == nil {
+			res.unknownAttributes = make(map[restic.GenericAttributeType]struct{})
+		}
+		for _, attributeType := range drop {
+			if !restic.IsKnownGenericAttributeType(attributeType) {
+				res.unknownAttributes[attributeType] = struct{}{}
+			}
+		}
+		res.unknownAttributeItems++
+		res.metadataLock.Unlock()
+	}
+	if len(drop) == 0 {
+		return node
+	}
+
+	n := *node
+	n.GenericAttributes = make(map[restic.GenericAttributeType]json.RawMessage, len(node.GenericAttributes))
+	for attributeType, value := range node.GenericAttributes {
+		n.GenericAttributes[attributeType] = value
+	}
+	for _, attributeType := range drop {
+		delete(n.GenericAttributes, attributeType)
+	}
+	return &n
+}
+
+// warnUnknownAttributes reports all generic attribute types unknown to
+// restic, which were skipped during the restore, as a single warning.
+func (res *Restorer) warnUnknownAttributes() {
+	if len(res.unknownAttributes) == 0 {
+		return
+	}
+	types := make([]string, 0, len(res.unknownAttributes))
+	for attributeType := range res.unknownAttributes {
+		types = append(types, string(attributeType))
+	}
+	sort.Strings(types)
+	res.warn(fmt.Sprintf("skipped unknown generic attributes %v of %d items, a newer version of restic may be required", strings.Join(types, ", "), res.unknownAttributeItems))
+}
@@ -0,0 +1,67 @@
+package restorer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerFilterGenericAttributes(t *testing.T) {
+	attrs := map[restic.GenericAttributeType]json.RawMessage{
+		restic.TypeBirthTime:      json.RawMessage(`1`),
+		restic.TypeFileFlags:      json.RawMessage(`2`),
+		restic.TypeFileAttributes: json.RawMessage(`3`),
+		"future.attribute":        json.RawMessage(`4`),
+	}
+	keys := func(node *restic.Node) map[restic.GenericAttributeType]struct{} {
+		result := make(map[restic.GenericAttributeType]struct{})
+		for attributeType := range node.GenericAttributes {
+			result[attributeType] = struct{}{}
+		}
+		return result
+	}
+
+	for _, test := range []struct {
+		filter *GenericAttributeFilter
+		kept   []restic.GenericAttributeType
+	}{
+		{nil, []restic.GenericAttributeType{restic.TypeBirthTime, restic.TypeFileFlags, restic.TypeFileAttributes}},
+		{&GenericAttributeFilter{Exclude: []restic.GenericAttributeType{restic.TypeFileAttributes}},
+			[]restic.GenericAttributeType{restic.TypeBirthTime, restic.TypeFileFlags}},
+		{&GenericAttributeFilter{
+			Include: []restic.GenericAttributeType{restic.TypeBirthTime, restic.TypeFileFlags},
+			Exclude: []restic.GenericAttributeType{restic.TypeFileFlags},
+		}, []restic.GenericAttributeType{restic.TypeBirthTime}},
+	} {
+		res := NewRestorer(nil, nil, Options{RestoreGenericAttributes: test.filter})
+		var warnings []string
+		res.Warn = func(msg string) {
+			warnings = append(warnings, msg)
+		}
+
+		node := &restic.Node{Name: "file", GenericAttributes: attrs}
+		filtered := res.filterGenericAttributes(node)
+		filtered = res.filterGenericAttributes(filtered)
+		res.filterGenericAttributes(&restic.Node{Name: "other", GenericAttributes: attrs})
+
+		want := make(map[restic.GenericAttributeType]struct{})
+		for _, attributeType := range test.kept {
+			want[attributeType] = struct{}{}
+		}
+		rtest.Equals(t, want, keys(filtered))
+		// the original node must not be modified
+		rtest.Equals(t, 4, len(node.GenericAttributes))
+
+		res.warnUnknownAttributes()
+		rtest.Equals(t, []string{"skipped unknown generic attributes future.attribute of 2 items, a newer version of restic may be required"}, warnings)
+	}
+
+	// nodes without dropped attributes are not copied
+	res := NewRestorer(nil, nil, Options{})
+	node := &restic.Node{Name: "file", GenericAttributes: map[restic.GenericAttributeType]json.RawMessage{
+		restic.TypeBirthTime: json.RawMessage(`1`),
+	}}
+	rtest.Assert(t, res.filterGenericAttributes(node) == node, "unexpected copy of node")
+}
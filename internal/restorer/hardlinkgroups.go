@@ -0,0 +1,146 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// hardlinkMember is a file of a hardlink group.
+type hardlinkMember struct {
+	location string
+	selected bool
+	// reachable is false for files below Options.MaxDepth, which are never
+	// restored
+	reachable bool
+}
+
+// splitHardlinkGroups walks the complete tree and returns all hardlink
+// groups of which SelectFilter selects some, but not all members. The
+// groups are sorted by the location of their first member.
+func (res *Restorer) splitHardlinkGroups(ctx context.Context, dst string) ([][]hardlinkMember, error) {
+	var order []HardlinkKey
+	groups := make(map[HardlinkKey][]hardlinkMember)
+	// whether the children of a directory are selected
+	childrenSelected := map[string]bool{string(filepath.Separator): true}
+
+	_, err := walkTree(ctx, res.repo, dst, string(filepath.Separator), res.root, &TreeVisitor{
+		// all nodes are visited, the selection is tracked separately
+		SelectFilter: func(item string, dstpath string, node *restic.Node) (bool, bool) {
+			reachable := res.opts.MaxDepth <= 0 || depth(item) <= res.opts.MaxDepth
+			parentSelected := childrenSelected[filepath.Dir(item)]
+			selected, childMayBeSelected := res.SelectFilter(item, dstpath, node)
+			if node.Type == "dir" {
+				childrenSelected[item] = parentSelected && childMayBeSelected &&
+					(res.opts.MaxDepth <= 0 || depth(item) < res.opts.MaxDepth)
+			}
+			if node.Type == "file" && node.Links > 1 {
+				key := HardlinkKey{Inode: node.Inode, Device: node.DeviceID}
+				if _, ok := groups[key]; !ok {
+					order = append(order, key)
+				}
+				groups[key] = append(groups[key], hardlinkMember{
+					location:  item,
+					selected:  parentSelected && selected && reachable,
+					reachable: reachable,
+				})
+			}
+			return false, true
+		},
+		Error: res.handleError,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var split [][]hardlinkMember
+	for _, key := range order {
+		members := groups[key]
+		selected := 0
+		for _, member := range members {
+			if member.selected {
+				selected++
+			}
+		}
+		if selected > 0 && selected < len(members) {
+			split = append(split, members)
+		}
+	}
+	return split, nil
+}
+
+// promotedHardlinks are the files selected by Options.PromoteHardlinks in
+// addition to SelectFilter, along with their parent directories.
+type promotedHardlinks struct {
+	files map[string]struct{}
+	dirs  map[string]struct{}
+}
+
+// handleSplitHardlinks promotes the unselected members of the groups if
+// Options.PromoteHardlinks is set and warns about all members which are not
+// restored if Options.WarnBrokenHardlinks is set.
+func (res *Restorer) handleSplitHardlinks(groups [][]hardlinkMember) *promotedHardlinks {
+	promoted := &promotedHardlinks{
+		files: make(map[string]struct{}),
+		dirs:  make(map[string]struct{}),
+	}
+	for _, members := range groups {
+		var first string
+		var broken []string
+		for _, member := range members {
+			switch {
+			case member.selected:
+				if first == "" {
+					first = member.location
+				}
+			case res.opts.PromoteHardlinks && member.reachable:
+				promoted.add(member.location)
+			default:
+				broken = append(broken, member.location)
+			}
+		}
+		if res.opts.WarnBrokenHardlinks && len(broken) > 0 {
+			res.warn(fmt.Sprintf("%v: hardlinked files %v are not selected, the link is not restored", first, strings.Join(broken, ", ")))
+		}
+	}
+	return promoted
+}
+
+func (p *promotedHardlinks) add(location string) {
+	p.files[location] = struct{}{}
+	for dir := filepath.Dir(location); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		p.dirs[dir] = struct{}{}
+	}
+}
+
+// wrap returns a SelectFilter which additionally selects the promoted files
+// and descends into their parent directories. Other children of these
+// directories are only selected if filter selects them and their parent.
+// The returned filter must only be used for a single traversal. A nil
+// promotedHardlinks returns filter unchanged.
+func (p *promotedHardlinks) wrap(filter SelectFilter) SelectFilter {
+	if p == nil || len(p.files) == 0 {
+		return filter
+	}
+	// whether filter selects the children of a directory
+	childrenSelected := map[string]bool{string(filepath.Separator): true}
+	return func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selectedForRestore, childMayBeSelected := false, false
+		if childrenSelected[filepath.Dir(item)] {
+			selectedForRestore, childMayBeSelected = filter(item, dstpath, node)
+		}
+		if node.Type == "dir" {
+			childrenSelected[item] = childMayBeSelected
+		}
+		if _, ok := p.files[item]; ok {
+			selectedForRestore = true
+		}
+		if _, ok := p.dirs[item]; ok {
+			childMayBeSelected = true
+		}
+		return selectedForRestore, childMayBeSelected
+	}
+}
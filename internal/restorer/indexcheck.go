@@ -0,0 +1,217 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// IndexProblemKind describes an inconsistency found by CheckIndexConsistency.
+type IndexProblemKind int
+
+const (
+	// IndexBlobMissing is a content blob which is not contained in the index.
+	IndexBlobMissing IndexProblemKind = iota
+	// IndexSizeMismatch is a file whose size differs from the total length
+	// of its content blobs according to the index.
+	IndexSizeMismatch
+	// IndexLengthMismatch is a blob which is stored with different lengths
+	// in different packs.
+	IndexLengthMismatch
+	// IndexPackMismatch is a blob which the index lists for a pack, but which
+	// the header of that pack does not contain at the same position and with
+	// the same length, or whose pack is missing or has an unreadable header.
+	IndexPackMismatch
+)
+
+func (k IndexProblemKind) String() string {
+	switch k {
+	case IndexBlobMissing:
+		return "blob missing"
+	case IndexSizeMismatch:
+		return "size mismatch"
+	case IndexLengthMismatch:
+		return "length mismatch"
+	case IndexPackMismatch:
+		return "pack mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// IndexProblem is an inconsistency between the selected files and the
+// repository index.
+type IndexProblem struct {
+	Kind IndexProblemKind
+	// Path is the location of the first affected file within the snapshot.
+	Path   string
+	BlobID restic.ID // zero for IndexSizeMismatch
+	PackID restic.ID // only set for IndexLengthMismatch and IndexPackMismatch
+	// Expected and Actual are the mismatching sizes, if any.
+	Expected, Actual uint64
+}
+
+func (p IndexProblem) String() string {
+	switch p.Kind {
+	case IndexBlobMissing:
+		return fmt.Sprintf("%v: blob %v missing from index", p.Path, p.BlobID.Str())
+	case IndexSizeMismatch:
+		return fmt.Sprintf("%v: size %v, but the content blobs contain %v bytes", p.Path, p.Expected, p.Actual)
+	case IndexLengthMismatch:
+		return fmt.Sprintf("%v: blob %v has length %v in pack %v, expected %v", p.Path, p.BlobID.Str(), p.Actual, p.PackID.Str(), p.Expected)
+	default:
+		return fmt.Sprintf("%v: blob %v is inconsistent with pack %v", p.Path, p.BlobID.Str(), p.PackID.Str())
+	}
+}
+
+// CheckIndexConsistency checks that the repository index is consistent for
+// the content of all files selected by SelectFilter, without loading any
+// blob. Each content blob must be contained in the index, the lengths of the
+// blobs of a file must add up to its size, all copies of a blob must have the
+// same length and the header of each pack must contain the blobs the index
+// attributes to it. Only the trees, the index and the pack headers are read.
+// Problems of
+// files are returned in traversal order, followed by those of packs sorted by
+// pack ID. As for RequiredPacks, stubs are skipped.
+func (res *Restorer) CheckIndexConsistency(ctx context.Context) ([]IndexProblem, error) {
+	var problems []IndexProblem
+
+	blobs := make(map[restic.ID]indexBlob)
+	// the blobs which the index attributes to each pack
+	expected := make(map[restic.ID][]restic.Blob)
+	// the location of the first file referencing each blob
+	firstUse := make(map[restic.ID]string)
+
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		visitNode: func(node *restic.Node, _, location string) error {
+			if node.Type != "file" || (res.opts.StubFilter != nil && res.opts.StubFilter(node)) {
+				return nil
+			}
+			var size uint64
+			complete := true
+			for _, id := range node.Content {
+				info, ok := blobs[id]
+				if !ok {
+					info = res.lookupIndexBlob(id, location, expected, &problems)
+					blobs[id] = info
+					firstUse[id] = location
+				}
+				if !info.found {
+					problems = append(problems, IndexProblem{Kind: IndexBlobMissing, Path: location, BlobID: id})
+					complete = false
+					continue
+				}
+				size += info.length
+			}
+			if complete && size != node.Size {
+				problems = append(problems, IndexProblem{Kind: IndexSizeMismatch, Path: location, Expected: node.Size, Actual: size})
+			}
+			return ctx.Err()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packSizes := make(map[restic.ID]int64, len(expected))
+	err = res.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if _, ok := expected[id]; ok {
+			packSizes[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var packProblems []IndexProblem
+	for packID, want := range expected {
+		var have []restic.Blob
+		if size, ok := packSizes[packID]; ok {
+			have, _, err = res.repo.ListPack(ctx, packID, size)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				debug.Log("unable to list pack %v: %v", packID, err)
+				have = nil
+			}
+		}
+		for _, blob := range inconsistentPackBlobs(want, have) {
+			packProblems = append(packProblems, IndexProblem{
+				Kind:   IndexPackMismatch,
+				Path:   firstUse[blob.ID],
+				BlobID: blob.ID,
+				PackID: packID,
+			})
+		}
+	}
+	sort.Slice(packProblems, func(i, j int) bool {
+		a, b := packProblems[i], packProblems[j]
+		if a.PackID != b.PackID {
+			return string(a.PackID[:]) < string(b.PackID[:])
+		}
+		return string(a.BlobID[:]) < string(b.BlobID[:])
+	})
+	return append(problems, packProblems...), nil
+}
+
+// indexBlob is the length of a data blob according to the index.
+type indexBlob struct {
+	length uint64
+	found  bool
+}
+
+// lookupIndexBlob returns the length of the data blob id according to the
+// index. All packs containing the blob are recorded in expected, length
+// mismatches between them are added to problems.
+func (res *Restorer) lookupIndexBlob(id restic.ID, location string, expected map[restic.ID][]restic.Blob, problems *[]IndexProblem) (info indexBlob) {
+	packed := res.repo.LookupBlob(restic.DataBlob, id)
+	if len(packed) == 0 {
+		return info
+	}
+	info.found = true
+	info.length = uint64(packed[0].DataLength())
+	for _, pb := range packed {
+		expected[pb.PackID] = append(expected[pb.PackID], pb.Blob)
+		if length := uint64(pb.DataLength()); length != info.length {
+			*problems = append(*problems, IndexProblem{
+				Kind:     IndexLengthMismatch,
+				Path:     location,
+				BlobID:   id,
+				PackID:   pb.PackID,
+				Expected: info.length,
+				Actual:   length,
+			})
+		}
+	}
+	return info
+}
+
+// inconsistentPackBlobs returns the blobs of want which are not contained at
+// the same offset and with the same length in have, the blobs listed by the
+// header of a pack.
+func inconsistentPackBlobs(want []restic.Blob, have []restic.Blob) []restic.Blob {
+	type entry struct {
+		handle restic.BlobHandle
+		offset uint
+	}
+	contained := make(map[entry]restic.Blob, len(have))
+	for _, blob := range have {
+		contained[entry{blob.BlobHandle, blob.Offset}] = blob
+	}
+
+	var result []restic.Blob
+	for _, blob := range want {
+		found, ok := contained[entry{blob.BlobHandle, blob.Offset}]
+		if !ok || found.Length != blob.Length || found.UncompressedLength != blob.UncompressedLength {
+			result = append(result, blob)
+		}
+	}
+	return result
+}
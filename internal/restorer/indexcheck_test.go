@@ -0,0 +1,99 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// modifiedIndexRepo changes the index entries returned by LookupBlob.
+type modifiedIndexRepo struct {
+	restic.Repository
+	modify func(id restic.ID, blobs []restic.PackedBlob) []restic.PackedBlob
+}
+
+func (r modifiedIndexRepo) LookupBlob(t restic.BlobType, id restic.ID) []restic.PackedBlob {
+	return r.modify(id, r.Repository.LookupBlob(t, id))
+}
+
+// modifiedPackRepo changes the pack header entries returned by ListPack.
+type modifiedPackRepo struct {
+	restic.Repository
+	modify func(id restic.ID, blobs []restic.Blob) []restic.Blob
+}
+
+func (r modifiedPackRepo) ListPack(ctx context.Context, id restic.ID, packSize int64) ([]restic.Blob, uint32, error) {
+	blobs, hdrSize, err := r.Repository.ListPack(ctx, id, packSize)
+	return r.modify(id, blobs), hdrSize, err
+}
+
+func TestRestorerCheckIndexConsistency(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"missing": File{Data: "content: missing\n"},
+				"resized": File{Data: "content: resized\n"},
+				"intact":  File{Data: "content: intact\n"},
+			}},
+			"excluded": File{Data: "content: excluded\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	missing := restic.Hash([]byte("content: missing\n"))
+	resized := restic.Hash([]byte("content: resized\n"))
+	resizedPack := repo.LookupBlob(restic.DataBlob, resized)[0].PackID
+
+	res := NewRestorer(repo, sn, Options{})
+	problems, err := res.CheckIndexConsistency(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(problems))
+
+	res = NewRestorer(modifiedIndexRepo{repo, func(id restic.ID, blobs []restic.PackedBlob) []restic.PackedBlob {
+		switch id {
+		case missing:
+			return nil
+		case resized:
+			blobs = append([]restic.PackedBlob(nil), blobs...)
+			blobs[0].Length++
+			if blobs[0].IsCompressed() {
+				blobs[0].UncompressedLength++
+			}
+		}
+		return blobs
+	}}, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/excluded", true
+	}
+	problems, err = res.CheckIndexConsistency(context.TODO())
+	rtest.OK(t, err)
+
+	size := uint64(len("content: resized\n"))
+	rtest.Equals(t, []IndexProblem{
+		{Kind: IndexBlobMissing, Path: filepath.FromSlash("/dir/missing"), BlobID: missing},
+		{Kind: IndexSizeMismatch, Path: filepath.FromSlash("/dir/resized"), Expected: size, Actual: size + 1},
+		{Kind: IndexPackMismatch, Path: filepath.FromSlash("/dir/resized"), BlobID: resized, PackID: resizedPack},
+	}, problems)
+
+	// the index is consistent, but the pack header lacks a blob
+	intact := restic.Hash([]byte("content: intact\n"))
+	intactPack := repo.LookupBlob(restic.DataBlob, intact)[0].PackID
+	res = NewRestorer(modifiedPackRepo{repo, func(id restic.ID, blobs []restic.Blob) []restic.Blob {
+		var result []restic.Blob
+		for _, blob := range blobs {
+			if blob.ID != intact {
+				result = append(result, blob)
+			}
+		}
+		return result
+	}}, sn, Options{})
+	problems, err = res.CheckIndexConsistency(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, []IndexProblem{
+		{Kind: IndexPackMismatch, Path: filepath.FromSlash("/dir/intact"), BlobID: intact, PackID: intactPack},
+	}, problems)
+}
@@ -0,0 +1,101 @@
+package restorer
+
+import (
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// localBlob is the location of a blob within a restored file.
+type localBlob struct {
+	path   string
+	offset int64
+	length uint
+}
+
+// localBlobs records where the blobs of restored files are stored in a
+// target directory. RestoreToMany uses it to read blobs from the first target
+// instead of downloading them again for each further target.
+type localBlobs struct {
+	lookupSize func(t restic.BlobType, id restic.ID) (size uint, exists bool)
+	blobs      map[restic.ID]localBlob
+}
+
+func newLocalBlobs(lookupSize func(t restic.BlobType, id restic.ID) (size uint, exists bool)) *localBlobs {
+	return &localBlobs{
+		lookupSize: lookupSize,
+		blobs:      make(map[restic.ID]localBlob),
+	}
+}
+
+// addFile records the blobs of the file at path, which must contain exactly
+// content. It is a no-op for a nil localBlobs.
+func (l *localBlobs) addFile(path string, content restic.IDs) {
+	if l == nil {
+		return
+	}
+	var offset int64
+	for _, id := range content {
+		size, ok := l.lookupSize(restic.DataBlob, id)
+		if !ok {
+			return
+		}
+		if _, ok := l.blobs[id]; !ok {
+			l.blobs[id] = localBlob{path: path, offset: offset, length: size}
+		}
+		offset += int64(size)
+	}
+}
+
+// load reads the blob from the restored file and verifies its hash, as the
+// file may have been modified in the meantime.
+func (l *localBlobs) load(id restic.ID) ([]byte, bool) {
+	blob, ok := l.blobs[id]
+	if !ok {
+		return nil, false
+	}
+	f, err := fs.OpenFile(blob.path, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		debug.Log("localBlobs: open %v: %v", blob.path, err)
+		return nil, false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	buf := make([]byte, blob.length)
+	if _, err := f.ReadAt(buf, blob.offset); err != nil && err != io.EOF {
+		debug.Log("localBlobs: read %v from %v: %v", id.Str(), blob.path, err)
+		return nil, false
+	}
+	if !restic.Hash(buf).Equal(id) {
+		debug.Log("localBlobs: %v in %v was modified", id.Str(), blob.path)
+		return nil, false
+	}
+	return buf, true
+}
+
+// wrap returns a blobsLoaderFn which reads blobs from the restored files and
+// only passes requests for other blobs on to loader.
+func (l *localBlobs) wrap(loader blobsLoaderFn) blobsLoaderFn {
+	return func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		var missing []restic.Blob
+		for _, blob := range blobs {
+			buf, ok := l.load(blob.ID)
+			if !ok {
+				missing = append(missing, blob)
+				continue
+			}
+			if err := handleBlobFn(blob.BlobHandle, buf, nil); err != nil {
+				return err
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		return loader(ctx, packID, missing, handleBlobFn)
+	}
+}
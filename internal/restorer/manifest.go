@@ -0,0 +1,74 @@
+package restorer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ContentHash returns the hash of a file's content as listed in the manifest
+// written to Options.ManifestWriter. It is the SHA-256 hash of the
+// concatenated IDs of the content blobs, thus it can be derived from a
+// snapshot without reading the file.
+func ContentHash(content restic.IDs) restic.ID {
+	buf := make([]byte, 0, len(content)*len(restic.ID{}))
+	for _, id := range content {
+		buf = append(buf, id[:]...)
+	}
+	return restic.Hash(buf)
+}
+
+type manifestEntry struct {
+	location string
+	size     uint64
+	hash     restic.ID
+}
+
+// manifest collects the files restored by RestoreTo. All methods are no-ops
+// for a nil manifest.
+type manifest struct {
+	wr      io.Writer
+	entries []manifestEntry
+}
+
+func newManifest(wr io.Writer) *manifest {
+	if wr == nil {
+		return nil
+	}
+	return &manifest{wr: wr}
+}
+
+func (m *manifest) add(location string, node *restic.Node) {
+	if m == nil {
+		return
+	}
+	m.entries = append(m.entries, manifestEntry{
+		location: location,
+		size:     node.Size,
+		hash:     ContentHash(node.Content),
+	})
+}
+
+// write writes one line "<hash> <size> <path>" per file, sorted by path.
+// Paths use forward slashes, independent of the operating system.
+func (m *manifest) write() error {
+	if m == nil {
+		return nil
+	}
+	sort.Slice(m.entries, func(i, j int) bool {
+		return m.entries[i].location < m.entries[j].location
+	})
+
+	wr := bufio.NewWriter(m.wr)
+	for _, e := range m.entries {
+		if _, err := fmt.Fprintf(wr, "%v %d %s\n", e.hash, e.size, filepath.ToSlash(e.location)); err != nil {
+			return errors.Wrap(err, "write manifest")
+		}
+	}
+	return errors.Wrap(wr.Flush(), "write manifest")
+}
@@ -0,0 +1,99 @@
+package restorer
+
+import (
+	"context"
+)
+
+// Default number of workers which restore the metadata of files.
+const nMetadataWorkers = 8
+
+// metadataPool restores the metadata of files in the second pass of the
+// restore in parallel. Each job consists of apply, which runs on a worker,
+// and complete, which runs once wait collects the job. Jobs are collected in
+// the order they were submitted, such that errors are reported and complete
+// is called in traversal order. With at most one worker, all jobs run
+// immediately in submit.
+type metadataPool struct {
+	sem  chan struct{}
+	jobs []*metadataJob
+	// handleError is called by wait for each failed job. If it returns an
+	// error, the restore is aborted.
+	handleError func(location string, err error) error
+}
+
+type metadataJob struct {
+	location string
+	done     chan struct{}
+	err      error
+	complete func()
+}
+
+func newMetadataPool(workers int, handleError func(location string, err error) error) *metadataPool {
+	p := &metadataPool{handleError: handleError}
+	if workers > 1 {
+		p.sem = make(chan struct{}, workers)
+	}
+	return p
+}
+
+// submit runs apply for the item at location, which may be nil if there is
+// nothing to apply. complete is only called if apply succeeds. Errors are
+// only returned directly if the jobs run sequentially.
+func (p *metadataPool) submit(location string, apply func() error, complete func()) error {
+	if p.sem == nil {
+		if apply != nil {
+			if err := apply(); err != nil {
+				return err
+			}
+		}
+		complete()
+		return nil
+	}
+
+	job := &metadataJob{location: location, done: make(chan struct{}), complete: complete}
+	p.jobs = append(p.jobs, job)
+	if apply == nil {
+		close(job.done)
+		return nil
+	}
+	p.sem <- struct{}{}
+	go func() {
+		job.err = apply()
+		<-p.sem
+		close(job.done)
+	}()
+	return nil
+}
+
+// wait waits for all submitted jobs and collects them in order. Once a job
+// fails and handleError returns an error, the remaining jobs are dropped and
+// the error is returned.
+func (p *metadataPool) wait() error {
+	jobs := p.jobs
+	p.jobs = nil
+	for _, job := range jobs {
+		<-job.done
+	}
+	for _, job := range jobs {
+		switch job.err {
+		case nil:
+			job.complete()
+		case context.Canceled, context.DeadlineExceeded:
+			// context errors are permanent, like for the tree walk
+			return job.err
+		default:
+			if err := p.handleError(job.location, job.err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// close waits for all running jobs without collecting them.
+func (p *metadataPool) close() {
+	for _, job := range p.jobs {
+		<-job.done
+	}
+	p.jobs = nil
+}
@@ -0,0 +1,129 @@
+package restorer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is a snapshot of the counters of a running restore.
+type Metrics struct {
+	BytesFetched  uint64  // blob data loaded from the repository
+	BytesWritten  uint64  // data written to the restored files
+	FilesDone     uint64  // files whose content was restored completely
+	ActiveWorkers int64   // workers currently downloading a pack
+	Throughput    float64 // bytes written per second since the previous report
+}
+
+// MetricsSink receives periodic metrics reports while RestoreTo is running.
+type MetricsSink interface {
+	ReportMetrics(m Metrics)
+}
+
+// Default interval between two metrics reports.
+const defaultMetricsInterval = time.Second
+
+// restoreMetrics collects the counters reported to a MetricsSink. All methods
+// are no-ops for a nil restoreMetrics.
+type restoreMetrics struct {
+	bytesFetched  atomic.Uint64
+	bytesWritten  atomic.Uint64
+	filesDone     atomic.Uint64
+	activeWorkers atomic.Int64
+
+	m       sync.Mutex
+	pending map[string]uint64 // bytes still to be written per file
+}
+
+func newRestoreMetrics() *restoreMetrics {
+	return &restoreMetrics{
+		pending: make(map[string]uint64),
+	}
+}
+
+func (m *restoreMetrics) fetched(size int) {
+	if m == nil {
+		return
+	}
+	m.bytesFetched.Add(uint64(size))
+}
+
+// written records that size bytes of the file at location, which has a total
+// size of total bytes, were written.
+func (m *restoreMetrics) written(location string, size uint64, total uint64) {
+	if m == nil {
+		return
+	}
+	m.bytesWritten.Add(size)
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	pending, ok := m.pending[location]
+	if !ok {
+		pending = total
+	}
+	if size >= pending {
+		delete(m.pending, location)
+		m.filesDone.Add(1)
+		return
+	}
+	m.pending[location] = pending - size
+}
+
+func (m *restoreMetrics) workerActive(delta int64) {
+	if m == nil {
+		return
+	}
+	m.activeWorkers.Add(delta)
+}
+
+// report calls sink.ReportMetrics every interval until the returned function
+// is called or ctx is cancelled. The throughput is computed using the time
+// returned by clock. The returned function waits for the reporting goroutine
+// to exit.
+func (m *restoreMetrics) report(ctx context.Context, sink MetricsSink, interval time.Duration, clock Clock) (stop func()) {
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := clock.Now()
+		var lastWritten uint64
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				now := clock.Now()
+				written := m.bytesWritten.Load()
+				var throughput float64
+				if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+					throughput = float64(written-lastWritten) / elapsed
+				}
+				sink.ReportMetrics(Metrics{
+					BytesFetched:  m.bytesFetched.Load(),
+					BytesWritten:  written,
+					FilesDone:     m.filesDone.Load(),
+					ActiveWorkers: m.activeWorkers.Load(),
+					Throughput:    throughput,
+				})
+				last, lastWritten = now, written
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,78 @@
+package restorer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type testMetricsSink struct {
+	m       sync.Mutex
+	reports []Metrics
+}
+
+func (s *testMetricsSink) ReportMetrics(m Metrics) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.reports = append(s.reports, m)
+}
+
+func TestRestoreMetrics(t *testing.T) {
+	m := newRestoreMetrics()
+	m.fetched(10)
+	m.written("/file", 4, 10)
+	m.written("/empty", 0, 0)
+	rtest.Equals(t, uint64(1), m.filesDone.Load())
+	m.written("/file", 6, 10)
+	rtest.Equals(t, uint64(2), m.filesDone.Load())
+	rtest.Equals(t, uint64(10), m.bytesWritten.Load())
+	rtest.Equals(t, 0, len(m.pending))
+
+	var sink testMetricsSink
+	stop := m.report(context.Background(), &sink, time.Millisecond, systemClock{})
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	sink.m.Lock()
+	n := len(sink.reports)
+	rtest.Assert(t, n > 0, "no metrics reported")
+	rtest.Equals(t, uint64(10), sink.reports[0].BytesFetched)
+	rtest.Equals(t, uint64(2), sink.reports[0].FilesDone)
+	sink.m.Unlock()
+
+	// no reports after stop returned
+	time.Sleep(10 * time.Millisecond)
+	sink.m.Lock()
+	rtest.Equals(t, n, len(sink.reports))
+	sink.m.Unlock()
+}
+
+func TestRestorerMetrics(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	var sink testMetricsSink
+	res := NewRestorer(repo, sn, Options{Metrics: &sink, MetricsInterval: time.Millisecond})
+	res.SelectFilter = func(string, string, *restic.Node) (bool, bool) {
+		// slow down the restore to receive at least one report
+		time.Sleep(5 * time.Millisecond)
+		return true, true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rtest.OK(t, res.RestoreTo(ctx, rtest.TempDir(t)))
+
+	sink.m.Lock()
+	defer sink.m.Unlock()
+	rtest.Assert(t, len(sink.reports) > 0, "no metrics reported")
+}
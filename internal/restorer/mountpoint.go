@@ -0,0 +1,123 @@
+package restorer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// mountpointChecker verifies that neither a restored directory nor one of its
+// parent directories within the target is a mountpoint, see
+// Options.RefuseMountpoints. A directory is a mountpoint if its device differs
+// from that of its parent directory.
+type mountpointChecker struct {
+	dst     string
+	allowed map[string]struct{}
+	// deviceID returns the device of an existing directory, or an error
+	// wrapping os.ErrNotExist
+	deviceID func(dir string) (uint64, error)
+
+	devices  map[string]uint64
+	checked  map[string]error
+	reported map[string]struct{}
+}
+
+func newMountpointChecker(dst string, allowed []string) *mountpointChecker {
+	c := &mountpointChecker{
+		dst:      dst,
+		allowed:  make(map[string]struct{}, len(allowed)),
+		deviceID: lstatDeviceID,
+		devices:  make(map[string]uint64),
+		checked:  make(map[string]error),
+		reported: make(map[string]struct{}),
+	}
+	for _, dir := range allowed {
+		c.allowed[filepath.Clean(dir)] = struct{}{}
+	}
+	return c
+}
+
+func lstatDeviceID(dir string) (uint64, error) {
+	fi, err := fs.Lstat(dir)
+	if err != nil {
+		return 0, err
+	}
+	return fs.DeviceID(fi)
+}
+
+// check verifies target and its parent directories below dst. Like for
+// targetChecker, the error for an offending directory is only returned once,
+// later calls just return ok == false and a nil error.
+func (c *mountpointChecker) check(target string) (ok bool, err error) {
+	rel, err := filepath.Rel(c.dst, target)
+	if err != nil {
+		return false, err
+	}
+	if rel == "." {
+		return true, nil
+	}
+
+	dir := c.dst
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+
+		err, found := c.checked[dir]
+		if !found {
+			var exists bool
+			exists, err = c.checkDir(dir)
+			if !exists {
+				// nothing below dir exists yet, thus there is no mountpoint
+				return true, nil
+			}
+			c.checked[dir] = err
+		}
+
+		if err != nil {
+			if _, ok := c.reported[dir]; ok {
+				return false, nil
+			}
+			c.reported[dir] = struct{}{}
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (c *mountpointChecker) checkDir(dir string) (exists bool, err error) {
+	device, err := c.device(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	parent, err := c.device(filepath.Dir(dir))
+	if err != nil {
+		return true, err
+	}
+	if device == parent {
+		return true, nil
+	}
+	if _, ok := c.allowed[dir]; ok {
+		debug.Log("restoring into allowed mountpoint %v", dir)
+		return true, nil
+	}
+	return true, errors.Errorf("refusing to restore into mountpoint %v", dir)
+}
+
+func (c *mountpointChecker) device(dir string) (uint64, error) {
+	if device, ok := c.devices[dir]; ok {
+		return device, nil
+	}
+	device, err := c.deviceID(dir)
+	if err != nil {
+		return 0, err
+	}
+	c.devices[dir] = device
+	return device, nil
+}
@@ -0,0 +1,163 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// OpenFile returns a reader for the content of the file at snapshotPath
+// within the snapshot, for example /var/lib/app/data, along with its node.
+// The blobs are loaded from the repository when they are read, nothing is
+// written to the file system. Besides io.ReadCloser, the reader implements
+// io.ReaderAt and io.Seeker, which only load the blobs covering the
+// requested range. ctx is used for all blobs loaded by the reader.
+func (res *Restorer) OpenFile(ctx context.Context, snapshotPath string) (io.ReadCloser, *restic.Node, error) {
+	dir, name := path.Split(path.Clean("/" + snapshotPath))
+	if name == "" {
+		return nil, nil, errors.Errorf("%v is not a file", snapshotPath)
+	}
+	treeID, err := restic.FindTreeDirectory(ctx, res.repo, &res.tree, dir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "snapshot path %v", snapshotPath)
+	}
+	tree, err := restic.LoadTree(ctx, res.repo, *treeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	node := tree.Find(name)
+	if node == nil {
+		return nil, nil, errors.Errorf("snapshot path %v: not found", snapshotPath)
+	}
+	if node.Type != "file" {
+		return nil, nil, errors.Errorf("snapshot path %v: not a file", snapshotPath)
+	}
+
+	offsets := make([]uint64, 1+len(node.Content))
+	for i, id := range node.Content {
+		size, found := res.repo.LookupBlobSize(restic.DataBlob, id)
+		if !found {
+			return nil, nil, errors.Errorf("snapshot path %v: blob %v not found in repository", snapshotPath, id.Str())
+		}
+		offsets[i+1] = offsets[i] + uint64(size)
+	}
+	if offsets[len(node.Content)] != node.Size {
+		return nil, nil, errors.Errorf("snapshot path %v: size %v, but the content blobs contain %v bytes", snapshotPath, node.Size, offsets[len(node.Content)])
+	}
+
+	return &fileReader{
+		ctx:     ctx,
+		repo:    res.repo,
+		content: node.Content,
+		offsets: offsets,
+		cached:  -1,
+	}, node, nil
+}
+
+// fileReader reads the content of a file from the repository. ReadAt may be
+// called concurrently, Read and Seek must not.
+type fileReader struct {
+	ctx     context.Context
+	repo    restic.Repository
+	content restic.IDs
+	// offsets[i] is the offset of content[i] within the file, the last
+	// entry is the size of the file
+	offsets []uint64
+	pos     int64
+
+	// the most recently loaded blob, which is reused by sequential reads
+	lock   sync.Mutex
+	cached int
+	buf    []byte
+	closed bool
+}
+
+func (r *fileReader) size() int64 {
+	return int64(r.offsets[len(r.offsets)-1])
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *fileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) && off < r.size() {
+		i := sort.Search(len(r.content), func(i int) bool {
+			return r.offsets[i+1] > uint64(off)
+		})
+		copied, err := r.copyBlob(i, p[n:], uint64(off)-r.offsets[i])
+		n += copied
+		off += int64(copied)
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// copyBlob copies the content of blob i starting at offset to p.
+func (r *fileReader) copyBlob(i int, p []byte, offset uint64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.cached != i {
+		buf, err := r.repo.LoadBlob(r.ctx, restic.DataBlob, r.content[i], r.buf)
+		if err != nil {
+			r.cached = -1
+			return 0, err
+		}
+		if uint64(len(buf)) != r.offsets[i+1]-r.offsets[i] {
+			r.cached = -1
+			return 0, errors.Errorf("blob %v has length %v, expected %v", r.content[i].Str(), len(buf), r.offsets[i+1]-r.offsets[i])
+		}
+		r.buf, r.cached = buf, i
+	}
+	return copy(p, r.buf[offset:]), nil
+}
+
+func (r *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size()
+	default:
+		return 0, errors.Errorf("invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *fileReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed, r.buf = true, nil
+	return nil
+}
@@ -0,0 +1,83 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerOpenFile(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file":  File{Data: "content: file\n", Mode: 0640},
+				"empty": File{},
+			}},
+			"a": File{Data: "0123456789"},
+			"b": File{Data: "abcdefghij"},
+		},
+	}, noopGetGenericAttributes)
+	ctx := context.TODO()
+	res := NewRestorer(repo, sn, Options{})
+
+	rd, node, err := res.OpenFile(ctx, "/dir/file")
+	rtest.OK(t, err)
+	rtest.Equals(t, "file", node.Name)
+	rtest.Equals(t, uint64(14), node.Size)
+	data, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: file\n", string(data))
+	rtest.OK(t, rd.Close())
+	_, err = rd.Read(make([]byte, 1))
+	rtest.Assert(t, err != nil, "read after close succeeded")
+
+	rd, _, err = res.OpenFile(ctx, "dir/empty")
+	rtest.OK(t, err)
+	data, err = io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(data))
+
+	for snapshotPath, msg := range map[string]string{
+		"/dir":         "not a file",
+		"/":            "not a file",
+		"/missing":     "not found",
+		"/dir/missing": "not found",
+		"/a/file":      "not a directory",
+	} {
+		_, _, err := res.OpenFile(ctx, snapshotPath)
+		rtest.Assert(t, err != nil && strings.Contains(err.Error(), msg), "unexpected error for %v: %v", snapshotPath, err)
+	}
+
+	// a file consisting of the blobs of a and b
+	r := &fileReader{
+		ctx:     ctx,
+		repo:    repo,
+		content: restic.IDs{restic.Hash([]byte("0123456789")), restic.Hash([]byte("abcdefghij"))},
+		offsets: []uint64{0, 10, 20},
+		cached:  -1,
+	}
+	buf := make([]byte, 6)
+	n, err := r.ReadAt(buf, 7)
+	rtest.OK(t, err)
+	rtest.Equals(t, "789abc", string(buf[:n]))
+	n, err = r.ReadAt(buf, 17)
+	rtest.Equals(t, io.EOF, err)
+	rtest.Equals(t, "hij", string(buf[:n]))
+	_, err = r.ReadAt(buf, 20)
+	rtest.Equals(t, io.EOF, err)
+
+	pos, err := r.Seek(-5, io.SeekEnd)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(15), pos)
+	data, err = io.ReadAll(r)
+	rtest.OK(t, err)
+	rtest.Equals(t, "fghij", string(data))
+	_, err = r.Seek(-1, io.SeekStart)
+	rtest.Assert(t, err != nil, "seek to negative position succeeded")
+}
@@ -0,0 +1,133 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// WhiteoutPrefix is the prefix of the name of a whiteout file, which marks an
+// item of the lower layer as removed, see Options.OverlayWhiteouts.
+const WhiteoutPrefix = ".wh."
+
+// overlayPlan contains the differences between Options.OverlayLower and the
+// restored tree. Only the differing items are restored.
+type overlayPlan struct {
+	// added, modified and replaced items, by location
+	changed map[string]struct{}
+	// directories which contain a changed item or whiteout, by location
+	parents map[string]struct{}
+	// names of the removed items of each directory which are marked by a
+	// whiteout, by location of the directory
+	whiteouts map[string][]string
+}
+
+// loadOverlayPlan compares Options.OverlayLower with the restored tree. It
+// returns nil if the option is not set.
+func (res *Restorer) loadOverlayPlan(ctx context.Context) (*overlayPlan, error) {
+	lower := res.opts.OverlayLower
+	if lower == nil {
+		if res.opts.OverlayWhiteouts {
+			return nil, errors.New("Options.OverlayWhiteouts requires Options.OverlayLower")
+		}
+		return nil, nil
+	}
+	if lower.Tree == nil {
+		return nil, errors.New("lower snapshot has no tree")
+	}
+	// compare the same subtree as the one which is restored
+	lowerRoot, err := restic.FindTreeDirectory(ctx, res.repo, lower.Tree, res.rootPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "lower snapshot")
+	}
+
+	p := &overlayPlan{
+		changed:   make(map[string]struct{}),
+		parents:   make(map[string]struct{}),
+		whiteouts: make(map[string][]string),
+	}
+	// items which no longer exist as a directory, their removed children
+	// are hidden along with them
+	gone := make(map[string]struct{})
+	err = DiffTrees(ctx, res.repo, *lowerRoot, res.root, func(change DiffEntry) error {
+		dir := filepath.Dir(change.Path)
+		if change.Kind != DiffRemoved {
+			p.changed[change.Path] = struct{}{}
+			if change.Kind == DiffTypeChanged && change.Old.Type == "dir" {
+				gone[change.Path] = struct{}{}
+			}
+			p.addParents(dir)
+			return nil
+		}
+
+		if change.Old.Type == "dir" {
+			gone[change.Path] = struct{}{}
+		}
+		if _, ok := gone[dir]; ok || !res.opts.OverlayWhiteouts {
+			return nil
+		}
+		p.whiteouts[dir] = append(p.whiteouts[dir], change.Path)
+		p.addParents(dir)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "compare with lower snapshot")
+	}
+	debug.Log("overlay: %d changed items, whiteouts in %d directories", len(p.changed), len(p.whiteouts))
+	return p, nil
+}
+
+// addParents records dir and all its parents as containing changes.
+func (p *overlayPlan) addParents(dir string) {
+	for {
+		if _, ok := p.parents[dir]; ok {
+			return
+		}
+		p.parents[dir] = struct{}{}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// wrap returns a SelectFilter which only selects the items selected by filter
+// which differ from the lower snapshot, and the directories which contain
+// whiteouts. A nil overlayPlan returns filter unchanged.
+func (p *overlayPlan) wrap(filter SelectFilter) SelectFilter {
+	if p == nil {
+		return filter
+	}
+	return func(item string, dstpath string, node *restic.Node) (bool, bool) {
+		selectedForRestore, childMayBeSelected := filter(item, dstpath, node)
+		_, changed := p.changed[item]
+		_, hasWhiteouts := p.whiteouts[item]
+		_, hasChanges := p.parents[item]
+		return selectedForRestore && (changed || hasWhiteouts), childMayBeSelected && hasChanges
+	}
+}
+
+// writeWhiteouts creates the whiteout files for the removed items of the
+// directory at location, which is restored to target.
+func (p *overlayPlan) writeWhiteouts(target, location string) error {
+	if p == nil {
+		return nil
+	}
+	for _, removed := range p.whiteouts[location] {
+		name := filepath.Join(target, WhiteoutPrefix+filepath.Base(removed))
+		debug.Log("whiteout for %v at %v", removed, name)
+		f, err := fs.OpenFile(name, fs.O_CREATE|fs.O_TRUNC|fs.O_WRONLY|fs.O_NOFOLLOW, 0644)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := f.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package restorer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/restore"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// Crude estimate of the overhead per cached blob, see bloblru.
+const packCacheBlobOverhead = len(restic.ID{}) + 64
+
+// cachedPack holds the already downloaded blobs of a single pack.
+type cachedPack struct {
+	blobs map[restic.ID][]byte
+	size  int
+}
+
+// packCache is a memory-bounded LRU cache of blobs, grouped by the pack they
+// are stored in. Whole packs are evicted at once. It is safe for concurrent use.
+type packCache struct {
+	mu sync.Mutex
+	c  *simplelru.LRU[restic.ID, *cachedPack]
+
+	free, size int // Current and max capacity, in bytes.
+}
+
+// newPackCache returns a cache that holds at most size bytes worth of blobs.
+func newPackCache(size int) *packCache {
+	c := &packCache{
+		free: size,
+		size: size,
+	}
+
+	// the actual limit is enforced by evicting packs in add
+	maxEntries := size/packCacheBlobOverhead + 1
+	lru, err := simplelru.NewLRU[restic.ID, *cachedPack](maxEntries, c.evict)
+	if err != nil {
+		panic(err) // Can only be maxEntries <= 0.
+	}
+	c.c = lru
+
+	return c
+}
+
+func (c *packCache) get(packID restic.ID, blobID restic.ID) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pack, ok := c.c.Get(packID)
+	if !ok {
+		return nil, false
+	}
+	buf, ok := pack.blobs[blobID]
+	return buf, ok
+}
+
+// add stores a copy of buf as the content of blob blobID from pack packID.
+func (c *packCache) add(packID restic.ID, blobID restic.ID, buf []byte) {
+	size := len(buf) + packCacheBlobOverhead
+	if size > c.size {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pack, ok := c.c.Peek(packID)
+	if ok {
+		if _, ok := pack.blobs[blobID]; ok {
+			return
+		}
+		// prevent evicting the pack we're adding to
+		c.c.Remove(packID)
+	}
+	if !ok || pack.size+size > c.size {
+		pack = &cachedPack{blobs: make(map[restic.ID][]byte)}
+	}
+
+	for pack.size+size > c.free {
+		if _, _, ok := c.c.RemoveOldest(); !ok {
+			break
+		}
+	}
+
+	pack.blobs[blobID] = append([]byte(nil), buf...)
+	pack.size += size
+	c.free -= pack.size
+	c.c.Add(packID, pack)
+}
+
+func (c *packCache) evict(packID restic.ID, pack *cachedPack) {
+	debug.Log("packCache: evict %v, %d bytes", packID.Str(), pack.size)
+	c.free += pack.size
+}
+
+// wrap returns a blobsLoaderFn which serves blobs from the cache and only
+// passes requests for missing blobs on to loader. The cache lookups are
+// reported to progress.
+func (c *packCache) wrap(loader blobsLoaderFn, progress *restore.Progress) blobsLoaderFn {
+	return func(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		var missing []restic.Blob
+		for _, blob := range blobs {
+			buf, ok := c.get(packID, blob.ID)
+			if !ok {
+				missing = append(missing, blob)
+				continue
+			}
+			if err := handleBlobFn(blob.BlobHandle, buf, nil); err != nil {
+				return err
+			}
+		}
+		progress.AddCacheLookups(uint64(len(blobs)-len(missing)), uint64(len(missing)))
+		if len(missing) == 0 {
+			return nil
+		}
+
+		return loader(ctx, packID, missing, func(blob restic.BlobHandle, buf []byte, err error) error {
+			if err == nil {
+				c.add(packID, blob.ID, buf)
+			}
+			return handleBlobFn(blob, buf, err)
+		})
+	}
+}
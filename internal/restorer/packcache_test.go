@@ -0,0 +1,73 @@
+package restorer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestPackCache(t *testing.T) {
+	packID := restic.NewRandomID()
+	blobs := make([]restic.Blob, 3)
+	content := make(map[restic.ID][]byte)
+	for i := range blobs {
+		data := []byte{byte(i), byte(i), byte(i)}
+		id := restic.Hash(data)
+		blobs[i] = restic.Blob{BlobHandle: restic.BlobHandle{Type: restic.DataBlob, ID: id}}
+		content[id] = data
+	}
+
+	loaded := make(map[restic.ID]int)
+	loader := func(_ context.Context, _ restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+		for _, blob := range blobs {
+			loaded[blob.ID]++
+			// the cache must not hold on to the buffer passed to handleBlobFn
+			buf := append([]byte(nil), content[blob.ID]...)
+			if err := handleBlobFn(blob.BlobHandle, buf, nil); err != nil {
+				return err
+			}
+			buf[0] = 0xff
+		}
+		return nil
+	}
+
+	c := newPackCache(1 << 20)
+	cachedLoader := c.wrap(loader, nil)
+	for i := 0; i < 2; i++ {
+		rtest.OK(t, cachedLoader(context.TODO(), packID, blobs[:2], func(blob restic.BlobHandle, buf []byte, err error) error {
+			rtest.OK(t, err)
+			rtest.Equals(t, content[blob.ID], buf)
+			return nil
+		}))
+	}
+	rtest.OK(t, cachedLoader(context.TODO(), packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+		rtest.OK(t, err)
+		rtest.Equals(t, content[blob.ID], buf)
+		return nil
+	}))
+
+	for _, blob := range blobs {
+		rtest.Equals(t, 1, loaded[blob.ID], "blob %v loaded more than once", blob.ID.Str())
+	}
+}
+
+func TestPackCacheEvict(t *testing.T) {
+	blobSize := 1000
+	c := newPackCache(2 * (blobSize + packCacheBlobOverhead))
+
+	packs := restic.IDs{restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()}
+	blobID := restic.NewRandomID()
+	for _, packID := range packs {
+		c.add(packID, blobID, make([]byte, blobSize))
+	}
+
+	_, ok := c.get(packs[0], blobID)
+	rtest.Assert(t, !ok, "least recently used pack was not evicted")
+	for _, packID := range packs[1:] {
+		_, ok := c.get(packID, blobID)
+		rtest.Assert(t, ok, "pack %v missing from cache", packID.Str())
+	}
+	rtest.Equals(t, 0, c.free)
+}
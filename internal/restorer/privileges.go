@@ -0,0 +1,162 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// PrivilegedOperation is an operation of a restore which requires privileges
+// which an unprivileged process lacks.
+type PrivilegedOperation int
+
+const (
+	// PrivilegeChown is restoring an owner other than the current user, or a
+	// group the current user is not a member of. Such owners are silently
+	// not restored, unless Options.StrictOwnership is set.
+	PrivilegeChown PrivilegedOperation = iota
+	// PrivilegeSetuid is restoring the setuid or setgid bit of an item whose
+	// owner or group cannot be restored.
+	PrivilegeSetuid
+	// PrivilegeDeviceNodes is creating block or character devices.
+	PrivilegeDeviceNodes
+	// PrivilegeXattrs is restoring extended attributes in the trusted or
+	// security namespace.
+	PrivilegeXattrs
+)
+
+func (o PrivilegedOperation) String() string {
+	switch o {
+	case PrivilegeChown:
+		return "changing the owner"
+	case PrivilegeSetuid:
+		return "setting setuid or setgid bits"
+	case PrivilegeDeviceNodes:
+		return "creating device nodes"
+	case PrivilegeXattrs:
+		return "restoring privileged extended attributes"
+	default:
+		return "unknown"
+	}
+}
+
+// PrivilegeProblem describes the items which require a privileged
+// operation.
+type PrivilegeProblem struct {
+	Operation PrivilegedOperation
+	// Path is the location of the first affected item within the snapshot.
+	Path  string
+	Count int
+}
+
+func (p PrivilegeProblem) String() string {
+	if p.Count == 1 {
+		return fmt.Sprintf("%v: %v requires privileges", p.Path, p.Operation)
+	}
+	return fmt.Sprintf("%v and %d other items: %v requires privileges", p.Path, p.Count-1, p.Operation)
+}
+
+// processIdentity is the user and the groups of the restoring process.
+type processIdentity struct {
+	privileged bool
+	uid        uint32
+	groups     map[uint32]struct{}
+}
+
+func currentIdentity() (processIdentity, error) {
+	if os.Geteuid() == 0 {
+		return processIdentity{privileged: true}, nil
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return processIdentity{}, errors.WithStack(err)
+	}
+	id := processIdentity{
+		uid:    uint32(os.Geteuid()),
+		groups: map[uint32]struct{}{uint32(os.Getegid()): {}},
+	}
+	for _, gid := range groups {
+		id.groups[uint32(gid)] = struct{}{}
+	}
+	return id, nil
+}
+
+// CheckPrivileges returns the operations required to restore the items
+// selected by SelectFilter which the current process lacks the privileges
+// for, ordered by operation. Ownership is resolved as for the restore, using
+// Options.UserLookup and Options.GroupLookup. Only the trees are read and
+// nothing is written. For a privileged process or on Windows, no problems
+// are returned.
+func (res *Restorer) CheckPrivileges(ctx context.Context) ([]PrivilegeProblem, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+	id, err := currentIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return res.privilegeProblems(ctx, id)
+}
+
+func (res *Restorer) privilegeProblems(ctx context.Context, id processIdentity) ([]PrivilegeProblem, error) {
+	if id.privileged {
+		return nil, nil
+	}
+
+	problems := make(map[PrivilegedOperation]*PrivilegeProblem)
+	require := func(operation PrivilegedOperation, location string) {
+		p, ok := problems[operation]
+		if !ok {
+			p = &PrivilegeProblem{Operation: operation, Path: location}
+			problems[operation] = p
+		}
+		p.Count++
+	}
+	visit := func(node *restic.Node, location string) error {
+		owner := res.withOwner(node)
+		_, groupMember := id.groups[owner.GID]
+		if owner.UID != id.uid || !groupMember {
+			require(PrivilegeChown, location)
+		}
+		if node.Type != "dir" && ((node.Mode&os.ModeSetuid != 0 && owner.UID != id.uid) || (node.Mode&os.ModeSetgid != 0 && !groupMember)) {
+			require(PrivilegeSetuid, location)
+		}
+		if node.Type == "dev" || node.Type == "chardev" {
+			require(PrivilegeDeviceNodes, location)
+		}
+		for _, attr := range node.ExtendedAttributes {
+			if strings.HasPrefix(attr.Name, "trusted.") || strings.HasPrefix(attr.Name, "security.") {
+				require(PrivilegeXattrs, location)
+				break
+			}
+		}
+		return ctx.Err()
+	}
+
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		enterDir: func(node *restic.Node, _, location string) error {
+			return visit(node, location)
+		},
+		visitNode: func(node *restic.Node, _, location string) error {
+			return visit(node, location)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []PrivilegeProblem
+	for operation := PrivilegeChown; operation <= PrivilegeXattrs; operation++ {
+		if p, ok := problems[operation]; ok {
+			result = append(result, *p)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,272 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// TargetFeature is a feature of the target file system which is required to
+// restore some items.
+type TargetFeature int
+
+const (
+	// FeatureSymlinks is required to restore symlinks.
+	FeatureSymlinks TargetFeature = iota
+	// FeatureHardlinks is required to restore files with several hardlinks.
+	FeatureHardlinks
+	// FeatureXattrs is required to restore extended attributes.
+	FeatureXattrs
+	// FeatureSpecialFiles is required to restore fifos and device nodes.
+	FeatureSpecialFiles
+	// FeatureCaseSensitive is required to restore items of a directory
+	// whose names only differ in case.
+	FeatureCaseSensitive
+	// FeatureLongNames is required to restore items whose names are longer
+	// than TargetCapabilities.MaxNameLength.
+	FeatureLongNames
+)
+
+func (f TargetFeature) String() string {
+	switch f {
+	case FeatureSymlinks:
+		return "symlinks"
+	case FeatureHardlinks:
+		return "hardlinks"
+	case FeatureXattrs:
+		return "extended attributes"
+	case FeatureSpecialFiles:
+		return "special files"
+	case FeatureCaseSensitive:
+		return "case sensitive names"
+	case FeatureLongNames:
+		return "long names"
+	default:
+		return "unknown"
+	}
+}
+
+// TargetCapabilities are the features supported by the target file system,
+// as determined by ProbeTarget.
+type TargetCapabilities struct {
+	Symlinks      bool
+	Hardlinks     bool
+	Xattrs        bool
+	SpecialFiles  bool
+	CaseSensitive bool
+	// MaxNameLength is the maximum length of a name in bytes, up to
+	// maxProbeNameLength.
+	MaxNameLength int
+}
+
+func (c TargetCapabilities) supports(feature TargetFeature) bool {
+	switch feature {
+	case FeatureSymlinks:
+		return c.Symlinks
+	case FeatureHardlinks:
+		return c.Hardlinks
+	case FeatureXattrs:
+		return c.Xattrs
+	case FeatureSpecialFiles:
+		return c.SpecialFiles
+	case FeatureCaseSensitive:
+		return c.CaseSensitive
+	default:
+		// FeatureLongNames depends on the name, see MaxNameLength
+		return false
+	}
+}
+
+// TargetProblem describes the items which require a feature the target file
+// system does not support. Restoring them is likely to fail or lose data.
+type TargetProblem struct {
+	Feature TargetFeature
+	// Path is the location of the first affected item within the snapshot.
+	Path  string
+	Count int
+}
+
+func (p TargetProblem) String() string {
+	if p.Count == 1 {
+		return fmt.Sprintf("%v: %v not supported by the target", p.Path, p.Feature)
+	}
+	return fmt.Sprintf("%v and %d other items: %v not supported by the target", p.Path, p.Count-1, p.Feature)
+}
+
+// TargetReport is the result of ProbeTarget.
+type TargetReport struct {
+	Capabilities TargetCapabilities
+	// Problems lists the unsupported features required by the selected
+	// items, ordered by feature.
+	Problems []TargetProblem
+}
+
+// maxProbeNameLength is the longest name tried by ProbeTarget.
+const maxProbeNameLength = 1024
+
+// ProbeTarget checks which features the file system at dst supports and
+// whether the items selected by SelectFilter require any others. The
+// features are probed by creating items in a temporary directory in dst, or
+// in its closest existing parent directory, which is removed afterwards.
+// Only the trees are read. Call it before RestoreTo to decide on fallbacks,
+// for example Options.OnUnsupportedNode or Options.ConflictResolver.
+func (res *Restorer) ProbeTarget(ctx context.Context, dst string) (*TargetReport, error) {
+	capabilities, err := probeTarget(dst)
+	if err != nil {
+		return nil, err
+	}
+	problems, err := res.targetProblems(ctx, capabilities)
+	if err != nil {
+		return nil, err
+	}
+	return &TargetReport{Capabilities: capabilities, Problems: problems}, nil
+}
+
+// targetProblems returns the problems of restoring the selected items to a
+// file system with the given capabilities.
+func (res *Restorer) targetProblems(ctx context.Context, capabilities TargetCapabilities) ([]TargetProblem, error) {
+	problems := make(map[TargetFeature]*TargetProblem)
+	require := func(feature TargetFeature, location string) {
+		if capabilities.supports(feature) {
+			return
+		}
+		p, ok := problems[feature]
+		if !ok {
+			p = &TargetProblem{Feature: feature, Path: location}
+			problems[feature] = p
+		}
+		p.Count++
+	}
+	// lower case names of the items in each directory, by location
+	names := make(map[string]map[string]struct{})
+	visit := func(node *restic.Node, location string) {
+		if len(node.Name) > capabilities.MaxNameLength {
+			require(FeatureLongNames, location)
+		}
+		dir := filepath.Dir(location)
+		if names[dir] == nil {
+			names[dir] = make(map[string]struct{})
+		}
+		name := strings.ToLower(node.Name)
+		if _, ok := names[dir][name]; ok {
+			require(FeatureCaseSensitive, location)
+		}
+		names[dir][name] = struct{}{}
+		if len(node.ExtendedAttributes) > 0 {
+			require(FeatureXattrs, location)
+		}
+	}
+
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		enterDir: func(node *restic.Node, _, location string) error {
+			visit(node, location)
+			return ctx.Err()
+		},
+		visitNode: func(node *restic.Node, _, location string) error {
+			visit(node, location)
+			switch node.Type {
+			case "symlink":
+				require(FeatureSymlinks, location)
+			case "file":
+				if node.Links > 1 {
+					require(FeatureHardlinks, location)
+				}
+			case "dev", "chardev", "fifo":
+				require(FeatureSpecialFiles, location)
+			}
+			return ctx.Err()
+		},
+		leaveDir: func(_ *restic.Node, _, location string) error {
+			delete(names, location)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []TargetProblem
+	for feature := FeatureSymlinks; feature <= FeatureLongNames; feature++ {
+		if p, ok := problems[feature]; ok {
+			result = append(result, *p)
+		}
+	}
+	return result, nil
+}
+
+// probeTarget determines the capabilities of the file system at dst.
+func probeTarget(dst string) (TargetCapabilities, error) {
+	dir := dst
+	for {
+		fi, err := fs.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return TargetCapabilities{}, errors.Errorf("%v is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || filepath.Dir(dir) == dir {
+			return TargetCapabilities{}, errors.WithStack(err)
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	probeDir, err := os.MkdirTemp(dir, ".restic-probe-")
+	if err != nil {
+		return TargetCapabilities{}, errors.WithStack(err)
+	}
+	defer func() {
+		if err := fs.RemoveAll(probeDir); err != nil {
+			debug.Log("removing %v failed: %v", probeDir, err)
+		}
+	}()
+
+	file := filepath.Join(probeDir, "Probe")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		return TargetCapabilities{}, errors.WithStack(err)
+	}
+
+	var c TargetCapabilities
+	c.Symlinks = fs.Symlink("Probe", filepath.Join(probeDir, "symlink")) == nil
+	c.Hardlinks = fs.Link(file, filepath.Join(probeDir, "hardlink")) == nil
+	if runtime.GOOS == "windows" {
+		// extended attributes are restored as NTFS extended attributes
+		c.Xattrs = true
+	} else {
+		c.Xattrs = fs.SetXattr(file, "user.restic.probe", []byte("probe")) == nil
+	}
+	c.SpecialFiles = mkfifo(filepath.Join(probeDir, "fifo")) == nil
+	_, err = fs.Lstat(filepath.Join(probeDir, "PROBE"))
+	c.CaseSensitive = errors.Is(err, os.ErrNotExist)
+	c.MaxNameLength = probeMaxNameLength(probeDir)
+	debug.Log("capabilities of %v: %+v", dir, c)
+	return c, nil
+}
+
+// probeMaxNameLength returns the length of the longest name of a file which
+// can be created in dir, up to maxProbeNameLength.
+func probeMaxNameLength(dir string) int {
+	low, high := 0, maxProbeNameLength
+	for low < high {
+		n := (low + high + 1) / 2
+		name := filepath.Join(dir, strings.Repeat("n", n))
+		f, err := fs.OpenFile(name, fs.O_CREATE|fs.O_EXCL|fs.O_WRONLY, 0600)
+		if err != nil {
+			high = n - 1
+			continue
+		}
+		_ = f.Close()
+		_ = fs.Remove(name)
+		low = n
+	}
+	return low
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package restorer
+
+import "golang.org/x/sys/unix"
+
+func mkfifo(path string) error {
+	return unix.Mkfifo(path, 0600)
+}
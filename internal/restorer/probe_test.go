@@ -0,0 +1,61 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerProbeTarget(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"Name":      File{Data: "content: upper\n"},
+				"name":      File{Data: "content: lower\n"},
+				"link":      Symlink{Target: "name"},
+				"hardlink1": File{Data: "content: link\n", Links: 2, Inode: 1},
+				"hardlink2": File{Data: "content: link\n", Links: 2, Inode: 1},
+			}},
+			"NAME":      File{Data: "content: other directory\n"},
+			"long-name": File{Data: "content: long\n"},
+			"fifo":      Special{Type: "fifo"},
+		},
+	}, noopGetGenericAttributes)
+	res := NewRestorer(repo, sn, Options{})
+
+	// the requirements are checked against the capabilities
+	problems, err := res.targetProblems(context.TODO(), TargetCapabilities{Symlinks: true, MaxNameLength: 8})
+	rtest.OK(t, err)
+	rtest.Equals(t, []TargetProblem{
+		{Feature: FeatureHardlinks, Path: filepath.FromSlash("/dir/hardlink1"), Count: 2},
+		{Feature: FeatureSpecialFiles, Path: filepath.FromSlash("/fifo"), Count: 1},
+		{Feature: FeatureCaseSensitive, Path: filepath.FromSlash("/dir/name"), Count: 1},
+		{Feature: FeatureLongNames, Path: filepath.FromSlash("/dir/hardlink1"), Count: 3},
+	}, problems)
+	rtest.Equals(t, "/dir/hardlink1 and 1 other items: hardlinks not supported by the target", filepath.ToSlash(problems[0].String()))
+
+	// the target does not have to exist yet
+	tempdir := rtest.TempDir(t)
+	report, err := res.ProbeTarget(context.TODO(), filepath.Join(tempdir, "missing", "target"))
+	rtest.OK(t, err)
+	rtest.Assert(t, report.Capabilities.MaxNameLength >= 255, "unexpected max name length %v", report.Capabilities.MaxNameLength)
+	if runtime.GOOS != "windows" {
+		rtest.Assert(t, report.Capabilities.Symlinks && report.Capabilities.Hardlinks && report.Capabilities.SpecialFiles,
+			"unexpected capabilities %+v", report.Capabilities)
+	}
+	for _, p := range report.Problems {
+		rtest.Assert(t, !strings.Contains(p.Path, "long-name") || p.Feature != FeatureLongNames, "unexpected problem %v", p)
+	}
+
+	// the probe directory is removed
+	entries, err := os.ReadDir(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(entries))
+}
@@ -0,0 +1,7 @@
+package restorer
+
+import "github.com/restic/restic/internal/errors"
+
+func mkfifo(_ string) error {
+	return errors.New("fifos are not supported on Windows")
+}
@@ -0,0 +1,59 @@
+package restorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+const (
+	// ProvenanceXattr is the extended attribute of the target directory to
+	// which Options.StampProvenance writes the Provenance.
+	ProvenanceXattr = "user.restic.snapshot"
+	// ProvenanceFile is the file in the target directory which is written
+	// instead of ProvenanceXattr if extended attributes are not supported.
+	ProvenanceFile = ".restic-snapshot.json"
+)
+
+// Provenance describes the snapshot a target was restored from, see
+// Options.StampProvenance.
+type Provenance struct {
+	ID       string    `json:"id,omitempty"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// stampProvenance writes the Provenance of the snapshot to dst. If neither
+// the extended attribute nor the file can be written, a warning is reported.
+func (res *Restorer) stampProvenance(dst string) error {
+	p := Provenance{
+		Time:     res.sn.Time,
+		Hostname: res.sn.Hostname,
+		Tags:     res.sn.Tags,
+	}
+	if id := res.sn.ID(); id != nil {
+		p.ID = id.String()
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = fs.SetXattr(dst, ProvenanceXattr, data)
+	if err == nil {
+		return nil
+	}
+	debug.Log("unable to set %v of %v, writing %v instead: %v", ProvenanceXattr, dst, ProvenanceFile, err)
+	err = os.WriteFile(filepath.Join(dst, ProvenanceFile), append(data, '\n'), 0644)
+	if err != nil {
+		res.warn(fmt.Sprintf("%v: unable to record the snapshot provenance: %v", dst, err))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package restorer
+
+import (
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// reflinkSource is a restored file whose data can be shared with files of
+// the same content, see Options.Reflink.
+type reflinkSource struct {
+	path    string
+	size    uint64
+	modTime time.Time
+}
+
+// unchanged returns whether the source still has the size and modification
+// time it was restored with.
+func (s reflinkSource) unchanged() bool {
+	fi, err := fs.Lstat(s.path)
+	return err == nil && fi.Mode().IsRegular() && uint64(fi.Size()) == s.size && fi.ModTime().Equal(s.modTime)
+}
+
+// reflinkFile is the source of a file which is reflinked instead of being
+// restored. Either path is an existing file, or location is a file restored
+// earlier during the same restore.
+type reflinkFile struct {
+	path     string
+	location string
+}
+
+// contentKey identifies the content of a file by its list of blobs.
+func contentKey(content restic.IDs) restic.ID {
+	buf := make([]byte, 0, len(content)*len(restic.ID{}))
+	for _, id := range content {
+		buf = append(buf, id[:]...)
+	}
+	return restic.Hash(buf)
+}
+
+// reflinkPlan tracks which files of a restore are reflinked from files with
+// the same content. All methods are no-ops for a nil reflinkPlan.
+type reflinkPlan struct {
+	// files restored by previous restores, shared with the Restorer
+	sources map[restic.ID]reflinkSource
+	// files restored by the current restore, by content
+	planned map[restic.ID]string
+	// files which are reflinked instead of restored, by location
+	files map[string]reflinkFile
+}
+
+func (res *Restorer) newReflinkPlan() *reflinkPlan {
+	if !res.opts.Reflink || res.opts.ContentTransform != nil || res.opts.TextConvert != nil {
+		// transformed files do not have the content of their blobs
+		return nil
+	}
+	if res.reflinkSources == nil {
+		res.reflinkSources = make(map[restic.ID]reflinkSource)
+	}
+	return &reflinkPlan{
+		sources: res.reflinkSources,
+		planned: make(map[restic.ID]string),
+		files:   make(map[string]reflinkFile),
+	}
+}
+
+// plan returns whether the file at location can be reflinked from a file
+// with the same content. Otherwise, it must be restored and is recorded as
+// the source for further files with this content.
+func (p *reflinkPlan) plan(node *restic.Node, location string) bool {
+	if p == nil || len(node.Content) == 0 {
+		return false
+	}
+	key := contentKey(node.Content)
+	if src, ok := p.sources[key]; ok && src.unchanged() {
+		p.files[location] = reflinkFile{path: src.path}
+		return true
+	}
+	if first, ok := p.planned[key]; ok {
+		p.files[location] = reflinkFile{location: first}
+		return true
+	}
+	p.planned[key] = location
+	return false
+}
+
+// source returns the source of the file at location, if it is reflinked.
+func (p *reflinkPlan) source(location string) (reflinkFile, bool) {
+	if p == nil {
+		return reflinkFile{}, false
+	}
+	src, ok := p.files[location]
+	return src, ok
+}
+
+// addSource records the file at path, which has the content and
+// modification time of node, as the source for further restores.
+func (p *reflinkPlan) addSource(node *restic.Node, path string) {
+	if p == nil || len(node.Content) == 0 {
+		return
+	}
+	p.sources[contentKey(node.Content)] = reflinkSource{path: path, size: node.Size, modTime: node.ModTime}
+}
+
+// reflinkFrom replaces the content of dst with that of src. If the file
+// system does not support reflinks, the content is copied instead.
+func reflinkFrom(src, dst string) error {
+	err := fs.Reflink(src, dst)
+	if !errors.Is(err, fs.ErrReflinkUnsupported) {
+		return err
+	}
+	debug.Log("reflinks are not supported for %v, copying the content instead", dst)
+
+	f, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = copyFileTo(f, src)
+	return errors.CombineErrors(err, errors.WithStack(f.Close()))
+}
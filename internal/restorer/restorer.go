@@ -2,19 +2,28 @@ package restorer
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"sync/atomic"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository/pack"
 	"github.com/restic/restic/internal/restic"
 	restoreui "github.com/restic/restic/internal/ui/restore"
 
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 // Restorer is used to restore a snapshot to a directory.
@@ -24,6 +33,70 @@ type Restorer struct {
 	opts Options
 
 	fileList map[string]bool
+	events   *eventWriter
+	packs    *packCache
+	damaged  map[string][]DamagedRange
+	// number of empty files created by the last restore
+	emptyFiles int
+
+	// files which were modified by Options.ContentTransform
+	transformed map[string]struct{}
+	// the parsed patterns of Options.TextConvert
+	textPatterns []filter.Pattern
+	// files which were restored as stubs by Options.StubFilter
+	stubs map[string]struct{}
+	// items whose flags are applied at the end of RestoreTo
+	pendingFlags      []pendingFileFlags
+	fileFlagsReported bool
+	streamsReported   bool
+
+	// tree is the root tree of the snapshot, or the tree passed to
+	// NewTreeRestorer
+	tree restic.ID
+	// root is the tree restored by the last call to RestoreTo or
+	// RestoreSubtree, which is also checked by VerifyFiles and VerifyMetadata
+	root restic.ID
+	// rootPath is the slash-separated location of root within tree, empty
+	// for tree itself
+	rootPath string
+
+	// errors passed on by Error during the current restore, see
+	// Options.CollectErrors
+	collectedLock sync.Mutex
+	collected     RestoreErrors
+
+	// files restored with Options.Reflink, by content
+	reflinkSources map[restic.ID]reflinkSource
+
+	// hardlinks selected by Options.PromoteHardlinks during the last restore
+	promoted *promotedHardlinks
+
+	// differences to Options.OverlayLower of the last restore
+	overlay *overlayPlan
+
+	// collisions tracks items which are renamed or skipped due to case collisions
+	collisions *caseCollisions
+
+	// metadataLock protects the state modified while restoring the
+	// metadata of files in parallel, see Options.MetadataWorkers
+	metadataLock sync.Mutex
+	// warnLock serializes the calls of Warn
+	warnLock sync.Mutex
+
+	// failures to restore metadata with Options.BestEffortMetadata
+	metadataFailures int
+	metadataFirstErr error
+
+	// generic attribute types unknown to restic and the number of items
+	// which had them, see Options.RestoreGenericAttributes
+	unknownAttributes     map[restic.GenericAttributeType]struct{}
+	unknownAttributeItems int
+
+	// used by RestoreToMany to restore the blobs of the first target to the
+	// further targets, errors are reported with the current target
+	recordBlobs   *localBlobs
+	reuseBlobs    *localBlobs
+	currentTarget string
 
 	Error        func(location string, err error) error
 	Warn         func(message string)
@@ -33,21 +106,451 @@ type Restorer struct {
 var restorerAbortOnAllErrors = func(_ string, err error) error { return err }
 
 type Options struct {
+	// Sparse creates holes instead of writing runs of zeros. If the holes of
+	// a file were recorded when creating the snapshot, exactly these holes
+	// are recreated on platforms which support it, while all other ranges
+	// are allocated.
 	Sparse    bool
 	Progress  *restoreui.Progress
 	Overwrite OverwriteBehavior
+	// OverwriteFunc, if set, decides whether an existing item at the
+	// destination of node is overwritten and takes precedence over
+	// Overwrite. It is not called for destinations which do not exist yet.
+	OverwriteFunc func(node *restic.Node, existing os.FileInfo) bool
+	// HardlinkPolicy decides how a group of hardlinked files is restored if
+	// some of its members already exist at the destination.
+	HardlinkPolicy HardlinkPolicy
+	// Reflink restores files whose list of blobs matches that of a file
+	// restored before, during this or a previous call to RestoreTo of the
+	// same Restorer, by sharing the data of that file using a copy-on-write
+	// reflink, for example on Btrfs or XFS. The content is only downloaded
+	// once. Files restored by earlier calls are only used if their size and
+	// modification time are unchanged. If reflinks are not supported, for
+	// example across file systems, the content is copied from the other file
+	// instead. This is ignored with ContentTransform and TextConvert.
+	Reflink bool
+	// DedupeByContent hardlinks a file to an earlier file of the same restore
+	// instead of writing another copy, if both have the same content and
+	// metadata and are located on the same file system. Unlike HardlinkPolicy,
+	// this creates hardlinks for files which were not hardlinked in the
+	// snapshot. This is ignored with ContentTransform and TextConvert.
+	DedupeByContent bool
+	// DedupeSkip excludes the files for which it returns true from
+	// DedupeByContent, such that they are restored as independent files.
+	DedupeSkip func(location string, node *restic.Node) bool
+	// CollectErrors records all errors for which Restorer.Error returns nil,
+	// that is which do not abort the restore. If the restore completes
+	// otherwise successfully, RestoreTo returns them as RestoreErrors.
+	// Restorer.Error is still called for each error as it occurs.
+	CollectErrors bool
+	// WarnBrokenHardlinks reports a warning via Restorer.Warn for each group
+	// of hardlinked files of which SelectFilter only selects some members.
+	// The selected members are restored as a separate group, which is no
+	// longer linked to the unselected ones.
+	WarnBrokenHardlinks bool
+	// PromoteHardlinks restores the unselected members of a group of
+	// hardlinked files if SelectFilter selects at least one member, such
+	// that the link structure is preserved. Their parent directories are
+	// created as for other selected files, but none of their other children
+	// is selected by this. Members below MaxDepth are never restored. As the
+	// whole snapshot must be traversed to find the members of the groups,
+	// this is slower for restores of a small part of a large snapshot.
+	// VerifyFiles and VerifyMetadata also check the promoted files.
+	PromoteHardlinks bool
+	// AlternateDataStreamSidecars writes the alternate data streams of items
+	// backed up on Windows to sidecar files named "<item>:<stream>" next to
+	// the item, if the platform does not support alternate data streams.
+	// Otherwise, the streams are dropped with a warning. On Windows, the
+	// streams are always restored.
+	AlternateDataStreamSidecars bool
+	// FinalReadOnly removes all write permissions of restored files, on
+	// Windows by setting the read-only attribute. It is applied along with
+	// the mode, after the content, owner and timestamps, thus the restorer
+	// can still write the files. Existing read-only files are made writable
+	// again while they are overwritten. VerifyMetadata expects files without
+	// write permissions. Directories are not affected.
+	FinalReadOnly bool
+	// MetadataWorkers is the number of files whose metadata is restored in
+	// parallel after their content was written, which speeds up restores to
+	// network storage. Directories are still finalized after all of their
+	// children. If it is zero, 8 workers are used, 1 restores the metadata
+	// sequentially. UserLookup, GroupLookup and Restorer.Warn may thus be
+	// called concurrently, but Warn is never called by several goroutines at
+	// once.
+	MetadataWorkers int
+	// OnUnsupportedNode is called for each node with a type which the
+	// restorer cannot create, for example as it was added by a newer version
+	// of restic, and selects how the node is handled. It may be called
+	// several times for the same node. If it is nil, an error is reported
+	// via Restorer.Error.
+	OnUnsupportedNode func(node *restic.Node) UnsupportedAction
+	// StampProvenance records the ID, time, host and tags of the snapshot in
+	// the extended attribute ProvenanceXattr of the target directory after
+	// the restore, as JSON encoded Provenance. If the attribute cannot be
+	// set, for example as the file system does not support extended
+	// attributes, it is written to the file ProvenanceFile in the target
+	// directory instead. If neither is possible, a warning is
+	// reported via Restorer.Warn.
+	StampProvenance bool
+	// RewriteSymlinkTarget, if set, returns the target of each restored
+	// symlink, given its target in the snapshot and the directory the link
+	// is restored to. This allows adjusting absolute targets when restoring
+	// a tree to another location, see RelativeSymlinkTarget. Links are also
+	// created if the rewritten target does not exist. If nil, the targets are
+	// restored verbatim. VerifyMetadata expects the rewritten targets.
+	RewriteSymlinkTarget func(target string, linkDir string) string
+	// WarnDanglingSymlinks reports a warning via Restorer.Warn for each
+	// symlink whose target was changed by RewriteSymlinkTarget and does not
+	// exist once all items have been restored.
+	WarnDanglingSymlinks bool
+	// SkipDirTimes restores the mode and ownership of directories but leaves
+	// their timestamps at the value set by the OS. File timestamps are still
+	// restored. This breaks timestamp-based comparisons of directories, for
+	// example by rsync, which is exactly what some users want after a restore.
+	SkipDirTimes bool
+	// Fsync flushes every restored file and directory to stable storage
+	// before RestoreTo returns, after the content and metadata have been
+	// written. Each directory is synced once. This is expensive, but
+	// guarantees that the restored data survives an immediate reboot.
+	Fsync bool
+	// AllowSymlinkedTarget permits restoring through pre-existing symlinks in
+	// the target directory which lead outside of it. By default, such items are
+	// reported as errors and skipped.
+	AllowSymlinkedTarget bool
+	// RefuseMountpoints reports directories within the target which are
+	// mountpoints, that is whose device differs from that of their parent
+	// directory, as errors and skips them including their children. It is
+	// always enabled by RestoreToOriginalLocations and when restoring to the
+	// root directory of the file system. Not supported on Windows.
+	RefuseMountpoints bool
+	// AllowMountpoints lists the paths of mountpoints in the file system into
+	// which items are restored despite RefuseMountpoints.
+	AllowMountpoints []string
+	// TargetMode is the mode of the target directory if it does not exist yet
+	// and is created by RestoreTo. It is applied after all other items have been
+	// restored such that a restrictive mode cannot block the restore. If nil,
+	// the target directory is created using the default permissions. The root
+	// of a snapshot has no node of its own, thus there is no metadata in the
+	// snapshot which could be applied to the target directory instead.
+	TargetMode *os.FileMode
+	// VerifyWorkers is the number of files VerifyFiles checks concurrently.
+	// If zero, a default of 8 workers is used.
+	VerifyWorkers int
+	// VerifyCheckpoint is the path of a file in which VerifyFiles records each
+	// successfully verified file. When verification is started again with the
+	// same checkpoint, for example after it was interrupted, files whose size
+	// and modification time are unchanged since they were recorded are not
+	// read again but reported as verified. The checkpoint is flushed even if
+	// the context is cancelled. It is never removed by VerifyFiles, thus it
+	// must be deleted to verify all files again.
+	VerifyCheckpoint string
+	// EventWriter receives a stream of JSON encoded events, one per line, which
+	// describe the progress of RestoreTo. This includes the start of the
+	// restore, each restored, updated or skipped item, all errors and a final
+	// summary. Writes are serialized, thus concurrent events never interleave.
+	EventWriter io.Writer
+	// PackCacheSize is the maximum number of bytes of downloaded blobs which
+	// are kept in memory, grouped by pack. Blobs are served from this cache
+	// instead of being downloaded again, for example when restoring the same
+	// snapshot multiple times using one Restorer. Packs are evicted in least
+	// recently used order. If zero, no blobs are cached.
+	PackCacheSize int
+	// BlobCache is consulted for each blob before it is downloaded, and
+	// stores all downloaded blobs. Unlike the cache of PackCacheSize, it can
+	// persist across restores and processes, see DiskBlobCache. If nil, blobs
+	// are only cached according to PackCacheSize.
+	BlobCache BlobCache
+	// ZeroFillMissing continues restoring a file if one of its blobs cannot be
+	// loaded, for example as it is damaged. The content of the blob is replaced
+	// with zeros and the affected range is reported by DamagedRanges.
+	ZeroFillMissing bool
+	// ConfirmOriginalLocations must be set to allow RestoreToOriginalLocations
+	// to overwrite data at the paths the snapshot was created from.
+	ConfirmOriginalLocations bool
+	// OnDirCreated is called with the target path of each restored directory
+	// once it exists and before any of its children are restored. This also
+	// applies to directories which already existed. The metadata of the
+	// directory is only restored after all children. An error is handled like
+	// other errors for path, that is it is passed to Restorer.Error.
+	OnDirCreated func(path string, node *restic.Node) error
+	// OnDirComplete is called once with the target path of each restored
+	// directory after all of its children and its own metadata have been
+	// restored, also if all children were filtered out. Directories kept
+	// unmodified by OverwriteNone are complete once their children are
+	// restored. Errors are handled like for OnDirCreated.
+	OnDirComplete func(path string, node *restic.Node) error
+	// AtomicReplace writes the content of each file to a temporary file in the
+	// same directory, which is then renamed to the target path. Thus, readers
+	// either see the previous or the completely restored file. Files are only
+	// replaced after the content of all files has been restored. Files for
+	// which an error was reported are left untouched.
+	AtomicReplace bool
+	// TempDir is the directory for the temporary files of AtomicReplace,
+	// ContentTransform and TextConvert instead of the directory of each file.
+	// It must be on the same file system as the target directory, such that
+	// the files can be renamed. If empty, or without these options, temporary
+	// files are created next to their targets.
+	TempDir string
+	// Metrics receives live counters every MetricsInterval while RestoreTo is
+	// running. If nil, no metrics are collected.
+	Metrics MetricsSink
+	// MetricsInterval is the interval between two reports to Metrics. If zero,
+	// a default of one second is used.
+	MetricsInterval time.Duration
+	// ConflictResolver is called if the item at location collides with the
+	// already restored item existing, as their names only differ in case and
+	// the target is located on a case-insensitive file system. It returns the
+	// name to restore the item as instead, for example with an added suffix.
+	// If ConflictResolver is nil or returns an empty name, the collision is
+	// reported via Restorer.Error and the item is skipped.
+	ConflictResolver func(location, existing string) string
+	// MaxDepth limits the restore to items at most MaxDepth levels below the
+	// target directory. Directories at the boundary are restored without their
+	// contents. If MaxDepth <= 0, the depth is unlimited.
+	MaxDepth int
+	// ModifiedSince and ModifiedUntil restrict the restore to items whose
+	// modification time in the snapshot is not before ModifiedSince and
+	// before ModifiedUntil, respectively. Directories are not restricted, but
+	// only created if they contain a selected item. A zero time disables the
+	// respective limit.
+	ModifiedSince time.Time
+	ModifiedUntil time.Time
+	// OverlayLower is a snapshot of the lower layer of an overlay, for which
+	// the target directory is the upper layer. Only items which differ from
+	// the lower snapshot, including their parent directories, are restored,
+	// unchanged items are left out. Hardlinks to unchanged files are thus
+	// not restored as such. For RestoreSubtree and IncludeTopDir, the
+	// restored subtree is compared with the same subtree of the lower
+	// snapshot. VerifyFiles and VerifyMetadata only check the restored items.
+	OverlayLower *restic.Snapshot
+	// OverlayWhiteouts marks items of OverlayLower which do not exist in the
+	// restored tree with an empty whiteout file named WhiteoutPrefix followed
+	// by the name of the removed item, as used by OCI image layers. The
+	// children of a removed directory have no whiteouts of their own. This
+	// requires OverlayLower.
+	OverlayWhiteouts bool
+	// BestEffortMetadata reports failures to restore metadata, like ownership,
+	// timestamps or the file mode, as a single aggregated warning via
+	// Restorer.Warn instead of passing them to Restorer.Error. This is useful
+	// for targets on network file systems which do not support all metadata.
+	// Failures to restore file contents are still reported as errors.
+	BestEffortMetadata bool
+	// VerifyOnWrite checks the hash of each blob right before it is written
+	// to a file. If the check fails or any other error is reported for a file
+	// which was created by the restore, the partially written file is removed
+	// instead of being left behind. Existing files are never removed, use
+	// AtomicReplace to keep them unchanged until their new content is
+	// complete. Unlike VerifyFiles, this does not read the restored files
+	// again.
+	VerifyOnWrite bool
+	// PostWriteHook is called with the path of each file whose content was
+	// restored, once its content and metadata have been written, for example
+	// to scan it for malware. If it returns an error, the file is removed and
+	// the error is passed to Restorer.Error. It runs on the workers of
+	// MetadataWorkers and may thus be called concurrently. With AtomicReplace,
+	// ContentTransform or TextConvert, it is instead called with the
+	// temporary file before it is renamed to the target path, thus the
+	// existing file is kept if the hook fails. The metadata is only restored
+	// after the rename. It is not called for files whose content was not
+	// written, like hardlinks, stubs or files whose metadata was updated.
+	PostWriteHook func(path string, node *restic.Node) error
+	// IncludeTopDir restores the snapshot into a directory below the target
+	// which is named after the last component of the snapshot's source path.
+	// For example, a snapshot of /data/project is restored to
+	// target/project, and the contents of /data/project become the contents
+	// of this directory. This requires a snapshot with exactly one path. Items
+	// are selected by their location below the source path, as for
+	// RestoreSubtree. VerifyFiles and VerifyMetadata expect the same target as
+	// RestoreTo.
+	IncludeTopDir bool
+	// UserLookup resolves the user name recorded in a node to a user ID on
+	// the destination system. If it is nil, the node has no user name or the
+	// name is not found, the numeric user ID from the snapshot is restored.
+	UserLookup func(name string) (uid uint32, ok bool)
+	// GroupLookup is the equivalent of UserLookup for group names.
+	GroupLookup func(name string) (gid uint32, ok bool)
+	// RestoreGenericAttributes selects the generic attributes, like Windows
+	// file attributes or file flags, which are restored. If it is nil, all
+	// attributes are applied which are meaningful on the current OS, others
+	// are ignored. Attribute types unknown to this version of restic are
+	// never restored, they are reported as a single warning per restore.
+	RestoreGenericAttributes *GenericAttributeFilter
+	// StrictOwnership reports an error for each item whose owner cannot be
+	// restored exactly, instead of leaving it owned by the restoring user.
+	// This includes names which UserLookup or GroupLookup cannot resolve
+	// and failures to change the owner, which are otherwise ignored for
+	// unprivileged users. These errors are passed to Restorer.Error even with
+	// BestEffortMetadata. The owner is not restored on Windows.
+	StrictOwnership bool
+	// SkipExistingVerifiedFrom is a snapshot which was previously restored to
+	// the same target. The restorer trusts that the target still matches it,
+	// thus files whose node is unchanged compared to that snapshot are skipped
+	// without accessing the target at all. All other files are restored
+	// according to Overwrite. Unlike OverwriteIfChanged, this avoids a stat
+	// call per file, which is slow for large trees on network storage.
+	SkipExistingVerifiedFrom *restic.Snapshot
+	// CleanupOnCancel removes all files whose content was partially written
+	// when the context passed to RestoreTo is cancelled. Files which were
+	// restored completely before are kept. With AtomicReplace, the temporary
+	// files are removed and all targets are left untouched.
+	CleanupOnCancel bool
+	// RelaxDirModesDuringRestore guarantees that all directories are
+	// writable by the owner while their children are restored, also if they
+	// already exist with a restrictive mode. The metadata of a directory,
+	// including its final mode, is applied once all its children were
+	// restored. Directories whose metadata was not applied as the restore
+	// was aborted, for example due to an error for one of their children,
+	// are tightened in a final pass before RestoreTo returns.
+	RelaxDirModesDuringRestore bool
+	// WriteBufferSize is the number of bytes of adjacent blobs which are
+	// collected before they are written to a file at once. This reduces the
+	// number of write calls, which is helpful for distributed file systems.
+	// Files are kept open until they are restored completely. Blobs which
+	// only contain zeros are not buffered with Sparse, thus holes are still
+	// created for them. If zero, each blob is written on its own.
+	WriteBufferSize int
+	// MaxMemory limits the number of bytes of blob data which are held in
+	// memory at the same time. Before the blobs of a pack are loaded, the
+	// range of the pack which is read, including unneeded data between the
+	// blobs, and the plaintext of its largest blob are reserved until the
+	// blobs have been written, such that workers wait while the limit is
+	// reached. PackCacheSize counts towards the limit, it is reduced to half
+	// of MaxMemory if it is larger. The buffers used for WriteBufferSize and
+	// by the backend are not included. If zero, the memory usage is only
+	// bounded by the number of workers.
+	MaxMemory int64
+	// ManifestWriter receives a manifest of all files whose content was
+	// restored or found to match the snapshot. It is written once RestoreTo
+	// has completed and contains one line "<hash> <size> <path>" per file,
+	// sorted by path, where hash is the ContentHash of the file's content.
+	// Files which failed to restore or contain damaged ranges are omitted.
+	// Unlike the EventWriter stream, the manifest is deterministic and
+	// therefore suitable for archival and later re-verification.
+	ManifestWriter io.Writer
+	// ContentTransform is called for each restored file and may return a
+	// writer which wraps dst, for example to convert line endings or to
+	// decode a custom container format. The content of the file is passed
+	// through this writer in order, after which it is closed. If it returns
+	// nil, the content is restored unchanged. As blobs are restored in
+	// parallel and in arbitrary order, all files are first written to a
+	// temporary file next to their target, like with AtomicReplace.
+	// VerifyFiles skips transformed files, as their content no longer matches
+	// the snapshot.
+	ContentTransform func(node *restic.Node, dst io.Writer) (io.WriteCloser, error)
+	// TextConvert converts the line endings of the files it selects while
+	// restoring them. Files whose first 8000 bytes contain a NUL byte are
+	// considered binary and restored unchanged. Like with ContentTransform,
+	// files are first written to a temporary file and VerifyFiles skips
+	// converted files. This is ignored with ContentTransform.
+	TextConvert *TextConvert
+	// MaxBytes limits the total size of the file contents written by
+	// RestoreTo. Files are either restored completely or not at all. The
+	// first file which does not fit into the remaining budget and all
+	// further files are skipped, the metadata of all other items is restored
+	// as usual and RestoreTo returns ErrQuotaExceeded. The full size of a
+	// file is counted even if parts of it already exist in the target. As the
+	// files to restore are planned before any content is written, the limit
+	// holds regardless of the number of workers. If zero, there is no limit.
+	MaxBytes int64
+	// RestoreFileFlags restores the immutable, append-only and hidden flags
+	// of files and directories. They are applied once all other items have
+	// been restored, as they would otherwise prevent writing the content or
+	// creating hardlinks. The hidden flag is only restored on macOS and
+	// FreeBSD. If an existing item which is overwritten has one of
+	// these flags, it is cleared first. On platforms which do not support
+	// these flags, a single warning is reported.
+	RestoreFileFlags bool
+	// StubFilter is called for each file which must be restored. If it
+	// returns true, the file is restored as a stub: it is created with the
+	// size and metadata from the snapshot, but no content is written. If the
+	// file system supports it, the file is sparse. Stubs are marked with the
+	// extended attribute restic.StubExtendedAttribute, which contains the
+	// original size, such that the content can be restored on demand later
+	// on. Stubs do not count towards MaxBytes and are not included in the
+	// manifest. VerifyFiles only checks the size of stubs and reports them
+	// as skipped.
+	StubFilter func(node *restic.Node) bool
+	// Clock is used whenever the restorer needs the current time, that is
+	// for the timestamps of the events written to EventWriter and for the
+	// throughput reported to Metrics. The timestamps of restored items are
+	// always taken from the snapshot. If nil, the system clock is used.
+	Clock Clock
+	// LoadRetries is the number of times loading the blobs of a pack is
+	// retried if the repository fails to load some of them, for example as
+	// an object store is only eventually consistent. Only the blobs which
+	// were not loaded yet are requested again, errors for them are reported
+	// once all retries failed. Blobs which fail to decrypt or decode are
+	// damaged and never retried. If zero, failures are not retried.
+	LoadRetries int
+	// LoadBackoff is the delay before the first retry of a failed load, it
+	// doubles with each further retry. Cancelling the context aborts the
+	// delay immediately.
+	LoadBackoff time.Duration
+	// PerFileTimeout abandons a file whose content is not restored
+	// completely within this duration after the first of its packs started
+	// downloading. The timeout is reported via Restorer.Error, the partially
+	// written file is removed and all other files are restored as usual. A
+	// pack is only abandoned once all files waiting for it have timed out.
+	// If zero, there is no timeout.
+	PerFileTimeout time.Duration
+	// Priority orders the restore of file contents, for example to write a
+	// manifest or lock file last. The content of all files with a lower
+	// priority is written completely before any content of a file with a
+	// higher priority is written. Files of the same priority are restored in
+	// parallel as usual. Directories are created and the metadata of all
+	// items is restored in traversal order as before. With AtomicReplace,
+	// ContentTransform or TextConvert, the files are thus also renamed to
+	// their targets in traversal order. Packs which contain blobs of files
+	// with different priorities are downloaded once per priority, unless
+	// they are kept by PackCacheSize or BlobCache. If nil, all files have the
+	// same priority.
+	Priority func(node *restic.Node) int
+	// SkipLocked skips files whose existing target is in use by another
+	// process, that is on Windows a file opened without sharing write access
+	// or locked, and elsewhere a running executable. Such files are left
+	// unmodified including their metadata, they can be restored by a later
+	// run. This is checked by opening the target for writing before the file
+	// is restored. Only these errors are skipped, others like missing
+	// permissions are still passed to Restorer.Error.
+	SkipLocked bool
+	// OnLocked is called with the location of each file skipped by
+	// SkipLocked and the error which indicated that it is in use.
+	OnLocked func(location string, err error)
 }
 
+// ErrQuotaExceeded is returned by RestoreTo if files were skipped as they
+// would have exceeded Options.MaxBytes.
+var ErrQuotaExceeded = errors.New("restore size limit exceeded")
+
+// ErrDeadlineExceeded is returned by RestoreTo if the deadline of its context
+// expired before the restore was complete. Files whose content was restored
+// completely before the deadline are kept and their metadata is restored,
+// while incomplete files are removed. Restorer.Stats reflects the restored
+// items. It wraps context.DeadlineExceeded.
+var ErrDeadlineExceeded = fmt.Errorf("restore stopped at deadline: %w", context.DeadlineExceeded)
+
 type OverwriteBehavior int
 
 // Constants for different overwrite behavior
 const (
 	OverwriteAlways OverwriteBehavior = iota
 	// OverwriteIfChanged is like OverwriteAlways except that it skips restoring the content
-	// of files with matching size&mtime. Metadata is always restored.
+	// of files with matching size&mtime. Metadata is restored unless it already matches,
+	// items which match the snapshot completely are not touched at all.
 	OverwriteIfChanged
 	OverwriteIfNewer
 	OverwriteNever
+	// OverwriteIfContentChanged always compares the content of existing files
+	// with matching size against the snapshot, regardless of their mtime. This
+	// detects modifications which preserve size and mtime, which
+	// OverwriteIfChanged misses. Unlike OverwriteAlways, files with a different
+	// size are rewritten without reading their content, and items which match
+	// the snapshot completely are not touched at all.
+	OverwriteIfContentChanged
+	// OverwriteNone is stricter than OverwriteNever: it only creates items which
+	// do not exist yet. Existing directories are descended into, but neither
+	// their metadata nor anything else of an existing item is modified.
+	OverwriteNone
 	OverwriteInvalid
 )
 
@@ -62,9 +565,13 @@ func (c *OverwriteBehavior) Set(s string) error {
 		*c = OverwriteIfNewer
 	case "never":
 		*c = OverwriteNever
+	case "if-content-changed":
+		*c = OverwriteIfContentChanged
+	case "none":
+		*c = OverwriteNone
 	default:
 		*c = OverwriteInvalid
-		return fmt.Errorf("invalid overwrite behavior %q, must be one of (always|if-newer|never)", s)
+		return fmt.Errorf("invalid overwrite behavior %q, must be one of (always|if-changed|if-content-changed|if-newer|never|none)", s)
 	}
 
 	return nil
@@ -80,6 +587,10 @@ func (c *OverwriteBehavior) String() string {
 		return "if-newer"
 	case OverwriteNever:
 		return "never"
+	case OverwriteIfContentChanged:
+		return "if-content-changed"
+	case OverwriteNone:
+		return "none"
 	default:
 		return "invalid"
 	}
@@ -89,17 +600,64 @@ func (c *OverwriteBehavior) Type() string {
 	return "behavior"
 }
 
+// HardlinkPolicy decides which member of a group of hardlinked files is
+// restored, such that the other members can be linked to it.
+type HardlinkPolicy int
+
+const (
+	// RelinkExisting links the group to a member which already exists at
+	// the destination with the expected content, instead of restoring the
+	// content again.
+	RelinkExisting HardlinkPolicy = iota
+	// AlwaysRecreate restores the first member of the group in snapshot
+	// order and links all other members to it. Only the content of this
+	// member is reused if it already exists.
+	AlwaysRecreate
+)
+
 // NewRestorer creates a restorer preloaded with the content from the snapshot id.
 func NewRestorer(repo restic.Repository, sn *restic.Snapshot, opts Options) *Restorer {
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
 	r := &Restorer{
 		repo:         repo,
 		opts:         opts,
 		fileList:     make(map[string]bool),
+		transformed:  make(map[string]struct{}),
+		stubs:        make(map[string]struct{}),
+		events:       newEventWriter(opts.EventWriter, opts.Clock),
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
 		sn:           sn,
 	}
+	if opts.TextConvert != nil {
+		r.textPatterns = filter.ParsePatterns(opts.TextConvert.Patterns)
+	}
+	if opts.PackCacheSize > 0 {
+		cacheSize := opts.PackCacheSize
+		if opts.MaxMemory > 0 && int64(cacheSize) > opts.MaxMemory/2 {
+			// leave room for the downloads
+			cacheSize = int(opts.MaxMemory / 2)
+		}
+		r.packs = newPackCache(cacheSize)
+	}
+	if sn != nil && sn.Tree != nil {
+		r.tree = *sn.Tree
+		r.root = r.tree
+	}
+
+	return r
+}
 
+// NewTreeRestorer creates a restorer preloaded with the content from the
+// tree treeID instead of a snapshot. Features which require a snapshot, such
+// as Options.IncludeTopDir or RestoreToOriginalLocations, return an error,
+// Options.StampProvenance is ignored and Snapshot returns nil.
+func NewTreeRestorer(repo restic.Repository, treeID restic.ID, opts Options) *Restorer {
+	r := NewRestorer(repo, nil, opts)
+	r.tree = treeID
+	r.root = treeID
 	return r
 }
 
@@ -107,116 +665,133 @@ type treeVisitor struct {
 	enterDir  func(node *restic.Node, target, location string) error
 	visitNode func(node *restic.Node, target, location string) error
 	leaveDir  func(node *restic.Node, target, location string) error
+	// skipSocket, if set, is called for each socket, which is skipped
+	skipSocket func(location string)
 }
 
 // traverseTree traverses a tree from the repo and calls treeVisitor.
 // target is the path in the file system, location within the snapshot.
 func (res *Restorer) traverseTree(ctx context.Context, target, location string, treeID restic.ID, visitor treeVisitor) (hasRestored bool, err error) {
-	debug.Log("%v %v %v", target, location, treeID)
-	tree, err := restic.LoadTree(ctx, res.repo, treeID)
-	if err != nil {
-		debug.Log("error loading tree %v: %v", treeID, err)
-		return hasRestored, res.Error(location, err)
-	}
-
-	for _, node := range tree.Nodes {
-
-		// ensure that the node name does not contain anything that refers to a
-		// top-level directory.
-		nodeName := filepath.Base(filepath.Join(string(filepath.Separator), node.Name))
-		if nodeName != node.Name {
-			debug.Log("node %q has invalid name %q", node.Name, nodeName)
-			err := res.Error(location, errors.Errorf("invalid child node name %s", node.Name))
-			if err != nil {
-				return hasRestored, err
+	filter := res.promoted.wrap(res.SelectFilter)
+	selectFilter := filter
+	if res.opts.MaxDepth > 0 {
+		selectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+			selectedForRestore, childMayBeSelected := filter(item, dstpath, node)
+			if depth(item) >= res.opts.MaxDepth {
+				childMayBeSelected = false
 			}
-			continue
+			return selectedForRestore, childMayBeSelected
 		}
-
-		nodeTarget := filepath.Join(target, nodeName)
-		nodeLocation := filepath.Join(location, nodeName)
-
-		if target == nodeTarget || !fs.HasPathPrefix(target, nodeTarget) {
-			debug.Log("target: %v %v", target, nodeTarget)
-			debug.Log("node %q has invalid target path %q", node.Name, nodeTarget)
-			err := res.Error(nodeLocation, errors.New("node has invalid path"))
-			if err != nil {
-				return hasRestored, err
+	}
+	if !res.opts.ModifiedSince.IsZero() || !res.opts.ModifiedUntil.IsZero() {
+		depthFilter := selectFilter
+		selectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
+			selectedForRestore, childMayBeSelected := depthFilter(item, dstpath, node)
+			if node.Type == "dir" {
+				// created on demand for the selected children
+				return false, childMayBeSelected
 			}
-			continue
+			return selectedForRestore && res.modifiedInWindow(node), childMayBeSelected
 		}
-
+	}
+	selectFilter = res.overlay.wrap(selectFilter)
+	overlayFilter := selectFilter
+	selectFilter = func(item string, dstpath string, node *restic.Node) (bool, bool) {
 		// sockets cannot be restored
 		if node.Type == "socket" {
-			continue
-		}
-
-		selectedForRestore, childMayBeSelected := res.SelectFilter(nodeLocation, nodeTarget, node)
-		debug.Log("SelectFilter returned %v %v for %q", selectedForRestore, childMayBeSelected, nodeLocation)
-
-		if selectedForRestore {
-			hasRestored = true
+			if visitor.skipSocket != nil {
+				visitor.skipSocket(item)
+			}
+			return false, false
 		}
+		return overlayFilter(item, dstpath, node)
+	}
 
-		sanitizeError := func(err error) error {
-			switch err {
-			case nil, context.Canceled, context.DeadlineExceeded:
-				// Context errors are permanent.
-				return err
-			default:
-				return res.Error(nodeLocation, err)
+	visitNode := visitor.visitNode
+	if visitNode != nil {
+		visitNode = func(node *restic.Node, target, location string) error {
+			node, ok := res.handleUnsupportedNode(node)
+			if !ok {
+				return nil
 			}
+			return visitor.visitNode(node, target, location)
 		}
+	}
 
-		if node.Type == "dir" {
-			if node.Subtree == nil {
-				return hasRestored, errors.Errorf("Dir without subtree in tree %v", treeID.Str())
-			}
+	return walkTree(ctx, res.repo, target, location, treeID, &TreeVisitor{
+		SelectFilter: selectFilter,
+		Error:        res.handleError,
+		EnterDir:     visitor.enterDir,
+		VisitNode:    visitNode,
+		LeaveDir:     visitor.leaveDir,
+	})
+}
 
-			if selectedForRestore && visitor.enterDir != nil {
-				err = sanitizeError(visitor.enterDir(node, nodeTarget, nodeLocation))
-				if err != nil {
-					return hasRestored, err
-				}
-			}
+// modifiedInWindow returns whether the modification time of node is within
+// Options.ModifiedSince and Options.ModifiedUntil.
+func (res *Restorer) modifiedInWindow(node *restic.Node) bool {
+	if !res.opts.ModifiedSince.IsZero() && node.ModTime.Before(res.opts.ModifiedSince) {
+		return false
+	}
+	return res.opts.ModifiedUntil.IsZero() || node.ModTime.Before(res.opts.ModifiedUntil)
+}
 
-			// keep track of restored child status
-			// so metadata of the current directory are restored on leaveDir
-			childHasRestored := false
+// priority returns the priority of the content of node, see
+// Options.Priority.
+func (res *Restorer) priority(node *restic.Node) int {
+	if res.opts.Priority == nil {
+		return 0
+	}
+	return res.opts.Priority(node)
+}
 
-			if childMayBeSelected {
-				childHasRestored, err = res.traverseTree(ctx, nodeTarget, nodeLocation, *node.Subtree, visitor)
-				err = sanitizeError(err)
-				if err != nil {
-					return hasRestored, err
-				}
-				// inform the parent directory to restore parent metadata on leaveDir if needed
-				if childHasRestored {
-					hasRestored = true
-				}
-			}
+// depth returns the number of path components of location.
+func depth(location string) int {
+	sep := string(filepath.Separator)
+	return len(strings.Split(strings.Trim(location, sep), sep))
+}
 
-			// metadata need to be restore when leaving the directory in both cases
-			// selected for restore or any child of any subtree have been restored
-			if (selectedForRestore || childHasRestored) && visitor.leaveDir != nil {
-				err = sanitizeError(visitor.leaveDir(node, nodeTarget, nodeLocation))
-				if err != nil {
-					return hasRestored, err
-				}
-			}
+// warn passes msg to res.Warn, if set.
+func (res *Restorer) warn(msg string) {
+	if res.Warn != nil {
+		res.warnLock.Lock()
+		defer res.warnLock.Unlock()
+		res.Warn(msg)
+	}
+}
 
-			continue
-		}
+// handleError passes err for location to res.Error after recording it.
+func (res *Restorer) handleError(location string, err error) error {
+	if res.currentTarget != "" {
+		err = withTarget(res.currentTarget, err)
+	}
+	res.events.error(location, err)
+	if handlerErr := res.Error(location, err); handlerErr != nil {
+		return handlerErr
+	}
 
-		if selectedForRestore {
-			err = sanitizeError(visitor.visitNode(node, nodeTarget, nodeLocation))
-			if err != nil {
-				return hasRestored, err
-			}
+	res.collectedLock.Lock()
+	defer res.collectedLock.Unlock()
+	if res.collected != nil {
+		if _, ok := res.collected[location]; !ok {
+			res.collected[location] = err
 		}
 	}
+	return nil
+}
+
+// RestoreErrors is returned by RestoreTo with Options.CollectErrors if the
+// restore completed, but errors were reported for some items. It maps the
+// location of each affected item to the first error reported for it.
+type RestoreErrors map[string]error
 
-	return hasRestored, nil
+func (e RestoreErrors) Error() string {
+	if len(e) == 1 {
+		for location, err := range e {
+			return fmt.Sprintf("failed to restore %v: %v", location, err)
+		}
+	}
+	return fmt.Sprintf("failed to restore %d items", len(e))
 }
 
 func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, target, location string) error {
@@ -237,13 +812,207 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, targe
 
 func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location string) error {
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
-	err := node.RestoreMetadata(target, res.Warn)
+	node = res.filterGenericAttributes(node)
+	node = res.withFinalReadOnly(node)
+	original := node
+	node = res.withOwner(node)
+	err := node.RestoreMetadataWithOptions(target, res.warn, restic.RestoreMetadataOptions{
+		SkipTimestamps: res.opts.SkipDirTimes && node.Type == "dir",
+	})
+	if res.opts.StrictOwnership && runtime.GOOS != "windows" {
+		if errOwner := res.checkOwnership(original, node, target); errOwner != nil {
+			return errOwner
+		}
+	}
+	if err == nil {
+		err = res.queueFileFlags(node, target, location)
+	}
+	if err == nil && runtime.GOOS != "windows" {
+		// on Windows, the streams are restored along with the metadata
+		err = res.restoreStreamSidecars(node, target, location)
+	}
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
+		if res.opts.BestEffortMetadata {
+			res.metadataLock.Lock()
+			defer res.metadataLock.Unlock()
+			if res.metadataFailures == 0 {
+				res.metadataFirstErr = err
+			}
+			res.metadataFailures++
+			return nil
+		}
 	}
 	return err
 }
 
+type pendingFileFlags struct {
+	target, location string
+	flags            fs.FileFlags
+}
+
+// queueFileFlags records the flags of node to be applied to target by
+// applyFileFlags, see Options.RestoreFileFlags.
+func (res *Restorer) queueFileFlags(node *restic.Node, target, location string) error {
+	if !res.opts.RestoreFileFlags || (node.Type != "file" && node.Type != "dir") {
+		return nil
+	}
+	flags, err := node.FileFlags()
+	if err != nil || flags == 0 {
+		return err
+	}
+	res.metadataLock.Lock()
+	defer res.metadataLock.Unlock()
+	res.pendingFlags = append(res.pendingFlags, pendingFileFlags{target: target, location: location, flags: flags})
+	return nil
+}
+
+// applyFileFlags applies all flags recorded by queueFileFlags.
+func (res *Restorer) applyFileFlags() error {
+	pending := res.pendingFlags
+	res.pendingFlags = nil
+	for _, item := range pending {
+		err := fs.SetFileFlags(item.target, item.flags)
+		if errors.Is(err, fs.ErrFileFlagsUnsupported) {
+			if !res.fileFlagsReported {
+				res.fileFlagsReported = true
+				res.warn(fmt.Sprintf("%v: %v, file flags are not restored", item.location, err))
+			}
+			continue
+		}
+		if err != nil {
+			if err := res.handleError(item.location, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clearFileFlags removes the flags of an existing file or directory at
+// target, which would otherwise prevent modifying it.
+func (res *Restorer) clearFileFlags(target string) error {
+	if !res.opts.RestoreFileFlags {
+		return nil
+	}
+	fi, err := fs.Lstat(target)
+	if err != nil || (!fi.Mode().IsRegular() && !fi.IsDir()) {
+		// a missing item is created later, other types have no such flags
+		return nil
+	}
+	flags, err := fs.GetFileFlags(target)
+	if errors.Is(err, fs.ErrFileFlagsUnsupported) || (err == nil && flags == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	debug.Log("clearing flags of %v", target)
+	return fs.SetFileFlags(target, 0)
+}
+
+// trustedFiles contains the file nodes of Options.SkipExistingVerifiedFrom by
+// location.
+type trustedFiles map[string]*restic.Node
+
+// loadTrustedFiles collects the files of Options.SkipExistingVerifiedFrom. It
+// returns nil if the option is not set.
+func (res *Restorer) loadTrustedFiles(ctx context.Context) (trustedFiles, error) {
+	base := res.opts.SkipExistingVerifiedFrom
+	if base == nil {
+		return nil, nil
+	}
+	if base.Tree == nil {
+		return nil, errors.New("snapshot to skip existing files from has no tree")
+	}
+
+	files := make(trustedFiles)
+	err := WalkTree(ctx, res.repo, string(filepath.Separator), *base.Tree, TreeVisitor{
+		VisitNode: func(node *restic.Node, _, location string) error {
+			if node.Type == "file" {
+				files[location] = node
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "load base snapshot")
+	}
+	debug.Log("trusting %d existing files", len(files))
+	return files, nil
+}
+
+// matches returns whether the file at location is unchanged compared to the
+// trusted snapshot. Metadata which is not restored is ignored.
+func (t trustedFiles) matches(node *restic.Node, location string) bool {
+	base, ok := t[location]
+	if !ok {
+		return false
+	}
+	return restoredMetadata(node).Equals(restoredMetadata(base))
+}
+
+// withOwner returns node with the user and group IDs resolved by
+// Options.UserLookup and Options.GroupLookup. node is not modified.
+func (res *Restorer) withOwner(node *restic.Node) *restic.Node {
+	uid, gid := node.UID, node.GID
+	if res.opts.UserLookup != nil && node.User != "" {
+		if id, ok := res.opts.UserLookup(node.User); ok {
+			uid = id
+		}
+	}
+	if res.opts.GroupLookup != nil && node.Group != "" {
+		if id, ok := res.opts.GroupLookup(node.Group); ok {
+			gid = id
+		}
+	}
+	if uid == node.UID && gid == node.GID {
+		return node
+	}
+
+	debug.Log("restoring owner of %v as %v:%v instead of %v:%v", node.Name, uid, gid, node.UID, node.GID)
+	n := *node
+	n.UID, n.GID = uid, gid
+	return &n
+}
+
+// checkOwnership returns an error if the owner of original, which was
+// resolved to owner by withOwner, is not restored exactly at target, see
+// Options.StrictOwnership.
+func (res *Restorer) checkOwnership(original, owner *restic.Node, target string) error {
+	if res.opts.UserLookup != nil && original.User != "" {
+		if _, ok := res.opts.UserLookup(original.User); !ok {
+			return errors.Errorf("user %q not found", original.User)
+		}
+	}
+	if res.opts.GroupLookup != nil && original.Group != "" {
+		if _, ok := res.opts.GroupLookup(original.Group); !ok {
+			return errors.Errorf("group %q not found", original.Group)
+		}
+	}
+
+	fi, err := fs.Lstat(target)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	stat := fs.ExtendedStat(fi)
+	if stat.UID != owner.UID || stat.GID != owner.GID {
+		return errors.Errorf("owner is %v:%v instead of %v:%v", stat.UID, stat.GID, owner.UID, owner.GID)
+	}
+	return nil
+}
+
+// withFinalReadOnly returns node without write permissions if it is a file
+// and Options.FinalReadOnly is set. node is not modified.
+func (res *Restorer) withFinalReadOnly(node *restic.Node) *restic.Node {
+	if !res.opts.FinalReadOnly || node.Type != "file" || node.Mode&0222 == 0 {
+		return node
+	}
+	n := *node
+	n.Mode &^= 0222
+	return &n
+}
+
 func (res *Restorer) restoreHardlinkAt(node *restic.Node, target, path, location string) error {
 	if err := fs.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return errors.Wrap(err, "RemoveCreateHardlink")
@@ -259,6 +1028,65 @@ func (res *Restorer) restoreHardlinkAt(node *restic.Node, target, path, location
 	return res.restoreNodeMetadataTo(node, path, location)
 }
 
+// sameFile returns whether the paths a and b refer to the same file.
+func sameFile(a, b string) bool {
+	fa, err := fs.Lstat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := fs.Lstat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// dirComplete calls Options.OnDirComplete, if set.
+func (res *Restorer) dirComplete(node *restic.Node, target string) error {
+	if res.opts.OnDirComplete == nil {
+		return nil
+	}
+	return res.opts.OnDirComplete(target, node)
+}
+
+// collectHardlinkGroups returns the locations of the selected members of all
+// groups of hardlinked files, see Options.HardlinkPolicy.
+func (res *Restorer) collectHardlinkGroups(ctx context.Context, dst string) (map[HardlinkKey][]string, error) {
+	groups := make(map[HardlinkKey][]string)
+	_, err := res.traverseTree(ctx, dst, string(filepath.Separator), res.root, treeVisitor{
+		visitNode: func(node *restic.Node, _, location string) error {
+			if node.Type == "file" && node.Links > 1 {
+				key := HardlinkKey{node.Inode, node.DeviceID}
+				groups[key] = append(groups[key], location)
+			}
+			return nil
+		},
+	})
+	return groups, err
+}
+
+// findExistingHardlink returns the location of another member of the group
+// of node which already exists with the content of node. It is only searched
+// if target itself does not have the size of node, otherwise the content at
+// target is reused as usual.
+func (res *Restorer) findExistingHardlink(groups map[HardlinkKey][]string, node *restic.Node, target, location string, targetPath func(string) string, buf *[]byte) (string, bool) {
+	members := groups[HardlinkKey{node.Inode, node.DeviceID}]
+	if len(members) < 2 || sizeMatches(node, target) {
+		return "", false
+	}
+	for _, member := range members {
+		if member == location || !sizeMatches(node, targetPath(member)) {
+			continue
+		}
+		var matches *fileState
+		matches, *buf, _ = res.verifyFile(targetPath(member), node, false, false, *buf)
+		if !matches.NeedsRestore() {
+			return member, true
+		}
+	}
+	return "", false
+}
+
 func (res *Restorer) ensureDir(target string) error {
 	fi, err := fs.Lstat(target)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -266,6 +1094,7 @@ func (res *Restorer) ensureDir(target string) error {
 	}
 	if err == nil && !fi.IsDir() {
 		// try to cleanup unexpected file
+		res.warn(fmt.Sprintf("%v: replacing existing %v with directory", target, describeFileMode(fi.Mode())))
 		if err := fs.Remove(target); err != nil {
 			return fmt.Errorf("failed to remove stale item: %w", err)
 		}
@@ -276,61 +1105,491 @@ func (res *Restorer) ensureDir(target string) error {
 	return fs.MkdirAll(target, 0700)
 }
 
+// includeTopDir returns the directory below dst to restore to if
+// Options.IncludeTopDir is set. Otherwise, dst is returned unchanged. As the
+// tree of the snapshot contains the full source path, the subtree at this
+// path becomes the restored tree.
+func (res *Restorer) includeTopDir(ctx context.Context, dst string) (string, error) {
+	if !res.opts.IncludeTopDir {
+		return dst, nil
+	}
+	if res.sn == nil {
+		return "", errors.New("Options.IncludeTopDir requires a snapshot")
+	}
+	if len(res.sn.Paths) != 1 {
+		return "", errors.Errorf("Options.IncludeTopDir requires a snapshot with a single path, found %d", len(res.sn.Paths))
+	}
+	name := filepath.Base(filepath.Clean(res.sn.Paths[0]))
+	if name == "." || name == ".." || name == string(filepath.Separator) || filepath.VolumeName(name) != "" {
+		return "", errors.Errorf("snapshot path %q has no usable last component", res.sn.Paths[0])
+	}
+	rootPath := snapshotTreePath(res.sn.Paths[0])
+	root, err := restic.FindTreeDirectory(ctx, res.repo, &res.tree, rootPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "snapshot path %v", res.sn.Paths[0])
+	}
+	res.root = *root
+	res.rootPath = rootPath
+	return filepath.Join(dst, name), nil
+}
+
+// snapshotTreePath returns the location of the source path p within the tree
+// of a snapshot. The archiver stores the volume name of Windows paths as a
+// directory without the colon.
+func snapshotTreePath(p string) string {
+	volume := filepath.VolumeName(p)
+	p = filepath.Clean(p[len(volume):])
+	if len(volume) == 2 && volume[1] == ':' {
+		volume = volume[:1]
+	}
+	return path.Join(volume, filepath.ToSlash(p))
+}
+
+// removeWrongType removes an existing item at target if its type does not
+// match node. Directories are removed including their contents.
+func (res *Restorer) removeWrongType(node *restic.Node, target, location string) error {
+	expectedType, ok := modeTypes[node.Type]
+	if !ok || node.Type == "dir" {
+		// directories are handled by ensureDir
+		return nil
+	}
+	fi, err := fs.Lstat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if fi.Mode().Type() == expectedType {
+		return nil
+	}
+
+	res.warn(fmt.Sprintf("%v: replacing existing %v with %v", location, describeFileMode(fi.Mode()), node.Type))
+	if fi.IsDir() {
+		return errors.Wrap(fs.RemoveAll(target), "RemoveAll")
+	}
+	return errors.Wrap(fs.Remove(target), "Remove")
+}
+
+// describeFileMode returns a human readable name for the type of mode.
+func describeFileMode(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode.IsRegular():
+		return "file"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "special file"
+	}
+}
+
+// checkLocked returns the error if the existing file at target cannot be
+// opened for writing as it is in use by another process, see
+// Options.SkipLocked. Other errors are ignored, they are reported once the
+// file is restored.
+func checkLocked(target string) error {
+	f, err := fs.OpenFile(target, fs.O_WRONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		if fs.IsLocked(err) {
+			return err
+		}
+		return nil
+	}
+	_ = f.Close()
+	return nil
+}
+
+// replaceFile renames tmp to target, removing an empty directory at target.
+func replaceFile(tmp, target string) error {
+	fi, err := fs.Lstat(target)
+	if err == nil && fi.IsDir() {
+		if err := fs.Remove(target); err != nil {
+			return errors.Wrap(err, "RemoveDir")
+		}
+	}
+	return errors.WithStack(fs.Rename(tmp, target))
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
-	var err error
+	return res.restoreTo(ctx, dst, res.tree, "")
+}
+
+// RestoreSubtree restores the directory at snapshotSubpath within the
+// snapshot such that its children are created directly below dst, for
+// example /var/lib/app. Only this subtree is traversed. The locations passed
+// to SelectFilter and Error as well as those in events are relative to the
+// subtree. Afterwards, VerifyFiles and VerifyMetadata also check the subtree.
+func (res *Restorer) RestoreSubtree(ctx context.Context, snapshotSubpath string, dst string) error {
+	if res.opts.IncludeTopDir {
+		return errors.New("Options.IncludeTopDir cannot be used to restore a subtree")
+	}
+	root, err := restic.FindTreeDirectory(ctx, res.repo, &res.tree, filepath.ToSlash(snapshotSubpath))
+	if err != nil {
+		return errors.Wrapf(err, "snapshot subpath %v", snapshotSubpath)
+	}
+	return res.restoreTo(ctx, dst, *root, filepath.ToSlash(snapshotSubpath))
+}
+
+func (res *Restorer) restoreTo(ctx context.Context, dst string, root restic.ID, rootPath string) (err error) {
+	if err := res.CheckCompatibility(); err != nil {
+		return err
+	}
+	if res.opts.TextConvert != nil {
+		if err := res.opts.TextConvert.validate(); err != nil {
+			return errors.Wrap(err, "TextConvert")
+		}
+	}
+	if res.opts.RefuseMountpoints && runtime.GOOS == "windows" {
+		return errors.New("Options.RefuseMountpoints is not supported on Windows")
+	}
+	defer func() {
+		if errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = ErrDeadlineExceeded
+		}
+	}()
+	res.root = root
+	res.rootPath = rootPath
+	// the state of the previous restore, for example to another target of
+	// RestoreToMany, must not affect this one
+	res.fileList = make(map[string]bool)
+	res.transformed = make(map[string]struct{})
+	res.stubs = make(map[string]struct{})
+	res.damaged = nil
+	res.emptyFiles = 0
+
 	if !filepath.IsAbs(dst) {
 		dst, err = filepath.Abs(dst)
 		if err != nil {
 			return errors.Wrap(err, "Abs")
 		}
 	}
+	dst, err = res.includeTopDir(ctx, dst)
+	if err != nil {
+		return err
+	}
 
-	idx := NewHardlinkIndex[string]()
-	filerestorer := newFileRestorer(dst, res.repo.LoadBlobsFromPack, res.repo.LookupBlob,
-		res.repo.Connections(), res.opts.Sparse, res.opts.Progress)
-	filerestorer.Error = res.Error
-
-	debug.Log("first pass for %q", dst)
-
-	var buf []byte
-
-	// first tree pass: create directories and collect all files to restore
-	_, err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
-		enterDir: func(_ *restic.Node, target, location string) error {
-			debug.Log("first pass, enterDir: mkdir %q, leaveDir should restore metadata", location)
-			res.opts.Progress.AddFile(0)
-			return res.ensureDir(target)
-		},
+	res.events.start(dst)
+	defer res.events.summary()
 
-		visitNode: func(node *restic.Node, target, location string) error {
-			debug.Log("first pass, visitNode: mkdir %q, leaveDir on second pass should restore metadata", location)
-			if err := res.ensureDir(filepath.Dir(target)); err != nil {
-				return err
+	if res.opts.CollectErrors {
+		res.collected = make(RestoreErrors)
+		defer func() {
+			res.collectedLock.Lock()
+			defer res.collectedLock.Unlock()
+			if err == nil && len(res.collected) > 0 {
+				err = res.collected
 			}
+			res.collected = nil
+		}()
+	}
 
-			if node.Type != "file" {
-				res.opts.Progress.AddFile(0)
-				return nil
+	res.metadataFailures, res.metadataFirstErr = 0, nil
+	res.unknownAttributes, res.unknownAttributeItems = nil, 0
+	res.pendingFlags = nil
+	defer func() {
+		if res.metadataFailures > 0 {
+			res.warn(fmt.Sprintf("failed to restore metadata of %d items, first error: %v", res.metadataFailures, res.metadataFirstErr))
+		}
+		res.warnUnknownAttributes()
+	}()
+
+	var relaxed *relaxedDirs
+	if res.opts.RelaxDirModesDuringRestore {
+		relaxed = newRelaxedDirs()
+		defer func() {
+			// runs even if the restore was aborted
+			if tightenErr := res.tightenDirs(relaxed); err == nil {
+				err = tightenErr
 			}
+		}()
+	}
 
-			if node.Links > 1 {
-				if idx.Has(node.Inode, node.DeviceID) {
-					// a hardlinked file does not increase the restore size
-					res.opts.Progress.AddFile(0)
+	idx := NewHardlinkIndex[string]()
+	blobsLoader := retryLoads(res.repo.LoadBlobsFromPack, res.opts.LoadRetries, res.opts.LoadBackoff)
+	blobsLoader = countFetchedBlobs(blobsLoader, res.opts.Progress)
+	blobsLoader = wrapBlobCache(res.opts.BlobCache, blobsLoader)
+	if res.packs != nil {
+		blobsLoader = res.packs.wrap(blobsLoader, res.opts.Progress)
+	}
+	if res.reuseBlobs != nil {
+		blobsLoader = res.reuseBlobs.wrap(blobsLoader)
+	}
+	filerestorer := newFileRestorer(dst, blobsLoader, res.repo.LookupBlob,
+		res.repo.Connections(), res.opts.Sparse, res.opts.Progress)
+	filerestorer.Error = res.handleError
+	filerestorer.zeroFillMissing = res.opts.ZeroFillMissing
+	// the content of a file must be complete before it can be transformed
+	filerestorer.atomicReplace = res.opts.AtomicReplace || res.opts.ContentTransform != nil || res.opts.TextConvert != nil
+	if filerestorer.atomicReplace && res.opts.TempDir != "" {
+		tempDir, err := filepath.Abs(res.opts.TempDir)
+		if err != nil {
+			return errors.Wrap(err, "Abs")
+		}
+		if err := checkTempDir(tempDir, dst); err != nil {
+			return errors.Wrap(err, "TempDir")
+		}
+		filerestorer.tempDir = tempDir
+	}
+	filerestorer.verifyOnWrite = res.opts.VerifyOnWrite
+	filerestorer.cleanupOnCancel = res.opts.CleanupOnCancel
+	filerestorer.perFileTimeout = res.opts.PerFileTimeout
+	// completed files are finished if the deadline expires while restoring
+	// the file contents. With atomicReplace, the targets are left untouched.
+	var deadlineFiles map[string]*restic.Node
+	if _, ok := ctx.Deadline(); ok && !filerestorer.atomicReplace {
+		filerestorer.trackCompleted = true
+		deadlineFiles = make(map[string]*restic.Node)
+	}
+	filerestorer.filesWriter.bufferSize = res.opts.WriteBufferSize
+	if res.opts.MaxMemory > 0 {
+		limit := res.opts.MaxMemory
+		if res.packs != nil {
+			limit -= int64(res.packs.size)
+		}
+		filerestorer.memory = semaphore.NewWeighted(limit)
+		filerestorer.memoryLimit = limit
+	}
+	if res.opts.Metrics != nil {
+		filerestorer.metrics = newRestoreMetrics()
+		stop := filerestorer.metrics.report(ctx, res.opts.Metrics, res.opts.MetricsInterval, res.opts.Clock)
+		defer stop()
+	}
+
+	createdTarget := false
+	if res.opts.TargetMode != nil {
+		if _, err := fs.Lstat(dst); errors.Is(err, os.ErrNotExist) {
+			if err := fs.MkdirAll(dst, 0700); err != nil {
+				return errors.Wrap(err, "MkdirAll")
+			}
+			createdTarget = true
+		}
+	}
+
+	checkTarget := func(string) (bool, error) { return true, nil }
+	if !res.opts.AllowSymlinkedTarget {
+		checkTarget = newTargetChecker(dst).check
+	}
+	if res.opts.RefuseMountpoints || (runtime.GOOS != "windows" && filepath.Dir(dst) == dst) {
+		checkSymlinks := checkTarget
+		mountpoints := newMountpointChecker(dst, res.opts.AllowMountpoints)
+		checkTarget = func(target string) (bool, error) {
+			if ok, err := checkSymlinks(target); !ok {
+				return false, err
+			}
+			return mountpoints.check(target)
+		}
+	}
+
+	trusted, err := res.loadTrustedFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	res.overlay, err = res.loadOverlayPlan(ctx)
+	if err != nil {
+		return err
+	}
+	if res.overlay != nil && len(res.overlay.whiteouts[string(filepath.Separator)]) > 0 {
+		if err := res.ensureDir(dst); err != nil {
+			return err
+		}
+		if err := res.overlay.writeWhiteouts(dst, string(filepath.Separator)); err != nil {
+			return err
+		}
+	}
+
+	res.promoted = nil
+	if res.opts.WarnBrokenHardlinks || res.opts.PromoteHardlinks {
+		groups, err := res.splitHardlinkGroups(ctx, dst)
+		if err != nil {
+			return err
+		}
+		res.promoted = res.handleSplitHardlinks(groups)
+	}
+
+	var hardlinkGroups map[HardlinkKey][]string
+	if _, err := fs.Lstat(dst); err == nil && res.opts.HardlinkPolicy == RelinkExisting {
+		// only an existing destination can contain members of a group
+		hardlinkGroups, err = res.collectHardlinkGroups(ctx, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	collisions := newCaseCollisions(dst, res.opts.ConflictResolver)
+	res.collisions = collisions
+
+	untouched := newUntouchedItems()
+	manifest := newManifest(res.opts.ManifestWriter)
+	syncs := newFsyncs(res.opts.Fsync)
+	reflinks := res.newReflinkPlan()
+	dedupe := res.newDedupePlan()
+
+	debug.Log("first pass for %q", dst)
+
+	var buf []byte
+	var plannedBytes int64
+	quotaExceeded := false
+
+	// first tree pass: create directories and collect all files to restore
+	_, err = res.traverseTree(ctx, dst, string(filepath.Separator), res.root, treeVisitor{
+		enterDir: func(node *restic.Node, target, location string) error {
+			debug.Log("first pass, enterDir: mkdir %q, leaveDir should restore metadata", location)
+			target, location, ok, err := collisions.check(target, location)
+			if !ok {
+				return err
+			}
+			if ok, err := checkTarget(target); !ok {
+				return err
+			}
+			if res.opts.OverwriteFunc == nil && res.opts.Overwrite == OverwriteNone {
+				if untouched.isBlocked(location) {
+					return nil
+				}
+				fi, err := fs.Lstat(target)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+				if err == nil {
+					untouched.add(location, fi.IsDir())
+					if !fi.IsDir() {
+						res.events.skipped(location, 0)
+						return nil
+					}
+					res.opts.Progress.AddFile(0)
+					return nil
+				}
+			}
+			res.opts.Progress.AddFile(0)
+			if err := res.clearFileFlags(target); err != nil {
+				return err
+			}
+			if err := res.ensureDir(target); err != nil {
+				return err
+			}
+			if err := res.overlay.writeWhiteouts(target, location); err != nil {
+				return err
+			}
+			if err := relaxed.add(node, target, location); err != nil {
+				return err
+			}
+			if res.opts.OnDirCreated != nil {
+				return res.opts.OnDirCreated(target, node)
+			}
+			return nil
+		},
+
+		visitNode: func(node *restic.Node, target, location string) error {
+			debug.Log("first pass, visitNode: mkdir %q, leaveDir on second pass should restore metadata", location)
+			target, location, ok, err := collisions.check(target, location)
+			if !ok {
+				return err
+			}
+			if untouched.isBlocked(location) {
+				return nil
+			}
+			if node.Type == "file" && trusted.matches(node, location) {
+				if node.Links > 1 && !idx.Has(node.Inode, node.DeviceID) {
+					// other hardlinks are linked to the existing file
+					idx.Add(node.Inode, node.DeviceID, location)
+				}
+				res.opts.Progress.AddSkippedFile(node.Size)
+				res.events.skipped(location, node.Size)
+				return nil
+			}
+			if ok, err := checkTarget(target); !ok {
+				return err
+			}
+			if err := res.ensureDir(filepath.Dir(target)); err != nil {
+				return err
+			}
+
+			if node.Type != "file" {
+				res.opts.Progress.AddFile(0)
+				return nil
+			}
+
+			if node.Links > 1 {
+				if idx.Has(node.Inode, node.DeviceID) {
+					// a hardlinked file does not increase the restore size
+					res.opts.Progress.AddFile(0)
+					return nil
+				}
+				if existing, ok := res.findExistingHardlink(hardlinkGroups, node, target, location, filerestorer.targetPath, &buf); ok {
+					debug.Log("linking %v to existing hardlink %v", location, existing)
+					// this node is linked to the existing file in the second pass
+					idx.Add(node.Inode, node.DeviceID, existing)
+					res.opts.Progress.AddFile(0)
 					return nil
 				}
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
+			if dedupe.plan(node, target, location) {
+				// this node is linked to the file with the same content in the
+				// second pass, which does not increase the restore size
+				res.opts.Progress.AddFile(0)
+				return nil
+			}
 
-			buf, err = res.withOverwriteCheck(node, target, false, buf, func(updateMetadataOnly bool, matches *fileState) error {
+			buf, err = res.withOverwriteCheck(node, target, location, false, buf, func(updateMetadataOnly bool, matches *fileState) error {
+				if !updateMetadataOnly && res.opts.SkipLocked {
+					if errLocked := checkLocked(target); errLocked != nil {
+						debug.Log("skipping %v, it is locked: %v", location, errLocked)
+						if node.Links > 1 {
+							// other hardlinks must not link to the skipped file
+							idx.Remove(node.Inode, node.DeviceID)
+						}
+						res.opts.Progress.AddSkippedFile(node.Size)
+						res.events.skipped(location, node.Size)
+						if res.opts.OnLocked != nil {
+							res.opts.OnLocked(location, errLocked)
+						}
+						return nil
+					}
+				}
+				if !updateMetadataOnly && res.opts.StubFilter != nil && res.opts.StubFilter(node) {
+					if err := createStub(target, node.Size); err != nil {
+						return err
+					}
+					res.stubs[location] = struct{}{}
+					res.opts.Progress.AddFile(0)
+					res.trackFile(location, false)
+					return nil
+				}
+				if !updateMetadataOnly && res.opts.MaxBytes > 0 {
+					if quotaExceeded || plannedBytes+int64(node.Size) > res.opts.MaxBytes {
+						debug.Log("skipping %v, exceeds the size limit", location)
+						quotaExceeded = true
+						if node.Links > 1 {
+							// other hardlinks must not link to the missing file
+							idx.Remove(node.Inode, node.DeviceID)
+						}
+						res.opts.Progress.AddSkippedFile(node.Size)
+						res.events.skipped(location, node.Size)
+						return nil
+					}
+					plannedBytes += int64(node.Size)
+				}
 				if updateMetadataOnly {
 					res.opts.Progress.AddSkippedFile(node.Size)
 				} else {
 					res.opts.Progress.AddFile(node.Size)
-					filerestorer.addFile(location, node.Content, int64(node.Size), matches)
+					if filerestorer.atomicReplace {
+						// the temporary file must be written completely
+						matches = nil
+					}
+					if !reflinks.plan(node, location) {
+						filerestorer.addFile(location, node.Content, int64(node.Size), matches, res.priority(node))
+						if deadlineFiles != nil {
+							deadlineFiles[location] = node
+						}
+					}
 				}
+				dedupe.addSource(node, target, location)
 				res.trackFile(location, updateMetadataOnly)
 				return nil
 			})
@@ -342,47 +1601,686 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	}
 
 	err = filerestorer.restoreFiles(ctx)
+	res.damaged = filerestorer.damaged
+	res.emptyFiles = filerestorer.emptyFiles
+	if deadlineFiles != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// errors for files affected by the deadline are not necessarily
+		// returned by restoreFiles
+		if err := res.finishCompletedFiles(filerestorer, deadlineFiles); err != nil {
+			return err
+		}
+		return ErrDeadlineExceeded
+	}
 	if err != nil {
 		return err
 	}
 
 	debug.Log("second pass for %q", dst)
 
+	var rewrittenLinks []string
+
+	workers := res.opts.MetadataWorkers
+	if workers <= 0 {
+		workers = nMetadataWorkers
+	}
+	metadata := newMetadataPool(workers, res.handleError)
+	defer metadata.close()
+
 	// second tree pass: restore special files and filesystem metadata
-	_, err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	//
+	// restoreFiles only returns once all workers have finished writing and
+	// all files are closed, and this pass runs sequentially. The metadata of
+	// consecutive files is restored in parallel, but all of them are
+	// collected before any other item and before leaveDir. Thus leaveDir is
+	// only called once nothing modifies the directory anymore, such that its
+	// timestamps are final even if many files were restored in parallel.
+	_, err = res.traverseTree(ctx, dst, string(filepath.Separator), res.root, treeVisitor{
+		skipSocket: func(location string) {
+			// a socket is only usable while the process which created it is
+			// listening, thus there is nothing meaningful to restore
+			res.warn(fmt.Sprintf("skipping socket %v", location))
+		},
 		visitNode: func(node *restic.Node, target, location string) error {
 			debug.Log("second pass, visitNode: restore node %q", location)
 			if node.Type != "file" {
-				_, err := res.withOverwriteCheck(node, target, false, nil, func(_ bool, _ *fileState) error {
-					return res.restoreNodeTo(ctx, node, target, location)
+				if err := metadata.wait(); err != nil {
+					return err
+				}
+			}
+			target, location, ok := collisions.resolve(target, location)
+			if !ok || untouched.isBlocked(location) {
+				return nil
+			}
+			if ok, err := checkTarget(target); !ok {
+				return err
+			}
+			if node.Type != "file" {
+				if rewritten := res.rewriteSymlink(node, target); rewritten != node {
+					node = rewritten
+					rewrittenLinks = append(rewrittenLinks, target)
+				}
+				_, err := res.withOverwriteCheck(node, target, location, false, nil, func(_ bool, _ *fileState) error {
+					if res.metadataUnchanged(node, target) {
+						res.opts.Progress.AddProgress(location, 0, 0)
+						res.events.skipped(location, 0)
+						return nil
+					}
+					if err := res.restoreNodeTo(ctx, node, target, location); err != nil {
+						return err
+					}
+					syncs.addEntry(target)
+					res.events.restored(location, 0)
+					return nil
 				})
 				return err
 			}
 
+			first, linked := dedupe.source(location)
+			if linked && (filerestorer.hasFailed(first) || len(res.damaged[first]) > 0) {
+				return errors.Errorf("file with the same content %v was not restored", first)
+			}
 			if idx.Has(node.Inode, node.DeviceID) && idx.Value(node.Inode, node.DeviceID) != location {
-				_, err := res.withOverwriteCheck(node, target, true, nil, func(_ bool, _ *fileState) error {
-					return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.Value(node.Inode, node.DeviceID)), target, location)
+				first, linked = idx.Value(node.Inode, node.DeviceID), true
+			}
+			if linked {
+				// the metadata of the first file must be complete
+				if err := metadata.wait(); err != nil {
+					return err
+				}
+				_, err := res.withOverwriteCheck(node, target, location, true, nil, func(_ bool, _ *fileState) error {
+					if sameFile(target, filerestorer.targetPath(first)) && res.metadataUnchanged(node, target) {
+						res.opts.Progress.AddProgress(location, 0, 0)
+						res.events.skipped(location, 0)
+					} else {
+						if err := res.restoreHardlinkAt(node, filerestorer.targetPath(first), target, location); err != nil {
+							return err
+						}
+						syncs.addEntry(target)
+						res.events.restored(location, 0)
+					}
+					if !filerestorer.hasFailed(first) && len(res.damaged[first]) == 0 {
+						manifest.add(location, node)
+					}
+					return nil
 				})
 				return err
 			}
 
-			if _, ok := res.hasRestoredFile(location); ok {
-				return res.restoreNodeMetadataTo(node, target, location)
+			if _, ok := res.stubs[location]; ok {
+				// do not modify the cached node
+				stub := *node
+				stub.GenericAttributes = make(map[restic.GenericAttributeType]json.RawMessage, len(node.GenericAttributes)+1)
+				for k, v := range node.GenericAttributes {
+					stub.GenericAttributes[k] = v
+				}
+				if err := stub.SetStub(node.Size); err != nil {
+					return err
+				}
+				return metadata.submit(location, func() error {
+					return res.restoreNodeMetadataTo(&stub, target, location)
+				}, func() {
+					syncs.addFile(target)
+					res.events.restored(location, 0)
+				})
+			}
+
+			if metadataOnly, ok := res.hasRestoredFile(location); ok {
+				if !metadataOnly && filerestorer.hasFailed(location) {
+					if filerestorer.atomicReplace {
+						// the error was already reported, keep the existing file
+						if err := fs.Remove(filerestorer.writePath(location)); err != nil && !errors.Is(err, os.ErrNotExist) {
+							return err
+						}
+						return nil
+					}
+					if res.opts.VerifyOnWrite || filerestorer.hasTimedOut(location) {
+						// the error was already reported and the file removed,
+						// or left incomplete if it existed before
+						return nil
+					}
+				}
+				if src, ok := reflinks.source(location); ok && !metadataOnly {
+					path := src.path
+					if src.location != "" {
+						if filerestorer.hasFailed(src.location) || len(res.damaged[src.location]) > 0 {
+							return errors.Errorf("file with the same content %v was not restored", src.location)
+						}
+						path = filerestorer.targetPath(src.location)
+					}
+					if err := reflinkFrom(path, filerestorer.writePath(location)); err != nil {
+						return err
+					}
+					res.opts.Progress.AddProgress(location, node.Size, node.Size)
+				}
+				transformed := false
+				if filerestorer.atomicReplace && !metadataOnly {
+					var err error
+					transformed, err = res.replaceFromTemp(node, location, filerestorer.writePath(location), target)
+					if err != nil {
+						return err
+					}
+				}
+				if transformed {
+					res.transformed[location] = struct{}{}
+				} else if res.opts.Sparse && !metadataOnly && !filerestorer.hasFailed(location) {
+					if err := res.restoreSparseHoles(node, target); err != nil {
+						return err
+					}
+				}
+				unchanged := metadataOnly && res.metadataUnchanged(node, target)
+				// with atomicReplace, the hook was called before the rename
+				written := !metadataOnly && !filerestorer.atomicReplace && !filerestorer.hasFailed(location)
+				var apply func() error
+				if !unchanged {
+					apply = func() error {
+						if err := res.restoreNodeMetadataTo(node, target, location); err != nil {
+							return err
+						}
+						if written {
+							return res.postWrite(node, target)
+						}
+						return nil
+					}
+				}
+				return metadata.submit(location, apply, func() {
+					if !unchanged {
+						syncs.addFile(target)
+					}
+					if !transformed && !filerestorer.hasFailed(location) && len(res.damaged[location]) == 0 {
+						manifest.add(location, node)
+						res.recordBlobs.addFile(target, node.Content)
+						reflinks.addSource(node, target)
+					}
+					if unchanged {
+						res.events.skipped(location, node.Size)
+					} else if metadataOnly {
+						res.events.updated(location, node.Size)
+					} else {
+						res.events.restored(location, node.Size)
+					}
+				})
 			}
 			// don't touch skipped files
 			return nil
 		},
 		leaveDir: func(node *restic.Node, target, location string) error {
-			err := res.restoreNodeMetadataTo(node, target, location)
-			if err == nil {
+			if err := metadata.wait(); err != nil {
+				return err
+			}
+			target, location, ok := collisions.resolve(target, location)
+			if !ok || untouched.isBlocked(location) {
+				return nil
+			}
+			if untouched.isExistingDir(location) {
 				res.opts.Progress.AddProgress(location, 0, 0)
+				res.events.dirSkipped(location)
+				return res.dirComplete(node, target)
 			}
-			return err
+			if ok, err := checkTarget(target); !ok {
+				return err
+			}
+			relaxed.done(target)
+			if res.metadataUnchanged(node, target) {
+				res.opts.Progress.AddProgress(location, 0, 0)
+				res.events.dirSkipped(location)
+				return res.dirComplete(node, target)
+			}
+			err := res.restoreNodeMetadataTo(node, target, location)
+			if err != nil {
+				return err
+			}
+			syncs.addDir(target)
+			res.opts.Progress.AddProgress(location, 0, 0)
+			res.events.dirRestored(location)
+			return res.dirComplete(node, target)
 		},
 	})
+	if err == nil {
+		// files directly below the root
+		err = metadata.wait()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := res.applyFileFlags(); err != nil {
+		return err
+	}
+
+	if res.opts.WarnDanglingSymlinks {
+		if err := res.warnDanglingSymlinks(rewrittenLinks); err != nil {
+			return err
+		}
+	}
+
+	if err := manifest.write(); err != nil {
+		return err
+	}
+	if res.opts.StampProvenance && res.sn != nil {
+		if err := res.stampProvenance(dst); err != nil {
+			return err
+		}
+	}
+
+	if createdTarget {
+		debug.Log("set mode of %q to %v", dst, *res.opts.TargetMode)
+		if err := fs.Chmod(dst, *res.opts.TargetMode); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := syncs.sync(); err != nil {
+		return err
+	}
+	if quotaExceeded {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// finishCompletedFiles restores the metadata of all files whose content was
+// restored completely, after the restore stopped at its deadline.
+func (res *Restorer) finishCompletedFiles(filerestorer *fileRestorer, nodes map[string]*restic.Node) error {
+	completed := filerestorer.completed
+	sort.Strings(completed)
+	for _, location := range completed {
+		node, ok := nodes[location]
+		if !ok || filerestorer.hasFailed(location) {
+			continue
+		}
+		target := filerestorer.targetPath(location)
+		if res.opts.Sparse {
+			if err := res.restoreSparseHoles(node, target); err != nil {
+				if err := res.handleError(location, err); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := res.restoreNodeMetadataTo(node, target, location); err != nil {
+			if err := res.handleError(location, err); err != nil {
+				return err
+			}
+			continue
+		}
+		res.events.restored(location, node.Size)
+	}
+	return nil
+}
+
+type relaxedDir struct {
+	node             *restic.Node
+	target, location string
+}
+
+// relaxedDirs tracks the directories whose metadata is still pending, see
+// Options.RelaxDirModesDuringRestore. All methods are no-ops for a nil
+// relaxedDirs.
+type relaxedDirs struct {
+	dirs    []relaxedDir
+	pending map[string]struct{}
+}
+
+func newRelaxedDirs() *relaxedDirs {
+	return &relaxedDirs{pending: make(map[string]struct{})}
+}
+
+// add ensures that the directory at target is writable by its owner and
+// records it as pending.
+func (d *relaxedDirs) add(node *restic.Node, target, location string) error {
+	if d == nil {
+		return nil
+	}
+	fi, err := fs.Lstat(target)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if mode := fi.Mode().Perm(); mode&0700 != 0700 {
+		debug.Log("relaxing mode %v of %v", mode, target)
+		if err := fs.Chmod(target, mode|0700); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	d.dirs = append(d.dirs, relaxedDir{node: node, target: target, location: location})
+	d.pending[target] = struct{}{}
+	return nil
+}
+
+// done records that the metadata of the directory at target is applied.
+func (d *relaxedDirs) done(target string) {
+	if d == nil {
+		return
+	}
+	delete(d.pending, target)
+}
+
+// tightenDirs applies the metadata of all directories which are still
+// pending in d, children before their parents. It returns the first error.
+func (res *Restorer) tightenDirs(d *relaxedDirs) error {
+	if d == nil {
+		return nil
+	}
+	var firstErr error
+	for i := len(d.dirs) - 1; i >= 0; i-- {
+		dir := d.dirs[i]
+		if _, ok := d.pending[dir.target]; !ok {
+			continue
+		}
+		delete(d.pending, dir.target)
+		debug.Log("tightening %v", dir.target)
+		if err := res.restoreNodeMetadataTo(dir.node, dir.target, dir.location); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// untouchedItems tracks items which already existed before the restore and
+// therefore must not be modified with OverwriteNone.
+type untouchedItems struct {
+	dirs    map[string]struct{}
+	blocked map[string]struct{}
+}
+
+func newUntouchedItems() *untouchedItems {
+	return &untouchedItems{
+		dirs:    make(map[string]struct{}),
+		blocked: make(map[string]struct{}),
+	}
+}
+
+// add records an existing item at location. A directory is still descended
+// into, whereas an item of another type blocks everything below location.
+func (u *untouchedItems) add(location string, isDir bool) {
+	if isDir {
+		u.dirs[location] = struct{}{}
+	} else {
+		u.blocked[location] = struct{}{}
+	}
+}
+
+func (u *untouchedItems) isExistingDir(location string) bool {
+	_, ok := u.dirs[location]
+	return ok
+}
+
+// isBlocked returns whether location or one of its parents is an existing
+// item which is not a directory.
+func (u *untouchedItems) isBlocked(location string) bool {
+	if len(u.blocked) == 0 {
+		return false
+	}
+	for {
+		if _, ok := u.blocked[location]; ok {
+			return true
+		}
+		parent := filepath.Dir(location)
+		if parent == location {
+			return false
+		}
+		location = parent
+	}
+}
+
+// RestoreToMany restores the snapshot to each of the targets, like calling
+// RestoreTo for each of them, but downloads the file contents only once. The
+// blobs are downloaded while restoring to the first target. For further
+// targets, they are read back from the files restored there and only
+// downloaded again if such a file was modified in the meantime. SelectFilter,
+// Overwrite and the metadata are applied separately to each target. Errors
+// passed to Error and the returned error name the affected target.
+func (res *Restorer) RestoreToMany(ctx context.Context, targets []string) error {
+	if len(targets) == 0 {
+		return errors.New("no targets to restore to")
+	}
+
+	local := newLocalBlobs(res.repo.LookupBlobSize)
+	defer func() {
+		res.recordBlobs, res.reuseBlobs, res.currentTarget = nil, nil, ""
+	}()
+
+	for i, target := range targets {
+		if i == 0 {
+			res.recordBlobs = local
+		} else {
+			res.recordBlobs, res.reuseBlobs = nil, local
+		}
+		res.currentTarget = target
+		if err := res.RestoreTo(ctx, target); err != nil {
+			return withTarget(target, err)
+		}
+	}
+	return nil
+}
+
+// targetError is an error which occurred while restoring to target.
+type targetError struct {
+	target string
+	err    error
+}
+
+func (e *targetError) Error() string {
+	return fmt.Sprintf("target %v: %v", e.target, e.err)
+}
+
+func (e *targetError) Unwrap() error {
+	return e.err
+}
+
+// withTarget adds target to err, unless err already names a target.
+func withTarget(target string, err error) error {
+	var te *targetError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &targetError{target: target, err: err}
+}
+
+// restoreStreamSidecars writes the alternate data streams of node to sidecar
+// files, see Options.AlternateDataStreamSidecars.
+func (res *Restorer) restoreStreamSidecars(node *restic.Node, target, location string) error {
+	streams, ok, err := node.AlternateDataStreams()
+	if err != nil || !ok {
+		return err
+	}
+	if !res.opts.AlternateDataStreamSidecars {
+		res.metadataLock.Lock()
+		reported := res.streamsReported
+		res.streamsReported = true
+		res.metadataLock.Unlock()
+		if !reported {
+			res.warn(fmt.Sprintf("%v: alternate data streams are not restored on this platform", location))
+		}
+		return nil
+	}
+	for name, data := range streams {
+		if err := os.WriteFile(target+":"+name, data, 0600); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// restoreSparseHoles recreates the holes recorded for node in the file at
+// target. If there are none, the holes created by zero detection are kept.
+// The holes are recorded before the content is read while creating the
+// snapshot, thus holes which contain data in the restored file, for example
+// as it was modified during the backup, are skipped.
+func (res *Restorer) restoreSparseHoles(node *restic.Node, target string) error {
+	holes, ok, err := node.SparseHoles()
+	if err != nil || !ok {
+		return err
+	}
+	holes, err = zeroHoles(target, holes)
+	if err != nil {
+		return err
+	}
+	err = fs.SetSparseHoles(target, holes)
+	if errors.Is(err, fs.ErrSparseUnsupported) {
+		debug.Log("not restoring holes of %v: %v", target, err)
+		return nil
+	}
 	return err
 }
 
+// zeroHoles returns the holes which only contain zeros in the file at path.
+func zeroHoles(path string, holes []fs.SparseHole) ([]fs.SparseHole, error) {
+	f, err := fs.OpenFile(path, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var result []fs.SparseHole
+	buf := make([]byte, 64*1024)
+	for _, hole := range holes {
+		zero := true
+		for offset := hole.Offset; zero && offset < hole.Offset+hole.Length; {
+			n := hole.Offset + hole.Length - offset
+			if n > int64(len(buf)) {
+				n = int64(len(buf))
+			}
+			read, err := f.ReadAt(buf[:n], offset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, errors.WithStack(err)
+			}
+			zero = restic.ZeroPrefixLen(buf[:read]) == read
+			if read < int(n) {
+				// the rest of the hole is beyond the end of the file
+				break
+			}
+			offset += n
+		}
+		if !zero {
+			debug.Log("%v: not restoring hole %v, it contains data", path, hole)
+			continue
+		}
+		result = append(result, hole)
+	}
+	return result, nil
+}
+
+// createStub creates an empty file of the given size at target, replacing an
+// existing file.
+func createStub(target string, size uint64) error {
+	f, err := fs.OpenFile(target, fs.O_CREATE|fs.O_WRONLY|fs.O_TRUNC|fs.O_NOFOLLOW, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = truncateSparse(f, int64(size))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.WithStack(err)
+}
+
+// replaceFromTemp replaces target with the temporary file tmp. If
+// Options.ContentTransform returns a writer for node or Options.TextConvert
+// selects the file at location, the content of tmp is passed through it
+// instead and true is returned.
+func (res *Restorer) replaceFromTemp(node *restic.Node, location, tmp, target string) (transformed bool, err error) {
+	transform := res.opts.ContentTransform
+	if transform == nil {
+		transform, err = res.textTransform(location, tmp)
+		if err != nil {
+			return false, err
+		}
+	}
+	if transform == nil {
+		if err := res.postWrite(node, tmp); err != nil {
+			return false, err
+		}
+		return false, replaceFile(tmp, target)
+	}
+
+	out := &lazyFile{path: filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".restic-transform")}
+	wr, err := transform(node, out)
+	if err == nil && wr == nil {
+		if err := res.postWrite(node, tmp); err != nil {
+			return false, err
+		}
+		return false, replaceFile(tmp, target)
+	}
+	if err == nil {
+		err = copyFileTo(wr, tmp)
+		err = errors.CombineErrors(err, wr.Close())
+	}
+	err = errors.CombineErrors(err, out.Close())
+	if err == nil {
+		err = res.postWrite(node, out.path)
+	}
+	if err == nil {
+		err = replaceFile(out.path, target)
+	}
+	if err != nil {
+		_ = fs.Remove(out.path)
+	}
+	if rmErr := fs.Remove(tmp); rmErr != nil && err == nil {
+		err = errors.WithStack(rmErr)
+	}
+	return err == nil, err
+}
+
+// postWrite calls Options.PostWriteHook for the file at path, which contains
+// the restored content of node. The file is removed if the hook fails.
+func (res *Restorer) postWrite(node *restic.Node, path string) error {
+	if res.opts.PostWriteHook == nil {
+		return nil
+	}
+	err := res.opts.PostWriteHook(path, node)
+	if err == nil {
+		return nil
+	}
+	debug.Log("PostWriteHook rejected %v: %v", path, err)
+	if rmErr := fs.Remove(path); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+		return errors.CombineErrors(err, errors.WithStack(rmErr))
+	}
+	return err
+}
+
+func copyFileTo(wr io.Writer, path string) error {
+	f, err := fs.OpenFile(path, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(wr, f)
+	return errors.CombineErrors(errors.WithStack(err), f.Close())
+}
+
+// lazyFile is an io.Writer which only creates the file at path once data
+// is written to it or it is closed.
+type lazyFile struct {
+	path string
+	f    *os.File
+}
+
+func (l *lazyFile) open() error {
+	if l.f != nil {
+		return nil
+	}
+	f, err := fs.OpenFile(l.path, fs.O_CREATE|fs.O_WRONLY|fs.O_TRUNC|fs.O_NOFOLLOW, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	l.f = f
+	return nil
+}
+
+func (l *lazyFile) Write(p []byte) (int, error) {
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	return l.f.Write(p)
+}
+
+func (l *lazyFile) Close() error {
+	if err := l.open(); err != nil {
+		return err
+	}
+	return l.f.Close()
+}
+
 func (res *Restorer) trackFile(location string, metadataOnly bool) {
 	res.fileList[location] = metadataOnly
 }
@@ -392,8 +2290,17 @@ func (res *Restorer) hasRestoredFile(location string) (metadataOnly bool, ok boo
 	return metadataOnly, ok
 }
 
-func (res *Restorer) withOverwriteCheck(node *restic.Node, target string, isHardlink bool, buf []byte, cb func(updateMetadataOnly bool, matches *fileState) error) ([]byte, error) {
-	overwrite, err := shouldOverwrite(res.opts.Overwrite, node, target)
+func (res *Restorer) withOverwriteCheck(node *restic.Node, target, location string, isHardlink bool, buf []byte, cb func(updateMetadataOnly bool, matches *fileState) error) ([]byte, error) {
+	behavior := res.opts.Overwrite
+	var overwrite bool
+	var err error
+	if res.opts.OverwriteFunc != nil {
+		// the content of overwritten files is compared as for OverwriteAlways
+		behavior = OverwriteAlways
+		overwrite, err = res.shouldOverwriteFunc(node, target)
+	} else {
+		overwrite, err = shouldOverwrite(behavior, node, target)
+	}
 	if err != nil {
 		return buf, err
 	} else if !overwrite {
@@ -402,14 +2309,30 @@ func (res *Restorer) withOverwriteCheck(node *restic.Node, target string, isHard
 			size = 0
 		}
 		res.opts.Progress.AddSkippedFile(size)
+		res.events.skipped(location, size)
 		return buf, nil
 	}
 
+	if err := res.clearFileFlags(target); err != nil {
+		return buf, err
+	}
+
+	// an item of a different type never matches node, thus this cannot
+	// remove content which would otherwise be kept
+	if err := res.removeWrongType(node, target, location); err != nil {
+		return buf, err
+	}
+
 	var matches *fileState
 	updateMetadataOnly := false
 	if node.Type == "file" && !isHardlink {
-		// if a file fails to verify, then matches is nil which results in restoring from scratch
-		matches, buf, _ = res.verifyFile(target, node, false, res.opts.Overwrite == OverwriteIfChanged, buf)
+		if behavior == OverwriteIfContentChanged && !sizeMatches(node, target) {
+			// a file with a different size must be rewritten anyway, skip reading its content
+			matches = nil
+		} else {
+			// if a file fails to verify, then matches is nil which results in restoring from scratch
+			matches, buf, _ = res.verifyFile(target, node, false, behavior == OverwriteIfChanged, buf)
+		}
 		// skip files that are already correct completely
 		updateMetadataOnly = !matches.NeedsRestore()
 	}
@@ -417,8 +2340,50 @@ func (res *Restorer) withOverwriteCheck(node *restic.Node, target string, isHard
 	return buf, cb(updateMetadataOnly, matches)
 }
 
+// keepsUnchangedItems returns whether existing items which already match the
+// snapshot are left untouched, including their metadata. This makes
+// restoring the same snapshot again a no-op.
+func (res *Restorer) keepsUnchangedItems() bool {
+	return res.opts.OverwriteFunc == nil &&
+		(res.opts.Overwrite == OverwriteIfChanged || res.opts.Overwrite == OverwriteIfContentChanged)
+}
+
+// metadataUnchanged returns whether the existing item at target already has
+// the metadata of node, such that restoring it would not modify anything.
+// It is always false unless keepsUnchangedItems is true.
+func (res *Restorer) metadataUnchanged(node *restic.Node, target string) bool {
+	if !res.keepsUnchangedItems() {
+		return false
+	}
+	mismatches, err := res.verifyNodeMetadata(node, target)
+	if err != nil || len(mismatches) > 0 {
+		return false
+	}
+	fi, err := fs.Lstat(target)
+	if err != nil {
+		return false
+	}
+	existing, err := restic.NodeFromFileInfo(target, fi, false)
+	if err != nil {
+		return false
+	}
+	if (node.Type == "dev" || node.Type == "chardev") && node.Device != existing.Device {
+		return false
+	}
+	return node.SameAttributes(*existing)
+}
+
+// sizeMatches returns whether destination is a regular file with the size of node.
+func sizeMatches(node *restic.Node, destination string) bool {
+	fi, err := fs.Lstat(destination)
+	if err != nil {
+		return false
+	}
+	return fi.Mode().IsRegular() && int64(node.Size) == fi.Size()
+}
+
 func shouldOverwrite(overwrite OverwriteBehavior, node *restic.Node, destination string) (bool, error) {
-	if overwrite == OverwriteAlways || overwrite == OverwriteIfChanged {
+	if overwrite == OverwriteAlways || overwrite == OverwriteIfChanged || overwrite == OverwriteIfContentChanged {
 		return true, nil
 	}
 
@@ -433,34 +2398,354 @@ func shouldOverwrite(overwrite OverwriteBehavior, node *restic.Node, destination
 	if overwrite == OverwriteIfNewer {
 		// return if node is newer
 		return node.ModTime.After(fi.ModTime()), nil
-	} else if overwrite == OverwriteNever {
+	} else if overwrite == OverwriteNever || overwrite == OverwriteNone {
 		// file exists
 		return false, nil
 	}
 	panic("unknown overwrite behavior")
 }
 
-// Snapshot returns the snapshot this restorer is configured to use.
+// shouldOverwriteFunc returns whether destination should be overwritten
+// according to Options.OverwriteFunc.
+func (res *Restorer) shouldOverwriteFunc(node *restic.Node, destination string) (bool, error) {
+	fi, err := fs.Lstat(destination)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	return res.opts.OverwriteFunc(node, fi), nil
+}
+
+// RestoreToOriginalLocations restores the snapshot to the absolute paths
+// recorded in the snapshot, for example /etc is restored to /etc. Only items
+// within these paths are restored and SelectFilter is applied as usual.
+// As this overwrites data outside of a dedicated target directory, it refuses
+// to run unless Options.ConfirmOriginalLocations is set.
+func (res *Restorer) RestoreToOriginalLocations(ctx context.Context) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("restoring to the original locations is not supported on Windows")
+	}
+	return res.restoreToOriginalLocations(ctx, string(filepath.Separator))
+}
+
+func (res *Restorer) restoreToOriginalLocations(ctx context.Context, root string) error {
+	if !res.opts.ConfirmOriginalLocations {
+		return errors.New("restoring to the original locations requires Options.ConfirmOriginalLocations")
+	}
+	if res.opts.IncludeTopDir {
+		return errors.New("Options.IncludeTopDir cannot be used to restore to the original locations")
+	}
+	if res.sn == nil {
+		return errors.New("restoring to the original locations requires a snapshot")
+	}
+
+	paths := make([]string, 0, len(res.sn.Paths))
+	for _, p := range res.sn.Paths {
+		if !filepath.IsAbs(p) {
+			return errors.Errorf("snapshot path %q is not absolute", p)
+		}
+		paths = append(paths, filepath.Clean(p))
+	}
+
+	selectFilter, refuseMountpoints := res.SelectFilter, res.opts.RefuseMountpoints
+	defer func() {
+		res.SelectFilter, res.opts.RefuseMountpoints = selectFilter, refuseMountpoints
+	}()
+	res.opts.RefuseMountpoints = true
+	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+		for _, p := range paths {
+			if fs.HasPathPrefix(p, item) {
+				return selectFilter(item, dstpath, node)
+			}
+		}
+		for _, p := range paths {
+			if fs.HasPathPrefix(item, p) {
+				// parent directory of a snapshot path
+				_, childMayBeSelected = selectFilter(item, dstpath, node)
+				return false, childMayBeSelected
+			}
+		}
+		return false, false
+	}
+
+	return res.RestoreTo(ctx, root)
+}
+
+// DamagedRanges returns the byte ranges which were filled with zeros during the
+// last call to RestoreTo, indexed by the location of the affected file within
+// the snapshot. Ranges are only recorded if Options.ZeroFillMissing is set.
+func (res *Restorer) DamagedRanges() map[string][]DamagedRange {
+	return res.damaged
+}
+
+// EmptyFiles returns the number of empty files which were created during the
+// last call to RestoreTo. These are created directly, without loading any
+// blobs, and only their metadata is restored afterwards.
+func (res *Restorer) EmptyFiles() int {
+	return res.emptyFiles
+}
+
+// RestoreStats counts the items processed by the last call to RestoreTo.
+// Directories are counted in DirsRestored; symlinks and special files count
+// as files.
+type RestoreStats struct {
+	// FilesRestored is the number of files, symlinks and other items except
+	// directories which were created or whose content was written.
+	FilesRestored uint64
+	// FilesUpdated is the number of existing items except directories of
+	// which only the metadata was restored.
+	FilesUpdated uint64
+	// FilesSkipped is the number of items except directories which were left
+	// untouched, either as they already matched the snapshot or due to
+	// Options.Overwrite.
+	FilesSkipped uint64
+	// DirsRestored is the number of directories which were created or whose
+	// metadata was restored.
+	DirsRestored uint64
+	// DirsSkipped is the number of directories which were left untouched.
+	DirsSkipped uint64
+	// Errors is the number of errors passed to Restorer.Error.
+	Errors uint64
+	// BytesRestored is the total size of the files counted in FilesRestored.
+	BytesRestored uint64
+}
+
+// Changed returns whether the restore modified the target. Restoring a
+// snapshot again with OverwriteIfChanged or OverwriteIfContentChanged does
+// not change anything, unless the target was modified in the meantime.
+func (s RestoreStats) Changed() bool {
+	return s.FilesRestored > 0 || s.FilesUpdated > 0 || s.DirsRestored > 0
+}
+
+// Stats returns the statistics of the last call to RestoreTo. They match the
+// summary written to Options.EventWriter.
+func (res *Restorer) Stats() RestoreStats {
+	return res.events.summaryStats()
+}
+
+// UnrestorableFile is a file which cannot be restored completely as some of
+// its content blobs are missing from the index.
+type UnrestorableFile struct {
+	Path    string     // location of the file within the snapshot
+	Missing restic.IDs // missing blobs, in the order of the file's content
+}
+
+// ListUnrestorable returns all files selected by SelectFilter which cannot
+// be restored completely as some of their content blobs are missing from
+// the repository index. Nothing is written. With Options.ZeroFillMissing,
+// these are the files which RestoreTo would report as damaged.
+func (res *Restorer) ListUnrestorable(ctx context.Context) ([]UnrestorableFile, error) {
+	var files []UnrestorableFile
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		visitNode: func(node *restic.Node, _, location string) error {
+			if node.Type != "file" {
+				return nil
+			}
+			var missing restic.IDs
+			seen := restic.NewIDSet()
+			for _, id := range node.Content {
+				if seen.Has(id) {
+					continue
+				}
+				seen.Insert(id)
+				if _, found := res.repo.LookupBlobSize(restic.DataBlob, id); !found {
+					missing = append(missing, id)
+				}
+			}
+			if len(missing) > 0 {
+				files = append(files, UnrestorableFile{Path: location, Missing: missing})
+			}
+			return nil
+		},
+	})
+	return files, err
+}
+
+// SelectionCount summarizes the nodes selected for restore.
+type SelectionCount struct {
+	Files uint64 // all nodes except directories
+	Dirs  uint64
+	Bytes uint64 // size of the regular files, hardlinked files count once
+}
+
+// CountSelected returns the number of files, directories and bytes selected
+// by SelectFilter, such that a progress bar can be sized before calling
+// RestoreTo. Only the trees are loaded, the file content is not read.
+func (res *Restorer) CountSelected(ctx context.Context) (SelectionCount, error) {
+	var count SelectionCount
+	idx := NewHardlinkIndex[struct{}]()
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		enterDir: func(_ *restic.Node, _, _ string) error {
+			count.Dirs++
+			return ctx.Err()
+		},
+		visitNode: func(node *restic.Node, _, _ string) error {
+			count.Files++
+			if node.Type != "file" {
+				return nil
+			}
+			if node.Links > 1 {
+				if idx.Has(node.Inode, node.DeviceID) {
+					return nil
+				}
+				idx.Add(node.Inode, node.DeviceID, struct{}{})
+			}
+			count.Bytes += node.Size
+			return nil
+		},
+	})
+	return count, err
+}
+
+// RequiredPack is a pack file which is read when restoring.
+type RequiredPack struct {
+	ID   restic.ID
+	Size int64 // size of the pack file according to the index
+}
+
+// RequiredPacks returns the pack files, sorted by ID, which contain the
+// content of the files selected by SelectFilter, such that they can be
+// fetched from cold storage before calling RestoreTo. Only the trees and the
+// index are read. The trees themselves are already loaded by this call. As
+// RestoreTo may reuse existing files, not all packs are necessarily read.
+func (res *Restorer) RequiredPacks(ctx context.Context) ([]RequiredPack, error) {
+	packIDs := restic.NewIDSet()
+	root := string(filepath.Separator)
+	_, err := res.traverseTree(ctx, root, root, res.tree, treeVisitor{
+		visitNode: func(node *restic.Node, _, _ string) error {
+			if node.Type != "file" || (res.opts.StubFilter != nil && res.opts.StubFilter(node)) {
+				return nil
+			}
+			for _, id := range node.Content {
+				// RestoreTo also uses the first pack containing a blob
+				if packs := res.repo.LookupBlob(restic.DataBlob, id); len(packs) > 0 {
+					packIDs.Insert(packs[0].PackID)
+				}
+			}
+			return ctx.Err()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packs := make([]RequiredPack, 0, len(packIDs))
+	for pb := range res.repo.ListPacksFromIndex(ctx, packIDs) {
+		size := int64(pack.CalculateHeaderSize(pb.Blobs))
+		for _, blob := range pb.Blobs {
+			size += int64(blob.Length)
+		}
+		packs = append(packs, RequiredPack{ID: pb.PackID, Size: size})
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		return string(packs[i].ID[:]) < string(packs[j].ID[:])
+	})
+	return packs, nil
+}
+
+// CheckCompatibility returns an error if the repository format is not
+// supported by this version of restic, for example as the repository was
+// created by a newer version. RestoreTo calls it before reading any tree.
+func (res *Restorer) CheckCompatibility() error {
+	return res.repo.Config().CheckVersion()
+}
+
+// Snapshot returns the snapshot this restorer is configured to use, or nil
+// if it was created by NewTreeRestorer.
 func (res *Restorer) Snapshot() *restic.Snapshot {
 	return res.sn
 }
 
-// Number of workers in VerifyFiles.
+// Default number of workers in VerifyFiles.
 const nVerifyWorkers = 8
 
+// VerifyResult summarizes the outcome of VerifyFilesWithResult.
+type VerifyResult struct {
+	// Verified lists the target paths of all files whose content matched the snapshot.
+	Verified []string
+	// Failed lists the files which failed to verify along with the reason.
+	Failed []VerifyError
+	// BytesChecked is the total size of all successfully verified files.
+	BytesChecked uint64
+	// Skipped lists the files which were not verified as their content was
+	// modified by Options.ContentTransform or TextConvert or as they were restored as stubs
+	// by Options.StubFilter. The size of stubs is still checked.
+	Skipped []string
+
+	m sync.Mutex
+}
+
+// VerifyError describes why a file failed to verify.
+type VerifyError struct {
+	Path string
+	Err  error
+}
+
+func (r *VerifyResult) addVerified(path string, size uint64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.Verified = append(r.Verified, path)
+	r.BytesChecked += size
+}
+
+func (r *VerifyResult) addSkipped(path string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.Skipped = append(r.Skipped, path)
+}
+
+func (r *VerifyResult) addFailed(path string, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.Failed = append(r.Failed, VerifyError{Path: path, Err: err})
+}
+
 // VerifyFiles checks whether all regular files in the snapshot res.sn
-// have been successfully written to dst. It stops when it encounters an
+// have been successfully written to dst. Like RestoreTo, it only visits the
+// nodes selected by SelectFilter, files outside of the selection are
+// ignored even if they exist in dst. It stops when it encounters an
 // error. It returns that error and the number of files it has successfully
 // verified.
 func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
+	result, err := res.VerifyFilesWithResult(ctx, dst)
+	return len(result.Verified), err
+}
+
+// VerifyFilesWithResult is like VerifyFiles, but returns the list of verified
+// and failed files along with the number of checked bytes. Files are verified
+// concurrently using Options.VerifyWorkers goroutines. Verification stops as
+// soon as res.Error returns an error for a failed file.
+func (res *Restorer) VerifyFilesWithResult(ctx context.Context, dst string) (*VerifyResult, error) {
+	dst, err := res.includeTopDir(ctx, dst)
+	if err != nil {
+		return &VerifyResult{}, err
+	}
+
 	type mustCheck struct {
 		node *restic.Node
 		path string
 	}
 
+	workerCount := res.opts.VerifyWorkers
+	if workerCount <= 0 {
+		workerCount = nVerifyWorkers
+	}
+
+	checkpoint, err := openVerifyCheckpoint(res.opts.VerifyCheckpoint)
+	if err != nil {
+		return &VerifyResult{}, err
+	}
+
 	var (
-		nchecked uint64
-		work     = make(chan mustCheck, 2*nVerifyWorkers)
+		result = &VerifyResult{}
+		work   = make(chan mustCheck, 2*workerCount)
 	)
 
 	g, ctx := errgroup.WithContext(ctx)
@@ -469,14 +2754,31 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 	g.Go(func() error {
 		defer close(work)
 
-		_, err := res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		_, err := res.traverseTree(ctx, dst, string(filepath.Separator), res.root, treeVisitor{
 			visitNode: func(node *restic.Node, target, location string) error {
 				if node.Type != "file" {
 					return nil
 				}
+				target, location, ok := res.collisions.resolve(target, location)
+				if !ok {
+					return nil
+				}
+				if _, ok := res.stubs[location]; ok {
+					if err := verifyStub(target, node); err != nil {
+						result.addFailed(target, err)
+						return res.Error(target, err)
+					}
+					result.addSkipped(target)
+					return nil
+				}
 				if metadataOnly, ok := res.hasRestoredFile(location); !ok || metadataOnly {
 					return nil
 				}
+				if _, ok := res.transformed[location]; ok {
+					// the content intentionally differs from the snapshot
+					result.addSkipped(target)
+					return nil
+				}
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
@@ -488,24 +2790,50 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 		return err
 	})
 
-	for i := 0; i < nVerifyWorkers; i++ {
+	for i := 0; i < workerCount; i++ {
 		g.Go(func() (err error) {
 			var buf []byte
 			for job := range work {
+				if checkpoint.isVerified(job.path, job.node) {
+					result.addVerified(job.path, job.node.Size)
+					continue
+				}
 				_, buf, err = res.verifyFile(job.path, job.node, true, false, buf)
 				if err != nil {
+					result.addFailed(job.path, err)
 					err = res.Error(job.path, err)
+				} else {
+					result.addVerified(job.path, job.node.Size)
+					err = checkpoint.add(job.path, job.node)
 				}
 				if err != nil || ctx.Err() != nil {
 					break
 				}
-				atomic.AddUint64(&nchecked, 1)
 			}
 			return err
 		})
 	}
 
-	return int(nchecked), g.Wait()
+	err = g.Wait()
+	if cerr := checkpoint.close(); err == nil {
+		err = cerr
+	}
+	return result, err
+}
+
+// verifyStub checks that the stub at target has the size of node.
+func verifyStub(target string, node *restic.Node) error {
+	fi, err := fs.Lstat(target)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("Not a regular file: %s", target)
+	}
+	if uint64(fi.Size()) != node.Size {
+		return errors.Errorf("Invalid stub size for %s: expected %d, got %d", target, node.Size, fi.Size())
+	}
+	return nil
 }
 
 type fileState struct {
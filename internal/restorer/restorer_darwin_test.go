@@ -0,0 +1,53 @@
+package restorer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerDarwinFileFlags(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	// the flags must be cleared again, otherwise the target cannot be removed
+	t.Cleanup(func() {
+		_ = filepath.Walk(tempdir, func(path string, _ os.FileInfo, _ error) error {
+			_ = fs.SetFileFlags(path, 0)
+			return nil
+		})
+	})
+
+	flags := fs.FileFlagHidden | fs.FileFlagImmutable
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+		},
+	}, func(_ *FileAttributes, _ bool) map[restic.GenericAttributeType]json.RawMessage {
+		node := restic.Node{}
+		rtest.OK(t, node.SetFileFlags(flags))
+		return node.GenericAttributes
+	})
+
+	res := NewRestorer(repo, sn, Options{RestoreFileFlags: true})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	// the immutable flag is applied after the content was written
+	data, err := os.ReadFile(filepath.Join(tempdir, "dir", "file"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: file\n", string(data))
+
+	for _, item := range []string{"dir", "dir/file"} {
+		restored, err := fs.GetFileFlags(filepath.Join(tempdir, filepath.FromSlash(item)))
+		rtest.OK(t, err)
+		rtest.Equals(t, flags, restored)
+	}
+}
@@ -0,0 +1,376 @@
+package restorer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/pkg/xattr"
+)
+
+func TestRestorerFileFlags(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	probe := filepath.Join(tempdir, "probe")
+	rtest.OK(t, os.WriteFile(probe, nil, 0600))
+	if err := fs.SetFileFlags(probe, fs.FileFlagImmutable); err != nil {
+		t.Skipf("setting file flags is not possible: %v", err)
+	}
+	rtest.OK(t, fs.SetFileFlags(probe, 0))
+	rtest.OK(t, os.Remove(probe))
+
+	// the flags must be cleared again, otherwise the target cannot be removed
+	t.Cleanup(func() {
+		_ = filepath.Walk(tempdir, func(path string, _ os.FileInfo, _ error) error {
+			_ = fs.SetFileFlags(path, 0)
+			return nil
+		})
+	})
+
+	flagsFor := func(flags fs.FileFlags) func(*FileAttributes, bool) map[restic.GenericAttributeType]json.RawMessage {
+		return func(_ *FileAttributes, _ bool) map[restic.GenericAttributeType]json.RawMessage {
+			node := restic.Node{}
+			rtest.OK(t, node.SetFileFlags(flags))
+			return node.GenericAttributes
+		}
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file":     File{Data: "content: file\n", Inode: 42, Links: 2},
+				"hardlink": File{Data: "content: file\n", Inode: 42, Links: 2},
+			}},
+		},
+	}, flagsFor(fs.FileFlagImmutable))
+
+	res := NewRestorer(repo, sn, Options{RestoreFileFlags: true})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	for _, item := range []string{"dir", "dir/file", "dir/hardlink"} {
+		flags, err := fs.GetFileFlags(filepath.Join(tempdir, filepath.FromSlash(item)))
+		rtest.OK(t, err)
+		rtest.Equals(t, fs.FileFlagImmutable, flags)
+	}
+
+	// overwriting clears the flags of the existing items first
+	sn, _ = saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: changed\n"},
+			}},
+		},
+	}, flagsFor(fs.FileFlagAppendOnly))
+
+	res = NewRestorer(repo, sn, Options{RestoreFileFlags: true, Overwrite: OverwriteAlways})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	data, err := os.ReadFile(filepath.Join(tempdir, "dir", "file"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: changed\n", string(data))
+	for _, item := range []string{"dir", "dir/file"} {
+		flags, err := fs.GetFileFlags(filepath.Join(tempdir, filepath.FromSlash(item)))
+		rtest.OK(t, err)
+		rtest.Equals(t, fs.FileFlagAppendOnly, flags)
+	}
+}
+
+func TestRestorerStubFilter(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"movie.mkv": File{Data: strings.Repeat("x", 100000)},
+			"notes.txt": File{Data: "content: notes\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	var manifest strings.Builder
+	res := NewRestorer(repo, sn, Options{
+		ManifestWriter: &manifest,
+		StubFilter: func(node *restic.Node) bool {
+			return strings.HasSuffix(node.Name, ".mkv")
+		},
+	})
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	stub := filepath.Join(tempdir, "movie.mkv")
+	data, err := os.ReadFile(stub)
+	rtest.OK(t, err)
+	rtest.Equals(t, 100000, len(data), "stub size")
+	rtest.Equals(t, strings.Repeat("\x00", 100000), string(data), "stub content")
+	rtest.Assert(t, !strings.Contains(manifest.String(), "movie.mkv"), "stub listed in manifest %q", manifest.String())
+
+	data, err = os.ReadFile(filepath.Join(tempdir, "notes.txt"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: notes\n", string(data))
+
+	if size, err := xattr.LGet(stub, restic.StubExtendedAttribute); err != nil {
+		t.Logf("extended attributes are not supported: %v", err)
+	} else {
+		rtest.Equals(t, "100000", string(size), "stub marker")
+	}
+
+	result, err := res.VerifyFilesWithResult(context.TODO(), tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{filepath.Join(tempdir, "notes.txt")}, result.Verified)
+	rtest.Equals(t, []string{stub}, result.Skipped)
+
+	// a stub with the wrong size fails to verify
+	rtest.OK(t, os.Truncate(stub, 10))
+	res.Error = func(_ string, err error) error { return nil }
+	result, err = res.VerifyFilesWithResult(context.TODO(), tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(result.Failed), "failed files")
+	rtest.Equals(t, stub, result.Failed[0].Path)
+}
+
+func TestRestorerSparseHoles(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	probe := filepath.Join(tempdir, "probe")
+	rtest.OK(t, os.WriteFile(probe, nil, 0600))
+	rtest.OK(t, os.Truncate(probe, 16384))
+	if holes, err := fs.SparseHoles(probe); err != nil || len(holes) == 0 {
+		t.Skipf("the file system does not report holes: %v", err)
+	}
+	rtest.OK(t, os.Remove(probe))
+
+	// the hole only covers the second half of the zeros, the first half was
+	// allocated in the original file
+	holes := []fs.SparseHole{{Offset: 16384, Length: 16384}}
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"image": File{Data: strings.Repeat("x", 4096) + strings.Repeat("\x00", 28672)},
+		},
+	}, func(_ *FileAttributes, isDir bool) map[restic.GenericAttributeType]json.RawMessage {
+		if isDir {
+			return nil
+		}
+		node := restic.Node{}
+		rtest.OK(t, node.SetSparseHoles(holes))
+		return node.GenericAttributes
+	})
+
+	res := NewRestorer(repo, sn, Options{Sparse: true})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	target := filepath.Join(tempdir, "image")
+	data, err := os.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, strings.Repeat("x", 4096)+strings.Repeat("\x00", 28672), string(data))
+
+	restored, err := fs.SparseHoles(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, holes, restored)
+}
+
+func TestRestorerSparseHolesWithData(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	probe := filepath.Join(tempdir, "probe")
+	rtest.OK(t, os.WriteFile(probe, nil, 0600))
+	rtest.OK(t, os.Truncate(probe, 16384))
+	if holes, err := fs.SparseHoles(probe); err != nil || len(holes) == 0 {
+		t.Skipf("the file system does not report holes: %v", err)
+	}
+	rtest.OK(t, os.Remove(probe))
+
+	// the first hole was filled with data after the holes were recorded
+	content := strings.Repeat("x", 4096) + strings.Repeat("\x00", 28672)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"image": File{Data: content},
+		},
+	}, func(_ *FileAttributes, isDir bool) map[restic.GenericAttributeType]json.RawMessage {
+		if isDir {
+			return nil
+		}
+		node := restic.Node{}
+		rtest.OK(t, node.SetSparseHoles([]fs.SparseHole{{Offset: 0, Length: 4096}, {Offset: 16384, Length: 16384}}))
+		return node.GenericAttributes
+	})
+
+	res := NewRestorer(repo, sn, Options{Sparse: true})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	target := filepath.Join(tempdir, "image")
+	data, err := os.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, content, string(data))
+
+	restored, err := fs.SparseHoles(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, []fs.SparseHole{{Offset: 16384, Length: 16384}}, restored)
+}
+
+func TestRestorerCapability(t *testing.T) {
+	// cap_net_raw+ep in the VFS_CAP_REVISION_2 format
+	capability := []byte{
+		0x01, 0x00, 0x00, 0x02, // revision 2, effective
+		0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // permitted, inheritable
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"ping": File{Data: "content: ping\n", Mode: 0755},
+		},
+	}, func(_ *FileAttributes, isDir bool) map[restic.GenericAttributeType]json.RawMessage {
+		if isDir {
+			return nil
+		}
+		node := restic.Node{}
+		rtest.OK(t, node.SetCapability(capability))
+		return node.GenericAttributes
+	})
+
+	var warnings []string
+	res := NewRestorer(repo, sn, Options{})
+	res.Warn = func(msg string) {
+		warnings = append(warnings, msg)
+	}
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	target := filepath.Join(tempdir, "ping")
+	data, err := os.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: ping\n", string(data))
+
+	if os.Geteuid() != 0 {
+		rtest.Equals(t, 1, len(warnings), "warnings")
+		return
+	}
+	restored, err := xattr.LGet(target, "security.capability")
+	if err != nil {
+		t.Skipf("file capabilities are not supported: %v", err)
+	}
+	rtest.Equals(t, capability, restored)
+}
+
+func TestRestorerSymlinkMetadata(t *testing.T) {
+	fileTime := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	linkTime := time.Date(2021, time.June, 2, 8, 30, 0, 0, time.UTC)
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	if os.Getuid() == 0 {
+		// only root can hand the symlink to another user
+		uid, gid = 1234, 5678
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file": File{Data: "content: file\n", ModTime: fileTime},
+			"link": Symlink{Target: "file", ModTime: linkTime, UID: &uid, GID: &gid},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	res := NewRestorer(repo, sn, Options{})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	fi, err := os.Lstat(filepath.Join(tempdir, "link"))
+	rtest.OK(t, err)
+	stat := fi.Sys().(*syscall.Stat_t)
+	rtest.Equals(t, uid, stat.Uid)
+	rtest.Equals(t, gid, stat.Gid)
+	rtest.Assert(t, fi.ModTime().Equal(linkTime), "wrong symlink mtime %v", fi.ModTime())
+
+	// the target keeps its own metadata
+	fi, err = os.Stat(filepath.Join(tempdir, "file"))
+	rtest.OK(t, err)
+	stat = fi.Sys().(*syscall.Stat_t)
+	rtest.Equals(t, uint32(os.Getuid()), stat.Uid)
+	rtest.Equals(t, uint32(os.Getgid()), stat.Gid)
+	rtest.Assert(t, fi.ModTime().Equal(fileTime), "wrong file mtime %v", fi.ModTime())
+}
+
+func TestRestorerStampProvenance(t *testing.T) {
+	repo := repository.TestRepository(t)
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+	// only loaded snapshots have an ID
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	sn.Hostname = "host"
+	sn.Tags = []string{"a", "b"}
+
+	res := NewRestorer(repo, sn, Options{StampProvenance: true})
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	// the file is only written if extended attributes are not supported
+	data, err := os.ReadFile(filepath.Join(tempdir, ProvenanceFile))
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = xattr.Get(tempdir, ProvenanceXattr)
+	}
+	rtest.OK(t, err)
+	var p Provenance
+	rtest.OK(t, json.Unmarshal(data, &p))
+	rtest.Equals(t, id.String(), p.ID)
+	rtest.Assert(t, p.Time.Equal(sn.Time), "unexpected time %v", p.Time)
+	rtest.Equals(t, "host", p.Hostname)
+	rtest.Equals(t, []string{"a", "b"}, p.Tags)
+}
+
+func TestRestorerSkipLocked(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skipf("sleep not found: %v", err)
+	}
+	data, err := os.ReadFile(sleep)
+	rtest.OK(t, err)
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"busy":  File{Data: "content: busy\n"},
+			"other": File{Data: "content: other\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	// a running executable cannot be opened for writing
+	tempdir := rtest.TempDir(t)
+	busy := filepath.Join(tempdir, "busy")
+	rtest.OK(t, os.WriteFile(busy, data, 0700))
+	cmd := exec.Command(busy, "60")
+	rtest.OK(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	var locked []string
+	res := NewRestorer(repo, sn, Options{SkipLocked: true, OnLocked: func(location string, err error) {
+		rtest.Assert(t, fs.IsLocked(err), "unexpected error %v", err)
+		locked = append(locked, location)
+	}})
+	res.Error = func(location string, err error) error {
+		t.Errorf("unexpected error for %v: %v", location, err)
+		return nil
+	}
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	rtest.Equals(t, []string{"/busy"}, locked)
+
+	restored, err := os.ReadFile(busy)
+	rtest.OK(t, err)
+	rtest.Assert(t, string(restored) == string(data), "locked file was modified")
+	restored, err = os.ReadFile(filepath.Join(tempdir, "other"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: other\n", string(restored))
+}
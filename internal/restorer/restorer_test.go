@@ -4,17 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -40,6 +45,8 @@ type File struct {
 type Symlink struct {
 	Target  string
 	ModTime time.Time
+	// UID and GID default to the current user
+	UID, GID *uint32
 }
 
 type Dir struct {
@@ -49,6 +56,13 @@ type Dir struct {
 	attributes *FileAttributes
 }
 
+type Special struct {
+	Type    string
+	Mode    os.FileMode
+	Device  uint64
+	ModTime time.Time
+}
+
 type FileAttributes struct {
 	ReadOnly  bool
 	Hidden    bool
@@ -110,13 +124,20 @@ func saveDir(t testing.TB, repo restic.BlobSaver, nodes map[string]Node, inode u
 			rtest.OK(t, err)
 		case Symlink:
 			symlink := n.(Symlink)
+			uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+			if symlink.UID != nil {
+				uid = *symlink.UID
+			}
+			if symlink.GID != nil {
+				gid = *symlink.GID
+			}
 			err := tree.Insert(&restic.Node{
 				Type:       "symlink",
 				Mode:       os.ModeSymlink | 0o777,
 				ModTime:    symlink.ModTime,
 				Name:       name,
-				UID:        uint32(os.Getuid()),
-				GID:        uint32(os.Getgid()),
+				UID:        uid,
+				GID:        gid,
 				LinkTarget: symlink.Target,
 				Inode:      inode,
 				Links:      1,
@@ -141,6 +162,19 @@ func saveDir(t testing.TB, repo restic.BlobSaver, nodes map[string]Node, inode u
 				GenericAttributes: getGenericAttributes(node.attributes, false),
 			})
 			rtest.OK(t, err)
+		case Special:
+			err := tree.Insert(&restic.Node{
+				Type:    node.Type,
+				Mode:    node.Mode,
+				ModTime: node.ModTime,
+				Name:    name,
+				UID:     uint32(os.Getuid()),
+				GID:     uint32(os.Getgid()),
+				Device:  node.Device,
+				Inode:   inode,
+				Links:   1,
+			})
+			rtest.OK(t, err)
 		default:
 			t.Fatalf("unknown node type %T", node)
 		}
@@ -534,6 +568,7 @@ func checkVisitOrder(list []TreeVisit) TraverseTreeCheck {
 	var pos int
 
 	return func(t testing.TB) treeVisitor {
+		pos = 0
 		check := func(funcName string) func(*restic.Node, string, string) error {
 			return func(node *restic.Node, target, location string) error {
 				if pos >= len(list) {
@@ -724,10 +759,67 @@ func TestRestorerTraverseTree(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+
+			// the public WalkTree must visit the same nodes
+			visitor := test.Visitor(t)
+			err = WalkTree(ctx, repo, target, *sn.Tree, TreeVisitor{
+				SelectFilter: test.Select,
+				EnterDir:     visitor.enterDir,
+				VisitNode:    visitor.visitNode,
+				LeaveDir:     visitor.leaveDir,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
 		})
 	}
 }
 
+func TestListNodes(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+				"subdir": Dir{Nodes: map[string]Node{
+					"deep": File{Data: "content: deep\n"},
+				}},
+			}},
+			"foo":  File{Data: "content: foo\n"},
+			"link": Symlink{Target: "foo"},
+		},
+	}, noopGetGenericAttributes)
+
+	for _, test := range []struct {
+		opts     ListOptions
+		expected []string
+	}{
+		{ListOptions{}, []string{"/dir", "/foo", "/link"}},
+		{ListOptions{Recursive: true}, []string{"/dir", "/dir/file", "/dir/subdir", "/dir/subdir/deep", "/foo", "/link"}},
+		{ListOptions{Recursive: true, MaxDepth: 2}, []string{"/dir", "/dir/file", "/dir/subdir", "/foo", "/link"}},
+		{ListOptions{Recursive: true, SelectFilter: NewTypeFilter("file")}, []string{"/dir/file", "/dir/subdir/deep", "/foo"}},
+	} {
+		nodes, err := ListNodes(context.TODO(), repo, *sn.Tree, test.opts)
+		rtest.OK(t, err)
+		var paths []string
+		for _, node := range nodes {
+			rtest.Equals(t, filepath.Base(node.Path), node.Node.Name)
+			paths = append(paths, filepath.ToSlash(node.Path))
+		}
+		rtest.Equals(t, test.expected, paths, fmt.Sprintf("options %+v", test.opts))
+	}
+
+	// the walk stops at the first error returned by the callback
+	stop := errors.New("stop")
+	var visited int
+	err := WalkNodes(context.TODO(), repo, *sn.Tree, ListOptions{Recursive: true}, func(ListedNode) error {
+		visited++
+		return stop
+	})
+	rtest.Equals(t, stop, err)
+	rtest.Equals(t, 1, visited)
+}
+
 func normalizeFileMode(mode os.FileMode) os.FileMode {
 	if runtime.GOOS == "windows" {
 		if mode.IsDir() {
@@ -829,157 +921,1467 @@ func TestRestorerConsistentTimestampsAndPermissions(t *testing.T) {
 	}
 }
 
-// VerifyFiles must not report cancellation of its context through res.Error.
-func TestVerifyCancel(t *testing.T) {
-	snapshot := Snapshot{
+func TestRestorerConsistentTimestampsManyFiles(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+	dirMode := normalizeFileMode(0750 | os.ModeDir)
+
+	// many files per directory keep all workers busy writing into the same
+	// directories, whose timestamps must nevertheless match the snapshot
+	var newDir func(depth int) Dir
+	newDir = func(depth int) Dir {
+		nodes := make(map[string]Node)
+		for i := 0; i < 100; i++ {
+			nodes[fmt.Sprintf("file%03d", i)] = File{
+				Data:    fmt.Sprintf("content: file %d %d\n", depth, i),
+				ModTime: timeForTest,
+			}
+		}
+		if depth > 0 {
+			nodes["subdir"] = newDir(depth - 1)
+		}
+		return Dir{Mode: dirMode, ModTime: timeForTest, Nodes: nodes}
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{"dir": newDir(3)},
+	}, noopGetGenericAttributes)
+
+	for _, opts := range []Options{
+		{},
+		{AtomicReplace: true},
+		{WriteBufferSize: 4096},
+	} {
+		res := NewRestorer(repo, sn, opts)
+		tempdir := rtest.TempDir(t)
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		path := "dir"
+		for depth := 3; depth >= 0; depth-- {
+			fi, err := os.Stat(filepath.Join(tempdir, path))
+			rtest.OK(t, err)
+			checkConsistentInfo(t, path, fi, timeForTest, dirMode)
+			path = filepath.Join(path, "subdir")
+		}
+	}
+}
+
+func TestRestorerPostWriteHook(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
 		Nodes: map[string]Node{
-			"foo": File{Data: "content: foo\n"},
+			"dir": Dir{Nodes: map[string]Node{
+				"clean":    File{Data: "content: clean\n"},
+				"infected": File{Data: "content: infected\n"},
+			}},
+			"hardlink": File{Data: "content: link\n", Inode: 42, Links: 2},
+			"link2":    File{Data: "content: link\n", Inode: 42, Links: 2},
 		},
+	}, noopGetGenericAttributes)
+
+	for _, atomic := range []bool{false, true} {
+		tempdir := rtest.TempDir(t)
+		// an existing file is only kept with AtomicReplace
+		rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, "dir"), 0700))
+		rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "dir", "infected"), []byte("content: old\n"), 0600))
+
+		var lock sync.Mutex
+		var scanned []string
+		res := NewRestorer(repo, sn, Options{AtomicReplace: atomic, PostWriteHook: func(path string, node *restic.Node) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			// with AtomicReplace, the hook is called with the temporary file
+			rtest.Assert(t, atomic != (filepath.Base(path) == node.Name), "unexpected path %v for %v", path, node.Name)
+			lock.Lock()
+			scanned = append(scanned, node.Name)
+			lock.Unlock()
+			if strings.Contains(string(data), "infected") {
+				return errors.New("malware found")
+			}
+			return nil
+		}})
+		var errs []string
+		res.Error = func(location string, err error) error {
+			errs = append(errs, fmt.Sprintf("%v: %v", location, err))
+			return nil
+		}
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		sort.Strings(scanned)
+		rtest.Equals(t, []string{"clean", "hardlink", "infected"}, scanned)
+		rtest.Equals(t, []string{"/dir/infected: malware found"}, errs)
+
+		data, err := os.ReadFile(filepath.Join(tempdir, "dir", "clean"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: clean\n", string(data))
+		data, err = os.ReadFile(filepath.Join(tempdir, "dir", "infected"))
+		if atomic {
+			rtest.OK(t, err)
+			rtest.Equals(t, "content: old\n", string(data))
+		} else {
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected rejected file to be removed, got %v", err)
+		}
+		// no temporary files are left behind
+		entries, err := os.ReadDir(filepath.Join(tempdir, "dir"))
+		rtest.OK(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if atomic {
+			rtest.Equals(t, []string{"clean", "infected"}, names)
+		} else {
+			rtest.Equals(t, []string{"clean"}, names)
+		}
 	}
+}
+
+func TestRestorerSkipDirTimes(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
 
 	repo := repository.TestRepository(t)
-	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Mode:    normalizeFileMode(0750 | os.ModeDir),
+				ModTime: timeForTest,
+				Nodes: map[string]Node{
+					"file": File{
+						Mode:    normalizeFileMode(os.FileMode(0700)),
+						ModTime: timeForTest,
+						Data:    "content: file\n",
+					},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
 
-	res := NewRestorer(repo, sn, Options{})
+	res := NewRestorer(repo, sn, Options{SkipDirTimes: true})
 
 	tempdir := rtest.TempDir(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	rtest.OK(t, res.RestoreTo(ctx, tempdir))
-	err := os.WriteFile(filepath.Join(tempdir, "foo"), []byte("bar"), 0644)
-	rtest.OK(t, err)
 
-	var errs []error
-	res.Error = func(filename string, err error) error {
-		errs = append(errs, err)
-		return err
-	}
+	fi, err := os.Stat(filepath.Join(tempdir, "dir", "file"))
+	rtest.OK(t, err)
+	checkConsistentInfo(t, "dir/file", fi, timeForTest, normalizeFileMode(os.FileMode(0700)))
 
-	nverified, err := res.VerifyFiles(ctx, tempdir)
-	rtest.Equals(t, 0, nverified)
-	rtest.Assert(t, err != nil, "nil error from VerifyFiles")
-	rtest.Equals(t, 1, len(errs))
-	rtest.Assert(t, strings.Contains(errs[0].Error(), "Invalid file size for"), "wrong error %q", errs[0].Error())
+	fi, err = os.Stat(filepath.Join(tempdir, "dir"))
+	rtest.OK(t, err)
+	rtest.Equals(t, normalizeFileMode(0750|os.ModeDir), fi.Mode())
+	rtest.Assert(t, !fi.ModTime().Equal(timeForTest), "directory timestamp was restored")
 }
 
-func TestRestorerSparseFiles(t *testing.T) {
+func TestRestorerEventWriter(t *testing.T) {
 	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
 
-	var zeros [1<<20 + 13]byte
-
-	target := &fs.Reader{
-		Mode:       0600,
-		Name:       "/zeros",
-		ReadCloser: io.NopCloser(bytes.NewReader(zeros[:])),
-	}
-	sc := archiver.NewScanner(target)
-	err := sc.Scan(context.TODO(), []string{"/zeros"})
-	rtest.OK(t, err)
-
-	arch := archiver.New(repo, target, archiver.Options{})
-	sn, _, _, err := arch.Snapshot(context.Background(), []string{"/zeros"},
-		archiver.SnapshotOptions{})
-	rtest.OK(t, err)
-
-	res := NewRestorer(repo, sn, Options{Sparse: true})
+	var buf bytes.Buffer
+	res := NewRestorer(repo, sn, Options{EventWriter: &buf})
 
 	tempdir := rtest.TempDir(t)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err = res.RestoreTo(ctx, tempdir)
-	rtest.OK(t, err)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
 
-	filename := filepath.Join(tempdir, "zeros")
-	content, err := os.ReadFile(filename)
-	rtest.OK(t, err)
+	var events []restoreEvent
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev restoreEvent
+		rtest.OK(t, dec.Decode(&ev))
+		events = append(events, ev)
+	}
 
-	rtest.Equals(t, len(zeros[:]), len(content))
-	rtest.Equals(t, zeros[:], content)
+	rtest.Assert(t, len(events) >= 2, "expected at least start and summary event, got %v", events)
+	rtest.Equals(t, "start", events[0].Action)
+	rtest.Equals(t, tempdir, events[0].Path)
 
-	blocks := getBlockCount(t, filename)
-	if blocks < 0 {
-		return
+	restored := make(map[string]uint64)
+	for _, ev := range events[1 : len(events)-1] {
+		rtest.Equals(t, "restored", ev.Action)
+		restored[ev.Path] = ev.Size
 	}
+	rtest.Equals(t, map[string]uint64{
+		"/dir":      0,
+		"/dir/file": uint64(len("content: file\n")),
+		"/foo":      uint64(len("content: foo\n")),
+	}, restored)
+
+	summary := events[len(events)-1]
+	rtest.Equals(t, "summary", summary.Action)
+	// the directory is not counted as a file
+	rtest.Equals(t, uint64(2), *summary.FilesRestored)
+	rtest.Equals(t, uint64(1), *summary.DirsRestored)
+	rtest.Equals(t, uint64(0), *summary.Errors)
+}
 
-	// st.Blocks is the size in 512-byte blocks.
-	denseBlocks := math.Ceil(float64(len(zeros)) / 512)
-	sparsity := 1 - float64(blocks)/denseBlocks
+type fixedClock time.Time
 
-	// This should report 100% sparse. We don't assert that,
-	// as the behavior of sparse writes depends on the underlying
-	// file system as well as the OS.
-	t.Logf("wrote %d zeros as %d blocks, %.1f%% sparse",
-		len(zeros), blocks, 100*sparsity)
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
 }
 
-func saveSnapshotsAndOverwrite(t *testing.T, baseSnapshot Snapshot, overwriteSnapshot Snapshot, options Options) string {
+func TestRestorerClock(t *testing.T) {
 	repo := repository.TestRepository(t)
-	tempdir := filepath.Join(rtest.TempDir(t), "target")
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
 
-	// base snapshot
-	sn, id := saveSnapshot(t, repo, baseSnapshot, noopGetGenericAttributes)
-	t.Logf("base snapshot saved as %v", id.Str())
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	res := NewRestorer(repo, sn, Options{EventWriter: &buf, Clock: fixedClock(now)})
+	rtest.OK(t, res.RestoreTo(context.TODO(), rtest.TempDir(t)))
 
-	res := NewRestorer(repo, sn, options)
-	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev restoreEvent
+		rtest.OK(t, dec.Decode(&ev))
+		rtest.Assert(t, ev.Time.Equal(now), "event %v has time %v, expected %v", ev.Action, ev.Time, now)
+	}
+}
 
-	// overwrite snapshot
-	sn, id = saveSnapshot(t, repo, overwriteSnapshot, noopGetGenericAttributes)
-	t.Logf("overwrite snapshot saved as %v", id.Str())
-	res = NewRestorer(repo, sn, options)
-	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+// blobCountingRepo counts how often each blob is loaded from a pack.
+type blobCountingRepo struct {
+	restic.Repository
+	m      sync.Mutex
+	loaded map[restic.ID]int
+}
 
-	_, err := res.VerifyFiles(ctx, tempdir)
-	rtest.OK(t, err)
+func (r *blobCountingRepo) LoadBlobsFromPack(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+	r.m.Lock()
+	for _, blob := range blobs {
+		r.loaded[blob.ID]++
+	}
+	r.m.Unlock()
+	return r.Repository.LoadBlobsFromPack(ctx, packID, blobs, handleBlobFn)
+}
 
-	return tempdir
+// flakyBackend fails the given number of loads of data packs.
+type flakyBackend struct {
+	backend.Backend
+	m        sync.Mutex
+	failures int
 }
 
-func TestRestorerSparseOverwrite(t *testing.T) {
-	baseSnapshot := Snapshot{
+func (b *flakyBackend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if h.Type == restic.PackFile && !h.IsMetadata {
+		b.m.Lock()
+		fail := b.failures > 0
+		if fail {
+			b.failures--
+		}
+		b.m.Unlock()
+		if fail {
+			return errors.New("temporary failure")
+		}
+	}
+	return b.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func TestRestorerLoadRetries(t *testing.T) {
+	be := &flakyBackend{Backend: repository.TestBackend(t)}
+	repo, _ := repository.TestRepositoryWithBackend(t, be, 0, repository.Options{})
+	sn, _ := saveSnapshot(t, repo, Snapshot{
 		Nodes: map[string]Node{
-			"foo": File{Data: "content: new\n"},
+			"file": File{Data: "content: file\n"},
 		},
+	}, noopGetGenericAttributes)
+
+	for _, retries := range []int{0, 1} {
+		// the pack and both attempts of the repository to load the single
+		// blob fail
+		be.failures = 3
+		tempdir := rtest.TempDir(t)
+		res := NewRestorer(repo, sn, Options{LoadRetries: retries, LoadBackoff: time.Millisecond})
+		var failed []string
+		res.Error = func(location string, err error) error {
+			failed = append(failed, location)
+			return nil
+		}
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		if retries == 0 {
+			rtest.Assert(t, len(failed) > 0 && failed[0] == "/file", "expected failure for /file, got %v", failed)
+			continue
+		}
+		rtest.Equals(t, 0, len(failed))
+		data, err := os.ReadFile(filepath.Join(tempdir, "file"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: file\n", string(data))
 	}
-	var zero [14]byte
-	sparseSnapshot := Snapshot{
+}
+
+func TestRestorerEmptyFiles(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	nodes := make(map[string]Node)
+	for i := 0; i < 20; i++ {
+		nodes[fmt.Sprintf("empty%d", i)] = File{Mode: 0444, ModTime: modTime}
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{"dir": Dir{Nodes: nodes}},
+	}, noopGetGenericAttributes)
+
+	countingRepo := &blobCountingRepo{Repository: repo, loaded: make(map[restic.ID]int)}
+	res := NewRestorer(countingRepo, sn, Options{})
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	rtest.Equals(t, 0, len(countingRepo.loaded))
+	rtest.Equals(t, 20, res.EmptyFiles())
+	for name := range nodes {
+		fi, err := os.Lstat(filepath.Join(tempdir, "dir", name))
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(0), fi.Size())
+		rtest.Equals(t, os.FileMode(0444), fi.Mode())
+		rtest.Assert(t, fi.ModTime().Equal(modTime), "unexpected mtime %v", fi.ModTime())
+	}
+}
+
+func TestRestorerRestoreToMany(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
 		Nodes: map[string]Node{
-			"foo": File{Data: string(zero[:])},
+			"foo": File{Data: "content: foo\n"},
+			"dir": Dir{Nodes: map[string]Node{
+				"bar":  File{Data: "content: bar\n"},
+				"copy": File{Data: "content: bar\n"},
+			}},
 		},
-	}
+	}, noopGetGenericAttributes)
 
-	saveSnapshotsAndOverwrite(t, baseSnapshot, sparseSnapshot, Options{Sparse: true, Overwrite: OverwriteAlways})
+	base := rtest.TempDir(t)
+	targets := []string{filepath.Join(base, "a"), filepath.Join(base, "b"), filepath.Join(base, "c")}
+	// the existing file is kept, thus foo must be downloaded for the other targets
+	rtest.OK(t, os.Mkdir(targets[0], 0700))
+	rtest.OK(t, os.WriteFile(filepath.Join(targets[0], "foo"), []byte("other\n"), 0600))
+
+	countingRepo := &blobCountingRepo{Repository: repo, loaded: make(map[restic.ID]int)}
+	res := NewRestorer(countingRepo, sn, Options{Overwrite: OverwriteNever})
+	rtest.OK(t, res.RestoreToMany(context.TODO(), targets))
+
+	for _, target := range targets[1:] {
+		for filename, content := range map[string]string{
+			"foo":      "content: foo\n",
+			"dir/bar":  "content: bar\n",
+			"dir/copy": "content: bar\n",
+		} {
+			data, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(filename)))
+			rtest.OK(t, err)
+			rtest.Equals(t, content, string(data))
+		}
+	}
+	rtest.Equals(t, map[restic.ID]int{
+		restic.Hash([]byte("content: foo\n")): 2,
+		restic.Hash([]byte("content: bar\n")): 1,
+	}, countingRepo.loaded)
+
+	// errors name the target
+	blocked := filepath.Join(base, "file")
+	rtest.OK(t, os.WriteFile(blocked, nil, 0600))
+	err := res.RestoreToMany(context.TODO(), []string{targets[1], blocked})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "target "+blocked), "expected error for target %v, got %v", blocked, err)
 }
 
-func TestRestorerOverwriteBehavior(t *testing.T) {
-	baseTime := time.Now()
-	baseSnapshot := Snapshot{
+func TestRestorerMaxBytes(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
 		Nodes: map[string]Node{
-			"foo": File{Data: "content: foo\n", ModTime: baseTime},
-			"dirtest": Dir{
-				Nodes: map[string]Node{
-					"file": File{Data: "content: file\n", ModTime: baseTime},
-				},
-				ModTime: baseTime,
-			},
+			"a": File{Data: "content: a\n"},
+			"b": File{Data: "content: b\n"},
+			"dir": Dir{Nodes: map[string]Node{
+				"c": File{Data: "content: c\n"},
+			}},
+			"e": File{Data: "e\n"},
 		},
+	}, noopGetGenericAttributes)
+
+	size := int64(len("content: a\n"))
+	res := NewRestorer(repo, sn, Options{MaxBytes: 2*size + 1})
+	tempdir := rtest.TempDir(t)
+	err := res.RestoreTo(context.TODO(), tempdir)
+	rtest.Assert(t, errors.Is(err, ErrQuotaExceeded), "expected ErrQuotaExceeded, got %v", err)
+
+	// files are restored in order until the first one which does not fit
+	for filename, content := range map[string]string{
+		"a": "content: a\n",
+		"b": "content: b\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
 	}
-	overwriteSnapshot := Snapshot{
+	for _, filename := range []string{"dir/c", "e"} {
+		_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected %v to be skipped, got %v", filename, err)
+	}
+
+	res = NewRestorer(repo, sn, Options{MaxBytes: 3*size + 2})
+	rtest.OK(t, res.RestoreTo(context.TODO(), rtest.TempDir(t)))
+}
+
+// configRepo returns a modified repository config.
+type configRepo struct {
+	restic.Repository
+	cfg restic.Config
+}
+
+func (r configRepo) Config() restic.Config {
+	return r.cfg
+}
+
+type missingBlobsRepo struct {
+	restic.Repository
+	missing restic.IDSet
+}
+
+func (r missingBlobsRepo) LookupBlobSize(t restic.BlobType, id restic.ID) (uint, bool) {
+	if r.missing.Has(id) {
+		return 0, false
+	}
+	return r.Repository.LookupBlobSize(t, id)
+}
+
+func TestRestorerListUnrestorable(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
 		Nodes: map[string]Node{
-			"foo": File{Data: "content: new\n", ModTime: baseTime.Add(time.Second)},
-			"dirtest": Dir{
-				Nodes: map[string]Node{
-					"file": File{Data: "content: file2\n", ModTime: baseTime.Add(-time.Second)},
-				},
-			},
+			"dir": Dir{Nodes: map[string]Node{
+				"damaged": File{Data: "content: damaged\n"},
+				"intact":  File{Data: "content: intact\n"},
+			}},
+			"excluded": File{Data: "content: excluded\n"},
 		},
+	}, noopGetGenericAttributes)
+
+	damaged := restic.Hash([]byte("content: damaged\n"))
+	missing := restic.NewIDSet(damaged, restic.Hash([]byte("content: excluded\n")))
+	res := NewRestorer(missingBlobsRepo{repo, missing}, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/excluded", true
 	}
 
-	var tests = []struct {
+	files, err := res.ListUnrestorable(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, []UnrestorableFile{{
+		Path:    filepath.FromSlash("/dir/damaged"),
+		Missing: restic.IDs{damaged},
+	}}, files)
+}
+
+func TestRestorerCountSelected(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file":     File{Data: "content: file\n"},
+				"link":     Symlink{Target: "file"},
+				"hardlink": File{Data: "content: hardlink\n", Inode: 42, Links: 2},
+				"subdir":   Dir{},
+			}},
+			"hardlink": File{Data: "content: hardlink\n", Inode: 42, Links: 2},
+			"excluded": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: excluded\n"},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/excluded", item != "/excluded"
+	}
+
+	count, err := res.CountSelected(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, SelectionCount{
+		Files: 4,
+		Dirs:  2,
+		Bytes: uint64(len("content: file\n") + len("content: hardlink\n")),
+	}, count)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = res.CountSelected(ctx)
+	rtest.Assert(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+}
+
+func TestRestorerFsync(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n", Mode: 0400},
+				"link": Symlink{Target: "file"},
+			}},
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	res := NewRestorer(repo, sn, Options{Fsync: true})
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	_, err := res.VerifyFiles(context.TODO(), tempdir)
+	rtest.OK(t, err)
+
+	// each directory is only synced once
+	syncs := newFsyncs(true)
+	syncs.addFile(filepath.Join(tempdir, "dir", "file"))
+	syncs.addEntry(filepath.Join(tempdir, "dir", "link"))
+	syncs.addDir(filepath.Join(tempdir, "dir"))
+	syncs.addFile(filepath.Join(tempdir, "foo"))
+	rtest.Equals(t, map[string]struct{}{
+		tempdir:                       {},
+		filepath.Join(tempdir, "dir"): {},
+	}, syncs.dirs)
+	rtest.OK(t, syncs.sync())
+
+	rtest.OK(t, newFsyncs(false).sync())
+}
+
+func TestRestorerRequiredPacks(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	packSizes := make(map[restic.ID]int64)
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(id restic.ID, size int64) error {
+		packSizes[id] = size
+		return nil
+	}))
+	packID := repo.LookupBlob(restic.DataBlob, restic.Hash([]byte("content: file\n")))[0].PackID
+
+	res := NewRestorer(repo, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/foo", true
+	}
+	packs, err := res.RequiredPacks(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, []RequiredPack{{ID: packID, Size: packSizes[packID]}}, packs)
+
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return false, false
+	}
+	packs, err = res.RequiredPacks(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(packs))
+}
+
+func TestRestorerCheckCompatibility(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{"foo": File{Data: "content: foo\n"}},
+	}, noopGetGenericAttributes)
+	rtest.OK(t, NewRestorer(repo, sn, Options{}).CheckCompatibility())
+
+	cfg := repo.Config()
+	cfg.Version = restic.MaxRepoVersion + 1
+	res := NewRestorer(configRepo{repo, cfg}, sn, Options{})
+	tempdir := rtest.TempDir(t)
+	err := res.RestoreTo(context.TODO(), tempdir)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), fmt.Sprint(restic.MaxRepoVersion+1)), "unexpected error %v", err)
+
+	// nothing is restored
+	entries, err := os.ReadDir(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(entries))
+}
+
+func TestRestorerBirthTime(t *testing.T) {
+	btime := time.Date(2020, 3, 4, 5, 6, 7, 8000, time.UTC)
+	getGenericAttributes := func(_ *FileAttributes, _ bool) map[restic.GenericAttributeType]json.RawMessage {
+		node := restic.Node{}
+		rtest.OK(t, node.SetBirthTime(btime))
+		return node.GenericAttributes
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+		},
+	}, getGenericAttributes)
+
+	// the birth time round-trips through the repository
+	tree, err := restic.LoadTree(context.TODO(), repo, *sn.Tree)
+	rtest.OK(t, err)
+	stored, ok, err := tree.Find("dir").BirthTime()
+	rtest.OK(t, err)
+	rtest.Assert(t, ok && stored.Equal(btime), "unexpected birth time %v, expected %v", stored, btime)
+
+	// and is silently ignored if it cannot be applied
+	res := NewRestorer(repo, sn, Options{})
+	res.Warn = func(message string) {
+		t.Errorf("unexpected warning: %v", message)
+	}
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	if runtime.GOOS == "darwin" {
+		for _, item := range []string{"dir", "dir/file"} {
+			path := filepath.Join(tempdir, filepath.FromSlash(item))
+			fi, err := os.Lstat(path)
+			rtest.OK(t, err)
+			restored, ok := fs.BirthTime(path, fi)
+			rtest.Assert(t, ok && restored.Equal(btime), "unexpected birth time %v for %v", restored, item)
+		}
+	}
+}
+
+func TestRestorerManifest(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+			"foo":   File{Data: "content: foo\n", Inode: 42, Links: 2},
+			"hard":  File{Data: "content: foo\n", Inode: 42, Links: 2},
+			"empty": File{},
+			"link":  Symlink{Target: "foo"},
+		},
+	}, noopGetGenericAttributes)
+
+	line := func(path, data string) string {
+		var content restic.IDs
+		if data != "" {
+			content = restic.IDs{restic.Hash([]byte(data))}
+		}
+		return fmt.Sprintf("%v %d %v\n", ContentHash(content), len(data), path)
+	}
+	expected := line("/dir/file", "content: file\n") +
+		line("/empty", "") +
+		line("/foo", "content: foo\n") +
+		line("/hard", "content: foo\n")
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the manifest also lists files which already match the snapshot
+	for _, overwrite := range []OverwriteBehavior{OverwriteAlways, OverwriteIfChanged} {
+		var buf bytes.Buffer
+		res := NewRestorer(repo, sn, Options{ManifestWriter: &buf, Overwrite: overwrite})
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+		rtest.Equals(t, expected, buf.String())
+	}
+}
+
+func TestRestorerOnDirCreated(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+					"subdir": Dir{
+						Nodes: map[string]Node{
+							"file": File{Data: "content: subdir file\n"},
+						},
+					},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	created := make(map[string]string)
+	res := NewRestorer(repo, sn, Options{
+		OnDirCreated: func(path string, node *restic.Node) error {
+			rel, err := filepath.Rel(tempdir, path)
+			rtest.OK(t, err)
+			created[filepath.ToSlash(rel)] = node.Name
+
+			// children must not be restored yet
+			entries, err := os.ReadDir(path)
+			rtest.OK(t, err)
+			rtest.Equals(t, 0, len(entries))
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.Equals(t, map[string]string{"dir": "dir", "dir/subdir": "subdir"}, created)
+
+	// errors are handled according to res.Error
+	errCallback := errors.New("callback failed")
+	res = NewRestorer(repo, sn, Options{
+		OnDirCreated: func(_ string, node *restic.Node) error {
+			if node.Name == "subdir" {
+				return errCallback
+			}
+			return nil
+		},
+	})
+	err := res.RestoreTo(ctx, rtest.TempDir(t))
+	rtest.Assert(t, errors.Is(err, errCallback), "expected callback error, got %v", err)
+}
+
+func TestRestorerOnDirComplete(t *testing.T) {
+	dirTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				ModTime: dirTime,
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+					"subdir": Dir{
+						ModTime: dirTime,
+						Nodes: map[string]Node{
+							"file": File{Data: "content: subdir file\n"},
+						},
+					},
+				},
+			},
+			"filtered": Dir{
+				ModTime: dirTime,
+				Nodes: map[string]Node{
+					"file": File{Data: "content: filtered\n"},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	var completed []string
+	res := NewRestorer(repo, sn, Options{
+		OnDirComplete: func(path string, node *restic.Node) error {
+			rel, err := filepath.Rel(tempdir, path)
+			rtest.OK(t, err)
+			completed = append(completed, filepath.ToSlash(rel))
+
+			// the directory including its metadata is already restored
+			fi, err := os.Stat(path)
+			rtest.OK(t, err)
+			rtest.Assert(t, fi.ModTime().Equal(dirTime), "unexpected mtime %v for %v", fi.ModTime(), rel)
+			if node.Name == "subdir" {
+				_, err = os.Stat(filepath.Join(path, "file"))
+				rtest.OK(t, err)
+			}
+			return nil
+		},
+	})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item != "/filtered/file", true
+	}
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	// children are completed before their parent
+	rtest.Equals(t, []string{"dir/subdir", "dir", "filtered"}, completed)
+}
+
+func TestRestorerMaxDepth(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+					"subdir": Dir{
+						Nodes: map[string]Node{
+							"file": File{Data: "content: subdir file\n"},
+						},
+					},
+				},
+			},
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, test := range []struct {
+		maxDepth int
+		exists   []string
+		missing  []string
+	}{
+		{0, []string{"foo", "dir/file", "dir/subdir/file"}, nil},
+		{1, []string{"foo", "dir"}, []string{"dir/file", "dir/subdir"}},
+		{2, []string{"foo", "dir/file", "dir/subdir"}, []string{"dir/subdir/file"}},
+	} {
+		tempdir := rtest.TempDir(t)
+		res := NewRestorer(repo, sn, Options{MaxDepth: test.maxDepth})
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+		for _, name := range test.exists {
+			_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.OK(t, err)
+		}
+		for _, name := range test.missing {
+			_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "max depth %d: expected %v to be missing, got %v", test.maxDepth, name, err)
+		}
+	}
+}
+
+func TestRestorerModifiedWindow(t *testing.T) {
+	base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: base.Add(-time.Hour), Nodes: map[string]Node{
+				"old": File{Data: "content: old\n", ModTime: base.Add(-time.Hour)},
+				"new": File{Data: "content: new\n", ModTime: base.Add(time.Hour)},
+			}},
+			"olddir": Dir{Nodes: map[string]Node{
+				"old": File{Data: "content: old\n", ModTime: base.Add(-2 * time.Hour)},
+			}},
+			"since":  File{Data: "content: since\n", ModTime: base},
+			"future": File{Data: "content: future\n", ModTime: base.Add(48 * time.Hour)},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, test := range []struct {
+		since, until time.Time
+		exists       []string
+		missing      []string
+	}{
+		{base, time.Time{}, []string{"since", "future", "dir/new"}, []string{"dir/old", "olddir"}},
+		{time.Time{}, base, []string{"dir/old", "olddir/old"}, []string{"since", "future", "dir/new"}},
+		{base, base.Add(24 * time.Hour), []string{"since", "dir/new"}, []string{"future", "dir/old", "olddir"}},
+	} {
+		tempdir := rtest.TempDir(t)
+		res := NewRestorer(repo, sn, Options{ModifiedSince: test.since, ModifiedUntil: test.until})
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+		for _, name := range test.exists {
+			_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.OK(t, err)
+		}
+		for _, name := range test.missing {
+			_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "window %v - %v: expected %v to be missing, got %v", test.since, test.until, name, err)
+		}
+		count, err := res.VerifyFiles(ctx, tempdir)
+		rtest.OK(t, err)
+		rtest.Equals(t, len(test.exists), count)
+	}
+
+	// the metadata of a directory is restored if it contains selected items
+	tempdir := rtest.TempDir(t)
+	res := NewRestorer(repo, sn, Options{ModifiedSince: base})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	fi, err := os.Stat(filepath.Join(tempdir, "dir"))
+	rtest.OK(t, err)
+	rtest.Equals(t, base.Add(-time.Hour), fi.ModTime().UTC())
+}
+
+func TestRestorerOverlay(t *testing.T) {
+	repo := repository.TestRepository(t)
+	lower, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"keep":   File{Data: "content: keep\n"},
+				"change": File{Data: "content: old\n"},
+				"gone":   File{Data: "content: gone\n"},
+			}},
+			"removed": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+			"unchanged": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+			"top": File{Data: "content: top\n"},
+		},
+	}, noopGetGenericAttributes)
+	upper, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"keep":   File{Data: "content: keep\n"},
+				"change": File{Data: "content: new\n"},
+				"added":  File{Data: "content: added\n"},
+			}},
+			"unchanged": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+			"top": File{Data: "content: top\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, test := range []struct {
+		whiteouts bool
+		exists    []string
+		missing   []string
+	}{
+		{false, []string{"dir/change", "dir/added"}, []string{"dir/keep", "dir/.wh.gone", ".wh.removed", "unchanged", "top"}},
+		{true, []string{"dir/change", "dir/added", "dir/.wh.gone", ".wh.removed"}, []string{"dir/keep", "removed", "unchanged", "top"}},
+	} {
+		tempdir := rtest.TempDir(t)
+		res := NewRestorer(repo, upper, Options{OverlayLower: lower, OverlayWhiteouts: test.whiteouts})
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+		for _, name := range test.exists {
+			_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.OK(t, err)
+		}
+		for _, name := range test.missing {
+			_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "whiteouts %v: expected %v to be missing, got %v", test.whiteouts, name, err)
+		}
+		data, err := os.ReadFile(filepath.Join(tempdir, "dir", "change"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: new\n", string(data))
+
+		count, err := res.VerifyFiles(ctx, tempdir)
+		rtest.OK(t, err)
+		rtest.Equals(t, 2, count)
+	}
+
+	// a subtree is compared with the same subtree of the lower snapshot
+	tempdir := rtest.TempDir(t)
+	res := NewRestorer(repo, upper, Options{OverlayLower: lower, OverlayWhiteouts: true})
+	rtest.OK(t, res.RestoreSubtree(ctx, "dir", tempdir))
+	for _, name := range []string{"change", "added", ".wh.gone"} {
+		_, err := os.Stat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+	}
+	_, err := os.Stat(filepath.Join(tempdir, "keep"))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected keep to be missing, got %v", err)
+
+	res = NewRestorer(repo, upper, Options{OverlayWhiteouts: true})
+	err = res.RestoreTo(ctx, rtest.TempDir(t))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "requires Options.OverlayLower"), "unexpected error %v", err)
+}
+
+func TestRestorerIncludeTopDir(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	source := filepath.Join(rtest.TempDir(t), "project")
+	rtest.OK(t, os.Mkdir(source, 0700))
+	archiver.TestCreateFiles(t, source, archiver.TestDir{
+		"dir": archiver.TestDir{
+			"file": archiver.TestFile{Content: "content: file\n"},
+		},
+		"foo": archiver.TestFile{Content: "content: foo\n"},
+	})
+	sn := archiver.TestSnapshot(t, repo, source, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir := rtest.TempDir(t)
+	res := NewRestorer(repo, sn, Options{IncludeTopDir: true})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for _, name := range []string{"project/foo", "project/dir/file"} {
+		_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+		rtest.OK(t, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(tempdir, "project"))
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(entries))
+	count, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, count)
+
+	sn.Paths = []string{source, filepath.Join(filepath.Dir(source), "other")}
+	res = NewRestorer(repo, sn, Options{IncludeTopDir: true})
+	err = res.RestoreTo(ctx, rtest.TempDir(t))
+	rtest.Assert(t, err != nil, "expected error for snapshot with multiple paths")
+}
+
+func TestRestorerRestoreSubtree(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"etc": Dir{Nodes: map[string]Node{
+				"config": File{Data: "content: config\n"},
+			}},
+			"var": Dir{Nodes: map[string]Node{
+				"lib": Dir{Nodes: map[string]Node{
+					"app": Dir{Nodes: map[string]Node{
+						"data": File{Data: "content: data\n"},
+						"sub": Dir{Nodes: map[string]Node{
+							"file": File{Data: "content: file\n"},
+						}},
+					}},
+				}},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := NewRestorer(repo, sn, Options{})
+	locations := make(map[string]bool)
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		locations[item] = true
+		return true, true
+	}
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreSubtree(ctx, "/var/lib/app", tempdir))
+
+	// the children of the subtree are restored directly below the target
+	entries, err := os.ReadDir(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(entries))
+	for filename, content := range map[string]string{
+		"data":     "content: data\n",
+		"sub/file": "content: file\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+	rtest.Equals(t, map[string]bool{
+		filepath.FromSlash("/data"):     true,
+		filepath.FromSlash("/sub"):      true,
+		filepath.FromSlash("/sub/file"): true,
+	}, locations)
+
+	count, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, count)
+
+	for subpath, msg := range map[string]string{
+		"/var/missing":      "not found",
+		"/var/lib/app/data": "not a directory",
+	} {
+		err := res.RestoreSubtree(ctx, subpath, rtest.TempDir(t))
+		rtest.Assert(t, err != nil && strings.Contains(err.Error(), msg), "unexpected error for %v: %v", subpath, err)
+	}
+}
+
+func TestRestorerNewTreeRestorer(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"top": File{Data: "content: top\n"},
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+				"sub": Dir{Nodes: map[string]Node{
+					"nested": File{Data: "content: nested\n"},
+				}},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	treeID, err := restic.FindTreeDirectory(ctx, repo, sn.Tree, "/dir")
+	rtest.OK(t, err)
+
+	res := NewTreeRestorer(repo, *treeID, Options{StampProvenance: true})
+	rtest.Assert(t, res.Snapshot() == nil, "expected no snapshot")
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"file":       "content: file\n",
+		"sub/nested": "content: nested\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+	_, err = os.Stat(filepath.Join(tempdir, "top"))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected file outside the tree: %v", err)
+	_, err = os.Stat(filepath.Join(tempdir, ProvenanceFile))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected provenance without a snapshot: %v", err)
+
+	count, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, count)
+
+	// subtrees are relative to the tree
+	subdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreSubtree(ctx, "/sub", subdir))
+	data, err := os.ReadFile(filepath.Join(subdir, "nested"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: nested\n", string(data))
+
+	res = NewTreeRestorer(repo, *treeID, Options{IncludeTopDir: true})
+	err = res.RestoreTo(ctx, rtest.TempDir(t))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "requires a snapshot"), "unexpected error: %v", err)
+}
+
+func TestRestorerOwnerLookup(t *testing.T) {
+	users := map[string]uint32{"alice": 2000}
+	groups := map[string]uint32{"staff": 3000}
+	res := NewRestorer(nil, nil, Options{
+		UserLookup: func(name string) (uint32, bool) {
+			uid, ok := users[name]
+			return uid, ok
+		},
+		GroupLookup: func(name string) (uint32, bool) {
+			gid, ok := groups[name]
+			return gid, ok
+		},
+	})
+
+	for _, test := range []struct {
+		user, group string
+		uid, gid    uint32
+	}{
+		{"alice", "staff", 2000, 3000},
+		{"alice", "", 2000, 100},
+		{"bob", "staff", 1000, 3000},
+		{"", "", 1000, 100},
+	} {
+		node := &restic.Node{Name: "file", User: test.user, Group: test.group, UID: 1000, GID: 100}
+		owner := res.withOwner(node)
+		rtest.Equals(t, test.uid, owner.UID)
+		rtest.Equals(t, test.gid, owner.GID)
+		// the original node must not be modified
+		rtest.Equals(t, uint32(1000), node.UID)
+		rtest.Equals(t, uint32(100), node.GID)
+	}
+
+	// without lookup functions the numeric IDs are used
+	res = NewRestorer(nil, nil, Options{})
+	node := &restic.Node{Name: "file", User: "alice", Group: "staff", UID: 1000, GID: 100}
+	rtest.Assert(t, res.withOwner(node) == node, "unexpected copy of node")
+}
+
+func TestRestorerBestEffortMetadata(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	node := &restic.Node{Name: "missing", Type: "file", Mode: 0644}
+	target := filepath.Join(rtest.TempDir(t), "missing")
+
+	res := NewRestorer(repo, sn, Options{})
+	rtest.Assert(t, res.restoreNodeMetadataTo(node, target, "/missing") != nil, "expected metadata error")
+
+	// failures are only counted, RestoreTo reports them as a single warning
+	res = NewRestorer(repo, sn, Options{BestEffortMetadata: true})
+	rtest.OK(t, res.restoreNodeMetadataTo(node, target, "/missing"))
+	rtest.OK(t, res.restoreNodeMetadataTo(node, target, "/missing"))
+	rtest.Equals(t, 2, res.metadataFailures)
+	rtest.Assert(t, res.metadataFirstErr != nil, "missing first error")
+}
+
+// VerifyFiles must not report cancellation of its context through res.Error.
+func TestVerifyCancel(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{})
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	err := os.WriteFile(filepath.Join(tempdir, "foo"), []byte("bar"), 0644)
+	rtest.OK(t, err)
+
+	var errs []error
+	res.Error = func(filename string, err error) error {
+		errs = append(errs, err)
+		return err
+	}
+
+	nverified, err := res.VerifyFiles(ctx, tempdir)
+	rtest.Equals(t, 0, nverified)
+	rtest.Assert(t, err != nil, "nil error from VerifyFiles")
+	rtest.Equals(t, 1, len(errs))
+	rtest.Assert(t, strings.Contains(errs[0].Error(), "Invalid file size for"), "wrong error %q", errs[0].Error())
+}
+
+func TestRestorerVerifyFilesSelectFilter(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file1": File{Data: "content: file1\n"},
+				"file2": File{Data: "content: file2\n"},
+			}},
+			"excluded": File{Data: "content: excluded\n"},
+			"missing":  File{Data: "content: missing\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	// a modified file outside of the selection must not be flagged
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "excluded"), []byte("modified"), 0600))
+
+	res := NewRestorer(repo, sn, Options{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		return item == "/dir" || strings.HasPrefix(item, "/dir/"), item == "/dir"
+	}
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	count, err := res.VerifyFiles(context.TODO(), tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, count)
+}
+
+func TestVerifyFilesWithResult(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"bar": File{Data: "content: bar\n"},
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"baz": File{Data: "content: baz\n"},
+				},
+			},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{VerifyWorkers: 2})
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "foo"), []byte("bar"), 0644))
+
+	var errs []error
+	res.Error = func(filename string, err error) error {
+		errs = append(errs, err)
+		return nil
+	}
+
+	result, err := res.VerifyFilesWithResult(ctx, tempdir)
+	rtest.OK(t, err)
+	sort.Strings(result.Verified)
+	rtest.Equals(t, []string{filepath.Join(tempdir, "bar"), filepath.Join(tempdir, "dir", "baz")}, result.Verified)
+	rtest.Equals(t, uint64(2*len("content: bar\n")), result.BytesChecked)
+	rtest.Equals(t, 1, len(result.Failed))
+	rtest.Equals(t, filepath.Join(tempdir, "foo"), result.Failed[0].Path)
+	rtest.Equals(t, errs, []error{result.Failed[0].Err})
+}
+
+// upperWriter converts all data written to it to upper case.
+type upperWriter struct {
+	io.Writer
+}
+
+func (w upperWriter) Write(p []byte) (int, error) {
+	return w.Writer.Write(bytes.ToUpper(p))
+}
+
+func (w upperWriter) Close() error {
+	return nil
+}
+
+func TestRestorerContentTransform(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo.txt": File{Data: "content: foo\n"},
+			"bar":     File{Data: "content: bar\n"},
+			"bad.txt": File{Data: "content: bad\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{
+		ContentTransform: func(node *restic.Node, dst io.Writer) (io.WriteCloser, error) {
+			switch {
+			case node.Name == "bad.txt":
+				return nil, errors.New("transform failed")
+			case strings.HasSuffix(node.Name, ".txt"):
+				return upperWriter{dst}, nil
+			}
+			return nil, nil
+		},
+	})
+	var errs []string
+	res.Error = func(location string, err error) error {
+		errs = append(errs, location)
+		return nil
+	}
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.Equals(t, []string{string(filepath.Separator) + "bad.txt"}, errs)
+
+	for filename, content := range map[string]string{
+		"foo.txt": "CONTENT: FOO\n",
+		"bar":     "content: bar\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+	// neither the failed file nor temporary files are left behind
+	entries, err := os.ReadDir(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(entries))
+
+	result, err := res.VerifyFilesWithResult(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{filepath.Join(tempdir, "bar")}, result.Verified)
+	rtest.Equals(t, []string{filepath.Join(tempdir, "foo.txt")}, result.Skipped)
+	// the file which failed to transform is missing
+	rtest.Equals(t, 1, len(result.Failed))
+	rtest.Equals(t, filepath.Join(tempdir, "bad.txt"), result.Failed[0].Path)
+}
+
+func TestRestorerSparseFiles(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	var zeros [1<<20 + 13]byte
+
+	target := &fs.Reader{
+		Mode:       0600,
+		Name:       "/zeros",
+		ReadCloser: io.NopCloser(bytes.NewReader(zeros[:])),
+	}
+	sc := archiver.NewScanner(target)
+	err := sc.Scan(context.TODO(), []string{"/zeros"})
+	rtest.OK(t, err)
+
+	arch := archiver.New(repo, target, archiver.Options{})
+	sn, _, _, err := arch.Snapshot(context.Background(), []string{"/zeros"},
+		archiver.SnapshotOptions{})
+	rtest.OK(t, err)
+
+	res := NewRestorer(repo, sn, Options{Sparse: true})
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = res.RestoreTo(ctx, tempdir)
+	rtest.OK(t, err)
+
+	filename := filepath.Join(tempdir, "zeros")
+	content, err := os.ReadFile(filename)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, len(zeros[:]), len(content))
+	rtest.Equals(t, zeros[:], content)
+
+	blocks := getBlockCount(t, filename)
+	if blocks < 0 {
+		return
+	}
+
+	// st.Blocks is the size in 512-byte blocks.
+	denseBlocks := math.Ceil(float64(len(zeros)) / 512)
+	sparsity := 1 - float64(blocks)/denseBlocks
+
+	// This should report 100% sparse. We don't assert that,
+	// as the behavior of sparse writes depends on the underlying
+	// file system as well as the OS.
+	t.Logf("wrote %d zeros as %d blocks, %.1f%% sparse",
+		len(zeros), blocks, 100*sparsity)
+}
+
+func saveSnapshotsAndOverwrite(t *testing.T, baseSnapshot Snapshot, overwriteSnapshot Snapshot, options Options) string {
+	repo := repository.TestRepository(t)
+	tempdir := filepath.Join(rtest.TempDir(t), "target")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// base snapshot
+	sn, id := saveSnapshot(t, repo, baseSnapshot, noopGetGenericAttributes)
+	t.Logf("base snapshot saved as %v", id.Str())
+
+	res := NewRestorer(repo, sn, options)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	// overwrite snapshot
+	sn, id = saveSnapshot(t, repo, overwriteSnapshot, noopGetGenericAttributes)
+	t.Logf("overwrite snapshot saved as %v", id.Str())
+	res = NewRestorer(repo, sn, options)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	_, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+
+	return tempdir
+}
+
+func TestRestorerSparseOverwrite(t *testing.T) {
+	baseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: new\n"},
+		},
+	}
+	var zero [14]byte
+	sparseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: string(zero[:])},
+		},
+	}
+
+	saveSnapshotsAndOverwrite(t, baseSnapshot, sparseSnapshot, Options{Sparse: true, Overwrite: OverwriteAlways})
+}
+
+func TestRestorerOverwriteBehavior(t *testing.T) {
+	baseTime := time.Now()
+	baseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n", ModTime: baseTime},
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n", ModTime: baseTime},
+				},
+				ModTime: baseTime,
+			},
+		},
+	}
+	overwriteSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: new\n", ModTime: baseTime.Add(time.Second)},
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file2\n", ModTime: baseTime.Add(-time.Second)},
+				},
+			},
+		},
+	}
+
+	var tests = []struct {
 		Overwrite OverwriteBehavior
 		Files     map[string]string
 	}{
@@ -1011,6 +2413,13 @@ func TestRestorerOverwriteBehavior(t *testing.T) {
 				"dirtest/file": "content: file\n",
 			},
 		},
+		{
+			Overwrite: OverwriteNone,
+			Files: map[string]string{
+				"foo":          "content: foo\n",
+				"dirtest/file": "content: file\n",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -1032,6 +2441,45 @@ func TestRestorerOverwriteBehavior(t *testing.T) {
 	}
 }
 
+func TestRestorerOverwriteFunc(t *testing.T) {
+	baseTime := time.Now()
+	baseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n", ModTime: baseTime},
+			"bar": File{Data: "content: bar\n", ModTime: baseTime},
+		},
+	}
+	overwriteSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: new foo\n", ModTime: baseTime.Add(10 * time.Second)},
+			"bar": File{Data: "content: new bar\n", ModTime: baseTime.Add(time.Second)},
+			"new": File{Data: "content: new\n", ModTime: baseTime},
+		},
+	}
+
+	var called []string
+	tempdir := saveSnapshotsAndOverwrite(t, baseSnapshot, overwriteSnapshot, Options{
+		// takes precedence over the overwrite behavior
+		Overwrite: OverwriteNever,
+		OverwriteFunc: func(node *restic.Node, existing os.FileInfo) bool {
+			called = append(called, node.Name)
+			return node.ModTime.Sub(existing.ModTime()) > 5*time.Second
+		},
+	})
+
+	sort.Strings(called)
+	rtest.Equals(t, []string{"bar", "foo"}, called)
+	for filename, content := range map[string]string{
+		"foo": "content: new foo\n",
+		"bar": "content: bar\n",
+		"new": "content: new\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filename))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+}
+
 func TestRestorerOverwriteSpecial(t *testing.T) {
 	baseTime := time.Now()
 	baseSnapshot := Snapshot{
@@ -1071,14 +2519,189 @@ func TestRestorerOverwriteSpecial(t *testing.T) {
 			continue
 		}
 
-		if !bytes.Equal(data, []byte(content)) {
-			t.Errorf("file %v has wrong content: want %q, got %q", filename, content, data)
-		}
-	}
-	for filename, target := range links {
-		link, err := fs.Readlink(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		if !bytes.Equal(data, []byte(content)) {
+			t.Errorf("file %v has wrong content: want %q, got %q", filename, content, data)
+		}
+	}
+	for filename, target := range links {
+		link, err := fs.Readlink(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, link, target, "wrong symlink target")
+	}
+}
+
+func TestRestorerOverwriteWrongType(t *testing.T) {
+	baseTime := time.Now()
+	baseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"file": File{Data: "content: dir/file\n", ModTime: baseTime},
+				"subdir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+					"file": File{Data: "content: dir/subdir/file\n", ModTime: baseTime},
+				}},
+			}},
+			"file":      File{Data: "content: file\n", ModTime: baseTime},
+			"link":      Dir{ModTime: baseTime, Nodes: map[string]Node{"file": File{Data: "content: link/file\n", ModTime: baseTime}}},
+			"unchanged": File{Data: "content: unchanged\n", ModTime: baseTime},
+		},
+	}
+	overwriteSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"dir": File{Data: "content: dir\n", ModTime: baseTime},
+			"file": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"child": File{Data: "content: file/child\n", ModTime: baseTime},
+			}},
+			"link":      Symlink{Target: "foo", ModTime: baseTime},
+			"unchanged": File{Data: "content: unchanged\n", ModTime: baseTime},
+		},
+	}
+
+	for _, overwrite := range []OverwriteBehavior{OverwriteAlways, OverwriteIfChanged} {
+		t.Run(overwrite.String(), func(t *testing.T) {
+			repo := repository.TestRepository(t)
+			tempdir := filepath.Join(rtest.TempDir(t), "target")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			sn, _ := saveSnapshot(t, repo, baseSnapshot, noopGetGenericAttributes)
+			rtest.OK(t, NewRestorer(repo, sn, Options{Overwrite: overwrite}).RestoreTo(ctx, tempdir))
+			unchangedInfo, err := os.Stat(filepath.Join(tempdir, "unchanged"))
+			rtest.OK(t, err)
+
+			sn, _ = saveSnapshot(t, repo, overwriteSnapshot, noopGetGenericAttributes)
+			res := NewRestorer(repo, sn, Options{Overwrite: overwrite})
+			var warnings []string
+			res.Warn = func(message string) {
+				warnings = append(warnings, message)
+			}
+			rtest.OK(t, res.RestoreTo(ctx, tempdir))
+			_, err = res.VerifyFiles(ctx, tempdir)
+			rtest.OK(t, err)
+
+			for filename, content := range map[string]string{
+				"dir":        "content: dir\n",
+				"file/child": "content: file/child\n",
+				"unchanged":  "content: unchanged\n",
+			} {
+				data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+				rtest.OK(t, err)
+				rtest.Equals(t, content, string(data))
+			}
+			link, err := fs.Readlink(filepath.Join(tempdir, "link"))
+			rtest.OK(t, err)
+			rtest.Equals(t, "foo", link)
+
+			fi, err := os.Stat(filepath.Join(tempdir, "unchanged"))
+			rtest.OK(t, err)
+			rtest.Assert(t, os.SameFile(unchangedInfo, fi), "unchanged file was replaced")
+
+			// each replaced item is reported
+			rtest.Assert(t, len(warnings) == 3, "unexpected warnings: %v", warnings)
+		})
+	}
+}
+
+func TestRestorerOverwriteNone(t *testing.T) {
+	snapshotTime := time.Now().Add(-time.Hour)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"existing": Dir{Mode: normalizeFileMode(0755 | os.ModeDir), ModTime: snapshotTime, Nodes: map[string]Node{
+				"old": File{Data: "content: new\n", ModTime: snapshotTime},
+				"new": File{Data: "content: existing/new\n", ModTime: snapshotTime},
+			}},
+			"blocked": Dir{ModTime: snapshotTime, Nodes: map[string]Node{
+				"file": File{Data: "content: blocked/file\n", ModTime: snapshotTime},
+			}},
+			"fresh": Dir{ModTime: snapshotTime, Nodes: map[string]Node{
+				"file": File{Data: "content: fresh/file\n", ModTime: snapshotTime},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	existing := filepath.Join(tempdir, "existing")
+	rtest.OK(t, os.Mkdir(existing, 0700))
+	rtest.OK(t, os.WriteFile(filepath.Join(existing, "old"), []byte("content: old\n"), 0600))
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "blocked"), []byte("content: blocked\n"), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := NewRestorer(repo, sn, Options{Overwrite: OverwriteNone})
+	res.Warn = func(message string) {
+		t.Errorf("unexpected warning: %v", message)
+	}
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"existing/old": "content: old\n",
+		"existing/new": "content: existing/new\n",
+		"blocked":      "content: blocked\n",
+		"fresh/file":   "content: fresh/file\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+
+	// the metadata of the existing directory must not be restored
+	fi, err := os.Stat(existing)
+	rtest.OK(t, err)
+	rtest.Assert(t, !fi.ModTime().Equal(snapshotTime), "mtime of existing directory was restored")
+	if runtime.GOOS != "windows" {
+		rtest.Equals(t, os.FileMode(0700), fi.Mode().Perm())
+	}
+	// whereas new directories are restored as usual
+	fi, err = os.Stat(filepath.Join(tempdir, "fresh"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(snapshotTime), "mtime of new directory was not restored")
+}
+
+func TestRestorerSkipExistingVerifiedFrom(t *testing.T) {
+	baseTime := time.Now()
+	baseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"unchanged": File{Data: "content: unchanged\n", ModTime: baseTime},
+			}},
+			"changed": File{Data: "content: old\n", ModTime: baseTime},
+		},
+	}
+	overwriteSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{ModTime: baseTime, Nodes: map[string]Node{
+				"unchanged": File{Data: "content: unchanged\n", ModTime: baseTime},
+			}},
+			"changed": File{Data: "content: new\n", ModTime: baseTime.Add(time.Second)},
+			"added":   File{Data: "content: added\n", ModTime: baseTime},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	tempdir := filepath.Join(rtest.TempDir(t), "target")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	base, _ := saveSnapshot(t, repo, baseSnapshot, noopGetGenericAttributes)
+	rtest.OK(t, NewRestorer(repo, base, Options{}).RestoreTo(ctx, tempdir))
+
+	// modify the unchanged file behind the back of the restorer, which must
+	// not notice as it trusts the target to match the base snapshot
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "dir", "unchanged"), []byte("modified"), 0644))
+
+	sn, _ := saveSnapshot(t, repo, overwriteSnapshot, noopGetGenericAttributes)
+	res := NewRestorer(repo, sn, Options{SkipExistingVerifiedFrom: base})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"dir/unchanged": "modified",
+		"changed":       "content: new\n",
+		"added":         "content: added\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
 		rtest.OK(t, err)
-		rtest.Equals(t, link, target, "wrong symlink target")
+		rtest.Equals(t, content, string(data))
 	}
 }
 
@@ -1166,3 +2789,570 @@ func TestRestoreIfChanged(t *testing.T) {
 		}
 	}
 }
+
+func TestRestoreIfContentChanged(t *testing.T) {
+	origData := "content: foo\n"
+	modData := "content: bar\n"
+	rtest.Equals(t, len(modData), len(origData), "broken testcase")
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: origData, ModTime: time.Now()},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	tempdir := filepath.Join(rtest.TempDir(t), "target")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sn, id := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+	t.Logf("snapshot saved as %v", id.Str())
+
+	res := NewRestorer(repo, sn, Options{})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	// modify file but maintain size and timestamp
+	path := filepath.Join(tempdir, "foo")
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	rtest.OK(t, err)
+	fi, err := f.Stat()
+	rtest.OK(t, err)
+	_, err = f.Write([]byte(modData))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+	var utimes = [...]syscall.Timespec{
+		syscall.NsecToTimespec(fi.ModTime().UnixNano()),
+		syscall.NsecToTimespec(fi.ModTime().UnixNano()),
+	}
+	rtest.OK(t, syscall.UtimesNano(path, utimes[:]))
+
+	res = NewRestorer(repo, sn, Options{Overwrite: OverwriteIfContentChanged})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	data, err := os.ReadFile(path)
+	rtest.OK(t, err)
+	// restore must notice the changed file content despite the unchanged size and mtime
+	rtest.Equals(t, origData, string(data), "expected original file content")
+
+	// a different size must also result in restoring the original content
+	rtest.OK(t, os.WriteFile(path, []byte("content: modified\n"), 0600))
+	res = NewRestorer(repo, sn, Options{Overwrite: OverwriteIfContentChanged})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	data, err = os.ReadFile(path)
+	rtest.OK(t, err)
+	rtest.Equals(t, origData, string(data), "expected original file content")
+
+	// unlike OverwriteAlways, items which already match the snapshot are not touched
+	res = NewRestorer(repo, sn, Options{Overwrite: OverwriteAlways})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.Assert(t, res.Stats().Changed(), "expected OverwriteAlways to restore the metadata")
+	res = NewRestorer(repo, sn, Options{Overwrite: OverwriteIfContentChanged})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+	rtest.Assert(t, !res.Stats().Changed(), "unexpected changes %+v", res.Stats())
+}
+
+func TestRestorerLongPath(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("long path handling is only relevant on windows")
+	}
+
+	tmp := t.TempDir()
+
+	name := strings.Repeat("path", 32)
+	files := map[string]Node{
+		"file": File{Data: "content: file\n"},
+	}
+	for i := 0; i < 4; i++ {
+		files = map[string]Node{
+			name: Dir{Nodes: files},
+		}
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{Nodes: files}, noopGetGenericAttributes)
+	res := NewRestorer(repo, sn, Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tmp))
+
+	relpath := filepath.Join(name, name, name, name, "file")
+	rtest.Assert(t, len(filepath.Join(tmp, relpath)) > 260, "test path is too short")
+	data, err := os.ReadFile(fs.FixPath(filepath.Join(tmp, relpath)))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: file\n", string(data))
+
+	count, err := res.VerifyFiles(ctx, tmp)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, count)
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"a": File{Data: "content: a\n"},
+			"b": File{Data: "content: b\n"},
+			"c": File{Data: "content: c\n"},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+
+	checkpoint := filepath.Join(rtest.TempDir(t), "checkpoint")
+	res := NewRestorer(repo, sn, Options{VerifyWorkers: 1, VerifyCheckpoint: checkpoint})
+
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.Background(), tempdir))
+
+	// corrupt c without changing its size, verification is cancelled there
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "c"), []byte("corrupted!\n"), 0644))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res.Error = func(filename string, err error) error {
+		cancel()
+		return err
+	}
+	result, err := res.VerifyFilesWithResult(ctx, tempdir)
+	rtest.Assert(t, err != nil, "nil error from VerifyFiles")
+	rtest.Equals(t, 2, len(result.Verified), "verified files")
+
+	buf, err := os.ReadFile(checkpoint)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, strings.Count(string(buf), "\n"), "checkpoint entries")
+
+	// a is skipped as neither size nor mtime changed, b is verified again
+	fi, err := os.Stat(filepath.Join(tempdir, "a"))
+	rtest.OK(t, err)
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "a"), []byte("corrupted!\n"), 0644))
+	rtest.OK(t, os.Chtimes(filepath.Join(tempdir, "a"), fi.ModTime(), fi.ModTime()))
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "b"), []byte("corrupted!\n"), 0644))
+	rtest.OK(t, os.WriteFile(filepath.Join(tempdir, "c"), []byte("content: c\n"), 0644))
+
+	var failed []string
+	res.Error = func(filename string, err error) error {
+		failed = append(failed, filepath.Base(filename))
+		return nil
+	}
+	result, err = res.VerifyFilesWithResult(context.Background(), tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"b"}, failed)
+	rtest.Equals(t, 2, len(result.Verified), "verified files")
+}
+
+func TestRestorerSplitHardlinks(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"a": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n", Inode: 42, Links: 2},
+			}},
+			"b": Dir{Nodes: map[string]Node{
+				"hardlink": File{Data: "content: file\n", Inode: 42, Links: 2},
+				"other":    File{Data: "content: other\n"},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	selectA := func(item string, _ string, _ *restic.Node) (bool, bool) {
+		selected := item == filepath.FromSlash("/a") || strings.HasPrefix(item, filepath.FromSlash("/a/"))
+		return selected, selected
+	}
+
+	for _, test := range []struct {
+		opts     Options
+		warnings int
+		promoted bool
+	}{
+		{Options{}, 0, false},
+		{Options{WarnBrokenHardlinks: true}, 1, false},
+		{Options{PromoteHardlinks: true, WarnBrokenHardlinks: true}, 0, true},
+	} {
+		t.Run("", func(t *testing.T) {
+			tempdir := rtest.TempDir(t)
+			res := NewRestorer(repo, sn, test.opts)
+			res.SelectFilter = selectA
+			var warnings []string
+			res.Warn = func(message string) {
+				warnings = append(warnings, message)
+			}
+			rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+			rtest.Assert(t, len(warnings) == test.warnings, "unexpected warnings %v", warnings)
+
+			file, err := os.Lstat(filepath.Join(tempdir, "a", "file"))
+			rtest.OK(t, err)
+			hardlink, err := os.Lstat(filepath.Join(tempdir, "b", "hardlink"))
+			if !test.promoted {
+				rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unselected hardlink was restored: %v", err)
+				return
+			}
+			rtest.OK(t, err)
+			rtest.Assert(t, os.SameFile(file, hardlink), "hardlink was not restored as link")
+
+			// only the hardlink is promoted, not its siblings
+			_, err = os.Lstat(filepath.Join(tempdir, "b", "other"))
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "sibling of promoted hardlink was restored: %v", err)
+			_, err = res.VerifyFiles(context.TODO(), tempdir)
+			rtest.OK(t, err)
+		})
+	}
+}
+
+func TestRestorerReflink(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"first": File{Data: "content: duplicate\n", ModTime: modTime},
+			"dir": Dir{Nodes: map[string]Node{
+				"second": File{Data: "content: duplicate\n", ModTime: modTime},
+				"other":  File{Data: "content: other\n", ModTime: modTime},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	countingRepo := &blobCountingRepo{Repository: repo, loaded: make(map[restic.ID]int)}
+	res := NewRestorer(countingRepo, sn, Options{Reflink: true})
+
+	// reflinks are likely not supported by the test file system, thus this
+	// usually checks the fallback
+	first := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), first))
+	_, err := res.VerifyFiles(context.TODO(), first)
+	rtest.OK(t, err)
+	rtest.Equals(t, map[restic.ID]int{
+		restic.Hash([]byte("content: duplicate\n")): 1,
+		restic.Hash([]byte("content: other\n")):     1,
+	}, countingRepo.loaded)
+
+	// unchanged files of the previous restore are shared
+	second := rtest.TempDir(t)
+	rtest.OK(t, os.Chtimes(filepath.Join(first, "dir", "other"), time.Now(), time.Now()))
+	rtest.OK(t, res.RestoreTo(context.TODO(), second))
+	_, err = res.VerifyFiles(context.TODO(), second)
+	rtest.OK(t, err)
+	rtest.Equals(t, map[restic.ID]int{
+		restic.Hash([]byte("content: duplicate\n")): 1,
+		// the modified file is not used
+		restic.Hash([]byte("content: other\n")): 2,
+	}, countingRepo.loaded)
+}
+
+func TestRestorerDedupeByContent(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"first": File{Data: "content: duplicate\n", ModTime: modTime},
+			"dir": Dir{Nodes: map[string]Node{
+				"second":      File{Data: "content: duplicate\n", ModTime: modTime},
+				"independent": File{Data: "content: duplicate\n", ModTime: modTime},
+				"newer":       File{Data: "content: duplicate\n", ModTime: modTime.Add(time.Hour)},
+				"other":       File{Data: "content: other\n", ModTime: modTime},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{
+		DedupeByContent: true,
+		DedupeSkip: func(location string, _ *restic.Node) bool {
+			return location == filepath.FromSlash("/dir/independent")
+		},
+	})
+	tempdir := rtest.TempDir(t)
+	for i := 0; i < 2; i++ {
+		// the links are kept when restoring again
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+		count, err := res.VerifyFiles(context.TODO(), tempdir)
+		rtest.OK(t, err)
+		if i == 0 {
+			// like other hardlinks, the linked file is verified as the first one
+			rtest.Equals(t, 4, count)
+		}
+
+		first := filepath.Join(tempdir, "first")
+		for name, linked := range map[string]bool{
+			"second":      true,
+			"independent": false,
+			// hardlinks share the metadata
+			"newer": false,
+			"other": false,
+		} {
+			rtest.Equals(t, linked, sameFile(first, filepath.Join(tempdir, "dir", name)), name)
+		}
+		fi, err := os.Stat(filepath.Join(tempdir, "dir", "second"))
+		rtest.OK(t, err)
+		rtest.Equals(t, modTime, fi.ModTime().UTC())
+	}
+}
+
+// damagedBlobsRepo reports an error when loading the damaged blobs.
+type damagedBlobsRepo struct {
+	restic.Repository
+	damaged restic.IDSet
+}
+
+func (r damagedBlobsRepo) LoadBlobsFromPack(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+	return r.Repository.LoadBlobsFromPack(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+		if r.damaged.Has(blob.ID) {
+			return handleBlobFn(blob, nil, errors.New("blob is damaged"))
+		}
+		return handleBlobFn(blob, buf, err)
+	})
+}
+
+func TestRestorerCollectErrors(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"damaged": File{Data: "content: damaged\n"},
+				"intact":  File{Data: "content: intact\n"},
+			}},
+			"broken": File{Data: "content: broken\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	damaged := restic.NewIDSet(restic.Hash([]byte("content: damaged\n")), restic.Hash([]byte("content: broken\n")))
+	res := NewRestorer(damagedBlobsRepo{repo, damaged}, sn, Options{CollectErrors: true})
+	var reported []string
+	res.Error = func(location string, err error) error {
+		reported = append(reported, location)
+		return nil
+	}
+
+	tempdir := rtest.TempDir(t)
+	err := res.RestoreTo(context.TODO(), tempdir)
+	var restoreErrors RestoreErrors
+	rtest.Assert(t, errors.As(err, &restoreErrors), "unexpected error %v", err)
+	rtest.Equals(t, 2, len(restoreErrors))
+	// Error is still called for each error, the first one per item is kept
+	rtest.Assert(t, len(reported) >= 2, "unexpected reported errors %v", reported)
+	for _, location := range []string{"/broken", "/dir/damaged"} {
+		err := restoreErrors[filepath.FromSlash(location)]
+		rtest.Assert(t, err != nil && strings.Contains(err.Error(), "blob is damaged"), "unexpected error for %v: %v", location, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempdir, "dir", "intact"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: intact\n", string(data))
+
+	// without errors, the restore succeeds
+	res = NewRestorer(repo, sn, Options{CollectErrors: true})
+	rtest.OK(t, res.RestoreTo(context.TODO(), rtest.TempDir(t)))
+}
+
+// slowBlobsRepo delays loading the slow blobs until the context is done.
+type slowBlobsRepo struct {
+	restic.Repository
+	slow restic.IDSet
+}
+
+func (r slowBlobsRepo) LoadBlobsFromPack(ctx context.Context, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+	var delayed []restic.BlobHandle
+	err := r.Repository.LoadBlobsFromPack(ctx, packID, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+		if r.slow.Has(blob.ID) {
+			delayed = append(delayed, blob)
+			return nil
+		}
+		return handleBlobFn(blob, buf, err)
+	})
+	if err != nil || len(delayed) == 0 {
+		return err
+	}
+	<-ctx.Done()
+	for _, blob := range delayed {
+		if err := handleBlobFn(blob, nil, ctx.Err()); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func TestRestorerDeadline(t *testing.T) {
+	repo := repository.TestRepository(t)
+	mtime := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"fast": File{Data: "content: fast\n", ModTime: mtime},
+			}},
+			"slow": File{Data: "content: slow\n", ModTime: mtime},
+		},
+	}, noopGetGenericAttributes)
+
+	slow := restic.NewIDSet(restic.Hash([]byte("content: slow\n")))
+	res := NewRestorer(slowBlobsRepo{repo, slow}, sn, Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	tempdir := rtest.TempDir(t)
+	err := res.RestoreTo(ctx, tempdir)
+	rtest.Assert(t, errors.Is(err, ErrDeadlineExceeded), "unexpected error %v", err)
+	rtest.Assert(t, errors.Is(err, context.DeadlineExceeded), "error %v does not match context.DeadlineExceeded", err)
+
+	// the completed file is valid, the incomplete one is removed
+	fast := filepath.Join(tempdir, "dir", "fast")
+	data, err := os.ReadFile(fast)
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: fast\n", string(data))
+	fi, err := os.Stat(fast)
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(mtime), "unexpected mtime %v", fi.ModTime())
+	_, err = os.Stat(filepath.Join(tempdir, "slow"))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "incomplete file was not removed: %v", err)
+
+	rtest.Equals(t, uint64(1), res.Stats().FilesRestored)
+}
+
+func TestRestorerUnsupportedNode(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"door": Special{Type: "door", Mode: 0640},
+			"foo":  File{Data: "content: foo\n"},
+		},
+	}, noopGetGenericAttributes)
+	ctx := context.TODO()
+
+	// by default, an error is reported
+	res := NewRestorer(repo, sn, Options{})
+	var errs []string
+	res.Error = func(location string, err error) error {
+		errs = append(errs, location)
+		return nil
+	}
+	rtest.OK(t, res.RestoreTo(ctx, rtest.TempDir(t)))
+	rtest.Equals(t, []string{filepath.FromSlash("/door")}, errs)
+
+	for _, test := range []struct {
+		action UnsupportedAction
+		exists bool
+	}{
+		{UnsupportedSkip, false},
+		{UnsupportedRestoreAsEmptyFile, true},
+	} {
+		var types []string
+		res := NewRestorer(repo, sn, Options{OnUnsupportedNode: func(node *restic.Node) UnsupportedAction {
+			types = append(types, node.Type)
+			return test.action
+		}})
+		tempdir := rtest.TempDir(t)
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+		rtest.Assert(t, len(types) > 0, "OnUnsupportedNode was not called")
+		for _, nodeType := range types {
+			rtest.Equals(t, "door", nodeType)
+		}
+
+		fi, err := os.Stat(filepath.Join(tempdir, "door"))
+		if test.exists {
+			rtest.OK(t, err)
+			rtest.Assert(t, fi.Mode().IsRegular() && fi.Size() == 0, "unexpected file %v", fi)
+		} else {
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected error %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(tempdir, "foo"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: foo\n", string(data))
+	}
+}
+
+func TestRestorerParallelMetadata(t *testing.T) {
+	base := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+	files := make(map[string]Node)
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("file%02d", i)] = File{
+			Data:    fmt.Sprintf("content: %d\n", i),
+			ModTime: base.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	// visited before the files
+	files["a-sub"] = Dir{ModTime: base.Add(time.Hour), Nodes: map[string]Node{
+		"a": File{Data: "content: a\n", ModTime: base},
+		"b": File{Data: "content: b\n", ModTime: base},
+	}}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir":  Dir{ModTime: base.Add(2 * time.Hour), Nodes: files},
+			"root": File{Data: "content: root\n", ModTime: base},
+		},
+	}, noopGetGenericAttributes)
+
+	restore := func(workers int) (string, []string) {
+		var buf bytes.Buffer
+		res := NewRestorer(repo, sn, Options{MetadataWorkers: workers, EventWriter: &buf})
+		tempdir := rtest.TempDir(t)
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		var paths []string
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var event restoreEvent
+			rtest.OK(t, dec.Decode(&event))
+			if event.Action != "start" {
+				paths = append(paths, event.Action+" "+event.Path)
+			}
+		}
+		return tempdir, paths
+	}
+
+	_, sequential := restore(1)
+	tempdir, parallel := restore(16)
+	// events are reported in the same order
+	rtest.Equals(t, sequential, parallel)
+
+	for name, node := range files {
+		if _, ok := node.(File); !ok {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(tempdir, "dir", name))
+		rtest.OK(t, err)
+		rtest.Assert(t, fi.ModTime().Equal(node.(File).ModTime), "unexpected mtime %v of %v", fi.ModTime(), name)
+	}
+	// directories are finalized after their children
+	for name, mtime := range map[string]time.Time{
+		"dir":       base.Add(2 * time.Hour),
+		"dir/a-sub": base.Add(time.Hour),
+	} {
+		fi, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+		rtest.OK(t, err)
+		rtest.Assert(t, fi.ModTime().Equal(mtime), "unexpected mtime %v of %v", fi.ModTime(), name)
+	}
+}
+
+func TestRestorerFinalReadOnly(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Mode: normalizeFileMode(0755 | os.ModeDir), ModTime: timeForTest, Nodes: map[string]Node{
+				"foo": File{Data: "content: foo\n", Mode: normalizeFileMode(0644), ModTime: timeForTest},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	ctx := context.TODO()
+	for i := 0; i < 2; i++ {
+		// the second restore overwrites the read-only file
+		res := NewRestorer(repo, sn, Options{FinalReadOnly: true, Overwrite: OverwriteAlways})
+		rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+		target := filepath.Join(tempdir, "dir", "foo")
+		data, err := os.ReadFile(target)
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: foo\n", string(data))
+		fi, err := os.Stat(target)
+		rtest.OK(t, err)
+		rtest.Assert(t, fi.Mode().Perm()&0222 == 0, "file is writable: %v", fi.Mode())
+		// directories remain writable
+		fi, err = os.Stat(filepath.Join(tempdir, "dir"))
+		rtest.OK(t, err)
+		rtest.Assert(t, fi.Mode().Perm()&0200 != 0, "directory is not writable: %v", fi.Mode())
+
+		mismatches, err := res.VerifyMetadata(ctx, tempdir)
+		rtest.OK(t, err)
+		rtest.Equals(t, 0, len(mismatches), fmt.Sprintf("%v", mismatches))
+	}
+}
@@ -5,17 +5,23 @@ package restorer
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/errors"
+	resticfs "github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 	restoreui "github.com/restic/restic/internal/ui/restore"
+	"golang.org/x/sys/unix"
 )
 
 func TestRestorerRestoreEmptyHardlinkedFields(t *testing.T) {
@@ -117,9 +123,83 @@ func TestRestorerProgressBar(t *testing.T) {
 		AllBytesWritten: 10,
 		AllBytesTotal:   10,
 		AllBytesSkipped: 0,
+		Blobs: restoreui.BlobStats{
+			Fetched:         2,
+			BytesFetched:    10,
+			References:      2,
+			BytesReferenced: 10,
+		},
+		Packs: restoreui.PackStats{
+			Total:        1,
+			Finished:     1,
+			Fetched:      1,
+			BytesFetched: 92,
+		},
 	}, mock.s)
 }
 
+func TestRestorerBlobStats(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file1": File{Data: "content: shared\n"},
+			"file2": File{Data: "content: shared\n"},
+			"file3": File{Data: "content: unique\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	mock := &printerMock{}
+	progress := restoreui.NewProgress(mock, 0)
+	res := NewRestorer(repo, sn, Options{Progress: progress})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, rtest.TempDir(t)))
+	progress.Finish()
+
+	rtest.Equals(t, restoreui.BlobStats{
+		Fetched:         2,
+		BytesFetched:    32,
+		References:      3,
+		BytesReferenced: 48,
+	}, mock.s.Blobs)
+	rtest.Equals(t, uint64(16), mock.s.Blobs.BytesReused())
+}
+
+func TestRestorerPackStats(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file1": File{Data: "content: file1\n"},
+			"file2": File{Data: "content: file2\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	mock := &printerMock{}
+	progress := restoreui.NewProgress(mock, 0)
+	res := NewRestorer(repo, sn, Options{Progress: progress, PackCacheSize: 1 << 20})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the second restore is served from the pack cache
+	rtest.OK(t, res.RestoreTo(ctx, rtest.TempDir(t)))
+	rtest.OK(t, res.RestoreTo(ctx, rtest.TempDir(t)))
+	progress.Finish()
+
+	packs := mock.s.Packs
+	rtest.Equals(t, uint64(2), packs.Total)
+	rtest.Equals(t, uint64(2), packs.Finished)
+	rtest.Equals(t, uint64(1), packs.Fetched)
+	rtest.Assert(t, packs.BytesFetched > mock.s.Blobs.BytesFetched, "expected encrypted size larger than %v, got %v", mock.s.Blobs.BytesFetched, packs.BytesFetched)
+	rtest.Equals(t, uint64(2), packs.CacheHits)
+	rtest.Equals(t, uint64(2), packs.CacheMisses)
+	rtest.Equals(t, 0.5, packs.CacheHitRatio())
+}
+
 func TestRestorePermissions(t *testing.T) {
 	snapshot := Snapshot{
 		Nodes: map[string]Node{
@@ -150,3 +230,796 @@ func TestRestorePermissions(t *testing.T) {
 		rtest.Equals(t, fs.FileMode(0o600), fi.Mode().Perm(), "unexpected permissions")
 	}
 }
+
+func TestRestoreSpecialPermissions(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"setuid": File{Data: "content: setuid\n", Mode: 0o755 | os.ModeSetuid, ModTime: time.Now()},
+			"setgid": Dir{Mode: 0o755 | os.ModeDir | os.ModeSetgid, ModTime: time.Now()},
+			"sticky": Dir{Mode: 0o777 | os.ModeDir | os.ModeSticky, ModTime: time.Now()},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	tempdir := filepath.Join(rtest.TempDir(t), "target")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+	res := NewRestorer(repo, sn, Options{})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for name, mode := range map[string]os.FileMode{
+		"setuid": 0o755 | os.ModeSetuid,
+		"setgid": 0o755 | os.ModeDir | os.ModeSetgid,
+		"sticky": 0o777 | os.ModeDir | os.ModeSticky,
+	} {
+		fi, err := os.Stat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, mode, fi.Mode(), "unexpected mode for "+name)
+	}
+}
+
+func TestRestorerSymlinkEscape(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"subdir": Dir{
+						Nodes: map[string]Node{
+							"file": File{Data: "content: file\n"},
+						},
+					},
+				},
+			},
+		},
+	}
+	selectFile := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
+		return item == "/dir/subdir/file", true
+	}
+
+	for _, allow := range []bool{false, true} {
+		repo := repository.TestRepository(t)
+		sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+
+		tempdir := rtest.TempDir(t)
+		target := filepath.Join(tempdir, "target")
+		outside := filepath.Join(tempdir, "outside")
+		rtest.OK(t, os.Mkdir(target, 0700))
+		rtest.OK(t, os.Mkdir(outside, 0700))
+		rtest.OK(t, os.Symlink(outside, filepath.Join(target, "dir")))
+
+		res := NewRestorer(repo, sn, Options{AllowSymlinkedTarget: allow})
+		res.SelectFilter = selectFile
+		var errs []error
+		res.Error = func(location string, err error) error {
+			errs = append(errs, err)
+			return nil
+		}
+
+		rtest.OK(t, res.RestoreTo(context.TODO(), target))
+
+		_, err := os.Stat(filepath.Join(outside, "subdir", "file"))
+		if allow {
+			rtest.OK(t, err)
+			rtest.Equals(t, 0, len(errs))
+		} else {
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "file was restored outside of the target directory")
+			rtest.Equals(t, 1, len(errs))
+			rtest.Assert(t, strings.Contains(errs[0].Error(), "leads outside of the target directory"), "unexpected error %v", errs[0])
+		}
+	}
+}
+
+func TestRestorerTargetMode(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+		},
+	}
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, snapshot, noopGetGenericAttributes)
+
+	mode := os.FileMode(0o510)
+	res := NewRestorer(repo, sn, Options{TargetMode: &mode})
+
+	tempdir := filepath.Join(rtest.TempDir(t), "target")
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	defer func() {
+		rtest.OK(t, os.Chmod(tempdir, 0o700))
+	}()
+
+	fi, err := os.Stat(tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, mode|os.ModeDir, fi.Mode())
+
+	count, err := res.VerifyFiles(context.TODO(), tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, count)
+}
+
+func TestRestorerSpecialFiles(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"fifo":    Special{Type: "fifo", Mode: os.ModeNamedPipe | 0640},
+					"socket":  Special{Type: "socket", Mode: os.ModeSocket | 0755},
+					"chardev": Special{Type: "chardev", Mode: os.ModeDevice | os.ModeCharDevice | 0600, Device: uint64(unix.Mkdev(1, 3))},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{})
+	var warnings []string
+	res.Warn = func(message string) {
+		warnings = append(warnings, message)
+	}
+	// sockets are skipped before SelectFilter is consulted
+	selected := make(map[string]struct{})
+	res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+		selected[item] = struct{}{}
+		return true, true
+	}
+	errs := make(map[string]error)
+	res.Error = func(location string, err error) error {
+		errs[location] = err
+		return nil
+	}
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	fi, err := os.Lstat(filepath.Join(tempdir, "dir", "fifo"))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.ModeNamedPipe|0640, fi.Mode())
+
+	_, err = os.Lstat(filepath.Join(tempdir, "dir", "socket"))
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected socket to be skipped, got %v", err)
+	rtest.Equals(t, []string{"skipping socket /dir/socket"}, warnings)
+	_, ok := selected[filepath.FromSlash("/dir/socket")]
+	rtest.Assert(t, !ok, "SelectFilter was called for the socket")
+
+	// creating device nodes requires sufficient privileges, otherwise the error is reported
+	fi, err = os.Lstat(filepath.Join(tempdir, "dir", "chardev"))
+	if err == nil {
+		rtest.Equals(t, os.ModeDevice|os.ModeCharDevice|0600, fi.Mode())
+		rtest.Equals(t, 0, len(errs))
+	} else {
+		rtest.Assert(t, errs["/dir/chardev"] != nil, "expected error for chardev, got %v", errs)
+		rtest.Equals(t, 1, len(errs))
+	}
+}
+
+func TestRestorerOriginalLocations(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"etc": Dir{
+				Nodes: map[string]Node{
+					"conf":  File{Data: "content: conf\n"},
+					"other": File{Data: "content: other\n"},
+				},
+			},
+			"home": Dir{
+				Nodes: map[string]Node{
+					"user": Dir{
+						Nodes: map[string]Node{
+							"file": File{Data: "content: file\n"},
+						},
+					},
+				},
+			},
+			"skip": File{Data: "content: skip\n"},
+		},
+	}, noopGetGenericAttributes)
+	sn.Paths = []string{"/etc/conf", "/home/user"}
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := NewRestorer(repo, sn, Options{})
+	err := res.restoreToOriginalLocations(ctx, tempdir)
+	rtest.Assert(t, err != nil, "expected error without confirmation")
+
+	res = NewRestorer(repo, sn, Options{ConfirmOriginalLocations: true})
+	rtest.OK(t, res.restoreToOriginalLocations(ctx, tempdir))
+
+	for _, name := range []string{"etc/conf", "home/user/file"} {
+		_, err := os.Stat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+	}
+	for _, name := range []string{"etc/other", "skip"} {
+		_, err := os.Stat(filepath.Join(tempdir, name))
+		rtest.Assert(t, errors.Is(err, os.ErrNotExist), "expected %v to not be restored, got %v", name, err)
+	}
+}
+
+func TestRestorerAtomicReplace(t *testing.T) {
+	repo := repository.TestRepository(t)
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file": File{Data: "content: old\n"},
+		},
+	}, noopGetGenericAttributes)
+	rtest.OK(t, NewRestorer(repo, sn, Options{}).RestoreTo(ctx, tempdir))
+
+	// a reader of the old file must not observe the restore
+	f, err := os.Open(filepath.Join(tempdir, "file"))
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, f.Close())
+	}()
+
+	sn, _ = saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file":     File{Data: "content: new file\n"},
+			"empty":    File{},
+			"hardlink": File{Data: "content: link\n", Inode: 42, Links: 2},
+			"link2":    File{Data: "content: link\n", Inode: 42, Links: 2},
+		},
+	}, noopGetGenericAttributes)
+	res := NewRestorer(repo, sn, Options{AtomicReplace: true})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	data, err := io.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: old\n", string(data))
+
+	entries, err := os.ReadDir(tempdir)
+	rtest.OK(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	rtest.Equals(t, []string{"empty", "file", "hardlink", "link2"}, names)
+
+	n, err := res.VerifyFiles(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, n)
+
+	fi1, err := os.Stat(filepath.Join(tempdir, "hardlink"))
+	rtest.OK(t, err)
+	fi2, err := os.Stat(filepath.Join(tempdir, "link2"))
+	rtest.OK(t, err)
+	rtest.Assert(t, os.SameFile(fi1, fi2), "hardlinks were not restored")
+}
+
+func TestRestorerAtomicReplaceTempDir(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+			"file": File{Data: "content: other file\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	base := rtest.TempDir(t)
+	target := filepath.Join(base, "target")
+	scratch := filepath.Join(base, "scratch")
+	rtest.OK(t, os.Mkdir(scratch, 0700))
+
+	var staged []int
+	res := NewRestorer(repo, sn, Options{
+		TempDir: scratch,
+		// called while the temporary files exist
+		ContentTransform: func(_ *restic.Node, _ io.Writer) (io.WriteCloser, error) {
+			entries, err := os.ReadDir(scratch)
+			rtest.OK(t, err)
+			staged = append(staged, len(entries))
+			return nil, nil
+		},
+	})
+	rtest.OK(t, res.RestoreTo(context.TODO(), target))
+	// the temporary files are replaced one after another
+	rtest.Equals(t, []int{2, 1}, staged)
+
+	for name, content := range map[string]string{
+		"dir/file": "content: file\n",
+		"file":     "content: other file\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data))
+	}
+	entries, err := os.ReadDir(scratch)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(entries))
+	entries, err = os.ReadDir(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(entries))
+
+	res = NewRestorer(repo, sn, Options{AtomicReplace: true, TempDir: filepath.Join(base, "missing")})
+	err = res.RestoreTo(context.TODO(), target)
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected error %v", err)
+}
+
+func TestRestorerVerifyMetadata(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Mode:    0750 | os.ModeDir,
+				ModTime: timeForTest,
+				Nodes: map[string]Node{
+					"file": File{
+						Mode:    0640,
+						ModTime: timeForTest,
+						Data:    "content: file\n",
+					},
+					"link": Symlink{Target: "file", ModTime: timeForTest},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := NewRestorer(repo, sn, Options{})
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	mismatches, err := res.VerifyMetadata(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Assert(t, len(mismatches) == 0, "unexpected mismatches %v", mismatches)
+
+	file := filepath.Join(tempdir, "dir", "file")
+	link := filepath.Join(tempdir, "dir", "link")
+	rtest.OK(t, os.Chmod(file, 0600))
+	rtest.OK(t, os.Remove(link))
+	rtest.OK(t, os.Symlink("other", link))
+	// restore the directory mtime changed by replacing the symlink
+	rtest.OK(t, os.Chtimes(filepath.Join(tempdir, "dir"), timeForTest, timeForTest))
+
+	mismatches, err = res.VerifyMetadata(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, []MetadataMismatch{
+		{Path: file, Field: "mode", Expected: "-rw-r-----", Actual: "-rw-------"},
+		{Path: link, Field: "linktarget", Expected: "file", Actual: "other"},
+	}, mismatches)
+}
+
+func TestRestorerRelaxDirModes(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Mode: 0555 | os.ModeDir,
+				Nodes: map[string]Node{
+					"bad":  File{Data: "content: bad\n"},
+					"good": File{Data: "content: good\n"},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	dir := filepath.Join(tempdir, "dir")
+	// an existing restrictive directory is writable during the restore
+	rtest.OK(t, os.Mkdir(dir, 0500))
+	t.Cleanup(func() {
+		_ = os.Chmod(dir, 0700)
+	})
+
+	var relaxedMode os.FileMode
+	res := NewRestorer(repo, sn, Options{
+		RelaxDirModesDuringRestore: true,
+		OnDirCreated: func(path string, _ *restic.Node) error {
+			fi, err := os.Stat(path)
+			relaxedMode = fi.Mode().Perm()
+			return err
+		},
+		// the failing child aborts the restore before leaving dir
+		ContentTransform: func(node *restic.Node, _ io.Writer) (io.WriteCloser, error) {
+			if node.Name == "bad" {
+				return nil, errors.New("transform failed")
+			}
+			return nil, nil
+		},
+	})
+	err := res.RestoreTo(context.TODO(), tempdir)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "transform failed"), "unexpected error %v", err)
+	rtest.Equals(t, os.FileMode(0700), relaxedMode)
+
+	fi, err := os.Stat(dir)
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0555), fi.Mode().Perm())
+}
+
+func TestRestorerHardlinkPolicy(t *testing.T) {
+	baseSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"hardlink": File{Data: "content: file\n"},
+		},
+	}
+	overwriteSnapshot := Snapshot{
+		Nodes: map[string]Node{
+			"file":     File{Data: "content: file\n", Inode: 42, Links: 2},
+			"hardlink": File{Data: "content: file\n", Inode: 42, Links: 2},
+		},
+	}
+
+	inode := func(t *testing.T, path string) uint64 {
+		fi, err := os.Lstat(path)
+		rtest.OK(t, err)
+		return fi.Sys().(*syscall.Stat_t).Ino
+	}
+
+	for _, test := range []struct {
+		policy HardlinkPolicy
+		relink bool
+	}{
+		{RelinkExisting, true},
+		{AlwaysRecreate, false},
+	} {
+		t.Run("", func(t *testing.T) {
+			repo := repository.TestRepository(t)
+			tempdir := rtest.TempDir(t)
+			opts := Options{HardlinkPolicy: test.policy}
+
+			sn, _ := saveSnapshot(t, repo, baseSnapshot, noopGetGenericAttributes)
+			rtest.OK(t, NewRestorer(repo, sn, opts).RestoreTo(context.TODO(), tempdir))
+			existing := inode(t, filepath.Join(tempdir, "hardlink"))
+
+			sn, _ = saveSnapshot(t, repo, overwriteSnapshot, noopGetGenericAttributes)
+			res := NewRestorer(repo, sn, opts)
+			rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+			_, err := res.VerifyFiles(context.TODO(), tempdir)
+			rtest.OK(t, err)
+
+			file := inode(t, filepath.Join(tempdir, "file"))
+			rtest.Equals(t, file, inode(t, filepath.Join(tempdir, "hardlink")))
+			rtest.Equals(t, test.relink, file == existing)
+		})
+	}
+}
+
+func TestRestorerAlternateDataStreams(t *testing.T) {
+	streams := map[string][]byte{"Zone.Identifier": []byte("[ZoneTransfer]\r\nZoneId=3\r\n")}
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"file": File{Data: "content: file\n"},
+		},
+	}, func(_ *FileAttributes, isDir bool) map[restic.GenericAttributeType]json.RawMessage {
+		if isDir {
+			return nil
+		}
+		node := restic.Node{}
+		rtest.OK(t, node.SetAlternateDataStreams(streams))
+		return node.GenericAttributes
+	})
+
+	for _, sidecars := range []bool{false, true} {
+		tempdir := rtest.TempDir(t)
+		var warnings []string
+		res := NewRestorer(repo, sn, Options{AlternateDataStreamSidecars: sidecars})
+		res.Warn = func(msg string) {
+			warnings = append(warnings, msg)
+		}
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		data, err := os.ReadFile(filepath.Join(tempdir, "file:Zone.Identifier"))
+		if sidecars {
+			rtest.OK(t, err)
+			rtest.Equals(t, streams["Zone.Identifier"], data)
+			rtest.Equals(t, 0, len(warnings))
+		} else {
+			rtest.Assert(t, errors.Is(err, os.ErrNotExist), "unexpected sidecar file, error %v", err)
+			rtest.Equals(t, 1, len(warnings))
+		}
+	}
+}
+
+func TestRestorerIdempotent(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Mode:    0750 | os.ModeDir,
+				ModTime: timeForTest,
+				Nodes: map[string]Node{
+					"file":     File{Mode: 0640, ModTime: timeForTest, Data: "content: file\n"},
+					"empty":    File{Mode: 0600, ModTime: timeForTest},
+					"hardlink": File{Mode: 0640, ModTime: timeForTest, Data: "content: link\n", Inode: 42, Links: 2},
+					"other":    File{Mode: 0640, ModTime: timeForTest, Data: "content: link\n", Inode: 42, Links: 2},
+					"link":     Symlink{Target: "file", ModTime: timeForTest},
+				},
+			},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	opts := Options{Overwrite: OverwriteIfChanged}
+
+	res := NewRestorer(repo, sn, opts)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	rtest.Assert(t, res.Stats().Changed(), "first restore did not report changes: %+v", res.Stats())
+
+	// every write and metadata change updates the ctime
+	ctimes := func() map[string]time.Time {
+		result := make(map[string]time.Time)
+		rtest.OK(t, filepath.Walk(tempdir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			result[path] = resticfs.ExtendedStat(fi).ChangeTime
+			return nil
+		}))
+		return result
+	}
+	before := ctimes()
+	// ensure that a change would result in a different ctime
+	time.Sleep(20 * time.Millisecond)
+
+	for _, overwrite := range []OverwriteBehavior{OverwriteIfChanged, OverwriteIfContentChanged} {
+		res = NewRestorer(repo, sn, Options{Overwrite: overwrite})
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+		rtest.Equals(t, RestoreStats{FilesSkipped: 5, DirsSkipped: 1}, res.Stats())
+		rtest.Assert(t, !res.Stats().Changed(), "repeated restore reported changes")
+		rtest.Equals(t, before, ctimes())
+	}
+
+	// only the modified file is updated
+	rtest.OK(t, os.Chmod(filepath.Join(tempdir, "dir", "file"), 0600))
+	res = NewRestorer(repo, sn, opts)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	rtest.Equals(t, RestoreStats{FilesUpdated: 1, FilesSkipped: 4, DirsSkipped: 1}, res.Stats())
+}
+
+func TestRestorerRewriteSymlinkTarget(t *testing.T) {
+	timeForTest := time.Date(2019, time.January, 9, 1, 46, 40, 0, time.UTC)
+
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"data": Dir{ModTime: timeForTest, Nodes: map[string]Node{
+				"project": Dir{ModTime: timeForTest, Nodes: map[string]Node{
+					"file":     File{Data: "content: file\n", ModTime: timeForTest},
+					"absolute": Symlink{Target: "/data/project/file"},
+					"dangling": Symlink{Target: "/data/project/missing"},
+					"relative": Symlink{Target: "../project/file"},
+				}},
+				"up": Symlink{Target: "/data/project/file"},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	tempdir := rtest.TempDir(t)
+	var warnings []string
+	res := NewRestorer(repo, sn, Options{
+		RewriteSymlinkTarget: RelativeSymlinkTarget(tempdir),
+		WarnDanglingSymlinks: true,
+	})
+	res.Warn = func(message string) {
+		warnings = append(warnings, message)
+	}
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	for link, expected := range map[string]string{
+		"data/project/absolute": "file",
+		"data/project/dangling": "missing",
+		"data/project/relative": "../project/file",
+		"data/up":               "project/file",
+	} {
+		linkTarget, err := os.Readlink(filepath.Join(tempdir, link))
+		rtest.OK(t, err)
+		rtest.Equals(t, expected, linkTarget, link)
+	}
+	rtest.Equals(t, 1, len(warnings))
+	rtest.Assert(t, strings.Contains(warnings[0], "dangling"), "unexpected warning %v", warnings[0])
+
+	mismatches, err := res.VerifyMetadata(context.TODO(), tempdir)
+	rtest.OK(t, err)
+	rtest.Assert(t, len(mismatches) == 0, "unexpected mismatches %v", mismatches)
+}
+
+func TestRestorerRewriteSymlinkTargetIfChanged(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"link": Symlink{Target: "file"},
+		},
+	}, noopGetGenericAttributes)
+
+	// the rewrite is not idempotent, thus applying it twice is noticed
+	opts := Options{
+		Overwrite: OverwriteIfChanged,
+		RewriteSymlinkTarget: func(target, _ string) string {
+			return "rewritten-" + target
+		},
+	}
+	tempdir := rtest.TempDir(t)
+	res := NewRestorer(repo, sn, opts)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	linkTarget, err := os.Readlink(filepath.Join(tempdir, "link"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "rewritten-file", linkTarget)
+
+	// the existing symlink already has the rewritten target
+	res = NewRestorer(repo, sn, opts)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	rtest.Assert(t, !res.Stats().Changed(), "unexpected changes %+v", res.Stats())
+
+	mismatches, err := res.VerifyMetadata(context.TODO(), tempdir)
+	rtest.OK(t, err)
+	rtest.Assert(t, len(mismatches) == 0, "unexpected mismatches %v", mismatches)
+}
+
+func TestRestorerStrictOwnership(t *testing.T) {
+	target := filepath.Join(rtest.TempDir(t), "file")
+	rtest.OK(t, os.WriteFile(target, []byte("content"), 0644))
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	node := &restic.Node{Name: "file", Type: "file", Mode: 0644, User: "alice", UID: uid, GID: gid}
+	lookup := func(name string) (uint32, bool) {
+		return uid, name == "alice"
+	}
+
+	// names which cannot be resolved are ignored unless in strict mode
+	res := NewRestorer(nil, nil, Options{GroupLookup: lookup})
+	rtest.OK(t, res.restoreNodeMetadataTo(node, target, "/file"))
+	res = NewRestorer(nil, nil, Options{UserLookup: lookup, StrictOwnership: true})
+	rtest.OK(t, res.restoreNodeMetadataTo(node, target, "/file"))
+	nodeGroup := *node
+	nodeGroup.Group = "staff"
+	res = NewRestorer(nil, nil, Options{GroupLookup: lookup, StrictOwnership: true, BestEffortMetadata: true})
+	err := res.restoreNodeMetadataTo(&nodeGroup, target, "/file")
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), `group "staff" not found`), "unexpected error %v", err)
+
+	if os.Geteuid() == 0 {
+		t.Skip("the owner can always be changed as root")
+	}
+	// permission errors of lchown are only ignored without strict mode
+	nodeRoot := &restic.Node{Name: "file", Type: "file", Mode: 0644, UID: 0, GID: 0}
+	res = NewRestorer(nil, nil, Options{})
+	rtest.OK(t, res.restoreNodeMetadataTo(nodeRoot, target, "/file"))
+	res = NewRestorer(nil, nil, Options{StrictOwnership: true})
+	err = res.restoreNodeMetadataTo(nodeRoot, target, "/file")
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "owner is"), "unexpected error %v", err)
+}
+
+func TestRestorerRefuseMountpoints(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"file": File{Data: "content: file\n"},
+			}},
+		},
+	}, noopGetGenericAttributes)
+
+	// without any mountpoints within the target, the restore is unaffected
+	res := NewRestorer(repo, sn, Options{RefuseMountpoints: true})
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	data, err := os.ReadFile(filepath.Join(tempdir, "dir", "file"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "content: file\n", string(data))
+
+	// pretend that dir/mnt is a mountpoint
+	rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, "dir", "mnt", "sub"), 0700))
+	mnt := filepath.Join(tempdir, "dir", "mnt")
+	newChecker := func(allowed ...string) *mountpointChecker {
+		c := newMountpointChecker(tempdir, allowed)
+		c.deviceID = func(dir string) (uint64, error) {
+			if _, err := os.Lstat(dir); err != nil {
+				return 0, err
+			}
+			if resticfs.HasPathPrefix(mnt, dir) {
+				return 2, nil
+			}
+			return 1, nil
+		}
+		return c
+	}
+
+	c := newChecker()
+	for _, target := range []string{"dir", "dir/file", "dir/missing/file"} {
+		ok, err := c.check(filepath.Join(tempdir, filepath.FromSlash(target)))
+		rtest.Assert(t, ok && err == nil, "unexpected result for %v: %v %v", target, ok, err)
+	}
+	ok, err := c.check(mnt)
+	rtest.Assert(t, !ok && err != nil && strings.Contains(err.Error(), "refusing to restore into mountpoint"), "unexpected result %v %v", ok, err)
+	// the error is only reported once, children are also refused
+	ok, err = c.check(filepath.Join(mnt, "sub", "file"))
+	rtest.Assert(t, !ok && err == nil, "unexpected result %v %v", ok, err)
+
+	c = newChecker(mnt)
+	ok, err = c.check(filepath.Join(mnt, "sub", "file"))
+	rtest.Assert(t, ok && err == nil, "unexpected result for an allowed mountpoint: %v %v", ok, err)
+}
+
+func TestRestorerCheckPrivileges(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	otherUID := uint32(4242)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{Nodes: map[string]Node{
+				"setuid":  File{Data: "setuid", Mode: os.ModeSetuid | 0755},
+				"symlink": Symlink{Target: "setuid", UID: &otherUID},
+			}},
+			"null": Special{Type: "chardev", Mode: os.ModeDevice | os.ModeCharDevice | 0666},
+			"fifo": Special{Type: "fifo", Mode: os.ModeNamedPipe | 0600},
+		},
+	}, noopGetGenericAttributes)
+	res := NewRestorer(repo, sn, Options{})
+
+	problems, err := res.privilegeProblems(context.TODO(), processIdentity{privileged: true})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(problems))
+
+	current := processIdentity{
+		uid:    uint32(os.Getuid()),
+		groups: map[uint32]struct{}{uint32(os.Getgid()): {}},
+	}
+	problems, err = res.privilegeProblems(context.TODO(), current)
+	rtest.OK(t, err)
+	rtest.Equals(t, []PrivilegeProblem{
+		{Operation: PrivilegeChown, Path: "/dir/symlink", Count: 1},
+		{Operation: PrivilegeDeviceNodes, Path: "/null", Count: 1},
+	}, problems)
+
+	// for another user, every item requires a chown
+	other := processIdentity{uid: otherUID, groups: map[uint32]struct{}{}}
+	problems, err = res.privilegeProblems(context.TODO(), other)
+	rtest.OK(t, err)
+	rtest.Equals(t, []PrivilegeProblem{
+		{Operation: PrivilegeChown, Path: "/dir", Count: 5},
+		{Operation: PrivilegeSetuid, Path: "/dir/setuid", Count: 1},
+		{Operation: PrivilegeDeviceNodes, Path: "/null", Count: 1},
+	}, problems)
+}
+
+func TestRestorerRestoreToManyExistingInLaterTarget(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n", Mode: 0644, ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}, noopGetGenericAttributes)
+	existingTime := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, atomic := range []bool{false, true} {
+		base := rtest.TempDir(t)
+		targets := []string{filepath.Join(base, "a"), filepath.Join(base, "b")}
+		existing := filepath.Join(targets[1], "foo")
+		rtest.OK(t, os.Mkdir(targets[1], 0700))
+		rtest.OK(t, os.WriteFile(existing, []byte("other\n"), 0600))
+		rtest.OK(t, os.Chtimes(existing, existingTime, existingTime))
+
+		res := NewRestorer(repo, sn, Options{Overwrite: OverwriteNever, AtomicReplace: atomic})
+		rtest.OK(t, res.RestoreToMany(context.TODO(), targets))
+
+		data, err := os.ReadFile(filepath.Join(targets[0], "foo"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content: foo\n", string(data))
+
+		// the file skipped in the second target is left untouched
+		data, err = os.ReadFile(existing)
+		rtest.OK(t, err)
+		rtest.Equals(t, "other\n", string(data))
+		fi, err := os.Lstat(existing)
+		rtest.OK(t, err)
+		rtest.Equals(t, os.FileMode(0600), fi.Mode())
+		rtest.Equals(t, existingTime, fi.ModTime().UTC())
+	}
+}
@@ -9,12 +9,15 @@ import (
 	"math"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 	"unsafe"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/test"
@@ -43,6 +46,36 @@ func getBlockCount(t *testing.T, filename string) int64 {
 	return int64(math.Ceil(float64(result) / 512))
 }
 
+func TestRestorerSparseFilesWindows(t *testing.T) {
+	zeros := strings.Repeat("\x00", 1<<20)
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"zeros": File{Data: zeros},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{Sparse: true})
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	target := filepath.Join(tempdir, "zeros")
+	data, err := os.ReadFile(target)
+	rtest.OK(t, err)
+	rtest.Equals(t, zeros, string(data))
+
+	holes, err := fs.SparseHoles(target)
+	if errors.Is(err, fs.ErrSparseUnsupported) {
+		t.Skip("the file system does not support sparse files")
+	}
+	rtest.OK(t, err)
+	rtest.Equals(t, []fs.SparseHole{{Offset: 0, Length: int64(len(zeros))}}, holes)
+
+	blocks := getBlockCount(t, target)
+	denseBlocks := int64(len(zeros) / 512)
+	rtest.Assert(t, blocks >= 0 && blocks < denseBlocks, "expected fewer than %d allocated blocks, got %d", denseBlocks, blocks)
+}
+
 type DataStreamInfo struct {
 	name string
 	data string
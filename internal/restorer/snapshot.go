@@ -0,0 +1,29 @@
+package restorer
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// OpenSnapshot loads the snapshot referenced by ref, which is either
+// "latest", a full snapshot ID or a unique prefix of one, optionally followed
+// by ":subfolder". For "latest", the newest snapshot matching filter is
+// returned. A snapshot referenced by its ID must match filter as well. An
+// ambiguous prefix results in a *restic.MultipleIDMatchesError. The returned
+// subfolder can be passed to RestoreSubtree.
+func OpenSnapshot(ctx context.Context, repo restic.ListerLoaderUnpacked, ref string, filter restic.SnapshotFilter) (*restic.Snapshot, string, error) {
+	sn, subfolder, err := filter.FindLatest(ctx, repo, repo, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	ok, err := filter.Matches(sn)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", errors.Errorf("snapshot %v does not match the filter", sn.ID().Str())
+	}
+	return sn, subfolder, nil
+}
@@ -0,0 +1,58 @@
+package restorer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestOpenSnapshot(t *testing.T) {
+	repo := repository.TestRepository(t)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var ids restic.IDs
+	for i, host := range []string{"foo", "bar", "foo"} {
+		sn, err := restic.NewSnapshot([]string{"/data"}, nil, host, base.Add(time.Duration(i)*time.Hour))
+		rtest.OK(t, err)
+		tree := restic.NewRandomID()
+		sn.Tree = &tree
+		id, err := restic.SaveSnapshot(context.TODO(), repo, sn)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+
+	for _, test := range []struct {
+		ref       string
+		filter    restic.SnapshotFilter
+		id        restic.ID
+		subfolder string
+	}{
+		{"latest", restic.SnapshotFilter{}, ids[2], ""},
+		{"latest", restic.SnapshotFilter{HostPatterns: []string{"bar"}}, ids[1], ""},
+		{"latest:data/sub", restic.SnapshotFilter{HostPatterns: []string{"bar"}}, ids[1], "data/sub"},
+		{ids[0].String(), restic.SnapshotFilter{}, ids[0], ""},
+		{ids[1].String()[:16], restic.SnapshotFilter{}, ids[1], ""},
+		{ids[1].String()[:16] + ":data", restic.SnapshotFilter{}, ids[1], "data"},
+	} {
+		sn, subfolder, err := OpenSnapshot(context.TODO(), repo, test.ref, test.filter)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.id, *sn.ID())
+		rtest.Equals(t, test.subfolder, subfolder)
+	}
+
+	_, _, err := OpenSnapshot(context.TODO(), repo, "latest", restic.SnapshotFilter{HostPatterns: []string{"other"}})
+	rtest.Assert(t, errors.Is(err, restic.ErrNoSnapshotFound), "unexpected error %v", err)
+
+	_, _, err = OpenSnapshot(context.TODO(), repo, ids[1].String(), restic.SnapshotFilter{HostPatterns: []string{"foo"}})
+	rtest.Assert(t, err != nil, "expected error for snapshot not matching the filter")
+
+	// the empty prefix matches all snapshots
+	_, _, err = OpenSnapshot(context.TODO(), repo, "", restic.SnapshotFilter{})
+	var ambiguous *restic.MultipleIDMatchesError
+	rtest.Assert(t, errors.As(err, &ambiguous), "unexpected error %v", err)
+}
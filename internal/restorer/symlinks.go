@@ -0,0 +1,67 @@
+package restorer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// RelativeSymlinkTarget returns a function for Options.RewriteSymlinkTarget
+// which converts absolute symlink targets into targets relative to the
+// directory of the link. root is the directory to which the root of the
+// snapshot is restored, thus an absolute target /data/x refers to
+// root/data/x. On Windows, the volume name of a target like C:\data\x is
+// mapped to the directory C below root, as in the snapshot. Relative targets
+// are kept unchanged.
+func RelativeSymlinkTarget(root string) func(target, linkDir string) string {
+	return func(target, linkDir string) string {
+		if !filepath.IsAbs(target) {
+			return target
+		}
+		vol := filepath.VolumeName(target)
+		path := filepath.Join(root, strings.TrimSuffix(vol, ":"), target[len(vol):])
+		rel, err := filepath.Rel(linkDir, path)
+		if err != nil {
+			return target
+		}
+		return rel
+	}
+}
+
+// rewriteSymlink returns node with the link target rewritten by
+// Options.RewriteSymlinkTarget for a symlink restored at target. All other
+// nodes are returned unchanged.
+func (res *Restorer) rewriteSymlink(node *restic.Node, target string) *restic.Node {
+	if node.Type != "symlink" || res.opts.RewriteSymlinkTarget == nil {
+		return node
+	}
+	linkTarget := res.opts.RewriteSymlinkTarget(node.LinkTarget, filepath.Dir(target))
+	if linkTarget == node.LinkTarget {
+		return node
+	}
+	// do not modify the cached node
+	rewritten := *node
+	rewritten.LinkTarget = linkTarget
+	return &rewritten
+}
+
+// warnDanglingSymlinks reports each symlink in links whose target does not
+// exist, see Options.WarnDanglingSymlinks.
+func (res *Restorer) warnDanglingSymlinks(links []string) error {
+	for _, link := range links {
+		_, err := fs.Stat(link)
+		if errors.Is(err, os.ErrNotExist) {
+			linkTarget, err := fs.Readlink(link)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			res.warn(fmt.Sprintf("%v: rewritten symlink target %v does not exist", link, linkTarget))
+		}
+	}
+	return nil
+}
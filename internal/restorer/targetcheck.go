@@ -0,0 +1,109 @@
+package restorer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// targetChecker verifies that the parent directories of a restored item do not
+// contain a symlink which leads outside of the restore target. Otherwise, a
+// pre-existing symlink in the target directory could redirect writes to an
+// arbitrary location.
+type targetChecker struct {
+	dst  string
+	root string // dst with all symlinks resolved
+
+	checked  map[string]error
+	reported map[string]struct{}
+}
+
+func newTargetChecker(dst string) *targetChecker {
+	return &targetChecker{
+		dst:      dst,
+		root:     evalSymlinksPartial(dst),
+		checked:  make(map[string]error),
+		reported: make(map[string]struct{}),
+	}
+}
+
+// evalSymlinksPartial resolves all symlinks in the longest existing prefix of
+// path and appends the remaining, not yet existing, path components.
+func evalSymlinksPartial(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+	return filepath.Join(evalSymlinksPartial(parent), filepath.Base(path))
+}
+
+// check verifies the parent directories of target. If a problem is found, ok
+// is false. The error describing the problem is only returned once per
+// offending directory, later calls just return ok == false and a nil error.
+func (c *targetChecker) check(target string) (ok bool, err error) {
+	rel, err := filepath.Rel(c.dst, filepath.Dir(target))
+	if err != nil {
+		return false, err
+	}
+	if rel == "." {
+		return true, nil
+	}
+
+	dir := c.dst
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+
+		err, found := c.checked[dir]
+		if !found {
+			var exists bool
+			exists, err = c.checkDir(dir)
+			if !exists {
+				// nothing below dir exists yet, all missing directories are created by the restorer
+				return true, nil
+			}
+			c.checked[dir] = err
+		}
+
+		if err != nil {
+			if _, ok := c.reported[dir]; ok {
+				return false, nil
+			}
+			c.reported[dir] = struct{}{}
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (c *targetChecker) checkDir(dir string) (exists bool, err error) {
+	fi, err := fs.Lstat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return true, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return true, errors.Errorf("failed to resolve symlink %v: %v", dir, err)
+	}
+	if !fs.HasPathPrefix(c.root, resolved) {
+		debug.Log("symlink %v resolves to %v outside of %v", dir, resolved, c.root)
+		return true, errors.Errorf("refusing to restore through symlink %v which leads outside of the target directory", dir)
+	}
+	return true, nil
+}
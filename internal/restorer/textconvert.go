@@ -0,0 +1,117 @@
+package restorer
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// LineEnding is the line ending which Options.TextConvert converts to.
+type LineEnding string
+
+const (
+	// LineEndingLF converts CRLF line endings to LF.
+	LineEndingLF LineEnding = "LF"
+	// LineEndingCRLF converts LF line endings to CRLF.
+	LineEndingCRLF LineEnding = "CRLF"
+)
+
+// TextConvert selects the files whose line endings are converted while
+// restoring them, see Options.TextConvert.
+type TextConvert struct {
+	// Patterns select files by their location within the snapshot, using the
+	// same syntax as include and exclude patterns.
+	Patterns   []string
+	LineEnding LineEnding
+}
+
+func (c *TextConvert) validate() error {
+	if c.LineEnding != LineEndingLF && c.LineEnding != LineEndingCRLF {
+		return errors.Errorf("unknown line ending %q", c.LineEnding)
+	}
+	return filter.ValidatePatterns(c.Patterns)
+}
+
+// binaryCheckSize is the size of the first block of a file which is checked
+// for NUL bytes to detect binary files.
+const binaryCheckSize = 8000
+
+// textTransform returns a ContentTransform which converts the line endings
+// of the file at location according to Options.TextConvert, or nil if the
+// file is not selected or its first block in tmp contains a NUL byte.
+func (res *Restorer) textTransform(location, tmp string) (func(node *restic.Node, dst io.Writer) (io.WriteCloser, error), error) {
+	if res.opts.TextConvert == nil {
+		return nil, nil
+	}
+	matched, err := filter.List(res.textPatterns, location)
+	if err != nil || !matched {
+		return nil, err
+	}
+
+	f, err := fs.OpenFile(tmp, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	buf := make([]byte, binaryCheckSize)
+	n, err := io.ReadFull(f, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	err = errors.CombineErrors(errors.WithStack(err), errors.WithStack(f.Close()))
+	if err != nil || bytes.IndexByte(buf[:n], 0) >= 0 {
+		return nil, err
+	}
+
+	crlf := res.opts.TextConvert.LineEnding == LineEndingCRLF
+	return func(_ *restic.Node, dst io.Writer) (io.WriteCloser, error) {
+		return &lineEndingWriter{w: dst, crlf: crlf}, nil
+	}, nil
+}
+
+// lineEndingWriter converts the line endings of the text written to it to
+// CRLF or LF.
+type lineEndingWriter struct {
+	w    io.Writer
+	crlf bool
+	// cr is set if the last byte written was a CR
+	cr  bool
+	buf []byte
+}
+
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	l.buf = l.buf[:0]
+	for _, b := range p {
+		switch {
+		case l.crlf && b == '\n' && !l.cr:
+			l.buf = append(l.buf, '\r', '\n')
+		case !l.crlf && l.cr && b != '\n':
+			// a single CR is kept
+			l.buf = append(l.buf, '\r')
+			if b != '\r' {
+				l.buf = append(l.buf, b)
+			}
+		case !l.crlf && b == '\r':
+			// only written once it is known not to be part of CRLF
+		default:
+			l.buf = append(l.buf, b)
+		}
+		l.cr = b == '\r'
+	}
+	if _, err := l.w.Write(l.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *lineEndingWriter) Close() error {
+	if !l.crlf && l.cr {
+		l.cr = false
+		_, err := l.w.Write([]byte{'\r'})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package restorer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLineEndingWriter(t *testing.T) {
+	for _, test := range []struct {
+		input  []string
+		crlf   bool
+		output string
+	}{
+		{[]string{"a\nb\r\nc"}, true, "a\r\nb\r\nc"},
+		{[]string{"a\r", "\nb\n"}, true, "a\r\nb\r\n"},
+		{[]string{"a\r\nb\r\n"}, false, "a\nb\n"},
+		{[]string{"a\r", "\nb\rc\r\r\n"}, false, "a\nb\rc\r\n"},
+		{[]string{"a\r"}, false, "a\r"},
+	} {
+		var buf bytes.Buffer
+		wr := &lineEndingWriter{w: &buf, crlf: test.crlf}
+		for _, s := range test.input {
+			n, err := wr.Write([]byte(s))
+			rtest.OK(t, err)
+			rtest.Equals(t, len(s), n)
+		}
+		rtest.OK(t, wr.Close())
+		rtest.Equals(t, test.output, buf.String(), fmt.Sprintf("input %q", test.input))
+	}
+}
+
+func TestRestorerTextConvert(t *testing.T) {
+	repo := repository.TestRepository(t)
+	sn, _ := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"src": Dir{Nodes: map[string]Node{
+				"main.go":  File{Data: "package main\n\nfunc main() {}\n"},
+				"data.bin": File{Data: "line\n\x00line\n"},
+				"logo.go":  File{Data: "binary\x00\n"},
+			}},
+			"notes": File{Data: "keep\n"},
+		},
+	}, noopGetGenericAttributes)
+
+	res := NewRestorer(repo, sn, Options{TextConvert: &TextConvert{
+		Patterns:   []string{"*.go", "*.bin"},
+		LineEnding: LineEndingCRLF,
+	}})
+	ctx := context.TODO()
+	tempdir := rtest.TempDir(t)
+	rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+	for filename, content := range map[string]string{
+		"src/main.go":  "package main\r\n\r\nfunc main() {}\r\n",
+		"src/data.bin": "line\n\x00line\n",
+		"src/logo.go":  "binary\x00\n",
+		"notes":        "keep\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, content, string(data), filename)
+	}
+
+	// only converted files are skipped
+	result, err := res.VerifyFilesWithResult(ctx, tempdir)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{filepath.Join(tempdir, "src", "main.go")}, result.Skipped)
+	rtest.Equals(t, 3, len(result.Verified))
+
+	res = NewRestorer(repo, sn, Options{TextConvert: &TextConvert{LineEnding: "CR"}})
+	rtest.Assert(t, res.RestoreTo(ctx, rtest.TempDir(t)) != nil, "unknown line ending accepted")
+}
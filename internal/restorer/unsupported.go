@@ -0,0 +1,61 @@
+package restorer
+
+import (
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// UnsupportedAction is returned by Options.OnUnsupportedNode.
+type UnsupportedAction int
+
+const (
+	// UnsupportedError reports an error for the node via Restorer.Error.
+	UnsupportedError UnsupportedAction = iota
+	// UnsupportedSkip ignores the node.
+	UnsupportedSkip
+	// UnsupportedRestoreAsEmptyFile restores an empty file with the metadata
+	// of the node instead.
+	UnsupportedRestoreAsEmptyFile
+)
+
+// supportedNodeTypes are the node types the restorer can create.
+var supportedNodeTypes = map[string]struct{}{
+	"dir":     {},
+	"file":    {},
+	"symlink": {},
+	"dev":     {},
+	"chardev": {},
+	"fifo":    {},
+	"socket":  {},
+}
+
+// handleUnsupportedNode applies Options.OnUnsupportedNode to a node with a
+// type the restorer cannot create. It returns the node to restore instead,
+// or false if the node is skipped. Other nodes are returned unchanged.
+func (res *Restorer) handleUnsupportedNode(node *restic.Node) (*restic.Node, bool) {
+	if _, ok := supportedNodeTypes[node.Type]; ok {
+		return node, true
+	}
+
+	action := UnsupportedError
+	if res.opts.OnUnsupportedNode != nil {
+		action = res.opts.OnUnsupportedNode(node)
+	}
+	switch action {
+	case UnsupportedSkip:
+		debug.Log("skipping %v with unsupported type %q", node.Name, node.Type)
+		return nil, false
+	case UnsupportedRestoreAsEmptyFile:
+		debug.Log("restoring %v with unsupported type %q as empty file", node.Name, node.Type)
+		// do not modify the cached node
+		n := *node
+		n.Type = "file"
+		n.Mode = node.Mode.Perm()
+		n.Content, n.Size, n.Links = nil, 0, 1
+		n.LinkTarget, n.Device, n.Subtree = "", 0, nil
+		return &n, true
+	default:
+		// creating the node fails with an error
+		return node, true
+	}
+}
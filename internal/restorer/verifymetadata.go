@@ -0,0 +1,145 @@
+package restorer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// MetadataMismatch describes a restored item whose metadata differs from the
+// snapshot.
+type MetadataMismatch struct {
+	Path     string // target path of the item
+	Field    string // one of "type", "mode", "mtime", "uid", "gid" or "linktarget"
+	Expected string
+	Actual   string
+}
+
+func (m MetadataMismatch) String() string {
+	return fmt.Sprintf("%v: %v mismatch, expected %v, got %v", m.Path, m.Field, m.Expected, m.Actual)
+}
+
+// modeTypes maps node types to the corresponding type bits of os.FileMode.
+var modeTypes = map[string]os.FileMode{
+	"file":    0,
+	"dir":     os.ModeDir,
+	"symlink": os.ModeSymlink,
+	"dev":     os.ModeDevice,
+	"chardev": os.ModeDevice | os.ModeCharDevice,
+	"fifo":    os.ModeNamedPipe,
+}
+
+const permMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// VerifyMetadata checks that the type, mode, modification time, ownership
+// and symlink target of all restored items below dst match the snapshot. It
+// returns all discrepancies found. Mode and ownership are not checked on
+// Windows. Errors while inspecting an item are passed to res.Error.
+func (res *Restorer) VerifyMetadata(ctx context.Context, dst string) ([]MetadataMismatch, error) {
+	var err error
+	if !filepath.IsAbs(dst) {
+		dst, err = filepath.Abs(dst)
+		if err != nil {
+			return nil, errors.Wrap(err, "Abs")
+		}
+	}
+	dst, err = res.includeTopDir(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []MetadataMismatch
+	check := func(node *restic.Node, target, location string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target, _, ok := res.collisions.resolve(target, location)
+		if !ok {
+			return nil
+		}
+		found, err := res.verifyNodeMetadata(res.rewriteSymlink(node, target), target)
+		mismatches = append(mismatches, found...)
+		return err
+	}
+
+	_, err = res.traverseTree(ctx, dst, string(filepath.Separator), res.root, treeVisitor{
+		visitNode: check,
+		leaveDir:  check,
+	})
+	return mismatches, err
+}
+
+// verifyNodeMetadata compares the item at target with node. The link target of
+// a symlink node must already be rewritten by Options.RewriteSymlinkTarget.
+func (res *Restorer) verifyNodeMetadata(node *restic.Node, target string) ([]MetadataMismatch, error) {
+	var mismatches []MetadataMismatch
+	mismatch := func(field string, expected, actual interface{}) {
+		mismatches = append(mismatches, MetadataMismatch{
+			Path:     target,
+			Field:    field,
+			Expected: fmt.Sprint(expected),
+			Actual:   fmt.Sprint(actual),
+		})
+	}
+
+	fi, err := fs.Lstat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		mismatch("type", node.Type, "missing")
+		return mismatches, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expectedType, ok := modeTypes[node.Type]
+	if !ok {
+		return nil, errors.Errorf("unsupported node type %q", node.Type)
+	}
+	if fi.Mode().Type() != expectedType {
+		mismatch("type", expectedType.Type(), fi.Mode().Type())
+		return mismatches, nil
+	}
+
+	if node.Type == "symlink" {
+		linkTarget, err := fs.Readlink(target)
+		if err != nil {
+			return mismatches, err
+		}
+		if linkTarget != node.LinkTarget {
+			mismatch("linktarget", node.LinkTarget, linkTarget)
+		}
+	} else if node.Type != "dir" || !res.opts.SkipDirTimes {
+		// the timestamps of symlinks cannot be restored on all platforms
+		if !fi.ModTime().Equal(node.ModTime) {
+			mismatch("mtime", node.ModTime.Format(time.RFC3339Nano), fi.ModTime().Format(time.RFC3339Nano))
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return mismatches, nil
+	}
+
+	node = res.withFinalReadOnly(node)
+	if node.Type != "symlink" && fi.Mode()&permMask != node.Mode&permMask {
+		mismatch("mode", node.Mode&permMask, fi.Mode()&permMask)
+	}
+
+	node = res.withOwner(node)
+	stat := fs.ExtendedStat(fi)
+	if stat.UID != node.UID {
+		mismatch("uid", strconv.FormatUint(uint64(node.UID), 10), strconv.FormatUint(uint64(stat.UID), 10))
+	}
+	if stat.GID != node.GID {
+		mismatch("gid", strconv.FormatUint(uint64(node.GID), 10), strconv.FormatUint(uint64(stat.GID), 10))
+	}
+
+	return mismatches, nil
+}
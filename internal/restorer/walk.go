@@ -0,0 +1,214 @@
+package restorer
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// TreeVisitor holds the callbacks used by WalkTree. target is the path in the
+// file system, location the path within the snapshot.
+type TreeVisitor struct {
+	// SelectFilter decides whether a node is visited and whether the children
+	// of a directory may be selected, with the same semantics as
+	// Restorer.SelectFilter. If nil, all nodes are selected.
+	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+	// Error is called for each error encountered while walking the tree or
+	// returned by a callback. If it returns nil, the walk continues. If nil,
+	// all errors abort the walk.
+	Error func(location string, err error) error
+
+	// EnterDir is called for a selected directory before its children.
+	EnterDir func(node *restic.Node, target, location string) error
+	// VisitNode is called for all selected nodes except directories.
+	VisitNode func(node *restic.Node, target, location string) error
+	// LeaveDir is called after the children of a directory were visited, if
+	// either the directory itself or any of its children was selected.
+	LeaveDir func(node *restic.Node, target, location string) error
+}
+
+// WalkTree traverses the tree treeID loaded from repo in the same way the
+// Restorer does and calls the callbacks of visitor. The root of the tree
+// corresponds to target in the file system.
+func WalkTree(ctx context.Context, repo restic.BlobLoader, target string, treeID restic.ID, visitor TreeVisitor) error {
+	if visitor.SelectFilter == nil {
+		visitor.SelectFilter = func(string, string, *restic.Node) (bool, bool) { return true, true }
+	}
+	if visitor.Error == nil {
+		visitor.Error = restorerAbortOnAllErrors
+	}
+
+	_, err := walkTree(ctx, repo, target, string(filepath.Separator), treeID, &visitor)
+	return err
+}
+
+func walkTree(ctx context.Context, repo restic.BlobLoader, target, location string, treeID restic.ID, visitor *TreeVisitor) (hasRestored bool, err error) {
+	debug.Log("%v %v %v", target, location, treeID)
+	tree, err := restic.LoadTree(ctx, repo, treeID)
+	if err != nil {
+		debug.Log("error loading tree %v: %v", treeID, err)
+		return hasRestored, visitor.Error(location, err)
+	}
+
+	for _, node := range tree.Nodes {
+
+		// ensure that the node name does not contain anything that refers to a
+		// top-level directory.
+		nodeName := filepath.Base(filepath.Join(string(filepath.Separator), node.Name))
+		if nodeName != node.Name {
+			debug.Log("node %q has invalid name %q", node.Name, nodeName)
+			err := visitor.Error(location, errors.Errorf("invalid child node name %s", node.Name))
+			if err != nil {
+				return hasRestored, err
+			}
+			continue
+		}
+
+		nodeTarget := filepath.Join(target, nodeName)
+		nodeLocation := filepath.Join(location, nodeName)
+
+		if target == nodeTarget || !fs.HasPathPrefix(target, nodeTarget) {
+			debug.Log("target: %v %v", target, nodeTarget)
+			debug.Log("node %q has invalid target path %q", node.Name, nodeTarget)
+			err := visitor.Error(nodeLocation, errors.New("node has invalid path"))
+			if err != nil {
+				return hasRestored, err
+			}
+			continue
+		}
+
+		selectedForRestore, childMayBeSelected := visitor.SelectFilter(nodeLocation, nodeTarget, node)
+		debug.Log("SelectFilter returned %v %v for %q", selectedForRestore, childMayBeSelected, nodeLocation)
+
+		if selectedForRestore {
+			hasRestored = true
+		}
+
+		sanitizeError := func(err error) error {
+			switch err {
+			case nil, context.Canceled, context.DeadlineExceeded:
+				// Context errors are permanent.
+				return err
+			default:
+				return visitor.Error(nodeLocation, err)
+			}
+		}
+
+		if node.Type == "dir" {
+			if node.Subtree == nil {
+				return hasRestored, errors.Errorf("Dir without subtree in tree %v", treeID.Str())
+			}
+
+			if selectedForRestore && visitor.EnterDir != nil {
+				err = sanitizeError(visitor.EnterDir(node, nodeTarget, nodeLocation))
+				if err != nil {
+					return hasRestored, err
+				}
+			}
+
+			// keep track of restored child status
+			// so metadata of the current directory are restored on leaveDir
+			childHasRestored := false
+
+			if childMayBeSelected {
+				childHasRestored, err = walkTree(ctx, repo, nodeTarget, nodeLocation, *node.Subtree, visitor)
+				err = sanitizeError(err)
+				if err != nil {
+					return hasRestored, err
+				}
+				// inform the parent directory to restore parent metadata on leaveDir if needed
+				if childHasRestored {
+					hasRestored = true
+				}
+			}
+
+			// metadata need to be restore when leaving the directory in both cases
+			// selected for restore or any child of any subtree have been restored
+			if (selectedForRestore || childHasRestored) && visitor.LeaveDir != nil {
+				err = sanitizeError(visitor.LeaveDir(node, nodeTarget, nodeLocation))
+				if err != nil {
+					return hasRestored, err
+				}
+			}
+
+			continue
+		}
+
+		if selectedForRestore && visitor.VisitNode != nil {
+			err = sanitizeError(visitor.VisitNode(node, nodeTarget, nodeLocation))
+			if err != nil {
+				return hasRestored, err
+			}
+		}
+	}
+
+	return hasRestored, nil
+}
+
+// ListOptions controls which nodes are returned by ListNodes and WalkNodes.
+type ListOptions struct {
+	// Recursive also lists the contents of subdirectories. Otherwise, only
+	// the direct children of the tree are listed.
+	Recursive bool
+	// MaxDepth limits the depth of the listed nodes if Recursive is set, the
+	// children of the tree have a depth of one. If zero, the depth is not
+	// limited.
+	MaxDepth int
+	// SelectFilter decides which nodes are listed and whether the children of
+	// a directory may be listed, like Restorer.SelectFilter. If nil, all
+	// nodes are selected.
+	SelectFilter SelectFilter
+}
+
+// ListedNode is a node returned by ListNodes along with its location within
+// the tree.
+type ListedNode struct {
+	Path string
+	Node *restic.Node
+}
+
+// WalkNodes calls fn for each node of the tree treeID selected by opts,
+// without keeping them in memory. Nodes are reported depth-first, sorted by
+// name within each directory, and a directory is reported before its
+// children. The walk stops at the first error.
+func WalkNodes(ctx context.Context, repo restic.BlobLoader, treeID restic.ID, opts ListOptions, fn func(node ListedNode) error) error {
+	maxDepth := opts.MaxDepth
+	if !opts.Recursive {
+		maxDepth = 1
+	}
+	selectFilter := opts.SelectFilter
+	if selectFilter == nil {
+		selectFilter = func(string, string, *restic.Node) (bool, bool) { return true, true }
+	}
+
+	visit := func(node *restic.Node, _, location string) error {
+		return fn(ListedNode{Path: location, Node: node})
+	}
+	root := string(filepath.Separator)
+	return WalkTree(ctx, repo, root, treeID, TreeVisitor{
+		SelectFilter: func(item string, dstpath string, node *restic.Node) (bool, bool) {
+			selected, childMayBeSelected := selectFilter(item, dstpath, node)
+			if maxDepth > 0 && depth(item) >= maxDepth {
+				childMayBeSelected = false
+			}
+			return selected, childMayBeSelected
+		},
+		EnterDir:  visit,
+		VisitNode: visit,
+	})
+}
+
+// ListNodes returns the nodes of the tree treeID selected by opts in the
+// order reported by WalkNodes. Nothing is restored.
+func ListNodes(ctx context.Context, repo restic.BlobLoader, treeID restic.ID, opts ListOptions) ([]ListedNode, error) {
+	var nodes []ListedNode
+	err := WalkNodes(ctx, repo, treeID, opts, func(node ListedNode) error {
+		nodes = append(nodes, node)
+		return nil
+	})
+	return nodes, err
+}
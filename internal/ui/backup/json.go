@@ -170,22 +170,23 @@ func (b *JSONProgress) Finish(snapshotID restic.ID, start time.Time, summary *ar
 		id = snapshotID.String()
 	}
 	b.print(summaryOutput{
-		MessageType:         "summary",
-		FilesNew:            summary.Files.New,
-		FilesChanged:        summary.Files.Changed,
-		FilesUnmodified:     summary.Files.Unchanged,
-		DirsNew:             summary.Dirs.New,
-		DirsChanged:         summary.Dirs.Changed,
-		DirsUnmodified:      summary.Dirs.Unchanged,
-		DataBlobs:           summary.ItemStats.DataBlobs,
-		TreeBlobs:           summary.ItemStats.TreeBlobs,
-		DataAdded:           summary.ItemStats.DataSize + summary.ItemStats.TreeSize,
-		DataAddedPacked:     summary.ItemStats.DataSizeInRepo + summary.ItemStats.TreeSizeInRepo,
-		TotalFilesProcessed: summary.Files.New + summary.Files.Changed + summary.Files.Unchanged,
-		TotalBytesProcessed: summary.ProcessedBytes,
-		TotalDuration:       time.Since(start).Seconds(),
-		SnapshotID:          id,
-		DryRun:              dryRun,
+		MessageType:              "summary",
+		FilesNew:                 summary.Files.New,
+		FilesChanged:             summary.Files.Changed,
+		FilesUnmodified:          summary.Files.Unchanged,
+		FilesChangedDuringBackup: summary.ChangedDuringBackup,
+		DirsNew:                  summary.Dirs.New,
+		DirsChanged:              summary.Dirs.Changed,
+		DirsUnmodified:           summary.Dirs.Unchanged,
+		DataBlobs:                summary.ItemStats.DataBlobs,
+		TreeBlobs:                summary.ItemStats.TreeBlobs,
+		DataAdded:                summary.ItemStats.DataSize + summary.ItemStats.TreeSize,
+		DataAddedPacked:          summary.ItemStats.DataSizeInRepo + summary.ItemStats.TreeSizeInRepo,
+		TotalFilesProcessed:      summary.Files.New + summary.Files.Changed + summary.Files.Unchanged,
+		TotalBytesProcessed:      summary.ProcessedBytes,
+		TotalDuration:            time.Since(start).Seconds(),
+		SnapshotID:               id,
+		DryRun:                   dryRun,
 	})
 }
 
@@ -226,20 +227,21 @@ type verboseUpdate struct {
 }
 
 type summaryOutput struct {
-	MessageType         string  `json:"message_type"` // "summary"
-	FilesNew            uint    `json:"files_new"`
-	FilesChanged        uint    `json:"files_changed"`
-	FilesUnmodified     uint    `json:"files_unmodified"`
-	DirsNew             uint    `json:"dirs_new"`
-	DirsChanged         uint    `json:"dirs_changed"`
-	DirsUnmodified      uint    `json:"dirs_unmodified"`
-	DataBlobs           int     `json:"data_blobs"`
-	TreeBlobs           int     `json:"tree_blobs"`
-	DataAdded           uint64  `json:"data_added"`
-	DataAddedPacked     uint64  `json:"data_added_packed"`
-	TotalFilesProcessed uint    `json:"total_files_processed"`
-	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
-	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id,omitempty"`
-	DryRun              bool    `json:"dry_run,omitempty"`
+	MessageType              string  `json:"message_type"` // "summary"
+	FilesNew                 uint    `json:"files_new"`
+	FilesChanged             uint    `json:"files_changed"`
+	FilesUnmodified          uint    `json:"files_unmodified"`
+	FilesChangedDuringBackup uint    `json:"files_changed_during_backup,omitempty"`
+	DirsNew                  uint    `json:"dirs_new"`
+	DirsChanged              uint    `json:"dirs_changed"`
+	DirsUnmodified           uint    `json:"dirs_unmodified"`
+	DataBlobs                int     `json:"data_blobs"`
+	TreeBlobs                int     `json:"tree_blobs"`
+	DataAdded                uint64  `json:"data_added"`
+	DataAddedPacked          uint64  `json:"data_added_packed"`
+	TotalFilesProcessed      uint    `json:"total_files_processed"`
+	TotalBytesProcessed      uint64  `json:"total_bytes_processed"`
+	TotalDuration            float64 `json:"total_duration"` // in seconds
+	SnapshotID               string  `json:"snapshot_id,omitempty"`
+	DryRun                   bool    `json:"dry_run,omitempty"`
 }
@@ -30,6 +30,8 @@ func (t *jsonPrinter) Update(p State, duration time.Duration) {
 		TotalBytes:     p.AllBytesTotal,
 		BytesRestored:  p.AllBytesWritten,
 		BytesSkipped:   p.AllBytesSkipped,
+		TotalPacks:     p.Packs.Total,
+		PacksFinished:  p.Packs.Finished,
 	}
 
 	if p.AllBytesTotal > 0 {
@@ -41,14 +43,22 @@ func (t *jsonPrinter) Update(p State, duration time.Duration) {
 
 func (t *jsonPrinter) Finish(p State, duration time.Duration) {
 	status := summaryOutput{
-		MessageType:    "summary",
-		SecondsElapsed: uint64(duration / time.Second),
-		TotalFiles:     p.FilesTotal,
-		FilesRestored:  p.FilesFinished,
-		FilesSkipped:   p.FilesSkipped,
-		TotalBytes:     p.AllBytesTotal,
-		BytesRestored:  p.AllBytesWritten,
-		BytesSkipped:   p.AllBytesSkipped,
+		MessageType:     "summary",
+		SecondsElapsed:  uint64(duration / time.Second),
+		TotalFiles:      p.FilesTotal,
+		FilesRestored:   p.FilesFinished,
+		FilesSkipped:    p.FilesSkipped,
+		TotalBytes:      p.AllBytesTotal,
+		BytesRestored:   p.AllBytesWritten,
+		BytesSkipped:    p.AllBytesSkipped,
+		BlobsFetched:    p.Blobs.Fetched,
+		BlobReferences:  p.Blobs.References,
+		BytesFetched:    p.Blobs.BytesFetched,
+		BytesReused:     p.Blobs.BytesReused(),
+		TotalPacks:      p.Packs.Total,
+		PacksFetched:    p.Packs.Fetched,
+		BytesDownloaded: p.Packs.BytesFetched,
+		CacheHitRatio:   p.Packs.CacheHitRatio(),
 	}
 	t.print(status)
 }
@@ -63,15 +73,25 @@ type statusUpdate struct {
 	TotalBytes     uint64  `json:"total_bytes,omitempty"`
 	BytesRestored  uint64  `json:"bytes_restored,omitempty"`
 	BytesSkipped   uint64  `json:"bytes_skipped,omitempty"`
+	TotalPacks     uint64  `json:"total_packs,omitempty"`
+	PacksFinished  uint64  `json:"packs_finished,omitempty"`
 }
 
 type summaryOutput struct {
-	MessageType    string `json:"message_type"` // "summary"
-	SecondsElapsed uint64 `json:"seconds_elapsed,omitempty"`
-	TotalFiles     uint64 `json:"total_files,omitempty"`
-	FilesRestored  uint64 `json:"files_restored,omitempty"`
-	FilesSkipped   uint64 `json:"files_skipped,omitempty"`
-	TotalBytes     uint64 `json:"total_bytes,omitempty"`
-	BytesRestored  uint64 `json:"bytes_restored,omitempty"`
-	BytesSkipped   uint64 `json:"bytes_skipped,omitempty"`
+	MessageType     string  `json:"message_type"` // "summary"
+	SecondsElapsed  uint64  `json:"seconds_elapsed,omitempty"`
+	TotalFiles      uint64  `json:"total_files,omitempty"`
+	FilesRestored   uint64  `json:"files_restored,omitempty"`
+	FilesSkipped    uint64  `json:"files_skipped,omitempty"`
+	TotalBytes      uint64  `json:"total_bytes,omitempty"`
+	BytesRestored   uint64  `json:"bytes_restored,omitempty"`
+	BytesSkipped    uint64  `json:"bytes_skipped,omitempty"`
+	BlobsFetched    uint64  `json:"blobs_fetched,omitempty"`
+	BlobReferences  uint64  `json:"blob_references,omitempty"`
+	BytesFetched    uint64  `json:"bytes_fetched,omitempty"`
+	BytesReused     uint64  `json:"bytes_reused,omitempty"`
+	TotalPacks      uint64  `json:"total_packs,omitempty"`
+	PacksFetched    uint64  `json:"packs_fetched,omitempty"`
+	BytesDownloaded uint64  `json:"bytes_downloaded,omitempty"`
+	CacheHitRatio   float64 `json:"cache_hit_ratio,omitempty"`
 }
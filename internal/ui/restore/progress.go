@@ -14,6 +14,47 @@ type State struct {
 	AllBytesWritten uint64
 	AllBytesTotal   uint64
 	AllBytesSkipped uint64
+	Blobs           BlobStats
+	Packs           PackStats
+}
+
+// BlobStats describes how the blobs referenced by the restored files were
+// obtained. A blob which is used by several files or several times within a
+// file is only downloaded once.
+type BlobStats struct {
+	Fetched         uint64 // number of blobs downloaded from the repository
+	BytesFetched    uint64 // size of the downloaded blobs
+	References      uint64 // number of blobs written to files
+	BytesReferenced uint64 // size of the blobs written to files
+}
+
+// BytesReused returns the number of bytes which were written to files without
+// being downloaded, as the blob was reused.
+func (s BlobStats) BytesReused() uint64 {
+	if s.BytesReferenced < s.BytesFetched {
+		return 0
+	}
+	return s.BytesReferenced - s.BytesFetched
+}
+
+// PackStats describes the progress of the restore in terms of pack files,
+// which is what is actually downloaded from the repository backend.
+type PackStats struct {
+	Total        uint64 // number of packs required by the restore
+	Finished     uint64 // number of packs whose blobs were all processed
+	Fetched      uint64 // number of packs downloaded from the backend
+	BytesFetched uint64 // size of the blobs downloaded from the backend
+	CacheHits    uint64 // number of blobs served from the pack cache
+	CacheMisses  uint64 // number of blobs not found in the pack cache
+}
+
+// CacheHitRatio returns the fraction of blobs looked up in the pack cache
+// which were found there, or 0 if the pack cache was not used.
+func (s PackStats) CacheHitRatio() float64 {
+	if s.CacheHits+s.CacheMisses == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(s.CacheHits+s.CacheMisses)
 }
 
 type Progress struct {
@@ -99,6 +140,87 @@ func (p *Progress) AddProgress(name string, bytesWrittenPortion uint64, bytesTot
 	}
 }
 
+// AddFetchedBlob records that a blob of the given size was downloaded from the
+// repository.
+func (p *Progress) AddFetchedBlob(size uint64) {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.s.Blobs.Fetched++
+	p.s.Blobs.BytesFetched += size
+}
+
+// AddBlobReferences records that a blob of the given size was written count
+// times to files.
+func (p *Progress) AddBlobReferences(count uint64, size uint64) {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.s.Blobs.References += count
+	p.s.Blobs.BytesReferenced += count * size
+}
+
+// AddRequiredPacks records that count further packs must be processed to
+// restore the file contents.
+func (p *Progress) AddRequiredPacks(count uint64) {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.s.Packs.Total += count
+}
+
+// AddFinishedPack records that all blobs of a required pack were processed.
+func (p *Progress) AddFinishedPack() {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.s.Packs.Finished++
+}
+
+// AddFetchedPack records that blobs with a total size of bytes were
+// downloaded from a pack in the backend.
+func (p *Progress) AddFetchedPack(bytes uint64) {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.s.Packs.Fetched++
+	p.s.Packs.BytesFetched += bytes
+}
+
+// AddCacheLookups records the number of blobs which were found in the pack
+// cache and of those which had to be loaded instead.
+func (p *Progress) AddCacheLookups(hits uint64, misses uint64) {
+	if p == nil {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.s.Packs.CacheHits += hits
+	p.s.Packs.CacheMisses += misses
+}
+
 func (p *Progress) AddSkippedFile(size uint64) {
 	if p == nil {
 		return
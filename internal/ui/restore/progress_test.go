@@ -45,7 +45,7 @@ func TestNew(t *testing.T) {
 		return false
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{0, 0, 0, 0, 0, 0}, 0, false},
+		printerTraceEntry{State{0, 0, 0, 0, 0, 0, BlobStats{}, PackStats{}}, 0, false},
 	}, result)
 }
 
@@ -57,7 +57,7 @@ func TestAddFile(t *testing.T) {
 		return false
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{0, 1, 0, 0, fileSize, 0}, 0, false},
+		printerTraceEntry{State{0, 1, 0, 0, fileSize, 0, BlobStats{}, PackStats{}}, 0, false},
 	}, result)
 }
 
@@ -71,7 +71,7 @@ func TestFirstProgressOnAFile(t *testing.T) {
 		return false
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{0, 1, 0, expectedBytesWritten, expectedBytesTotal, 0}, 0, false},
+		printerTraceEntry{State{0, 1, 0, expectedBytesWritten, expectedBytesTotal, 0, BlobStats{}, PackStats{}}, 0, false},
 	}, result)
 }
 
@@ -86,7 +86,7 @@ func TestLastProgressOnAFile(t *testing.T) {
 		return false
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{1, 1, 0, fileSize, fileSize, 0}, 0, false},
+		printerTraceEntry{State{1, 1, 0, fileSize, fileSize, 0, BlobStats{}, PackStats{}}, 0, false},
 	}, result)
 }
 
@@ -102,7 +102,7 @@ func TestLastProgressOnLastFile(t *testing.T) {
 		return false
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{2, 2, 0, 50 + fileSize, 50 + fileSize, 0}, 0, false},
+		printerTraceEntry{State{2, 2, 0, 50 + fileSize, 50 + fileSize, 0, BlobStats{}, PackStats{}}, 0, false},
 	}, result)
 }
 
@@ -117,7 +117,7 @@ func TestSummaryOnSuccess(t *testing.T) {
 		return true
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{2, 2, 0, 50 + fileSize, 50 + fileSize, 0}, mockFinishDuration, true},
+		printerTraceEntry{State{2, 2, 0, 50 + fileSize, 50 + fileSize, 0, BlobStats{}, PackStats{}}, mockFinishDuration, true},
 	}, result)
 }
 
@@ -132,7 +132,7 @@ func TestSummaryOnErrors(t *testing.T) {
 		return true
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{1, 2, 0, 50 + fileSize/2, 50 + fileSize, 0}, mockFinishDuration, true},
+		printerTraceEntry{State{1, 2, 0, 50 + fileSize/2, 50 + fileSize, 0, BlobStats{}, PackStats{}}, mockFinishDuration, true},
 	}, result)
 }
 
@@ -144,6 +144,32 @@ func TestSkipFile(t *testing.T) {
 		return true
 	})
 	test.Equals(t, printerTrace{
-		printerTraceEntry{State{0, 0, 1, 0, 0, fileSize}, mockFinishDuration, true},
+		printerTraceEntry{State{0, 0, 1, 0, 0, fileSize, BlobStats{}, PackStats{}}, mockFinishDuration, true},
 	}, result)
 }
+
+func TestBlobStats(t *testing.T) {
+	result := testProgress(func(progress *Progress) bool {
+		progress.AddFetchedBlob(100)
+		progress.AddBlobReferences(3, 100)
+		return true
+	})
+	test.Equals(t, printerTrace{
+		printerTraceEntry{State{0, 0, 0, 0, 0, 0, BlobStats{1, 100, 3, 300}, PackStats{}}, mockFinishDuration, true},
+	}, result)
+	test.Equals(t, uint64(200), result[0].progress.Blobs.BytesReused())
+}
+
+func TestPackStats(t *testing.T) {
+	result := testProgress(func(progress *Progress) bool {
+		progress.AddRequiredPacks(3)
+		progress.AddFetchedPack(1000)
+		progress.AddFinishedPack()
+		progress.AddCacheLookups(1, 3)
+		return true
+	})
+	test.Equals(t, printerTrace{
+		printerTraceEntry{State{0, 0, 0, 0, 0, 0, BlobStats{}, PackStats{3, 1, 1, 1000, 1, 3}}, mockFinishDuration, true},
+	}, result)
+	test.Equals(t, 0.25, result[0].progress.Packs.CacheHitRatio())
+}
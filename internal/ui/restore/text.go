@@ -27,6 +27,9 @@ func (t *textPrinter) Update(p State, duration time.Duration) {
 	if p.FilesSkipped > 0 {
 		progress += fmt.Sprintf(", skipped %v files/dirs %v", p.FilesSkipped, ui.FormatBytes(p.AllBytesSkipped))
 	}
+	if p.Packs.Total > 0 {
+		progress += fmt.Sprintf(", packs %v / %v", p.Packs.Finished, p.Packs.Total)
+	}
 
 	t.terminal.SetStatus([]string{progress})
 }
@@ -48,6 +51,15 @@ func (t *textPrinter) Finish(p State, duration time.Duration) {
 	if p.FilesSkipped > 0 {
 		summary += fmt.Sprintf(", skipped %v files/dirs %v", p.FilesSkipped, ui.FormatBytes(p.AllBytesSkipped))
 	}
+	if p.Blobs.BytesReused() > 0 {
+		summary += fmt.Sprintf(", downloaded %v, reused %v", ui.FormatBytes(p.Blobs.BytesFetched), ui.FormatBytes(p.Blobs.BytesReused()))
+	}
+	if p.Packs.Fetched > 0 {
+		summary += fmt.Sprintf(", fetched %v / %v packs (%v)", p.Packs.Fetched, p.Packs.Total, ui.FormatBytes(p.Packs.BytesFetched))
+	}
+	if lookups := p.Packs.CacheHits + p.Packs.CacheMisses; lookups > 0 {
+		summary += fmt.Sprintf(", pack cache hits %v", ui.FormatPercent(p.Packs.CacheHits, lookups))
+	}
 
 	t.terminal.Print(summary)
 }